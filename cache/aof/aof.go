@@ -1,107 +1,359 @@
 package aof
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"os"
-	"strings"
+	"path/filepath"
+	"sync"
 	"time"
 
-	"gopkg.in/natefinch/lumberjack.v2"
+	"../batch"
+	"../raw"
+	"../types"
 )
 
-var path = "./data/aof/aof.log"
+const (
+	// DefaultPath is the AOF file of binary records
+	DefaultPath = "./data/aof/appendonly.aof"
+	// LegacyPath is the log formatted AOF written by previous versions, it can be converted by MigrateLegacy
+	LegacyPath = "./data/aof/aof.log"
+)
 
-func Init() {
-	log.SetOutput(&lumberjack.Logger{
-		Filename:   path,
-		MaxSize:    500, // megabytes
-		MaxBackups: 10,
-		MaxAge:     30, //days
-	})
-}
+// Fsync policies of AOF, they match appendfsync options of Redis
+const (
+	// FsyncAlways syncs file before operation is acknowledged
+	FsyncAlways = "always"
+	// FsyncEverySec syncs file once a second from background goroutine, so a second of writes may be lost
+	FsyncEverySec = "everysec"
+	// FsyncNo leaves flushing to OS
+	FsyncNo = "no"
+)
+
+var (
+	path  = DefaultPath
+	fsync = FsyncEverySec
+)
+
+var writer struct {
+	sync.Mutex
+	file *os.File
+	// fsync is policy of opened file
+	fsync string
+	// dirty tells that file has writes which aren't synced yet
+	dirty bool
+	// stop and done control goroutine syncing file every second
+	stop, done chan struct{}
 
-func Infof(format string, v ...interface{}) {
-	log.Printf(format, v...)
+	// size of file and its size after opening or the last rewrite, growth between them triggers automatic rewrite
+	size, baseSize int64
+	// rewriting tells that records are also kept in buffer to be appended to rewritten file
+	rewriting bool
+	buffer    []byte
+	// autoRewrite is set by SetAutoRewrite
+	autoRewrite struct {
+		percentage int
+		minSize    int64
+		snapshot   func(*Snapshot) error
+	}
 }
 
-func Write(key string, value interface{}, ttl int64, state string) {
-	log.Printf(" set %s %s %d - %s", key, string(marshal(value)), ttl, state)
+// SetFsync sets fsync policy of AOF opened afterwards by Init
+func SetFsync(policy string) error {
+	switch policy {
+	case FsyncAlways, FsyncEverySec, FsyncNo:
+		fsync = policy
+		return nil
+	}
+	return fmt.Errorf("Unknown AOF fsync policy '%s'.", policy)
 }
 
-func Delete(key string, state string) {
-	log.Printf(" delete %s - %s", key, state)
+// Log writes records of namespace
+type Log struct {
+	namespace string
 }
 
-func marshal(value interface{}) []byte {
-	data, _ := json.Marshal(value)
-	return data
+// Namespace returns log of namespace, empty name is the default namespace
+func Namespace(name string) Log {
+	return Log{namespace: name}
 }
 
-// GetCommands parses AOF log and prepare list of all commands that were requested from passed timestamp
-func GetCommands(from int64) (result []map[string]string) {
-	if from == 0 {
-		fmt.Println("AOF: restoring all records...")
-	} else {
-		fmt.Printf("AOF: restoring from %d timestamp...\n", from)
+// Init opens AOF file for appending, file is created with header if it doesn't exist. Previously opened file is closed.
+func Init() error {
+	Close()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := checkFile(path); err != nil {
+		return err
+	}
+	file, err := openFile(path)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(LegacyPath); err == nil && path == DefaultPath {
+		fmt.Printf("AOF: legacy log %s is not restored, convert it by 'cacher_cli aof-migrate'.\n", LegacyPath)
 	}
 
-	file, err := os.Open(path)
+	info, err := file.Stat()
 	if err != nil {
-		fmt.Println(err)
-		return result
+		file.Close()
+		return err
 	}
-	defer file.Close()
 
-	timezone, _ := time.Now().Local().Zone()
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		parts := strings.Split(scanner.Text(), " ")
-		dateTime, _ := time.Parse("2006/01/02 15:04:05 MST", parts[0]+" "+parts[1]+" "+timezone)
-		timestamp := dateTime.UTC().Unix()
-		if from != 0 {
-			if timestamp >= from {
-				record := prepareRecord(parts)
-				if record != nil {
-					result = append(result, record)
-				}
+	writer.Lock()
+	defer writer.Unlock()
+	writer.file, writer.fsync = file, fsync
+	writer.size, writer.baseSize = info.Size(), info.Size()
+	startSyncer()
+	return nil
+}
+
+// Close syncs and closes AOF file, records aren't written after it
+func Close() error {
+	stopSyncer()
+	writer.Lock()
+	file := writer.file
+	writer.file, writer.dirty = nil, false
+	writer.Unlock()
+
+	if file == nil {
+		return nil
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// startSyncer starts goroutine syncing opened file every second if policy asks for it, caller holds the lock
+func startSyncer() {
+	if writer.fsync == FsyncEverySec && writer.file != nil {
+		writer.stop, writer.done = make(chan struct{}), make(chan struct{})
+		go syncEverySecond(writer.file, writer.stop, writer.done)
+	}
+}
+
+// stopSyncer stops goroutine syncing file and waits for it
+func stopSyncer() {
+	writer.Lock()
+	stop, done := writer.stop, writer.done
+	writer.stop, writer.done = nil, nil
+	writer.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// syncEverySecond syncs writes to file in background, so writers don't wait for disk
+func syncEverySecond(file *os.File, stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			writer.Lock()
+			dirty := writer.dirty
+			writer.dirty = false
+			writer.Unlock()
+			if !dirty {
+				continue
+			}
+			if err := file.Sync(); err != nil {
+				fmt.Println("AOF: error while syncing file:", err)
 			}
-		} else {
-			result = append(result, prepareRecord(parts))
 		}
 	}
+}
+
+// openFile opens AOF file for appending and writes header to an empty one
+func openFile(name string) (*os.File, error) {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err == nil && info.Size() == 0 {
+		_, err = file.Write(fileHeader)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Println(err)
+func (l Log) append(record Record) {
+	record.Namespace, record.Timestamp = l.namespace, time.Now().UnixNano()
+	if record.TTL > 0 && record.ExpiredAt == 0 {
+		record.ExpiredAt = time.Now().Add(time.Second * time.Duration(record.TTL)).Unix()
 	}
+	data := encodeRecord(record)
 
-	return result
+	writer.Lock()
+	defer writer.Unlock()
+	if writer.file == nil {
+		return
+	}
+	if writer.rewriting {
+		writer.buffer = append(writer.buffer, data...)
+	}
+	if _, err := writer.file.Write(data); err != nil {
+		fmt.Println("AOF: error while writing record:", err)
+		return
+	}
+	writer.size += int64(len(data))
+	if needsRewrite() {
+		writer.rewriting = true
+		go rewriteInBackground(writer.autoRewrite.snapshot)
+	}
+	// operation is acknowledged after its completed or failed record, syncing it covers the pending one too
+	if writer.fsync == FsyncAlways && record.State != Pending {
+		if err := writer.file.Sync(); err != nil {
+			fmt.Println("AOF: error while syncing file:", err)
+		}
+		return
+	}
+	writer.dirty = true
+}
+
+// Write logs set of key, version keeps CAS token of the value across restore
+func (l Log) Write(key string, value interface{}, ttl int64, version uint64, state State) {
+	l.append(setRecord(key, value, ttl, version, state))
+}
+
+func setRecord(key string, value interface{}, ttl int64, version uint64, state State) Record {
+	record := Record{Op: OpSet, State: state, Key: key, Value: marshal(value), TTL: ttl, Version: version}
+	switch v := value.(type) {
+	case raw.Bytes, raw.Typed:
+		// opaque bytes are kept as base64 string, restore has to decode them back,
+		// typed bytes are kept as object with content type
+		record.Op = OpSetRaw
+	case types.Structure:
+		record.Op, record.Type = OpSetStructure, v.Type()
+	}
+	return record
+}
+
+// BatchEntry is an item of MSET record, which keeps the whole batch in place of value
+type BatchEntry struct {
+	Key     string
+	Value   interface{}
+	TTL     int64
+	Version uint64
+	// ExpiredAt is absolute expiration time of entry with TTL
+	ExpiredAt int64 `json:",omitempty"`
+	// Value is raw.Bytes kept as base64 string or raw.Typed kept as object
+	Opaque bool `json:",omitempty"`
+	// Type of structure kept in Value
+	Type string `json:",omitempty"`
+	// Deleted entry of transaction removes the key
+	Deleted bool `json:",omitempty"`
 }
 
-func prepareRecord(parts []string) (record map[string]string) {
-	var status string
-	if parts[3] == "delete" {
-		record = map[string]string{
-			"op":  parts[3],
-			"key": parts[4],
+// WriteBatch logs MSET as one record, so it's restored as a whole
+func (l Log) WriteBatch(items []batch.Item, state State) {
+	l.append(Record{Op: OpMSet, State: state, Value: marshal(batchEntries(items))})
+}
+
+// WriteTx logs writes of committed transaction as one record, items without Found are deleted keys
+func (l Log) WriteTx(items []batch.Item, state State) {
+	entries := batchEntries(items)
+	for i, item := range items {
+		if !item.Found {
+			entries[i] = BatchEntry{Key: item.Key, Deleted: true}
 		}
-		status = parts[6]
-	} else {
-		record = map[string]string{
-			"op":    parts[3],
-			"key":   parts[4],
-			"value": parts[5],
-			"ttl":   parts[6],
+	}
+	l.append(Record{Op: OpTx, State: state, Value: marshal(entries)})
+}
+
+func batchEntries(items []batch.Item) []BatchEntry {
+	now := time.Now()
+	entries := make([]BatchEntry, len(items))
+	for i, item := range items {
+		entries[i] = BatchEntry{Key: item.Key, Value: item.Value, TTL: item.TTL, Version: item.Version, ExpiredAt: item.ExpiredAt}
+		if item.TTL > 0 && item.ExpiredAt == 0 {
+			entries[i].ExpiredAt = now.Add(time.Second * time.Duration(item.TTL)).Unix()
+		}
+		switch v := item.Value.(type) {
+		case raw.Bytes, raw.Typed:
+			entries[i].Opaque = true
+		case types.Structure:
+			entries[i].Type = v.Type()
 		}
-		status = parts[8]
 	}
+	return entries
+}
+
+// DecodeBatch returns entries of MSET or transaction record value
+func DecodeBatch(value []byte) (entries []BatchEntry, err error) {
+	err = json.Unmarshal(value, &entries)
+	return entries, err
+}
+
+func (l Log) Delete(key string, state State) {
+	l.append(Record{Op: OpDelete, State: state, Key: key})
+}
+
+// DeleteBatch logs MDEL as one record, keys are kept as JSON list in value
+func (l Log) DeleteBatch(keys []string, state State) {
+	l.append(Record{Op: OpMDelete, State: state, Value: marshal(keys)})
+}
+
+func marshal(value interface{}) []byte {
+	data, _ := json.Marshal(value)
+	return data
+}
 
-	// consider only pending commands
-	if status == "pending" {
-		return record
+// GetRecords reads records of AOF written from passed timestamp in seconds, zero means all records.
+// Records read before a damaged one are returned with ErrTruncated or ErrCorrupted.
+func GetRecords(from int64) (result []Record, err error) {
+	if from == 0 {
+		fmt.Println("AOF: restoring all records...")
 	} else {
-		return nil
+		fmt.Printf("AOF: restoring from %d timestamp...\n", from)
+	}
+
+	err = readFile(path, func(record Record) {
+		if record.Timestamp >= from*int64(time.Second) {
+			result = append(result, record)
+		}
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return result, err
+}
+
+// readFile passes records of AOF file to handle until the end of file or the first corrupted record
+func readFile(name string, handle func(Record)) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := NewReader(file)
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		handle(record)
 	}
 }
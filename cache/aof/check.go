@@ -0,0 +1,110 @@
+package aof
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// loadTruncated allows Init to cut partially written record at the end of file, see SetLoadTruncated
+var loadTruncated = true
+
+// SetLoadTruncated sets whether Init truncates partially written record at the end of AOF, e.g. left by crash,
+// or refuses to open such file. Corrupted record is truncated automatically only if it's the last one, since
+// its length can be written before its payload reaches disk.
+func SetLoadTruncated(truncate bool) {
+	loadTruncated = truncate
+}
+
+// Check reads all records of AOF file. It returns number of valid records and size of file they take with
+// header. ErrTruncated or ErrCorrupted tells that the rest of file is damaged, it can be cut by Repair.
+func Check(name string) (records int, valid int64, err error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	reader, err := NewReader(file)
+	if err != nil {
+		if err == io.EOF {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	for {
+		valid = reader.Offset()
+		if _, err = reader.Next(); err != nil {
+			if err == io.EOF {
+				return records, valid, nil
+			}
+			return records, valid, err
+		}
+		records++
+	}
+}
+
+// Repair truncates AOF file after its valid records found by Check
+func Repair(name string, valid int64) error {
+	file, err := os.OpenFile(name, os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err = file.Truncate(valid); err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// checkFile makes sure that records appended to AOF file will be readable on restore
+func checkFile(name string) error {
+	records, valid, err := Check(name)
+	if err == ErrCorrupted && loadTruncated {
+		last, lastErr := lastRecord(name, valid)
+		if lastErr != nil {
+			return lastErr
+		}
+		if last {
+			err = ErrTruncated
+		}
+	}
+	switch {
+	case err == nil || os.IsNotExist(err):
+		return nil
+	case err == ErrTruncated && loadTruncated:
+		info, statErr := os.Stat(name)
+		if statErr != nil {
+			return statErr
+		}
+		fmt.Printf("AOF: truncating partially written record after %d records, %d bytes are removed.\n", records, info.Size()-valid)
+		return Repair(name, valid)
+	case err == ErrTruncated || err == ErrCorrupted:
+		return fmt.Errorf("AOF %s is damaged after %d records at offset %d: %s Check it by 'cacher_cli aof-check --fix'.",
+			name, records, valid, err)
+	}
+	return err
+}
+
+// lastRecord tells whether record at offset ends exactly at the end of file
+func lastRecord(name string, offset int64) (bool, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	var frame [8]byte
+	if _, err := file.ReadAt(frame[:], offset); err != nil {
+		return false, nil
+	}
+	size := binary.LittleEndian.Uint32(frame[:])
+	return size <= maxRecordSize && offset+int64(len(frame))+int64(size) == info.Size(), nil
+}
@@ -0,0 +1,200 @@
+package aof
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// legacyTimeLayout is prefix of lines written by the standard log package in local time
+const legacyTimeLayout = "2006/01/02 15:04:05"
+
+// ErrLegacyKeyWithSpaces is returned for line which key can't be told apart from value, migration is stopped then
+var ErrLegacyKeyWithSpaces = errors.New("Key with spaces can't be migrated.")
+
+// MigrateLegacy converts log formatted AOF into binary records of destination file. Records already kept
+// in destination are placed after converted ones. Cacher shouldn't be running while migration.
+// It returns number of converted records and error of the last line if it's skipped as written partially.
+func MigrateLegacy(legacy string, destination string) (converted int, skipped error, err error) {
+	source, err := os.Open(legacy)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer source.Close()
+
+	temp := destination + ".migrate"
+	file, err := os.OpenFile(temp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer os.Remove(temp)
+	defer file.Close()
+
+	output := bufio.NewWriter(file)
+	output.Write(fileHeader)
+	counter := 0
+	scanner := bufio.NewScanner(source)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	// the last line may be written partially, it's skipped then
+	var lineErr error
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		if lineErr != nil {
+			return counter, nil, lineErr
+		}
+		record, err := parseLegacyLine(scanner.Text())
+		if err == ErrLegacyKeyWithSpaces {
+			return counter, nil, fmt.Errorf("Line %d: %s", line, err)
+		}
+		if err != nil {
+			lineErr = fmt.Errorf("Line %d: %s", line, err)
+			continue
+		}
+		output.Write(encodeRecord(record))
+		counter++
+	}
+	if err := scanner.Err(); err != nil {
+		return counter, nil, err
+	}
+	err = readFile(destination, func(record Record) {
+		output.Write(encodeRecord(record))
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return counter, nil, err
+	}
+	if err := output.Flush(); err != nil {
+		return counter, nil, err
+	}
+	if err := file.Sync(); err != nil {
+		return counter, nil, err
+	}
+	if err := file.Close(); err != nil {
+		return counter, nil, err
+	}
+	return counter, lineErr, os.Rename(temp, destination)
+}
+
+// parseLegacyLine parses line like "2006/01/02 15:04:05  set@ns key value ttl version - state".
+// Value may contain spaces, so line is parsed from both ends. Lines written before versioning have no version.
+func parseLegacyLine(line string) (record Record, err error) {
+	parts := strings.Split(line, " ")
+	if len(parts) < 7 || parts[len(parts)-2] != "-" {
+		return record, fmt.Errorf("Unknown record format.")
+	}
+	dateTime, err := time.ParseInLocation(legacyTimeLayout, parts[0]+" "+parts[1], time.Local)
+	if err != nil {
+		return record, err
+	}
+	record.Timestamp = dateTime.UnixNano()
+	switch parts[len(parts)-1] {
+	case "pending":
+		record.State = Pending
+	case "completed":
+		record.State = Completed
+	case "failed":
+		record.State = Failed
+	default:
+		return record, fmt.Errorf("Unknown state '%s'.", parts[len(parts)-1])
+	}
+
+	op := parts[3]
+	if i := strings.LastIndex(op, "@"); i >= 0 {
+		op, record.Namespace = op[:i], op[i+1:]
+	}
+	fields := parts[4 : len(parts)-2]
+	switch op {
+	case "delete":
+		record.Op, record.Key = OpDelete, strings.Join(fields, " ")
+		return record, nil
+	case "mdelete":
+		record.Op, record.Value = OpMDelete, []byte(strings.Join(fields, " "))
+		return record, nil
+	case "mset":
+		record.Op = OpMSet
+	case "tx":
+		record.Op = OpTx
+	case "set":
+		record.Op = OpSet
+	case "setraw":
+		record.Op = OpSetRaw
+	default:
+		if !strings.HasPrefix(op, "set") {
+			return record, fmt.Errorf("Unknown operation '%s'.", op)
+		}
+		record.Op, record.Type = OpSetStructure, strings.TrimPrefix(op, "set")
+	}
+	if len(fields) < 3 {
+		return record, fmt.Errorf("Unknown record format.")
+	}
+	keyed := record.Op == OpSet || record.Op == OpSetRaw || record.Op == OpSetStructure
+	if keyed {
+		record.Key = fields[0]
+	}
+
+	// the last fields are TTL and version, value is what's left between key and them
+	value, ttl, version := parseLegacyFields(fields[1:], 2)
+	tail := 2
+	if version == "" {
+		// no version, TTL is the last field
+		value, ttl, _ = parseLegacyFields(fields[1:], 1)
+		tail = 1
+	}
+	if keyed && keyWithSpaces(fields, ttl != "", tail) {
+		return record, ErrLegacyKeyWithSpaces
+	}
+	if ttl == "" {
+		return record, fmt.Errorf("Unknown record format.")
+	}
+	record.Version, _ = strconv.ParseUint(version, 10, 64)
+	record.TTL, _ = strconv.ParseInt(ttl, 10, 64)
+	record.Value = []byte(value)
+	if record.TTL > 0 {
+		record.ExpiredAt = dateTime.Add(time.Second * time.Duration(record.TTL)).Unix()
+	}
+	return record, nil
+}
+
+// parseLegacyFields splits fields into JSON value and tail of numbers, empty TTL means they don't match the format.
+// Marshaled JSON has spaces only inside strings, so the only split giving valid JSON is the right one.
+func parseLegacyFields(fields []string, tail int) (value string, ttl string, version string) {
+	if len(fields) <= tail {
+		return "", "", ""
+	}
+	value = strings.Join(fields[:len(fields)-tail], " ")
+	if !json.Valid([]byte(value)) {
+		return "", "", ""
+	}
+	for _, field := range fields[len(fields)-tail:] {
+		if _, err := strconv.ParseInt(field, 10, 64); err != nil {
+			return "", "", ""
+		}
+	}
+	ttl = fields[len(fields)-tail]
+	if tail == 2 {
+		version = fields[len(fields)-1]
+	}
+	return value, ttl, version
+}
+
+// keyWithSpaces reports whether fields could be parsed with key taking several of them. Key is separated from
+// value by space only, so such line is either a key with spaces or ambiguous. If the line is parsed with tail of
+// the given length, only the same tail is checked, otherwise any. Line without version which key ends with
+// a number, like "set user 5 7 60", looks like a versioned one and isn't detected.
+func keyWithSpaces(fields []string, parsed bool, tail int) bool {
+	for i := 2; i < len(fields); i++ {
+		if _, ttl, _ := parseLegacyFields(fields[i:], tail); ttl != "" {
+			return true
+		}
+		if _, ttl, _ := parseLegacyFields(fields[i:], 3-tail); !parsed && ttl != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Op is a type of AOF record
+type Op byte
+
+// State of operation, operation is logged as pending before it's applied and as completed or failed after it
+type State byte
+
+const (
+	// OpSet sets JSON value
+	OpSet Op = iota + 1
+	// OpSetRaw sets opaque value kept as base64 string or object of raw.Typed
+	OpSetRaw
+	// OpSetStructure sets structure of Record.Type
+	OpSetStructure
+	OpDelete
+	// OpMSet keeps JSON list of BatchEntry in value
+	OpMSet
+	// OpMDelete keeps JSON list of keys in value
+	OpMDelete
+	// OpTx keeps JSON list of BatchEntry written by transaction in value
+	OpTx
+)
+
+const (
+	Pending State = iota + 1
+	Completed
+	Failed
+)
+
+// fileHeader starts every AOF file, the last byte is version of format
+var fileHeader = []byte("CACHERAOF\x01")
+
+// maxRecordSize protects from allocating memory for length read from corrupted file
+const maxRecordSize = 1 << 30
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	// ErrCorrupted is returned when record doesn't match its checksum
+	ErrCorrupted = errors.New("AOF record is corrupted.")
+	// ErrTruncated is returned when file ends in the middle of record, e.g. after crash while writing it
+	ErrTruncated = errors.New("AOF ends with partially written record.")
+	// ErrNotAOF is returned when file doesn't start with header of supported format
+	ErrNotAOF = errors.New("File is not AOF of supported version.")
+)
+
+// Record is an operation kept in AOF. Every record is framed by its length and CRC-32C checksum.
+type Record struct {
+	Op    Op
+	State State
+	// Timestamp is time of writing in nanoseconds since Unix epoch
+	Timestamp int64
+	// Namespace is empty for the default namespace
+	Namespace string
+	Key       string
+	// Type of structure set by OpSetStructure
+	Type string
+	// Value is JSON of value
+	Value []byte
+	TTL   int64
+	// ExpiredAt is absolute expiration time in seconds since Unix epoch, 0 means no expiration
+	ExpiredAt int64
+	Version   uint64
+}
+
+func (op Op) String() string {
+	switch op {
+	case OpSet:
+		return "set"
+	case OpSetRaw:
+		return "setraw"
+	case OpSetStructure:
+		return "setstructure"
+	case OpDelete:
+		return "delete"
+	case OpMSet:
+		return "mset"
+	case OpMDelete:
+		return "mdelete"
+	case OpTx:
+		return "tx"
+	}
+	return fmt.Sprintf("op(%d)", byte(op))
+}
+
+func (state State) String() string {
+	switch state {
+	case Pending:
+		return "pending"
+	case Completed:
+		return "completed"
+	case Failed:
+		return "failed"
+	}
+	return fmt.Sprintf("state(%d)", byte(state))
+}
+
+// encodeRecord returns record framed by length and checksum of its payload.
+// Payload is op, state, timestamp, TTL, expiration time, version and length-prefixed namespace, key, type and value.
+func encodeRecord(record Record) []byte {
+	payload := make([]byte, 0, 34+len(record.Namespace)+len(record.Key)+len(record.Type)+len(record.Value)+4*binary.MaxVarintLen64)
+	payload = append(payload, byte(record.Op), byte(record.State))
+	payload = appendUint64(payload, uint64(record.Timestamp))
+	payload = appendUint64(payload, uint64(record.TTL))
+	payload = appendUint64(payload, uint64(record.ExpiredAt))
+	payload = appendUint64(payload, record.Version)
+	for _, field := range [][]byte{[]byte(record.Namespace), []byte(record.Key), []byte(record.Type), record.Value} {
+		payload = appendBytes(payload, field)
+	}
+
+	frame := make([]byte, 8, 8+len(payload))
+	binary.LittleEndian.PutUint32(frame, uint32(len(payload)))
+	binary.LittleEndian.PutUint32(frame[4:], crc32.Checksum(payload, crcTable))
+	return append(frame, payload...)
+}
+
+func appendUint64(data []byte, value uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], value)
+	return append(data, buf[:]...)
+}
+
+func appendBytes(data []byte, field []byte) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(field)))
+	return append(append(data, buf[:n]...), field...)
+}
+
+func decodePayload(payload []byte) (record Record, err error) {
+	if len(payload) < 34 {
+		return record, ErrCorrupted
+	}
+	record.Op, record.State = Op(payload[0]), State(payload[1])
+	record.Timestamp = int64(binary.LittleEndian.Uint64(payload[2:]))
+	record.TTL = int64(binary.LittleEndian.Uint64(payload[10:]))
+	record.ExpiredAt = int64(binary.LittleEndian.Uint64(payload[18:]))
+	record.Version = binary.LittleEndian.Uint64(payload[26:])
+	rest := payload[34:]
+	fields := make([][]byte, 4)
+	for i := range fields {
+		size, n := binary.Uvarint(rest)
+		if n <= 0 || uint64(len(rest)-n) < size {
+			return record, ErrCorrupted
+		}
+		fields[i], rest = rest[n:n+int(size)], rest[n+int(size):]
+	}
+	record.Namespace, record.Key, record.Type = string(fields[0]), string(fields[1]), string(fields[2])
+	record.Value = fields[3]
+	return record, nil
+}
+
+// Reader reads records of AOF file one by one
+type Reader struct {
+	r *bufio.Reader
+	// offset is position of the next record in file
+	offset int64
+}
+
+// NewReader checks header of AOF file and returns reader of its records
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{r: bufio.NewReader(r)}
+	header := make([]byte, len(fileHeader))
+	n, err := io.ReadFull(reader.r, header)
+	if !bytes.HasPrefix(fileHeader, header[:n]) {
+		return nil, ErrNotAOF
+	}
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		// crashed while writing header of a new file
+		return nil, ErrTruncated
+	}
+	reader.offset = int64(len(fileHeader))
+	return reader, nil
+}
+
+// Next returns the next record, io.EOF means that all records are read.
+// ErrTruncated or ErrCorrupted is returned if the rest of file isn't a valid record, Offset tells where it starts.
+func (reader *Reader) Next() (Record, error) {
+	var frame [8]byte
+	if _, err := io.ReadFull(reader.r, frame[:]); err != nil {
+		if err == io.EOF {
+			return Record{}, io.EOF
+		}
+		return Record{}, readError(err)
+	}
+	size := binary.LittleEndian.Uint32(frame[:])
+	if size > maxRecordSize {
+		return Record{}, ErrCorrupted
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader.r, payload); err != nil {
+		return Record{}, readError(err)
+	}
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(frame[4:]) {
+		return Record{}, ErrCorrupted
+	}
+	record, err := decodePayload(payload)
+	if err != nil {
+		return record, err
+	}
+	reader.offset += int64(len(frame)) + int64(size)
+	return record, nil
+}
+
+// Offset returns position in file of the record which is going to be read by Next
+func (reader *Reader) Offset() int64 {
+	return reader.offset
+}
+
+// readError tells whether record is cut by the end of file
+func readError(err error) error {
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return ErrTruncated
+	}
+	return err
+}
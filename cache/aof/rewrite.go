@@ -0,0 +1,181 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	// ErrRewriteInProgress is returned when rewrite is requested while another one is running
+	ErrRewriteInProgress = errors.New("Background append only file rewriting already in progress.")
+	// ErrClosed is returned when AOF isn't opened by Init or it was closed while rewrite
+	ErrClosed = errors.New("Append only file is not opened.")
+)
+
+// Snapshot writes current keyspace into rewritten AOF
+type Snapshot struct {
+	output *bufio.Writer
+	now    time.Time
+	err    error
+}
+
+// Set writes value of key kept by namespace, expired values are skipped
+func (s *Snapshot) Set(namespace string, key string, value interface{}, expiredAt int64, version uint64) {
+	if s.err != nil {
+		return
+	}
+	var ttl int64
+	if expiredAt != 0 {
+		if ttl = expiredAt - s.now.Unix(); ttl <= 0 {
+			return
+		}
+	}
+	// restore replays completed operations
+	record := setRecord(key, value, ttl, version, Completed)
+	record.Namespace, record.Timestamp, record.ExpiredAt = namespace, s.now.UnixNano(), expiredAt
+	_, s.err = s.output.Write(encodeRecord(record))
+}
+
+// SetAutoRewrite makes AOF rewritten in background when it grows by percentage since it was opened or rewritten
+// and it's at least minSize bytes. Snapshot writes keyspace into the new file. Zero percentage disables it.
+func SetAutoRewrite(percentage int, minSize int64, snapshot func(*Snapshot) error) {
+	writer.Lock()
+	defer writer.Unlock()
+	writer.autoRewrite.percentage, writer.autoRewrite.minSize = percentage, minSize
+	writer.autoRewrite.snapshot = snapshot
+}
+
+// needsRewrite tells whether file grew enough for automatic rewrite, caller holds the lock
+func needsRewrite() bool {
+	auto := writer.autoRewrite
+	return auto.percentage > 0 && auto.snapshot != nil && !writer.rewriting && writer.size >= auto.minSize &&
+		writer.size >= writer.baseSize+writer.baseSize*int64(auto.percentage)/100
+}
+
+// Rewrite replaces AOF with a minimal one which keeps keyspace written by snapshot. Records written meanwhile
+// go to the current file and to buffer, which is appended to the new file before it atomically replaces the current one.
+func Rewrite(snapshot func(*Snapshot) error) error {
+	if err := beginRewrite(); err != nil {
+		return err
+	}
+	return rewrite(snapshot)
+}
+
+// RewriteInBackground starts Rewrite in goroutine, its errors are printed
+func RewriteInBackground(snapshot func(*Snapshot) error) error {
+	if err := beginRewrite(); err != nil {
+		return err
+	}
+	go rewriteInBackground(snapshot)
+	return nil
+}
+
+func rewriteInBackground(snapshot func(*Snapshot) error) {
+	if err := rewrite(snapshot); err != nil {
+		fmt.Println("AOF: error while rewriting file:", err)
+	}
+}
+
+// beginRewrite sets rewriting flag, so writes are buffered since then
+func beginRewrite() error {
+	writer.Lock()
+	defer writer.Unlock()
+	if writer.file == nil {
+		return ErrClosed
+	}
+	if writer.rewriting {
+		return ErrRewriteInProgress
+	}
+	writer.rewriting = true
+	return nil
+}
+
+// rewrite does rewriting started by caller, who has set rewriting flag
+func rewrite(snapshot func(*Snapshot) error) error {
+	writer.Lock()
+	original := writer.file
+	writer.Unlock()
+
+	temp := path + ".rewrite"
+	file, err := writeSnapshot(temp, snapshot)
+	if err != nil {
+		finishRewrite()
+		return err
+	}
+
+	// swapping of files blocks writers, so the syncer isn't waited with the lock held
+	stopSyncer()
+	writer.Lock()
+	defer writer.Unlock()
+	defer startSyncer()
+	if writer.file != original || original == nil {
+		file.Close()
+		os.Remove(temp)
+		writer.rewriting, writer.buffer = false, nil
+		return ErrClosed
+	}
+	if _, err = file.Write(writer.buffer); err == nil {
+		if err = file.Sync(); err == nil {
+			err = os.Rename(temp, path)
+		}
+	}
+	writer.rewriting, writer.buffer = false, nil
+	if err != nil {
+		file.Close()
+		os.Remove(temp)
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	original.Close()
+
+	writer.file, writer.dirty = file, false
+	if info, err := file.Stat(); err == nil {
+		writer.size, writer.baseSize = info.Size(), info.Size()
+	}
+	return nil
+}
+
+// writeSnapshot writes header and keyspace into temp file, returned file is synced and opened for appending
+func writeSnapshot(temp string, snapshot func(*Snapshot) error) (*os.File, error) {
+	file, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{output: bufio.NewWriter(file), now: time.Now()}
+	_, s.err = s.output.Write(fileHeader)
+	if err = snapshot(s); err == nil {
+		err = s.err
+	}
+	if err == nil {
+		err = s.output.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(temp)
+		return nil, err
+	}
+	return file, nil
+}
+
+func finishRewrite() {
+	writer.Lock()
+	defer writer.Unlock()
+	writer.rewriting, writer.buffer = false, nil
+}
+
+// syncDir makes rename of file durable
+func syncDir(name string) {
+	dir, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	dir.Sync()
+	dir.Close()
+}
@@ -0,0 +1,721 @@
+// Package arena_map keeps records serialized in large byte arenas indexed by key hash, like bigcache and freecache.
+// Index maps and arenas hold no pointers, so GC doesn't scan them no matter how many keys are stored,
+// that's why colliding keys are probed in the same index and Scan walks arenas instead of keeping keys ordered.
+// Overwritten and deleted records are only marked dead and shards are compacted once garbage outweighs live data.
+package arena_map
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"../batch"
+	"../counter"
+	"../raw"
+	"../scan"
+	"../types"
+	"../version"
+)
+
+// DefaultShards is number of shards used when it isn't configured
+const DefaultShards = 32
+
+// Layout of record header: flags, structure type, expiration time, version, key length, value length
+// and content type length. Key, content type and value follow the header.
+const (
+	flagsOffset          = 0
+	typeOffset           = 1
+	expiredAtOffset      = 2
+	versionOffset        = 10
+	keyLenOffset         = 18
+	valueLenOffset       = 22
+	contentTypeLenOffset = 26
+	headerSize           = 27
+)
+
+const (
+	flagDead byte = 1 << iota
+	flagOpaque
+	flagStructure
+)
+
+const (
+	// shard is compacted once it has at least minGarbage bytes of dead records and they outweigh live ones
+	minGarbage = 64 * 1024
+	// sweepFactor limits records without TTL passed by active expiration per sampled record
+	sweepFactor = 8
+)
+
+// structureTypes are stored in header by their index + 1
+var structureTypes = []string{types.HashType, types.ListType, types.SetType, types.SortedSetType}
+
+type (
+	// record is a decoded header with copies of key and value.
+	// Content type of opaque value is kept in arena unless it's raw.BinaryType.
+	record struct {
+		flags       byte
+		typeCode    byte
+		expiredAt   int64
+		version     uint64
+		key         string
+		contentType string
+		value       []byte
+	}
+
+	shard struct {
+		mu    sync.RWMutex
+		arena []byte
+		// index keeps offset of record by hash of its key, a key colliding with indexed ones takes
+		// the next free slot after its hash (linear probing)
+		index   map[uint64]uint64
+		garbage int
+		// sweep is offset in arena where active expiration continues
+		sweep int
+		// compactions tells Scan that offsets of its cursor aren't valid anymore
+		compactions uint64
+	}
+
+	Storage struct {
+		shards   []*shard
+		versions version.Counter
+		// next is the shard active expiration starts sampling from
+		next uint32
+	}
+)
+
+// New returns storage with n shards, DefaultShards is used if n isn't positive
+func New(n int) *Storage {
+	if n <= 0 {
+		n = DefaultShards
+	}
+	s := &Storage{shards: make([]*shard, n)}
+	for i := range s.shards {
+		s.shards[i] = &shard{index: make(map[uint64]uint64)}
+	}
+	return s
+}
+
+// hash is FNV-1a of key, it doesn't allocate unlike hash/fnv
+func hash(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+func (s *Storage) shardOf(h uint64) *shard {
+	return s.shards[h%uint64(len(s.shards))]
+}
+
+// lockKeys locks shards of keys in ascending order, so concurrent batches can't deadlock, and returns unlock
+func (s *Storage) lockKeys(keys []string) func() {
+	indexes := make([]int, 0, len(keys))
+	seen := make(map[int]bool, len(keys))
+	for _, key := range keys {
+		if i := int(hash(key) % uint64(len(s.shards))); !seen[i] {
+			seen[i] = true
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		s.shards[i].mu.Lock()
+	}
+	return func() {
+		for _, i := range indexes {
+			s.shards[i].mu.Unlock()
+		}
+	}
+}
+
+// NextVersion returns version for a record which is going to be written by Set or CompareAndSet
+func (s *Storage) NextVersion() uint64 {
+	return s.versions.Next()
+}
+
+// Set stores value with passed version, which is either got from NextVersion or restored from persistence
+func (s *Storage) Set(key string, value interface{}, ttl int64, version uint64) error {
+	r, err := newRecord(key, value, ttl, version)
+	if err != nil {
+		return err
+	}
+	s.versions.Observe(version)
+
+	h := hash(key)
+	sh := s.shardOf(h)
+	sh.mu.Lock()
+	sh.store(h, r)
+	sh.mu.Unlock()
+	return nil
+}
+
+// CompareAndSet stores value only if the key has expected version, 0 means that key has to be missed
+func (s *Storage) CompareAndSet(key string, value interface{}, ttl int64, expected uint64, version uint64) (bool, error) {
+	r, err := newRecord(key, value, ttl, version)
+	if err != nil {
+		return false, err
+	}
+
+	h := hash(key)
+	sh := s.shardOf(h)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	current, found := sh.get(h, key, time.Now().Unix())
+	if !found {
+		current = record{}
+	}
+	if current.version != expected {
+		return false, nil
+	}
+	sh.store(h, r)
+	return true, nil
+}
+
+// Get returns value, expiration time and version of the key
+func (s *Storage) Get(key string) (interface{}, int64, uint64, bool, error) {
+	h := hash(key)
+	sh := s.shardOf(h)
+	sh.mu.RLock()
+	r, found := sh.get(h, key, time.Now().Unix())
+	sh.mu.RUnlock()
+	if !found {
+		return nil, 0, 0, false, nil
+	}
+	return r.decode()
+}
+
+// GetRaw returns bytes kept for value of the key without decoding them, with expiration time and version
+func (s *Storage) GetRaw(key string) (raw.Typed, int64, uint64, bool, error) {
+	h := hash(key)
+	sh := s.shardOf(h)
+	sh.mu.RLock()
+	r, found := sh.get(h, key, time.Now().Unix())
+	sh.mu.RUnlock()
+	if !found {
+		return raw.Typed{}, 0, 0, false, nil
+	}
+	return raw.Typed{ContentType: r.contentType, Data: r.value}, r.expiredAt, r.version, true, nil
+}
+
+// MGet returns items of keys in the same order, every key is read under the lock of its shard
+func (s *Storage) MGet(keys []string) ([]batch.Item, error) {
+	now := time.Now().Unix()
+	items := make([]batch.Item, len(keys))
+	for i, key := range keys {
+		items[i].Key = key
+		h := hash(key)
+		sh := s.shardOf(h)
+		sh.mu.RLock()
+		r, found := sh.get(h, key, now)
+		sh.mu.RUnlock()
+		if !found {
+			continue
+		}
+		var err error
+		items[i].Value, items[i].ExpiredAt, items[i].Version, items[i].Found, err = r.decode()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// MSet stores all items with their TTL and version holding locks of their shards at once,
+// nothing is stored if any value is invalid
+func (s *Storage) MSet(items []batch.Item) error {
+	records := make([]record, len(items))
+	keys := make([]string, len(items))
+	for i, item := range items {
+		r, err := newRecord(item.Key, item.Value, item.TTL, item.Version)
+		if err != nil {
+			return err
+		}
+		records[i], keys[i] = r, item.Key
+		s.versions.Observe(item.Version)
+	}
+
+	unlock := s.lockKeys(keys)
+	for _, r := range records {
+		h := hash(r.key)
+		s.shardOf(h).store(h, r)
+	}
+	unlock()
+	return nil
+}
+
+// Apply atomically replaces items of keys with ones returned by fn, fn and applied are called under locks of their shards.
+// fn has to return items of passed keys only.
+func (s *Storage) Apply(keys []string, fn batch.ApplyFunc, applied batch.AppliedFunc) error {
+	unlock := s.lockKeys(keys)
+	defer unlock()
+	now := time.Now().Unix()
+	current := make([]batch.Item, len(keys))
+	for i, key := range keys {
+		current[i].Key = key
+		h := hash(key)
+		if r, found := s.shardOf(h).get(h, key, now); found {
+			var err error
+			current[i].Value, current[i].ExpiredAt, current[i].Version, current[i].Found, err = r.decode()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	writes, err := fn(current)
+	if err != nil {
+		return err
+	}
+
+	records := make([]record, len(writes))
+	for i, item := range writes {
+		if !item.Found {
+			continue
+		}
+		r, err := newRecord(item.Key, item.Value, 0, item.Version)
+		if err != nil {
+			return err
+		}
+		r.expiredAt = item.ExpiredAt
+		records[i] = r
+		s.versions.Observe(item.Version)
+	}
+	for i, item := range writes {
+		h := hash(item.Key)
+		if item.Found {
+			s.shardOf(h).store(h, records[i])
+		} else {
+			s.shardOf(h).remove(h, item.Key)
+		}
+	}
+	if applied != nil {
+		applied()
+	}
+	return nil
+}
+
+// Update atomically replaces structure stored at key with the one returned by fn, keeping its TTL.
+// Structure is decoded from the arena, so fn always gets a private copy.
+func (s *Storage) Update(key string, fn types.UpdateFunc) (stored types.Structure, expiredAt int64, version uint64, changed bool, err error) {
+	h := hash(key)
+	sh := s.shardOf(h)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	r, found := sh.get(h, key, time.Now().Unix())
+	if !found {
+		r = record{}
+	}
+	var current types.Structure
+	if r.flags&flagStructure != 0 {
+		value, _, _, _, err := r.decode()
+		if err != nil {
+			return nil, 0, 0, false, err
+		}
+		current = value.(types.Structure)
+	}
+	next, err := fn(current, r.expiredAt, found)
+	if err != nil || next == nil {
+		return nil, 0, 0, false, err
+	}
+	if next.Len() == 0 {
+		// empty structures are removed like in Redis
+		sh.remove(h, key)
+		return nil, 0, 0, found, nil
+	}
+	updated, err := newRecord(key, next, 0, s.versions.Next())
+	if err != nil {
+		return nil, 0, 0, false, err
+	}
+	updated.expiredAt = r.expiredAt
+	sh.store(h, updated)
+	return next, updated.expiredAt, updated.version, true, nil
+}
+
+// Incr atomically adds delta to the number stored at key, missed key counts as zero.
+// Key keeps its TTL unless ttl isn't 0.
+func (s *Storage) Incr(key string, delta counter.Delta, ttl int64) (interface{}, int64, uint64, error) {
+	now := time.Now()
+	h := hash(key)
+	sh := s.shardOf(h)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	r, found := sh.get(h, key, now.Unix())
+	if !found {
+		r = record{}
+	}
+	if r.flags&flagStructure != 0 {
+		return nil, 0, 0, types.WrongTypeError{}
+	}
+	var current interface{}
+	if found {
+		var err error
+		if current, err = raw.Decode(r.value, r.contentType); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	result, err := counter.Add(current, found, delta)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	updated, err := newRecord(key, result, ttl, s.versions.Next())
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if ttl == 0 {
+		updated.expiredAt = r.expiredAt
+	}
+	sh.store(h, updated)
+	return result, updated.expiredAt, updated.version, nil
+}
+
+func (s *Storage) Delete(key string) error {
+	h := hash(key)
+	sh := s.shardOf(h)
+	sh.mu.Lock()
+	sh.remove(h, key)
+	sh.mu.Unlock()
+	return nil
+}
+
+// MDelete removes keys holding locks of their shards at once and returns number of keys that existed
+func (s *Storage) MDelete(keys []string) (int, error) {
+	now := time.Now().Unix()
+	deleted := 0
+	unlock := s.lockKeys(keys)
+	for _, key := range keys {
+		h := hash(key)
+		sh := s.shardOf(h)
+		if _, found := sh.get(h, key, now); found {
+			deleted++
+		}
+		sh.remove(h, key)
+	}
+	unlock()
+	return deleted, nil
+}
+
+// GetKeys returns keys of all shards, every shard is read under its own lock
+func (s *Storage) GetKeys() ([]string, error) {
+	keys := make([]string, 0)
+	now := time.Now().Unix()
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		sh.walk(now, func(key string) { keys = append(keys, key) })
+		sh.mu.RUnlock()
+	}
+	return keys, nil
+}
+
+// Scan examines up to count records after cursor and returns keys of the live matching ones with cursor
+// of the next call. Arenas of shards are walked one after another, cursor keeps shard and offset in its arena,
+// so keys come in order of writes and a key overwritten during scan may be returned again.
+// Compaction moves records, so the shard compacted since the previous call is walked again from its start.
+func (s *Storage) Scan(cursor string, match func(key string) bool, count int) ([]string, string, error) {
+	return scan.Shards(len(s.shards), cursor, count, func(i int, from string, count int) ([]string, int, string, error) {
+		now := time.Now().Unix()
+		sh := s.shards[i]
+		sh.mu.RLock()
+		defer sh.mu.RUnlock()
+		offset, err := sh.position(from)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		var keys []string
+		examined := 0
+		for ; offset < len(sh.arena) && examined < count; offset += recordSize(sh.arena, offset) {
+			if !sh.bounded(offset) {
+				return nil, 0, "", scan.ErrInvalidCursor
+			}
+			examined++
+			if !sh.live(offset, now) {
+				continue
+			}
+			if key := string(recordKey(sh.arena, offset)); match(key) {
+				keys = append(keys, key)
+			}
+		}
+		if offset >= len(sh.arena) {
+			return keys, examined, "", nil
+		}
+		return keys, examined, strconv.FormatUint(sh.compactions, 10) + "." + strconv.Itoa(offset), nil
+	})
+}
+
+// DeleteExpired checks up to sampleSize keys with TTL and removes the expired ones. Every shard is swept
+// sequentially from where the previous call stopped, shards with enough garbage are compacted then.
+func (s *Storage) DeleteExpired(sampleSize int) (expired []string, sampled int) {
+	now := time.Now().Unix()
+	start := int(atomic.AddUint32(&s.next, 1)) % len(s.shards)
+	for i := 0; i < len(s.shards) && sampled < sampleSize; i++ {
+		sh := s.shards[(start+i)%len(s.shards)]
+		sh.mu.Lock()
+		if sh.sweep >= len(sh.arena) {
+			sh.sweep = 0
+		}
+		for passed := 0; sh.sweep < len(sh.arena) && sampled < sampleSize && passed < sampleSize*sweepFactor; passed++ {
+			offset := sh.sweep
+			sh.sweep += recordSize(sh.arena, offset)
+			flags := sh.arena[offset+flagsOffset]
+			expiredAt := int64(binary.LittleEndian.Uint64(sh.arena[offset+expiredAtOffset:]))
+			if flags&flagDead != 0 || expiredAt == 0 {
+				continue
+			}
+			sampled++
+			if now >= expiredAt {
+				key := string(recordKey(sh.arena, offset))
+				sh.remove(hash(key), key)
+				expired = append(expired, key)
+			}
+		}
+		sh.compactIfNeeded()
+		sh.mu.Unlock()
+	}
+	return expired, sampled
+}
+
+// get returns record of key unless it's missed or expired, caller holds the lock
+func (sh *shard) get(h uint64, key string, now int64) (record, bool) {
+	offset, _, found := sh.find(h, key)
+	if !found {
+		return record{}, false
+	}
+	r := readRecord(sh.arena, offset)
+	if r.expiredAt > 0 && now >= r.expiredAt {
+		return record{}, false
+	}
+	return r, true
+}
+
+// find returns offset of live record of key and its slot in index. Missed key gets the free slot
+// it has to be stored in. Caller holds the lock.
+func (sh *shard) find(h uint64, key string) (int, uint64, bool) {
+	for slot := h; ; slot++ {
+		offset, found := sh.index[slot]
+		if !found {
+			return 0, slot, false
+		}
+		if string(recordKey(sh.arena, int(offset))) == key {
+			return int(offset), slot, true
+		}
+	}
+}
+
+// store appends record to arena and points index to it, previous record of key becomes garbage.
+// Caller holds the lock.
+func (sh *shard) store(h uint64, r record) {
+	offset := uint64(len(sh.arena))
+	var header [headerSize]byte
+	header[flagsOffset] = r.flags
+	header[typeOffset] = r.typeCode
+	binary.LittleEndian.PutUint64(header[expiredAtOffset:], uint64(r.expiredAt))
+	binary.LittleEndian.PutUint64(header[versionOffset:], r.version)
+	binary.LittleEndian.PutUint32(header[keyLenOffset:], uint32(len(r.key)))
+	binary.LittleEndian.PutUint32(header[valueLenOffset:], uint32(len(r.value)))
+	var contentType string
+	if r.flags&flagOpaque != 0 && r.contentType != raw.BinaryType {
+		contentType = r.contentType
+	}
+	header[contentTypeLenOffset] = byte(len(contentType))
+
+	previous, slot, found := sh.find(h, r.key)
+	sh.arena = append(sh.arena, header[:]...)
+	sh.arena = append(sh.arena, r.key...)
+	sh.arena = append(sh.arena, contentType...)
+	sh.arena = append(sh.arena, r.value...)
+	if found {
+		sh.markDead(previous)
+	}
+	sh.index[slot] = offset
+	sh.compactIfNeeded()
+}
+
+// remove marks record of key as garbage and drops it from index, caller holds the lock.
+// Following slots of colliding keys are shifted back, so probing of them doesn't stop at the freed slot.
+func (sh *shard) remove(h uint64, key string) {
+	offset, slot, found := sh.find(h, key)
+	if !found {
+		return
+	}
+	sh.markDead(offset)
+	delete(sh.index, slot)
+	for next := slot + 1; ; next++ {
+		offset, found := sh.index[next]
+		if !found {
+			break
+		}
+		// key can take the freed slot if it lies between its hash and its current slot
+		if home := hash(string(recordKey(sh.arena, int(offset)))); next-home >= next-slot {
+			sh.index[slot] = offset
+			delete(sh.index, next)
+			slot = next
+		}
+	}
+	sh.compactIfNeeded()
+}
+
+func (sh *shard) markDead(offset int) {
+	sh.arena[offset+flagsOffset] |= flagDead
+	sh.garbage += recordSize(sh.arena, offset)
+}
+
+// walk calls fn with keys of live records which aren't expired, caller holds the lock
+func (sh *shard) walk(now int64, fn func(key string)) {
+	for offset := 0; offset < len(sh.arena); offset += recordSize(sh.arena, offset) {
+		if sh.live(offset, now) {
+			fn(string(recordKey(sh.arena, offset)))
+		}
+	}
+}
+
+// live tells whether record at offset is neither dead nor expired, caller holds the lock
+func (sh *shard) live(offset int, now int64) bool {
+	if sh.arena[offset+flagsOffset]&flagDead != 0 {
+		return false
+	}
+	expiredAt := int64(binary.LittleEndian.Uint64(sh.arena[offset+expiredAtOffset:]))
+	return expiredAt == 0 || now < expiredAt
+}
+
+// position returns offset in arena where scan continues from position of cursor, which is number of compactions
+// and offset. Offset given out before the latest compaction is dropped and the arena is walked from its start.
+func (sh *shard) position(from string) (int, error) {
+	if from == "" {
+		return 0, nil
+	}
+	i := strings.IndexByte(from, '.')
+	if i < 0 {
+		return 0, scan.ErrInvalidCursor
+	}
+	compactions, err := strconv.ParseUint(from[:i], 10, 64)
+	if err != nil {
+		return 0, scan.ErrInvalidCursor
+	}
+	offset, err := strconv.Atoi(from[i+1:])
+	if err != nil || offset < 0 {
+		return 0, scan.ErrInvalidCursor
+	}
+	if compactions != sh.compactions {
+		return 0, nil
+	}
+	return offset, nil
+}
+
+// bounded tells whether record at offset fits into arena, so offset of forged cursor doesn't read past it
+func (sh *shard) bounded(offset int) bool {
+	return offset+headerSize <= len(sh.arena) && offset+recordSize(sh.arena, offset) <= len(sh.arena)
+}
+
+// compactIfNeeded copies live records to a new arena and rebuilds index once garbage outweighs them.
+// Expired records are kept, they're removed by active expiration which reports their keys.
+func (sh *shard) compactIfNeeded() {
+	if sh.garbage < minGarbage || sh.garbage*2 < len(sh.arena) {
+		return
+	}
+	arena := make([]byte, 0, len(sh.arena)-sh.garbage)
+	index := make(map[uint64]uint64, len(sh.index))
+	for offset := 0; offset < len(sh.arena); {
+		size := recordSize(sh.arena, offset)
+		if sh.arena[offset+flagsOffset]&flagDead == 0 {
+			slot := hash(string(recordKey(sh.arena, offset)))
+			for _, taken := index[slot]; taken; _, taken = index[slot] {
+				slot++
+			}
+			index[slot] = uint64(len(arena))
+			arena = append(arena, sh.arena[offset:offset+size]...)
+		}
+		offset += size
+	}
+	sh.arena, sh.index = arena, index
+	sh.garbage, sh.sweep = 0, 0
+	sh.compactions++
+}
+
+func recordSize(arena []byte, offset int) int {
+	keyLen := binary.LittleEndian.Uint32(arena[offset+keyLenOffset:])
+	valueLen := binary.LittleEndian.Uint32(arena[offset+valueLenOffset:])
+	return headerSize + int(keyLen) + int(arena[offset+contentTypeLenOffset]) + int(valueLen)
+}
+
+// recordKey returns key of record without copying it
+func recordKey(arena []byte, offset int) []byte {
+	keyLen := int(binary.LittleEndian.Uint32(arena[offset+keyLenOffset:]))
+	return arena[offset+headerSize : offset+headerSize+keyLen]
+}
+
+// readRecord decodes header of record and copies its key and value out of arena
+func readRecord(arena []byte, offset int) record {
+	key := recordKey(arena, offset)
+	contentTypeOffset := offset + headerSize + len(key)
+	valueOffset := contentTypeOffset + int(arena[offset+contentTypeLenOffset])
+	valueLen := int(binary.LittleEndian.Uint32(arena[offset+valueLenOffset:]))
+	value := make([]byte, valueLen)
+	copy(value, arena[valueOffset:valueOffset+valueLen])
+	r := record{
+		flags:       arena[offset+flagsOffset],
+		typeCode:    arena[offset+typeOffset],
+		expiredAt:   int64(binary.LittleEndian.Uint64(arena[offset+expiredAtOffset:])),
+		version:     binary.LittleEndian.Uint64(arena[offset+versionOffset:]),
+		key:         string(key),
+		contentType: raw.JSONType,
+		value:       value,
+	}
+	if r.flags&flagOpaque != 0 {
+		r.contentType = raw.BinaryType
+		if contentTypeOffset < valueOffset {
+			r.contentType = string(arena[contentTypeOffset:valueOffset])
+		}
+	}
+	return r
+}
+
+// newRecord serializes value, structures are kept as JSON together with their type
+func newRecord(key string, value interface{}, ttl int64, version uint64) (record, error) {
+	r := record{key: key, version: version}
+	if structure, ok := value.(types.Structure); ok {
+		data, err := json.Marshal(structure)
+		if err != nil {
+			return r, err
+		}
+		r.value, r.flags, r.contentType = data, flagStructure, raw.JSONType
+		for i, typeName := range structureTypes {
+			if typeName == structure.Type() {
+				r.typeCode = byte(i + 1)
+			}
+		}
+	} else {
+		data, contentType, err := raw.Encode(value)
+		if err != nil {
+			return r, err
+		}
+		r.value, r.contentType = data, contentType
+		if contentType != raw.JSONType {
+			r.flags = flagOpaque
+		}
+	}
+	if ttl != 0 {
+		r.expiredAt = time.Now().Add(time.Second * time.Duration(ttl)).Unix()
+	}
+	return r, nil
+}
+
+// decode returns value of record, caller checks that it isn't expired
+func (r record) decode() (interface{}, int64, uint64, bool, error) {
+	var value interface{}
+	var err error
+	if r.flags&flagStructure != 0 {
+		value, err = types.Unmarshal(structureTypes[r.typeCode-1], r.value)
+	} else {
+		value, err = raw.Decode(r.value, r.contentType)
+	}
+	if err != nil {
+		return nil, 0, 0, false, err
+	}
+	return value, r.expiredAt, r.version, true, nil
+}
@@ -0,0 +1,19 @@
+// Package batch describes items of multi-key operations shared by storages and cache manager.
+package batch
+
+// Item is a key with its value. MSet takes TTL and version of items, MGet fills expiration time, version and Found.
+type Item struct {
+	Key       string
+	Value     interface{}
+	TTL       int64
+	ExpiredAt int64
+	Version   uint64
+	Found     bool
+}
+
+// ApplyFunc gets current items of keys, Found is false for missed keys. It returns items to write: item with Found
+// stores Value with ExpiredAt and Version, item without Found deletes the key. Error aborts, nothing is written then.
+type ApplyFunc func(current []Item) ([]Item, error)
+
+// AppliedFunc is called by Apply after items returned by ApplyFunc are written, while keys are still locked
+type AppliedFunc func()
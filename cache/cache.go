@@ -1,24 +1,56 @@
 package cache
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"hash/crc32"
 	l "log"
-	"strconv"
+	"regexp"
+	"sort"
+	"time"
 
 	"./aof"
+	am "./arena_map"
+	"./batch"
 	"./cdb"
+	"./counter"
+	"./eviction"
 	mm "./mutex_map"
+	"./raw"
+	shm "./sharded_map"
 	sm "./sync_map"
+	"./types"
 )
 
 type (
 	// Cache is the basic interface expected from the backing in-memory cache
 	Cache interface {
-		Set(key string, value interface{}, ttl int64) error
-		Get(key string) (interface{}, int64, bool, error)
+		// NextVersion returns a new version for Set and CompareAndSet, versions of records are never reused
+		NextVersion() uint64
+		Set(key string, value interface{}, ttl int64, version uint64) error
+		// CompareAndSet stores value only if current version of key is expected one, 0 stands for missed key
+		CompareAndSet(key string, value interface{}, ttl int64, expected uint64, version uint64) (bool, error)
+		// Get returns value, expiration time and version of key
+		Get(key string) (interface{}, int64, uint64, bool, error)
+		// GetRaw is Get returning bytes kept for value with their content type instead of decoding them
+		GetRaw(key string) (raw.Typed, int64, uint64, bool, error)
 		Delete(key string) error
 		GetKeys() ([]string, error)
+		// Scan examines up to count keys after cursor and returns the matching ones with cursor of the next call,
+		// see scan.Shards. Empty cursor starts scan and means that it's over.
+		Scan(cursor string, match func(key string) bool, count int) ([]string, string, error)
+		// multi-key operations, mutex-map takes its lock once per batch, sharded-map locks shards of keys at once
+		MGet(keys []string) ([]batch.Item, error)
+		MSet(items []batch.Item) error
+		MDelete(keys []string) (int, error)
+		// Apply atomically replaces items of keys with ones returned by fn, see batch.ApplyFunc.
+		// Applied isn't called if nothing is written because of error.
+		Apply(keys []string, fn batch.ApplyFunc, applied batch.AppliedFunc) error
+		// Update atomically changes structure stored at key, see types.UpdateFunc
+		Update(key string, fn types.UpdateFunc) (types.Structure, int64, uint64, bool, error)
+		// Incr atomically adds delta to number stored at key and returns the result, ttl 0 keeps TTL of the key
+		Incr(key string, delta counter.Delta, ttl int64) (interface{}, int64, uint64, error)
 	}
 
 	CacheManager struct {
@@ -26,19 +58,87 @@ type (
 		CDBEnabled bool
 		// prevent doubling records in AOF while restoring
 		RestoreMode bool
+
+		expirationStop chan struct{}
+		evictor        *eviction.Evictor
+		evictionPolicy string
+		watch          watchHub
+		scripts        scriptCache
+
+		// namespace is empty for the default one, named namespaces have own CDB keyspace and tagged AOF records
+		namespace  string
+		cacheType  string
+		aofEnabled bool
+		aofLog     aof.Log
+		keyspace   cdb.Keyspace
+		// defaultTTL is applied to values set without TTL, 0 means no expiration
+		defaultTTL int64
 	}
 
 	CacheManagerError struct {
 		cacheType string
 	}
+
+	// SetOptions make SetWithOptions conditional, zero value means plain Set
+	SetOptions struct {
+		// NX sets key only if it's missed, XX only if it exists
+		NX bool
+		XX bool
+		// Get asks to return value which was stored before
+		Get bool
+		// KeepTTL keeps TTL of existing key instead of passed one
+		KeepTTL bool
+	}
+
+	// VersionConflictError is returned by CompareAndSet when key was changed since the expected version
+	VersionConflictError struct {
+		key string
+	}
+
+	// InvalidNamespaceError is returned by NewNamespace for name which can't be used in CDB keys and AOF records
+	InvalidNamespaceError struct {
+		name string
+	}
 )
 
+var namespaceName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
 var log *l.Logger
 
+// shards is number of shards of sharded-map and arena-map storages, see SetShards
+var shards = shm.DefaultShards
+
 func (cme CacheManagerError) Error() string {
 	return fmt.Sprintf("Cache Provider '%s' is invalid.", cme.cacheType)
 }
 
+func (vce VersionConflictError) Error() string {
+	return fmt.Sprintf("Key '%s' was changed, version doesn't match.", vce.key)
+}
+
+func (ine InvalidNamespaceError) Error() string {
+	return fmt.Sprintf("Namespace name '%s' is invalid, only letters, digits, '_', '-' and '.' are allowed.", ine.name)
+}
+
+// SetShards sets number of shards of storages created afterwards, not positive n restores the default
+func SetShards(n int) {
+	if n <= 0 {
+		n = shm.DefaultShards
+	}
+	shards = n
+}
+
+// SetAppendFsync sets fsync policy of AOF initialized afterwards: "always", "everysec" or "no"
+func SetAppendFsync(policy string) error {
+	return aof.SetFsync(policy)
+}
+
+// SetAOFLoadTruncated sets whether partially written record at the end of AOF is truncated on start or cache
+// refuses to start with such file
+func SetAOFLoadTruncated(truncate bool) {
+	aof.SetLoadTruncated(truncate)
+}
+
 // New returns a new resources cache.
 func New(cacheType string, logger *l.Logger, CDBEnabled bool, CDBPeriod int, AOFEnabled bool) (manager *CacheManager, err error) {
 	log = logger
@@ -46,9 +146,24 @@ func New(cacheType string, logger *l.Logger, CDBEnabled bool, CDBPeriod int, AOF
 		cdb.Init(CDBPeriod, log)
 	}
 	if AOFEnabled {
-		aof.Init()
+		if err = aof.Init(); err != nil {
+			return nil, err
+		}
+	}
+
+	return newManager("", cacheType, CDBEnabled, AOFEnabled)
+}
+
+// NewNamespace returns manager of namespace with own storage of the same type. Its records are kept in own
+// CDB keyspace and tagged in AOF, so they're restored independently. Persistence has to be initialized by New.
+func (cm *CacheManager) NewNamespace(name string) (*CacheManager, error) {
+	if !namespaceName.MatchString(name) {
+		return nil, InvalidNamespaceError{name}
 	}
+	return newManager(name, cm.cacheType, cm.CDBEnabled, cm.aofEnabled)
+}
 
+func newManager(namespace string, cacheType string, CDBEnabled bool, AOFEnabled bool) (manager *CacheManager, err error) {
 	if cacheType == "mutex-map" {
 		manager = &CacheManager{
 			Provider: mm.New(),
@@ -57,9 +172,20 @@ func New(cacheType string, logger *l.Logger, CDBEnabled bool, CDBPeriod int, AOF
 		manager = &CacheManager{
 			Provider: sm.New(),
 		}
+	} else if cacheType == "sharded-map" {
+		manager = &CacheManager{
+			Provider: shm.New(shards),
+		}
+	} else if cacheType == "arena-map" {
+		manager = &CacheManager{
+			Provider: am.New(shards),
+		}
 	} else {
 		return nil, CacheManagerError{cacheType}
 	}
+	manager.namespace, manager.cacheType, manager.aofEnabled = namespace, cacheType, AOFEnabled
+	manager.aofLog, manager.keyspace = aof.Namespace(namespace), cdb.Namespace(namespace)
+	manager.SetWatchHistory(DefaultWatchHistory)
 
 	if CDBEnabled {
 		manager.CDBEnabled = true
@@ -68,22 +194,69 @@ func New(cacheType string, logger *l.Logger, CDBEnabled bool, CDBPeriod int, AOF
 
 	if AOFEnabled {
 		restoreFromAOF(manager)
+		registerAOF(manager)
 	}
 	return manager, nil
 }
 
+// Namespace returns name of namespace of manager, it's empty for the default namespace
+func (cm *CacheManager) Namespace() string {
+	return cm.namespace
+}
+
+// SetDefaultTTL sets TTL in seconds of values set without TTL, 0 means no expiration
+func (cm *CacheManager) SetDefaultTTL(ttl int64) {
+	cm.defaultTTL = ttl
+}
+
+// ttlOrDefault returns default TTL in place of 0
+func (cm *CacheManager) ttlOrDefault(ttl int64) int64 {
+	if ttl == 0 {
+		return cm.defaultTTL
+	}
+	return ttl
+}
+
+// Flush removes all keys of manager and returns their number
+func (cm *CacheManager) Flush() (int, error) {
+	keys, err := cm.Provider.GetKeys()
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+	return cm.MDelete(keys)
+}
+
 func restoreFromCDB(cm *CacheManager) {
 	cm.RestoreMode = true
 	counter := 0
+	now := time.Now().Unix()
 	iter := cdb.GetIterator()
 	for iter.Next() {
+		key, ok := cm.keyspace.Key(string(iter.Key()))
+		if !ok {
+			continue
+		}
 		record := new(cdb.Record)
 		err := json.Unmarshal([]byte(iter.Value()), &record)
 		if err != nil {
 			log.Printf("Error while unmarshaling CDB message: %s", err)
+			continue
 		}
 
-		cm.Set(string(iter.Key()), record.Value, record.ExpiredAt)
+		// CDB keeps absolute expiration time, skip records that expired while Cacher was down
+		var ttl int64
+		if record.ExpiredAt != 0 {
+			ttl = record.ExpiredAt - now
+			if ttl <= 0 {
+				continue
+			}
+		}
+		value, err := restoreValue(record.Value, record.Opaque, record.Type)
+		if err != nil {
+			log.Printf("Error while restoring CDB value of key '%s': %s", key, err)
+			continue
+		}
+		cm.restore(key, value, ttl, record.Version)
 		counter++
 	}
 	iter.Release()
@@ -95,6 +268,45 @@ func restoreFromCDB(cm *CacheManager) {
 	log.Printf("Restored %d records from CDB\n", counter)
 }
 
+// restoreValue converts value that was read from persistence back to raw.Bytes if it was opaque,
+// JSON keeps bytes as base64 string, or to structure if it has native type.
+// Opaque value with content type is kept as JSON object of raw.Typed.
+func restoreValue(value interface{}, opaque bool, typeName string) (interface{}, error) {
+	if typeName != "" {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return types.Unmarshal(typeName, data)
+	}
+	if !opaque {
+		return value, nil
+	}
+	if object, ok := value.(map[string]interface{}); ok {
+		data, err := json.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		var typed raw.Typed
+		err = json.Unmarshal(data, &typed)
+		return typed, err
+	}
+	encoded, _ := value.(string)
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	return raw.Bytes(data), err
+}
+
+// pendingOperation identifies pending AOF record to match it with its completed or failed record
+type pendingOperation struct {
+	op       aof.Op
+	key      string
+	version  uint64
+	checksum uint32
+}
+
+// restoreFromAOF replays completed operations in order they were written. Values keep absolute expiration time,
+// so ones that expired while Cacher was down are skipped. Operations which were pending without completion,
+// e.g. interrupted by crash, aren't applied and are reported.
 func restoreFromAOF(cm *CacheManager) {
 	cm.RestoreMode = true
 	counter := 0
@@ -103,72 +315,344 @@ func restoreFromAOF(cm *CacheManager) {
 		from = cdb.GetUpdatedAtTimestamp()
 	}
 
-	listCommands := aof.GetCommands(from)
-	for _, hash := range listCommands {
-		if hash["op"] == "set" {
-			ttl, _ := strconv.Atoi(hash["ttl"])
-			cm.Set(hash["key"], hash["value"], int64(ttl))
-		} else {
-			cm.Delete(hash["key"])
+	records, err := aof.GetRecords(from)
+	if err != nil {
+		log.Printf("Error while reading AOF, records after %d restored ones are skipped: %s", len(records), err)
+	}
+	pending := map[pendingOperation][]int{}
+	for i, record := range records {
+		if record.Namespace != cm.namespace {
+			continue
+		}
+		operation := pendingOperation{record.Op, record.Key, record.Version, crc32.ChecksumIEEE(record.Value)}
+		if record.State == aof.Pending {
+			pending[operation] = append(pending[operation], i)
+			continue
+		}
+		if indexes := pending[operation]; len(indexes) > 0 {
+			pending[operation] = indexes[1:]
+		}
+		if record.State != aof.Completed {
+			continue
+		}
+		if err := cm.restoreRecord(record); err != nil {
+			log.Printf("Error while restoring AOF %s: %s", describeRecord(record), err)
+			continue
 		}
-
 		counter++
 	}
 
 	cm.RestoreMode = false
 	log.Printf("Restored %d operations from AOF\n", counter)
+	reportPending(records, pending)
+}
+
+// restoreRecord applies completed operation
+func (cm *CacheManager) restoreRecord(record aof.Record) error {
+	written := record.Timestamp / int64(time.Second)
+	switch record.Op {
+	case aof.OpMSet:
+		entries, err := aof.DecodeBatch(record.Value)
+		if err != nil {
+			return err
+		}
+		return cm.restoreBatch(entries, written)
+	case aof.OpTx:
+		entries, err := aof.DecodeBatch(record.Value)
+		if err != nil {
+			return err
+		}
+		return cm.restoreTx(entries, written)
+	case aof.OpMDelete:
+		var keys []string
+		if err := json.Unmarshal(record.Value, &keys); err != nil {
+			return err
+		}
+		cm.MDelete(keys)
+	case aof.OpDelete:
+		cm.Delete(record.Key)
+	case aof.OpSet, aof.OpSetRaw, aof.OpSetStructure:
+		var value interface{}
+		err := json.Unmarshal(record.Value, &value)
+		if err == nil {
+			value, err = restoreValue(value, record.Op == aof.OpSetRaw, record.Type)
+		}
+		if err != nil {
+			return err
+		}
+		ttl, expired := restoredTTL(record.ExpiredAt, record.TTL, written)
+		if expired {
+			// value stored before, e.g. by CDB, is outdated as well
+			cm.Delete(record.Key)
+			return nil
+		}
+		cm.restore(record.Key, value, ttl, record.Version)
+	default:
+		return fmt.Errorf("Unknown operation.")
+	}
+	return nil
+}
+
+// describeRecord returns operation of AOF record with its key for log messages
+func describeRecord(record aof.Record) string {
+	if record.Key == "" {
+		return record.Op.String()
+	}
+	return fmt.Sprintf("%s of key '%s'", record.Op, record.Key)
+}
+
+// restoredTTL returns TTL left till absolute expiration time of restored value and whether it has expired.
+// Entries of AOF converted from log format may have only TTL, it's counted from time of writing then.
+func restoredTTL(expiredAt int64, ttl int64, written int64) (int64, bool) {
+	if expiredAt == 0 && ttl > 0 {
+		expiredAt = written + ttl
+	}
+	if expiredAt == 0 {
+		return 0, false
+	}
+	ttl = expiredAt - time.Now().Unix()
+	return ttl, ttl <= 0
+}
+
+// reportPending logs operations which were pending without completion
+func reportPending(records []aof.Record, pending map[pendingOperation][]int) {
+	var indexes []int
+	for _, remained := range pending {
+		indexes = append(indexes, remained...)
+	}
+	if len(indexes) == 0 {
+		return
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		written := time.Unix(0, records[i].Timestamp).Format(time.RFC3339Nano)
+		log.Printf("AOF %s written at %s was pending without completion, it isn't restored", describeRecord(records[i]), written)
+	}
+	log.Printf("%d operations of AOF were pending without completion\n", len(indexes))
+}
+
+// restore sets value read from persistence keeping its version, records persisted before versioning get a new one
+func (cm *CacheManager) restore(key string, value interface{}, ttl int64, version uint64) {
+	if version == 0 {
+		version = cm.Provider.NextVersion()
+	}
+	cm.set(key, value, ttl, version)
 }
 
 func (cm *CacheManager) Get(key string) (interface{}, int64, bool, error) {
-	value, expiredAt, found, err := cm.Provider.Get(key)
+	value, expiredAt, _, found, err := cm.GetVersioned(key)
+	return value, expiredAt, found, err
+}
+
+// GetVersioned returns value together with its version, which could be passed to CompareAndSet
+func (cm *CacheManager) GetVersioned(key string) (interface{}, int64, uint64, bool, error) {
+	value, expiredAt, version, found, err := cm.Provider.Get(key)
 	if err != nil {
 		log.Fatalf("Error while getting value for key %s: %s", key, err)
 	}
-	return value, expiredAt, found, err
+	if found && cm.evictor != nil {
+		cm.evictor.Touch(key)
+	}
+	return value, expiredAt, version, found, err
+}
+
+// GetRaw returns bytes kept for value of key with their content type, expiration time and version.
+// JSON values and structures are returned as JSON, so they can be written to response without decoding.
+func (cm *CacheManager) GetRaw(key string) (raw.Typed, int64, uint64, bool, error) {
+	value, expiredAt, version, found, err := cm.Provider.GetRaw(key)
+	if err != nil {
+		log.Fatalf("Error while getting value for key %s: %s", key, err)
+	}
+	if found && cm.evictor != nil {
+		cm.evictor.Touch(key)
+	}
+	return value, expiredAt, version, found, err
+}
+
+func (cm *CacheManager) Set(key string, value interface{}, ttl int64) error {
+	_, err := cm.SetVersioned(key, value, ttl)
+	return err
+}
+
+// SetVersioned sets value and returns its new version
+func (cm *CacheManager) SetVersioned(key string, value interface{}, ttl int64) (uint64, error) {
+	version := cm.Provider.NextVersion()
+	return version, cm.set(key, value, cm.ttlOrDefault(ttl), version)
+}
+
+// CompareAndSet sets value only if key still has passed version, 0 means that key has to be missed.
+// VersionConflictError is returned otherwise. Result is the new version of key.
+func (cm *CacheManager) CompareAndSet(key string, value interface{}, ttl int64, version uint64) (uint64, error) {
+	return cm.compareAndSet(key, value, cm.ttlOrDefault(ttl), version)
+}
+
+func (cm *CacheManager) compareAndSet(key string, value interface{}, ttl int64, version uint64) (uint64, error) {
+	if cm.evictor != nil {
+		if err := cm.reserve(key, value, ttl); err != nil {
+			return 0, err
+		}
+	}
+	newVersion := cm.Provider.NextVersion()
+	unlock := cm.lockEvents(key)
+	stored, err := cm.Provider.CompareAndSet(key, value, ttl, version, newVersion)
+	if err == nil && !stored {
+		err = VersionConflictError{key}
+	}
+	if err != nil {
+		unlock()
+		if cm.evictor != nil {
+			cm.reaccount(key)
+		}
+		return 0, err
+	}
+
+	// value is logged only once it's stored, so restore doesn't bring back rejected values
+	var expiredAt int64
+	if ttl != 0 {
+		expiredAt = time.Now().Add(time.Second * time.Duration(ttl)).Unix()
+	}
+	cm.persist(key, value, expiredAt, newVersion)
+	unlock()
+	return newVersion, nil
 }
 
-func (cm *CacheManager) Set(key string, value interface{}, ttl int64) (err error) {
+// SetWithOptions sets value according to options and returns its new version, 0 means that value wasn't stored
+// because of NX or XX. Previous value is returned if options.Get is set, nil stands for missed key.
+//
+// Options are atomic with both storages: checked state is read with its version and value is stored by
+// CompareAndSet only if the version wasn't changed (under the lock of mutex-map, by CompareAndSwap of sync-map),
+// otherwise options are checked again against the new state.
+func (cm *CacheManager) SetWithOptions(key string, value interface{}, ttl int64, options SetOptions) (uint64, interface{}, error) {
+	if options == (SetOptions{}) {
+		version, err := cm.SetVersioned(key, value, ttl)
+		return version, nil, err
+	}
+	if !options.KeepTTL {
+		ttl = cm.ttlOrDefault(ttl)
+	}
+	for {
+		current, expiredAt, version, found, err := cm.GetVersioned(key)
+		if err != nil {
+			return 0, nil, err
+		}
+		var previous interface{}
+		if options.Get {
+			previous = current
+		}
+		if (options.NX && found) || (options.XX && !found) {
+			return 0, previous, nil
+		}
+		if options.KeepTTL {
+			ttl = remainingTTL(expiredAt)
+		}
+		newVersion, err := cm.compareAndSet(key, value, ttl, version)
+		if _, ok := err.(VersionConflictError); ok {
+			continue
+		}
+		return newVersion, previous, err
+	}
+}
+
+func (cm *CacheManager) set(key string, value interface{}, ttl int64, version uint64) (err error) {
+	if cm.evictor != nil {
+		err = cm.reserve(key, value, ttl)
+		if err != nil {
+			return err
+		}
+	}
+	unlock := cm.lockEvents(key)
 	if !cm.RestoreMode {
-		aof.Write(key, value, ttl, "pending")
+		cm.aofLog.Write(key, value, ttl, version, aof.Pending)
 	}
-	err = cm.Provider.Set(key, value, ttl)
+	err = cm.Provider.Set(key, value, ttl, version)
 	if !cm.RestoreMode {
 		if err != nil {
-			aof.Write(key, value, ttl, "failed")
+			cm.aofLog.Write(key, value, ttl, version, aof.Failed)
 		} else {
-			aof.Write(key, value, ttl, "completed")
+			cm.aofLog.Write(key, value, ttl, version, aof.Completed)
 		}
 	}
 	if cm.CDBEnabled {
-		cdb.Set(key, value, ttl)
+		cm.keyspace.Set(key, value, ttl, version)
+	}
+	if err == nil {
+		var expiredAt int64
+		if ttl != 0 {
+			expiredAt = time.Now().Add(time.Second * time.Duration(ttl)).Unix()
+		}
+		cm.notify(EventSet, key, value, expiredAt)
+	}
+	unlock()
+	if err != nil && cm.evictor != nil {
+		cm.reaccount(key)
 	}
 	//TODO: retry in case of error
 	return err
 }
 
 func (cm *CacheManager) Delete(key string) (err error) {
+	unlock := cm.lockEvents(key)
+	defer unlock()
 	if !cm.RestoreMode {
-		aof.Delete(key, "pending")
+		cm.aofLog.Delete(key, aof.Pending)
 	}
 	err = cm.Provider.Delete(key)
+	if cm.evictor != nil {
+		cm.evictor.Remove(key)
+	}
 	if !cm.RestoreMode {
 		if err != nil {
-			aof.Delete(key, "failed")
+			cm.aofLog.Delete(key, aof.Failed)
 		} else {
-			aof.Delete(key, "completed")
+			cm.aofLog.Delete(key, aof.Completed)
 		}
 	}
 	if cm.CDBEnabled {
-		cdb.Delete(key)
+		cm.keyspace.Delete(key)
+	}
+	if err == nil {
+		cm.notify(EventDelete, key, nil, 0)
 	}
 	//TODO: retry in case of error
 	return err
 }
 
+// Expire atomically sets TTL of existing key keeping its value and version, TTL which isn't positive deletes the key.
+// Result tells whether key exists.
+func (cm *CacheManager) Expire(key string, ttl int64) (found bool, err error) {
+	err = cm.apply([]string{key}, func(current []Item) ([]Item, error) {
+		item := current[0]
+		if found = item.Found; !found {
+			return nil, nil
+		}
+		if ttl <= 0 {
+			return []Item{{Key: key}}, nil
+		}
+		item.ExpiredAt = time.Now().Unix() + ttl
+		return []Item{item}, nil
+	})
+	return found, err
+}
+
 func (cm *CacheManager) GetKeys() ([]string, error) {
 	return cm.Provider.GetKeys()
 }
 
+// dropPersisted removes from persistence a record that Cacher removed from provider on its own,
+// otherwise it would be brought back by restore.
+func (cm *CacheManager) dropPersisted(key string) {
+	if !cm.RestoreMode {
+		cm.aofLog.Delete(key, aof.Pending)
+		cm.aofLog.Delete(key, aof.Completed)
+	}
+	if cm.CDBEnabled {
+		cm.keyspace.Delete(key)
+	}
+}
+
 func Close() {
 	cdb.Close()
+	if err := aof.Close(); err != nil {
+		log.Printf("Error while closing AOF: %s", err)
+	}
 }
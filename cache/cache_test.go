@@ -1,10 +1,26 @@
 package cache
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	l "log"
+	"math"
+	"os"
+	"os/exec"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
+	"./aof"
+	"./counter"
+	"./raw"
+	"./types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -14,8 +30,11 @@ type (
 	}
 )
 
+// providers are storages every test of common behaviour runs with
+var providers = []string{"sync-map", "mutex-map", "sharded-map", "arena-map"}
+
 func TestNew(t *testing.T) {
-	for _, name := range []string{"sync-map", "mutex-map"} {
+	for _, name := range providers {
 		_, err := New(name, log, false, 60, false)
 		if err != nil {
 			t.Fatalf("Provider '%s' failed to init: %v", name, err)
@@ -28,155 +47,433 @@ func TestNew(t *testing.T) {
 }
 
 func TestGet(t *testing.T) {
-	provider, _ := New("sync-map", log, false, 60, false)
-	// test missed key
-	value, expiredAt, found, err := provider.Get("test")
-	assert.Nil(t, value)
-	assert.Equal(t, int64(0), expiredAt)
-	assert.False(t, found)
-	assert.Nil(t, err)
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		// test missed key
+		value, expiredAt, found, err := provider.Get("test")
+		assert.Nil(t, value, name)
+		assert.Equal(t, int64(0), expiredAt, name)
+		assert.False(t, found, name)
+		assert.Nil(t, err, name)
 
-	// prepare key/value pair
-	err = provider.Set("test", "value", 0)
-	if err != nil {
-		t.Fatalf("Error occurred while calling Set: %v", err)
+		// prepare key/value pair
+		err = provider.Set("test", "value", 0)
+		if err != nil {
+			t.Fatalf("%s: Error occurred while calling Set: %v", name, err)
+		}
+		// return existing value
+		value, expiredAt, found, err = provider.Get("test")
+		assert.Equal(t, "value", value, name)
+		assert.Equal(t, int64(0), expiredAt, name)
+		assert.True(t, found, name)
+		assert.Nil(t, err, name)
 	}
-	// return existing value
-	value, expiredAt, found, err = provider.Get("test")
-	assert.Equal(t, "value", value)
-	assert.Equal(t, int64(0), expiredAt)
-	assert.True(t, found)
-	assert.Nil(t, err)
 }
 
 func TestSet(t *testing.T) {
-	provider, _ := New("sync-map", log, false, 60, false)
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
 
-	// set int value
-	err := provider.Set("test", float64(100), 0)
-	if err != nil {
-		t.Fatalf("Error occurred while calling Set with int: %v", err)
+		// set int value
+		err := provider.Set("test", float64(100), 0)
+		if err != nil {
+			t.Fatalf("%s: Error occurred while calling Set with int: %v", name, err)
+		}
+		value, _, _, _ := provider.Get("test")
+		assert.Equal(t, float64(100), value, name)
+
+		// set string value
+		err = provider.Set("test", "100", 0)
+		if err != nil {
+			t.Fatalf("%s: Error occurred while calling Set with string value: %v", name, err)
+		}
+		value, _, _, _ = provider.Get("test")
+		assert.Equal(t, "100", value, name)
+
+		// set array value
+		valueArray := []interface{}{"1", "2"}
+		err = provider.Set("test", valueArray, 0)
+		if err != nil {
+			t.Fatalf("%s: Error occurred while calling Set with string value: %v", name, err)
+		}
+		value, _, _, _ = provider.Get("test")
+		assert.Equal(t, valueArray, value, name)
+
+		// set Map value
+		valueMap := map[string]interface{}{"1": "5"}
+		err = provider.Set("test", valueMap, 0)
+		if err != nil {
+			t.Fatalf("%s: Error occurred while calling Set with string value: %v", name, err)
+		}
+		value, _, _, _ = provider.Get("test")
+		assert.Equal(t, valueMap, value, name)
+
+		// validate value expiration after 1 sec
+		err = provider.Set("test", "value", 1)
+		if err != nil {
+			t.Fatalf("%s: Error occurred while calling Set: %v", name, err)
+		}
+
+		value, expiredAt, found, err := provider.Get("test")
+		assert.Equal(t, "value", value, name)
+		assert.NotEqual(t, int64(0), expiredAt, name)
+		assert.True(t, found, name)
+		assert.Nil(t, err, name)
+
+		// wait for timeout
+		//NOTE: bad approach to wait, better to manipulate with time ... quick workaround
+		time.Sleep(time.Second)
+		// check again
+		value, expiredAt, found, err = provider.Get("test")
+		assert.Nil(t, value, name)
+		assert.Equal(t, int64(0), expiredAt, name)
+		assert.False(t, found, name)
+		assert.Nil(t, err, name)
+
+		// call set twice
+		var valueString = "value"
+		provider.Set("test", valueString, 0)
+		v, _, _, _ := provider.Get("test")
+		assert.Equal(t, valueString, v, name)
+		// second call rewrite value and ttl
+		valueString = "value_2"
+		expectedTimestamp := time.Now().Add(time.Second * time.Duration(3600)).Unix()
+		provider.Set("test", valueString, 3600)
+		v, expiredAt, _, _ = provider.Get("test")
+		assert.Equal(t, valueString, v, name)
+		assert.LessOrEqual(t, expectedTimestamp, expiredAt, name)
 	}
-	value, _, _, _ := provider.Get("test")
-	assert.Equal(t, float64(100), value)
+}
 
-	// set string value
-	err = provider.Set("test", "100", 0)
-	if err != nil {
-		t.Fatalf("Error occurred while calling Set with string value: %v", err)
+func TestSetRaw(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		// raw bytes are stored as is and not decoded as JSON
+		value := raw.Bytes{0xff, 0x00, '"'}
+		err := provider.Set("test", value, 0)
+		if err != nil {
+			t.Fatalf("Error occurred while calling Set with raw value: %v", err)
+		}
+		v, _, found, err := provider.Get("test")
+		assert.True(t, found)
+		assert.Nil(t, err)
+		assert.Equal(t, value, v)
+
+		// regular values are decoded from JSON again
+		provider.Set("test", "value", 0)
+		v, _, _, _ = provider.Get("test")
+		assert.Equal(t, "value", v)
 	}
-	value, _, _, _ = provider.Get("test")
-	assert.Equal(t, "100", value)
+}
 
-	// set array value
-	valueArray := []interface{}{"1", "2"}
-	err = provider.Set("test", valueArray, 0)
-	if err != nil {
-		t.Fatalf("Error occurred while calling Set with string value: %v", err)
+func TestGetRaw(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		provider.Set("test_json", map[string]interface{}{"b": 1, "a": "x"}, 10)
+		provider.Set("test_bytes", raw.Bytes{0xff, 0x00}, 0)
+		provider.Set("test_text", raw.Typed{ContentType: "text/plain", Data: []byte("hello")}, 0)
+		provider.HSet("test_hash", map[string]string{"field": "value"})
+
+		value, expiredAt, version, found, err := provider.GetRaw("test_json")
+		assert.Nil(t, err, name)
+		assert.True(t, found, name)
+		assert.NotZero(t, expiredAt, name)
+		assert.NotZero(t, version, name)
+		assert.Equal(t, raw.Typed{ContentType: raw.JSONType, Data: []byte(`{"a":"x","b":1}`)}, value, name)
+		value, _, _, _, _ = provider.GetRaw("test_bytes")
+		assert.Equal(t, raw.Typed{ContentType: raw.BinaryType, Data: []byte{0xff, 0x00}}, value, name)
+		value, _, _, _, _ = provider.GetRaw("test_text")
+		assert.Equal(t, raw.Typed{ContentType: "text/plain", Data: []byte("hello")}, value, name)
+		value, _, _, _, _ = provider.GetRaw("test_hash")
+		assert.Equal(t, raw.Typed{ContentType: raw.JSONType, Data: []byte(`{"field":"value"}`)}, value, name)
+		_, _, _, found, _ = provider.GetRaw("missed")
+		assert.False(t, found, name)
+
+		// typed value is decoded back with its content type, binary and JSON ones as usual values
+		v, _, _, _ := provider.Get("test_text")
+		assert.Equal(t, raw.Typed{ContentType: "text/plain", Data: []byte("hello")}, v, name)
+		provider.Set("test_typed", raw.Typed{ContentType: raw.BinaryType, Data: []byte{0x01}}, 0)
+		v, _, _, _ = provider.Get("test_typed")
+		assert.Equal(t, raw.Bytes{0x01}, v, name)
+		provider.Set("test_typed", raw.Typed{ContentType: raw.JSONType, Data: []byte(`[1]`)}, 0)
+		v, _, _, _ = provider.Get("test_typed")
+		assert.Equal(t, []interface{}{float64(1)}, v, name)
+		err = provider.Set("test_typed", raw.Typed{ContentType: raw.JSONType, Data: []byte(`{`)}, 0)
+		assert.NotNil(t, err, name)
+
+		_, err = provider.Incr("test_text", 1, 0)
+		assert.Equal(t, counter.ErrNotInteger, err, name)
+		provider.Set("test_text", raw.Typed{ContentType: "text/plain", Data: []byte("10")}, 0)
+		result, err := provider.Incr("test_text", 1, 0)
+		assert.Nil(t, err, name)
+		assert.Equal(t, int64(11), result, name)
+		value, _, _, _, _ = provider.GetRaw("test_text")
+		assert.Equal(t, raw.Typed{ContentType: "text/plain", Data: []byte("11")}, value, name)
 	}
-	value, _, _, _ = provider.Get("test")
-	assert.Equal(t, valueArray, value)
+}
 
-	// set Map value
-	valueMap := map[string]interface{}{"1": "5"}
-	err = provider.Set("test", valueMap, 0)
-	if err != nil {
-		t.Fatalf("Error occurred while calling Set with string value: %v", err)
+func TestRestoreTyped(t *testing.T) {
+	// typed value is kept by AOF and CDB as JSON object, bytes as base64 string
+	typed := raw.Typed{ContentType: "image/png", Data: []byte{0x89, 'P', 'N', 'G'}}
+	for _, value := range []interface{}{typed, raw.Bytes{0x89, 'P', 'N', 'G'}} {
+		data, err := json.Marshal(value)
+		assert.Nil(t, err)
+		var decoded interface{}
+		json.Unmarshal(data, &decoded)
+		restored, err := restoreValue(decoded, true, "")
+		assert.Nil(t, err)
+		assert.Equal(t, value, restored)
 	}
-	value, _, _, _ = provider.Get("test")
-	assert.Equal(t, valueMap, value)
+}
 
-	// validate value expiration after 1 sec
-	err = provider.Set("test", "value", 1)
-	if err != nil {
-		t.Fatalf("Error occurred while calling Set: %v", err)
+func TestDelete(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+
+		// delete nonexistent keys
+		_, _, found, _ := provider.Get("test")
+		assert.False(t, found, name)
+		err := provider.Delete("test")
+		if err != nil {
+			t.Fatalf("%s: Error occurred while deleting nonexistent key: %v", name, err)
+		}
+
+		// delete existing key
+		err = provider.Set("test", "value", 0)
+		if err != nil {
+			t.Fatalf("%s: Error occurred while calling Set: %v", name, err)
+		}
+
+		err = provider.Delete("test")
+		if err != nil {
+			t.Fatalf("%s: Error occurred while deleting record: %v", name, err)
+		}
+		value, expiredAt, found, err := provider.Get("test")
+		assert.Nil(t, value, name)
+		assert.Equal(t, int64(0), expiredAt, name)
+		assert.False(t, found, name)
+		assert.Nil(t, err, name)
 	}
+}
 
-	value, expiredAt, found, err := provider.Get("test")
-	assert.Equal(t, "value", value)
-	assert.NotEqual(t, int64(0), expiredAt)
-	assert.True(t, found)
-	assert.Nil(t, err)
+func TestGetKeys(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		// check that cache is empty
+		keys, err := provider.GetKeys()
+		if err != nil {
+			t.Fatalf("%s: Error occurred while getting keys record: %v", name, err)
+		}
+		assert.Equal(t, []string{}, keys, name)
 
-	// wait for timeout
-	//NOTE: bad approach to wait, better to manipulate with time ... quick workaround
-	time.Sleep(time.Second)
-	// check again
-	value, expiredAt, found, err = provider.Get("test")
-	assert.Nil(t, value)
-	assert.Equal(t, int64(0), expiredAt)
-	assert.False(t, found)
-	assert.Nil(t, err)
+		err = provider.Set("test", "value", 0)
+		if err != nil {
+			t.Fatalf("%s: Error occurred while calling Set: %v", name, err)
+		}
+
+		err = provider.Set("test_array", "[1, 2, 3]", 0)
+		if err != nil {
+			t.Fatalf("%s: Error occurred while calling Set: %v", name, err)
+		}
 
-	// call set twice
-	var valueString = "value"
-	provider.Set("test", valueString, 0)
-	v, _, _, _ := provider.Get("test")
-	assert.Equal(t, valueString, v)
-	// second call rewrite value and ttl
-	valueString = "value_2"
-	expectedTimestamp := time.Now().Add(time.Second * time.Duration(3600)).Unix()
-	provider.Set("test", valueString, 3600)
-	v, expiredAt, _, _ = provider.Get("test")
-	assert.Equal(t, valueString, v)
-	assert.LessOrEqual(t, expectedTimestamp, expiredAt)
+		keys, err = provider.GetKeys()
+		if err != nil {
+			t.Fatalf("%s: Error occurred while getting keys record: %v", name, err)
+		}
 
+		assert.ElementsMatch(t, []string{"test", "test_array"}, keys, name)
+	}
 }
 
-func TestDelete(t *testing.T) {
-	provider, _ := New("sync-map", log, false, 60, false)
+func TestDeleteExpired(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		provider.Set("persistent", "value", 0)
+		provider.Set("expiring", "value", 1)
+		provider.Set("long_living", "value", 3600)
+		expirer := provider.Provider.(Expirer)
 
-	// delete nonexistent keys
-	_, _, found, _ := provider.Get("test")
-	assert.False(t, found)
-	err := provider.Delete("test")
-	if err != nil {
-		t.Fatalf("Error occurred while deleting nonexistent key: %v", err)
+		// nothing is expired yet, only keys with TTL are sampled
+		expired, sampled := expirer.DeleteExpired(20)
+		assert.Empty(t, expired, name)
+		assert.Equal(t, 2, sampled, name)
+
+		time.Sleep(time.Second)
+		// expired key is hidden from keys even before it's removed
+		keys, _ := provider.GetKeys()
+		assert.ElementsMatch(t, []string{"persistent", "long_living"}, keys, name)
+
+		expired, sampled = expirer.DeleteExpired(20)
+		assert.Equal(t, []string{"expiring"}, expired, name)
+		assert.Equal(t, 2, sampled, name)
+
+		// removed key doesn't stay in sample
+		_, sampled = expirer.DeleteExpired(20)
+		assert.Equal(t, 1, sampled, name)
+
+		// key that lost its TTL is excluded from sample
+		provider.Set("long_living", "value", 0)
+		_, sampled = expirer.DeleteExpired(20)
+		assert.Equal(t, 0, sampled, name)
 	}
+}
 
-	// delete existing key
-	err = provider.Set("test", "value", 0)
+func TestDeleteExpiredConcurrentTTL(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 2000; i++ {
+					// writers of the same key alternate setting and dropping TTL
+					provider.Set("test_"+strconv.Itoa(i%10), "value", int64((i+g)%2)*3600)
+				}
+			}(g)
+		}
+		wg.Wait()
+		withTTL := 0
+		for i := 0; i < 10; i++ {
+			if _, expiredAt, _, _ := provider.Get("test_" + strconv.Itoa(i)); expiredAt > 0 {
+				withTTL++
+			}
+		}
+
+		// the first pass drops keys which lost TTL, every key which kept it stays sampled
+		expirer := provider.Provider.(Expirer)
+		expirer.DeleteExpired(2000)
+		_, sampled := expirer.DeleteExpired(2000)
+		assert.Equal(t, withTTL, sampled, name)
+	}
+}
+
+func TestExpiration(t *testing.T) {
+	provider, _ := New("mutex-map", log, false, 60, false)
+	err := provider.StartExpiration(10*time.Millisecond, 0, 25)
+	assert.NotNil(t, err)
+	err = provider.StartExpiration(10*time.Millisecond, 20, 101)
+	assert.NotNil(t, err)
+
+	err = provider.StartExpiration(10*time.Millisecond, 5, 25)
 	if err != nil {
-		t.Fatalf("Error occurred while calling Set: %v", err)
+		t.Fatalf("Error occurred while starting expiration: %v", err)
 	}
+	defer provider.StopExpiration()
+	for i := 0; i < 100; i++ {
+		provider.Set("test_"+strconv.Itoa(i), i, 1)
+	}
+	provider.Set("persistent", "value", 0)
+
+	// sweeper removes expired records without anyone reading them
+	time.Sleep(1500 * time.Millisecond)
+	expirer := provider.Provider.(Expirer)
+	_, sampled := expirer.DeleteExpired(200)
+	assert.Equal(t, 0, sampled)
+	keys, _ := provider.GetKeys()
+	assert.Equal(t, []string{"persistent"}, keys)
+}
 
-	err = provider.Delete("test")
+func TestMemoryLimit(t *testing.T) {
+	provider, _ := New("mutex-map", log, false, 60, false)
+	err := provider.SetMemoryLimit(0, 2, "wrong_policy", 5)
+	assert.Equal(t, "Eviction policy 'wrong_policy' is invalid.", err.Error())
+
+	// "value" takes 7 bytes of JSON
+	provider.Set("test_1", "value", 0)
+	provider.Set("test_2", "value", 0)
+	err = provider.SetMemoryLimit(14, 0, "noeviction", 5)
 	if err != nil {
-		t.Fatalf("Error occurred while deleting record: %v", err)
+		t.Fatalf("Error occurred while setting memory limit: %v", err)
 	}
-	value, expiredAt, found, err := provider.Get("test")
-	assert.Nil(t, value)
-	assert.Equal(t, int64(0), expiredAt)
+	used, keys := provider.MemoryUsage()
+	assert.Equal(t, int64(14), used)
+	assert.Equal(t, 2, keys)
+
+	// overwriting with value of the same size is fine
+	err = provider.Set("test_1", "other", 0)
+	assert.Nil(t, err)
+	err = provider.Set("test_3", "value", 0)
+	assert.IsType(t, MemoryLimitError{}, err)
+	_, _, found, _ := provider.Get("test_3")
 	assert.False(t, found)
+
+	// deleted keys free memory
+	provider.Delete("test_2")
+	err = provider.Set("test_3", "value", 0)
 	assert.Nil(t, err)
+
+	// memory reserved for failed write is given back
+	provider.Provider = failingSet{provider.Provider}
+	err = provider.Set("test_4", "v", 0)
+	assert.NotNil(t, err)
+	used, keys = provider.MemoryUsage()
+	assert.Equal(t, int64(14), used)
+	assert.Equal(t, 2, keys)
 }
 
-func TestGetKeys(t *testing.T) {
-	provider, _ := New("sync-map", log, false, 60, false)
-	// check that cache is empty
-	keys, err := provider.GetKeys()
-	if err != nil {
-		t.Fatalf("Error occurred while getting keys record: %v", err)
-	}
-	assert.Equal(t, []string{}, keys)
+// failingSet is a storage which rejects every Set
+type failingSet struct {
+	Cache
+}
 
-	err = provider.Set("test", "value", 0)
-	if err != nil {
-		t.Fatalf("Error occurred while calling Set: %v", err)
-	}
+func (failingSet) Set(key string, value interface{}, ttl int64, version uint64) error {
+	return errors.New("storage is broken")
+}
 
-	err = provider.Set("test_array", "[1, 2, 3]", 0)
-	if err != nil {
-		t.Fatalf("Error occurred while calling Set: %v", err)
-	}
+func TestEvictionPolicies(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		provider.SetMemoryLimit(0, 3, "allkeys-lru", 10)
+		provider.Set("test_1", 1, 0)
+		provider.Set("test_2", 2, 0)
+		provider.Set("test_3", 3, 0)
+		provider.Get("test_1")
+		provider.Get("test_3")
+		provider.Set("test_4", 4, 0)
+		keys, _ := provider.GetKeys()
+		assert.ElementsMatch(t, []string{"test_1", "test_3", "test_4"}, keys, name)
 
-	keys, err = provider.GetKeys()
-	if err != nil {
-		t.Fatalf("Error occurred while getting keys record: %v", err)
-	}
+		provider, _ = New(name, log, false, 60, false)
+		provider.SetMemoryLimit(0, 3, "allkeys-lfu", 10)
+		provider.Set("test_1", 1, 0)
+		provider.Set("test_2", 2, 0)
+		provider.Set("test_3", 3, 0)
+		provider.Get("test_1")
+		provider.Get("test_2")
+		provider.Get("test_2")
+		provider.Set("test_4", 4, 0)
+		keys, _ = provider.GetKeys()
+		assert.ElementsMatch(t, []string{"test_1", "test_2", "test_4"}, keys, name)
+
+		provider, _ = New(name, log, false, 60, false)
+		provider.SetMemoryLimit(0, 3, "volatile-ttl", 10)
+		provider.Set("test_1", 1, 0)
+		provider.Set("test_2", 2, 3600)
+		provider.Set("test_3", 3, 60)
+		provider.Set("test_4", 4, 0)
+		keys, _ = provider.GetKeys()
+		assert.ElementsMatch(t, []string{"test_1", "test_2", "test_4"}, keys, name)
 
-	assert.ElementsMatch(t, []string{"test", "test_array"}, keys)
+		provider, _ = New(name, log, false, 60, false)
+		provider.SetMemoryLimit(0, 2, "volatile-lru", 10)
+		provider.Set("test_1", 1, 0)
+		provider.Set("test_2", 2, 0)
+		// nothing to evict among keys with TTL
+		err := provider.Set("test_3", 3, 0)
+		assert.IsType(t, MemoryLimitError{}, err, name)
+
+		provider, _ = New(name, log, false, 60, false)
+		provider.SetMemoryLimit(0, 2, "random", 10)
+		provider.Set("test_1", 1, 0)
+		provider.Set("test_2", 2, 0)
+		provider.Set("test_3", 3, 0)
+		keys, _ = provider.GetKeys()
+		assert.Len(t, keys, 2, name)
+		assert.Contains(t, keys, "test_3", name)
+	}
 }
+
 func BenchmarkGetMutexMap(b *testing.B) {
 	provider, _ := New("mutex-map", log, false, 60, false)
 	provider.Set("test_int", 1, 0)
@@ -254,3 +551,1563 @@ func BenchmarkGetKeysSyncMap(b *testing.B) {
 		provider.GetKeys()
 	}
 }
+
+func BenchmarkGetShardedMap(b *testing.B) {
+	provider, _ := New("sharded-map", log, false, 60, false)
+	provider.Set("test_int", 1, 0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		provider.Get("test_int")
+	}
+}
+
+func BenchmarkSetShardedMap(b *testing.B) {
+	provider, _ := New("sharded-map", log, false, 60, false)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		provider.Set("test_int_"+strconv.Itoa(i), i, 0)
+	}
+}
+
+func BenchmarkDeleteShardedMap(b *testing.B) {
+	provider, _ := New("sharded-map", log, false, 60, false)
+	for i := 0; i < b.N; i++ {
+		provider.Set("test_int_"+strconv.Itoa(i), i, 0)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		provider.Delete("test_int_" + strconv.Itoa(i))
+	}
+}
+
+func BenchmarkGetKeysShardedMap(b *testing.B) {
+	provider, _ := New("sharded-map", log, false, 60, false)
+	for i := 0; i < b.N; i++ {
+		provider.Set("test_int_"+strconv.Itoa(i), i, 0)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		provider.GetKeys()
+	}
+}
+
+func BenchmarkGetArenaMap(b *testing.B) {
+	provider, _ := New("arena-map", log, false, 60, false)
+	provider.Set("test_int", 1, 0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		provider.Get("test_int")
+	}
+}
+
+func BenchmarkSetArenaMap(b *testing.B) {
+	provider, _ := New("arena-map", log, false, 60, false)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		provider.Set("test_int_"+strconv.Itoa(i), i, 0)
+	}
+}
+
+func BenchmarkDeleteArenaMap(b *testing.B) {
+	provider, _ := New("arena-map", log, false, 60, false)
+	for i := 0; i < b.N; i++ {
+		provider.Set("test_int_"+strconv.Itoa(i), i, 0)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		provider.Delete("test_int_" + strconv.Itoa(i))
+	}
+}
+
+func BenchmarkGetKeysArenaMap(b *testing.B) {
+	provider, _ := New("arena-map", log, false, 60, false)
+	for i := 0; i < b.N; i++ {
+		provider.Set("test_int_"+strconv.Itoa(i), i, 0)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		provider.GetKeys()
+	}
+}
+
+// benchmarkParallelSetGet writes and reads different keys from all goroutines to compare lock contention
+func benchmarkParallelSetGet(b *testing.B, name string) {
+	provider, _ := New(name, log, false, 60, false)
+	var worker int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		prefix := "test_int_" + strconv.FormatInt(atomic.AddInt64(&worker, 1), 10) + "_"
+		for i := 0; pb.Next(); i++ {
+			key := prefix + strconv.Itoa(i%1000)
+			provider.Set(key, i, 0)
+			provider.Get(key)
+		}
+	})
+}
+
+func BenchmarkParallelSetGetMutexMap(b *testing.B) {
+	benchmarkParallelSetGet(b, "mutex-map")
+}
+
+func BenchmarkParallelSetGetSyncMap(b *testing.B) {
+	benchmarkParallelSetGet(b, "sync-map")
+}
+
+func BenchmarkParallelSetGetShardedMap(b *testing.B) {
+	benchmarkParallelSetGet(b, "sharded-map")
+}
+
+func BenchmarkParallelSetGetArenaMap(b *testing.B) {
+	benchmarkParallelSetGet(b, "arena-map")
+}
+
+// benchmarkParallelInsert creates new keys from all goroutines, so every write also adds its key to the scan index
+func benchmarkParallelInsert(b *testing.B, name string) {
+	provider, _ := New(name, log, false, 60, false)
+	var worker int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		prefix := "test_int_" + strconv.FormatInt(atomic.AddInt64(&worker, 1), 10) + "_"
+		for i := 0; pb.Next(); i++ {
+			provider.Set(prefix+strconv.Itoa(i), i, 0)
+		}
+	})
+}
+
+func BenchmarkParallelInsertMutexMap(b *testing.B) {
+	benchmarkParallelInsert(b, "mutex-map")
+}
+
+func BenchmarkParallelInsertSyncMap(b *testing.B) {
+	benchmarkParallelInsert(b, "sync-map")
+}
+
+func BenchmarkParallelInsertShardedMap(b *testing.B) {
+	benchmarkParallelInsert(b, "sharded-map")
+}
+
+func BenchmarkParallelInsertArenaMap(b *testing.B) {
+	benchmarkParallelInsert(b, "arena-map")
+}
+
+// benchmarkSetAOF shows throughput of sets logged to AOF synced by policy
+func benchmarkSetAOF(b *testing.B, policy string) {
+	os.RemoveAll("./data/aof")
+	SetAppendFsync(policy)
+	defer SetAppendFsync(aof.FsyncEverySec)
+	provider, _ := New("mutex-map", log, false, 60, true)
+	defer aof.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		provider.Set("test_int_"+strconv.Itoa(i), i, 0)
+	}
+}
+
+func BenchmarkSetAOFFsyncAlways(b *testing.B) {
+	benchmarkSetAOF(b, aof.FsyncAlways)
+}
+
+func BenchmarkSetAOFFsyncEverySec(b *testing.B) {
+	benchmarkSetAOF(b, aof.FsyncEverySec)
+}
+
+func BenchmarkSetAOFFsyncNo(b *testing.B) {
+	benchmarkSetAOF(b, aof.FsyncNo)
+}
+
+func TestShards(t *testing.T) {
+	for _, shards := range []int{1, 3, 0} {
+		SetShards(shards)
+		for _, name := range []string{"sharded-map", "arena-map"} {
+			provider, err := New(name, log, false, 60, false)
+			assert.Nil(t, err)
+			for i := 0; i < 50; i++ {
+				provider.Set("test_"+strconv.Itoa(i), i, 0)
+			}
+			keys, _ := provider.GetKeys()
+			assert.Len(t, keys, 50, name)
+			deleted, _ := provider.MDelete([]string{"test_1", "test_2", "test_3", "missed"})
+			assert.Equal(t, 3, deleted, name)
+			page, cursor, _ := provider.Scan(ScanEnd, "test_*", 100)
+			assert.Len(t, page, 47, name)
+			assert.Equal(t, ScanEnd, cursor, name)
+		}
+	}
+}
+
+func TestArenaMapCompaction(t *testing.T) {
+	SetShards(1)
+	defer SetShards(0)
+	provider, _ := New("arena-map", log, false, 60, false)
+	value := strings.Repeat("x", 1024)
+	for i := 0; i < 500; i++ {
+		provider.Set("test_"+strconv.Itoa(i%10), value+strconv.Itoa(i), 0)
+		provider.Set("test_ttl_"+strconv.Itoa(i%10), i, 1)
+		provider.Delete("test_deleted")
+		provider.Set("test_deleted", i, 0)
+	}
+	provider.HSet("test_hash", map[string]string{"field": "value"})
+
+	for i := 0; i < 10; i++ {
+		stored, _, found, _ := provider.Get("test_" + strconv.Itoa(i))
+		assert.True(t, found)
+		assert.Equal(t, value+strconv.Itoa(490+i), stored)
+	}
+	keys, _ := provider.GetKeys()
+	assert.Len(t, keys, 22)
+
+	// scan goes on over compaction, which moves records, and still returns every key at least once
+	seen := make(map[string]bool)
+	cursor := ScanEnd
+	for i := 0; ; i++ {
+		page, next, err := provider.Scan(cursor, "", 5)
+		assert.NoError(t, err)
+		for _, key := range page {
+			seen[key] = true
+		}
+		if i == 0 {
+			for j := 0; j < 200; j++ {
+				provider.Set("test_"+strconv.Itoa(j%10), value+strconv.Itoa(500+j), 0)
+			}
+		}
+		if next == ScanEnd {
+			break
+		}
+		cursor = next
+	}
+	assert.Len(t, seen, 22)
+
+	time.Sleep(time.Second * 2)
+	expired, sampled := provider.Provider.(Expirer).DeleteExpired(100)
+	assert.Len(t, expired, 10)
+	assert.Equal(t, 10, sampled)
+	keys, _ = provider.GetKeys()
+	assert.Len(t, keys, 12)
+	fields, _ := provider.HGetAll("test_hash")
+	assert.Equal(t, map[string]string{"field": "value"}, fields)
+}
+
+func TestWatch(t *testing.T) {
+	provider, _ := New("mutex-map", log, false, 60, false)
+	events, cancel := provider.Watch("user:")
+	provider.Set("other", 1, 0)
+	provider.Set("user:1", "name", 3600)
+	provider.Delete("user:1")
+
+	event := <-events
+	assert.Equal(t, EventSet, event.Type)
+	assert.Equal(t, "user:1", event.Key)
+	assert.Equal(t, "name", event.Value)
+	assert.NotEqual(t, int64(0), event.ExpiredAt)
+	event = <-events
+	assert.Equal(t, EventDelete, event.Type)
+	assert.Equal(t, "user:1", event.Key)
+	assert.Nil(t, event.Value)
+
+	// evicted keys are reported too
+	provider.SetMemoryLimit(0, 1, "allkeys-lru", 5)
+	provider.Set("user:2", 2, 0)
+	provider.Set("user:3", 3, 0)
+	assert.Equal(t, EventSet, (<-events).Type)
+	event = <-events
+	assert.Equal(t, EventEvicted, event.Type)
+	assert.Equal(t, "user:2", event.Key)
+	assert.Equal(t, EventSet, (<-events).Type)
+
+	cancel()
+	_, ok := <-events
+	assert.False(t, ok)
+
+	// watcher that doesn't read events is dropped
+	events, _ = provider.Watch("")
+	for i := 0; i < watchBuffer+1; i++ {
+		provider.Set("test", i, 0)
+	}
+	assert.Len(t, events, watchBuffer)
+	for range events {
+	}
+}
+
+func TestWatchFrom(t *testing.T) {
+	provider, _ := New("sync-map", log, false, 60, false)
+	provider.SetWatchHistory(3)
+	assert.Equal(t, uint64(0), provider.EventCursor())
+	provider.Set("user:1", 1, 0)
+	cursor := provider.EventCursor()
+	provider.Set("other", 2, 0)
+	provider.Delete("user:1")
+
+	backlog, events, cancel, missed := provider.WatchFrom("user:*", cursor)
+	assert.False(t, missed)
+	assert.Len(t, backlog, 1)
+	assert.Equal(t, EventDelete, backlog[0].Type)
+	assert.Equal(t, cursor+2, backlog[0].Seq)
+	assert.False(t, backlog[0].Timestamp.IsZero())
+	provider.Set("user:2", 2, 0)
+	event := <-events
+	assert.Equal(t, "user:2", event.Key)
+	assert.Equal(t, cursor+3, event.Seq)
+	cancel()
+
+	// history keeps only 3 latest events
+	events2, missed := provider.Events("", 0)
+	assert.True(t, missed)
+	assert.Len(t, events2, 3)
+	assert.Equal(t, "other", events2[0].Key)
+	_, missed = provider.Events("", cursor)
+	assert.False(t, missed)
+
+	// cursor from future, e.g. issued before restart
+	events2, missed = provider.Events("", cursor+100)
+	assert.True(t, missed)
+	assert.Empty(t, events2)
+
+	// history doesn't keep values
+	events2, _ = provider.Events("user:*", 0)
+	assert.Equal(t, EventSet, events2[len(events2)-1].Type)
+	assert.Nil(t, events2[len(events2)-1].Value)
+
+	// without history and watchers events aren't recorded
+	provider.SetWatchHistory(0)
+	cursor = provider.EventCursor()
+	provider.Set("user:3", 3, 0)
+	assert.Equal(t, cursor, provider.EventCursor())
+}
+
+func TestWatchSeqFollowsWrites(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		events, cancel := provider.Watch("")
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					provider.Set("key", strconv.Itoa(i*100+j), 0)
+				}
+			}(i)
+		}
+		wg.Wait()
+		cancel()
+
+		// the event with the highest Seq has to carry the stored value
+		var last Event
+		for event := range events {
+			if event.Seq > last.Seq {
+				last = event
+			}
+		}
+		value, _, _, _ := provider.Get("key")
+		assert.Equal(t, value, last.Value, name)
+	}
+}
+
+func TestScan(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		for i := 0; i < 100; i++ {
+			provider.Set("user:"+strconv.Itoa(i), i, 0)
+		}
+		provider.Set("other", 1, 0)
+		provider.Set("", 1, 0)
+
+		// keys present during the whole scan are returned once, though other keys are changed
+		seen := make(map[string]int)
+		cursor := ScanEnd
+		for i := 0; ; i++ {
+			keys, next, err := provider.Scan(cursor, "user:*", 7)
+			assert.NoError(t, err, name)
+			assert.True(t, len(keys) <= 7, name)
+			for _, key := range keys {
+				seen[key]++
+			}
+			provider.Set("user:new"+strconv.Itoa(i), i, 0)
+			provider.Delete("user:new" + strconv.Itoa(i-1))
+			if next == ScanEnd {
+				break
+			}
+			cursor = next
+		}
+		for i := 0; i < 100; i++ {
+			assert.Equal(t, 1, seen["user:"+strconv.Itoa(i)], name)
+		}
+		assert.Equal(t, 0, seen["other"], name)
+
+		keys, cursor, _ := provider.Scan("", "", 1000)
+		assert.Equal(t, ScanEnd, cursor, name)
+		assert.Len(t, keys, 103, name)
+		assert.Contains(t, keys, "", name)
+
+		// count limits examined keys, so expired, deleted and not matching keys make pages shorter
+		expired := time.Now().Unix() - 1
+		provider.Provider.Apply([]string{"a:1", "a:2"}, func(current []Item) ([]Item, error) {
+			return []Item{
+				{Key: "a:1", Value: 1, ExpiredAt: expired, Version: provider.Provider.NextVersion(), Found: true},
+				{Key: "a:2", Value: 2, ExpiredAt: expired, Version: provider.Provider.NextVersion(), Found: true},
+			}, nil
+		}, nil)
+		for _, key := range []string{"a:3", "a:4", "a:5", "a:6"} {
+			provider.Set(key, 1, 0)
+		}
+		provider.Delete("a:4")
+		var matched []string
+		calls := 0
+		for cursor = ScanEnd; ; calls++ {
+			keys, cursor, _ = provider.Scan(cursor, "a:*", 1)
+			assert.True(t, len(keys) <= 1, name)
+			matched = append(matched, keys...)
+			if cursor == ScanEnd {
+				break
+			}
+		}
+		assert.ElementsMatch(t, []string{"a:3", "a:5", "a:6"}, matched, name)
+		assert.True(t, calls >= 106, name)
+
+		_, _, err := provider.Scan("not base64!", "", 10)
+		assert.IsType(t, InvalidCursorError{}, err, name)
+
+		keys, _ = provider.Keys("user:9?")
+		assert.Len(t, keys, 10, name)
+		_, err = provider.Keys("user:[")
+		assert.IsType(t, InvalidPatternError{}, err, name)
+	}
+}
+
+func TestGlobMatcher(t *testing.T) {
+	cases := []struct {
+		pattern string
+		key     string
+		matched bool
+	}{
+		{"user:*", "user:1/profile", true},
+		{"*/profile", "user:1/profile", true},
+		{"user:?", "user:12", false},
+		{"user:??", "user:12", true},
+		{"*:*:*", "a:b", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"h[ae]llo", "hello", true},
+		{"h[^e]llo", "hello", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[c-a]llo", "hbllo", true},
+		{"h[a-c]llo", "hello", false},
+		{"a\\*b", "a*b", true},
+		{"a\\*b", "axb", false},
+		{"[\\]]", "]", true},
+		{"", "anything", true},
+		{"*", "", true},
+	}
+	for _, c := range cases {
+		match, err := globMatcher(c.pattern)
+		assert.NoError(t, err, c.pattern)
+		assert.Equal(t, c.matched, match(c.key), c.pattern+" "+c.key)
+	}
+	for _, pattern := range []string{"[abc", "abc\\", "[a\\", "[^"} {
+		assert.IsType(t, InvalidPatternError{}, ValidatePattern(pattern), pattern)
+	}
+}
+
+func TestHashes(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		added, err := provider.HSet("test", map[string]string{"a": "1", "b": "2"})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, added, name)
+		added, _ = provider.HSet("test", map[string]string{"a": "3", "c": "4"})
+		assert.Equal(t, 1, added, name)
+
+		value, found, _ := provider.HGet("test", "a")
+		assert.True(t, found)
+		assert.Equal(t, "3", value, name)
+		_, found, _ = provider.HGet("test", "missed")
+		assert.False(t, found)
+
+		removed, _ := provider.HDel("test", "a", "missed")
+		assert.Equal(t, 1, removed, name)
+		hash, _ := provider.HGetAll("test")
+		assert.Equal(t, map[string]string{"b": "2", "c": "4"}, hash, name)
+
+		// key is removed together with the last field
+		provider.HDel("test", "b", "c")
+		_, _, found, _ = provider.Get("test")
+		assert.False(t, found, name)
+	}
+}
+
+func TestLists(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		length, err := provider.RPush("test", "b", "c")
+		assert.Nil(t, err)
+		assert.Equal(t, 2, length, name)
+		length, _ = provider.LPush("test", "a", "z")
+		assert.Equal(t, 4, length, name)
+
+		values, _ := provider.LRange("test", 0, -1)
+		assert.Equal(t, []string{"z", "a", "b", "c"}, values, name)
+		values, _ = provider.LRange("test", -2, 10)
+		assert.Equal(t, []string{"b", "c"}, values, name)
+		values, _ = provider.LRange("test", 3, 1)
+		assert.Equal(t, []string{}, values, name)
+
+		value, found, _ := provider.LPop("test")
+		assert.True(t, found)
+		assert.Equal(t, "z", value, name)
+		value, _, _ = provider.RPop("test")
+		assert.Equal(t, "c", value, name)
+		provider.RPop("test")
+		provider.RPop("test")
+		_, found, _ = provider.LPop("test")
+		assert.False(t, found, name)
+		keys, _ := provider.GetKeys()
+		assert.Empty(t, keys, name)
+	}
+}
+
+func TestSets(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		added, err := provider.SAdd("test", "c", "a", "b", "a")
+		assert.Nil(t, err)
+		assert.Equal(t, 3, added, name)
+		removed, _ := provider.SRem("test", "b", "missed")
+		assert.Equal(t, 1, removed, name)
+		members, _ := provider.SMembers("test")
+		assert.Equal(t, []string{"a", "c"}, members, name)
+
+		provider.SAdd("other", "a", "b", "c")
+		members, _ = provider.SInter("test", "other")
+		assert.Equal(t, []string{"a", "c"}, members, name)
+		members, _ = provider.SInter("test", "missed")
+		assert.Equal(t, []string{}, members, name)
+	}
+}
+
+func TestSortedSets(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		added, err := provider.ZAdd("test",
+			types.ZMember{Member: "c", Score: 3},
+			types.ZMember{Member: "a", Score: 1},
+			types.ZMember{Member: "b", Score: 1},
+			types.ZMember{Member: "max", Score: math.Inf(1)})
+		assert.Nil(t, err)
+		assert.Equal(t, 4, added, name)
+		// score update moves member
+		added, _ = provider.ZAdd("test", types.ZMember{Member: "a", Score: 5})
+		assert.Equal(t, 0, added, name)
+
+		members, _ := provider.ZRange("test", 0, -1)
+		assert.Equal(t, []types.ZMember{{Member: "b", Score: 1}, {Member: "c", Score: 3}, {Member: "a", Score: 5}, {Member: "max", Score: math.Inf(1)}}, members, name)
+		members, _ = provider.ZRange("test", 1, 1)
+		assert.Equal(t, []types.ZMember{{Member: "c", Score: 3}}, members, name)
+
+		min, _ := types.ParseScoreBound("(1")
+		max, _ := types.ParseScoreBound("5")
+		members, _ = provider.ZRangeByScore("test", min, max)
+		assert.Equal(t, []types.ZMember{{Member: "c", Score: 3}, {Member: "a", Score: 5}}, members, name)
+		min, _ = types.ParseScoreBound("-inf")
+		max, _ = types.ParseScoreBound("(3")
+		members, _ = provider.ZRangeByScore("test", min, max)
+		assert.Equal(t, []types.ZMember{{Member: "b", Score: 1}}, members, name)
+	}
+}
+
+func TestWrongType(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		provider.Set("plain", "value", 0)
+		provider.SAdd("set", "a")
+
+		_, err := provider.HSet("plain", map[string]string{"a": "1"})
+		assert.IsType(t, WrongTypeError{}, err, name)
+		_, err = provider.LPush("set", "a")
+		assert.IsType(t, WrongTypeError{}, err, name)
+		_, err = provider.SMembers("plain")
+		assert.Equal(t, "WRONGTYPE Operation against a key holding the wrong kind of value", err.Error())
+		_, err = provider.SInter("set", "plain")
+		assert.IsType(t, WrongTypeError{}, err, name)
+
+		// plain Set overwrites structure
+		provider.Set("set", "value", 0)
+		value, _, _, _ := provider.Get("set")
+		assert.Equal(t, "value", value, name)
+	}
+}
+
+func TestStructureUpdatesAreAtomic(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		provider.Set("test", "value", 3600)
+		provider.Delete("test")
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					provider.HSet("test", map[string]string{strconv.Itoa(i*100 + j): "1"})
+					provider.RPush("list", "1")
+				}
+			}(i)
+		}
+		wg.Wait()
+		hash, _ := provider.HGetAll("test")
+		assert.Len(t, hash, 500, name)
+		values, _ := provider.LRange("list", 0, -1)
+		assert.Len(t, values, 500, name)
+	}
+}
+
+func TestIncr(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		value, err := provider.Incr("missed", 5, 0)
+		assert.Equal(t, int64(5), value, name)
+		assert.Nil(t, err, name)
+
+		provider.Set("number", 1.5, 3600)
+		_, err = provider.Incr("number", 1, 0)
+		assert.Error(t, err, name)
+		floatValue, _ := provider.IncrFloat("number", 1, 0)
+		assert.Equal(t, 2.5, floatValue, name)
+		stored, expiredAt, _, _ := provider.Get("number")
+		assert.Equal(t, 2.5, stored, name)
+		assert.NotEqual(t, int64(0), expiredAt, name)
+
+		// strings set over RESP and memcached stay strings
+		provider.Set("string", "10", 0)
+		value, _ = provider.Decr("string", 3, 60)
+		assert.Equal(t, int64(7), value, name)
+		stored, expiredAt, _, _ = provider.Get("string")
+		assert.Equal(t, "7", stored, name)
+		assert.NotEqual(t, int64(0), expiredAt, name)
+
+		provider.Set("string", "max", 0)
+		_, err = provider.Incr("string", 1, 0)
+		assert.Error(t, err, name)
+		provider.Set("string", strconv.FormatInt(math.MaxInt64, 10), 0)
+		_, err = provider.Incr("string", 1, 0)
+		assert.Error(t, err, name)
+		_, err = provider.Decr("string", math.MinInt64, 0)
+		assert.Error(t, err, name)
+
+		provider.SAdd("set", "a")
+		_, err = provider.Incr("set", 1, 0)
+		assert.IsType(t, WrongTypeError{}, err, name)
+	}
+}
+
+func TestIncrIsAtomic(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					provider.Incr("counter", 2, 0)
+					provider.Decr("counter", 1, 0)
+				}
+			}()
+		}
+		wg.Wait()
+		value, _, _, _ := provider.Get("counter")
+		assert.Equal(t, float64(1000), value, name)
+	}
+}
+
+func TestIncrMemoryLimit(t *testing.T) {
+	provider, _ := New("mutex-map", log, false, 60, false)
+	provider.SetMemoryLimit(0, 1, "noeviction", 5)
+	provider.Incr("counter", 1, 0)
+	_, err := provider.Incr("another", 1, 0)
+	assert.IsType(t, MemoryLimitError{}, err)
+	_, err = provider.Incr("counter", 1, 0)
+	assert.Nil(t, err)
+}
+
+func TestVersions(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		_, _, version, found, _ := provider.GetVersioned("test")
+		assert.False(t, found, name)
+		assert.Equal(t, uint64(0), version, name)
+
+		version, err := provider.CompareAndSet("test", "value", 0, 0)
+		assert.Nil(t, err, name)
+		_, err = provider.CompareAndSet("test", "other", 0, 0)
+		assert.IsType(t, VersionConflictError{}, err, name)
+
+		// every write changes version, even if value is the same
+		provider.Set("test", "value", 0)
+		_, _, current, _, _ := provider.GetVersioned("test")
+		assert.True(t, current > version, name)
+		_, err = provider.CompareAndSet("test", "other", 0, version)
+		assert.IsType(t, VersionConflictError{}, err, name)
+		version, err = provider.CompareAndSet("test", "other", 3600, current)
+		assert.Nil(t, err, name)
+		value, expiredAt, current, _, _ := provider.GetVersioned("test")
+		assert.Equal(t, "other", value, name)
+		assert.Equal(t, version, current, name)
+		assert.NotEqual(t, int64(0), expiredAt, name)
+
+		provider.Incr("counter", 1, 0)
+		_, _, version, _, _ = provider.GetVersioned("counter")
+		assert.True(t, version > current, name)
+		provider.SAdd("set", "a")
+		_, _, current, _, _ = provider.GetVersioned("set")
+		assert.True(t, current > version, name)
+	}
+}
+
+func TestCompareAndSetIsAtomic(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		provider.Set("counter", 0, 0)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; {
+					value, _, version, _, _ := provider.GetVersioned("counter")
+					if _, err := provider.CompareAndSet("counter", value.(float64)+1, 0, version); err == nil {
+						j++
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		value, _, _, _ := provider.Get("counter")
+		assert.Equal(t, float64(500), value, name)
+	}
+}
+
+func TestRestoreVersions(t *testing.T) {
+	os.RemoveAll("./data/aof")
+	logger := l.New(ioutil.Discard, "", 0)
+	provider, _ := New("mutex-map", logger, false, 60, true)
+	version, _ := provider.SetVersioned("test", "value", 0)
+	provider.HSet("hash", map[string]string{"a": "1"})
+	_, _, hashVersion, _, _ := provider.GetVersioned("hash")
+
+	restored, _ := New("sync-map", logger, false, 60, true)
+	_, _, restoredVersion, found, _ := restored.GetVersioned("test")
+	assert.True(t, found)
+	assert.Equal(t, version, restoredVersion)
+	_, _, restoredVersion, _, _ = restored.GetVersioned("hash")
+	assert.Equal(t, hashVersion, restoredVersion)
+	_, err := restored.CompareAndSet("test", "other", 0, version)
+	assert.Nil(t, err)
+	// restored versions aren't given out again
+	version, _ = restored.SetVersioned("another", "value", 0)
+	assert.True(t, version > hashVersion)
+}
+
+func TestMultiKey(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		err := provider.MSet([]Item{{Key: "a", Value: 1}, {Key: "b", Value: "2", TTL: 3600}})
+		assert.Nil(t, err, name)
+		items, _ := provider.MGet([]string{"a", "missed", "b"})
+		assert.Equal(t, 3, len(items), name)
+		assert.Equal(t, float64(1), items[0].Value, name)
+		assert.True(t, items[0].Found, name)
+		assert.NotEqual(t, uint64(0), items[0].Version, name)
+		assert.Equal(t, "missed", items[1].Key, name)
+		assert.False(t, items[1].Found, name)
+		assert.InDelta(t, time.Now().Unix()+3600, items[2].ExpiredAt, 1, name)
+
+		deleted, _ := provider.MDelete([]string{"a", "missed"})
+		assert.Equal(t, 1, deleted, name)
+		keys, _ := provider.GetKeys()
+		assert.Equal(t, []string{"b"}, keys, name)
+	}
+}
+
+func TestMSetMemoryLimit(t *testing.T) {
+	provider, _ := New("mutex-map", log, false, 60, false)
+	provider.SetMemoryLimit(0, 1, "noeviction", 5)
+	err := provider.MSet([]Item{{Key: "a", Value: 1}, {Key: "b", Value: 2}})
+	assert.IsType(t, MemoryLimitError{}, err)
+	_, _, found, _ := provider.Get("a")
+	assert.False(t, found)
+}
+
+func TestRestoreMultiKey(t *testing.T) {
+	os.RemoveAll("./data/aof")
+	logger := l.New(ioutil.Discard, "", 0)
+	provider, _ := New("mutex-map", logger, false, 60, true)
+	provider.MSet([]Item{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}, {Key: "c", Value: "3"}})
+	provider.MDelete([]string{"a", "b"})
+
+	restored, _ := New("sync-map", logger, false, 60, true)
+	keys, _ := restored.GetKeys()
+	assert.Equal(t, []string{"c"}, keys)
+	value, _, _, _ := restored.Get("c")
+	assert.Equal(t, "3", value)
+}
+
+func TestNamespaces(t *testing.T) {
+	provider, _ := New("mutex-map", log, false, 60, false)
+	team, err := provider.NewNamespace("team")
+	assert.NoError(t, err)
+	assert.Equal(t, "team", team.Namespace())
+	_, err = provider.NewNamespace("bad name")
+	assert.IsType(t, InvalidNamespaceError{}, err)
+
+	provider.Set("key", "default", 0)
+	team.SetDefaultTTL(100)
+	team.Set("key", "team", 0)
+	team.Set("other", "team", 10)
+	value, expiredAt, _, _ := provider.Get("key")
+	assert.Equal(t, "default", value)
+	assert.Equal(t, int64(0), expiredAt)
+	value, expiredAt, _, _ = team.Get("key")
+	assert.Equal(t, "team", value)
+	assert.InDelta(t, time.Now().Unix()+100, expiredAt, 1)
+	_, expiredAt, _, _ = team.Get("other")
+	assert.InDelta(t, time.Now().Unix()+10, expiredAt, 1)
+
+	// transactions use default TTL too
+	team.Exec([]TxOp{{Op: TxSet, Key: "tx", Value: 1}}, nil)
+	_, expiredAt, _, _ = team.Get("tx")
+	assert.InDelta(t, time.Now().Unix()+100, expiredAt, 1)
+
+	flushed, err := team.Flush()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, flushed)
+	keys, _ := team.GetKeys()
+	assert.Empty(t, keys)
+	keys, _ = provider.GetKeys()
+	assert.Equal(t, []string{"key"}, keys)
+}
+
+func TestRestoreNamespaces(t *testing.T) {
+	os.RemoveAll("./data/aof")
+	logger := l.New(ioutil.Discard, "", 0)
+	provider, _ := New("mutex-map", logger, false, 60, true)
+	team, _ := provider.NewNamespace("team")
+	provider.Set("key", "default", 0)
+	team.Set("key", "team", 0)
+	team.MSet([]Item{{Key: "a", Value: "1"}})
+	team.Delete("key")
+
+	restored, _ := New("sync-map", logger, false, 60, true)
+	keys, _ := restored.GetKeys()
+	assert.Equal(t, []string{"key"}, keys)
+	restoredTeam, _ := restored.NewNamespace("team")
+	keys, _ = restoredTeam.GetKeys()
+	assert.Equal(t, []string{"a"}, keys)
+}
+
+func TestTransactions(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		provider.Set("from", 10, 0)
+		provider.Set("to", 1, 3600)
+		watched, _ := provider.Versions([]string{"from", "missed"})
+		assert.Equal(t, uint64(0), watched["missed"], name)
+
+		results, err := provider.Exec([]TxOp{
+			{Op: TxGet, Key: "from"},
+			{Op: TxIncr, Key: "to", Delta: counter.Int(10)},
+			{Op: TxDelete, Key: "from"},
+			{Op: TxSet, Key: "other", Value: "a"},
+			{Op: TxGet, Key: "from"},
+		}, watched)
+		assert.Nil(t, err, name)
+		assert.Equal(t, float64(10), results[0].Value, name)
+		assert.Equal(t, int64(11), results[1].Value, name)
+		assert.InDelta(t, time.Now().Unix()+3600, results[1].ExpiredAt, 1, name)
+		assert.True(t, results[2].Found, name)
+		assert.NotEqual(t, uint64(0), results[3].Version, name)
+		assert.False(t, results[4].Found, name)
+		keys, _ := provider.GetKeys()
+		assert.ElementsMatch(t, []string{"to", "other"}, keys, name)
+
+		// watched key was changed since the last exec
+		_, err = provider.Exec([]TxOp{{Op: TxSet, Key: "to", Value: 0}}, watched)
+		assert.Equal(t, TxAbortedError{"from"}, err, name)
+		value, _, _, _ := provider.Get("to")
+		assert.Equal(t, float64(11), value, name)
+
+		// failed op cancels the whole transaction
+		_, err = provider.Exec([]TxOp{{Op: TxSet, Key: "to", Value: 0}, {Op: TxIncr, Key: "other", Delta: counter.Int(1)}}, nil)
+		assert.Equal(t, counter.ErrNotInteger, err, name)
+		value, _, _, _ = provider.Get("to")
+		assert.Equal(t, float64(11), value, name)
+	}
+}
+
+func TestTransactionIsAtomic(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		provider.Set("a", 100, 0)
+		provider.Set("b", 0, 0)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					provider.Exec([]TxOp{
+						{Op: TxIncr, Key: "a", Delta: counter.Int(-1)},
+						{Op: TxIncr, Key: "b", Delta: counter.Int(1)},
+					}, nil)
+				}
+			}()
+			// readers never see a half done move
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					results, _ := provider.Exec([]TxOp{{Op: TxGet, Key: "a"}, {Op: TxGet, Key: "b"}}, nil)
+					assert.Equal(t, float64(100), results[0].Value.(float64)+results[1].Value.(float64), name)
+				}
+			}()
+		}
+		wg.Wait()
+		value, _, _, _ := provider.Get("b")
+		assert.Equal(t, float64(100), value, name)
+	}
+}
+
+func TestTransactionMemoryLimit(t *testing.T) {
+	provider, _ := New("mutex-map", log, false, 60, false)
+	provider.Set("a", 1, 0)
+	provider.SetMemoryLimit(0, 1, "noeviction", 5)
+	_, err := provider.Exec([]TxOp{{Op: TxSet, Key: "a", Value: 2}, {Op: TxSet, Key: "b", Value: 2}}, nil)
+	assert.IsType(t, MemoryLimitError{}, err)
+	value, _, _, _ := provider.Get("a")
+	assert.Equal(t, float64(1), value)
+	used, keys := provider.MemoryUsage()
+	assert.Equal(t, int64(1), used)
+	assert.Equal(t, 1, keys)
+}
+
+func TestRestoreTransaction(t *testing.T) {
+	os.RemoveAll("./data/aof")
+	logger := l.New(ioutil.Discard, "", 0)
+	provider, _ := New("mutex-map", logger, false, 60, true)
+	provider.Set("from", "value", 0)
+	provider.Exec([]TxOp{{Op: TxDelete, Key: "from"}, {Op: TxSet, Key: "to", Value: "value", TTL: 3600}}, nil)
+	_, _, version, _, _ := provider.GetVersioned("to")
+
+	restored, _ := New("sync-map", logger, false, 60, true)
+	keys, _ := restored.GetKeys()
+	assert.Equal(t, []string{"to"}, keys)
+	value, expiredAt, restoredVersion, _, _ := restored.GetVersioned("to")
+	assert.Equal(t, "value", value)
+	assert.Equal(t, version, restoredVersion)
+	assert.InDelta(t, time.Now().Unix()+3600, expiredAt, 1)
+}
+
+func TestScripts(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		// moves tokens between keys while there are enough of them
+		sha, err := provider.ScriptLoad(`
+			local tokens = tonumber(cacher.call("get", KEYS[1]) or ARGV[1])
+			if tokens < ARGV[2] then
+				return {false, tokens}
+			end
+			cacher.call("set", KEYS[1], tokens - ARGV[2], 3600)
+			cacher.call("incr", KEYS[2], ARGV[2])
+			return {true, tokens - ARGV[2]}
+		`)
+		assert.Nil(t, err, name)
+		assert.Equal(t, 40, len(sha), name)
+
+		result, err := provider.EvalSHA(sha, []string{"bucket", "spent"}, []interface{}{10, 4}, 0)
+		assert.Nil(t, err, name)
+		assert.Equal(t, []interface{}{true, float64(6)}, result, name)
+		provider.EvalSHA(sha, []string{"bucket", "spent"}, []interface{}{10, 4}, 0)
+		result, _ = provider.EvalSHA(sha, []string{"bucket", "spent"}, []interface{}{10, 4}, 0)
+		assert.Equal(t, []interface{}{false, float64(2)}, result, name)
+		value, _, _, _ := provider.Get("spent")
+		assert.Equal(t, float64(8), value, name)
+		_, expiredAt, _, _ := provider.Get("bucket")
+		assert.InDelta(t, time.Now().Unix()+3600, expiredAt, 1, name)
+
+		_, err = provider.EvalSHA("missed", nil, nil, 0)
+		assert.IsType(t, ScriptNotFoundError{}, err, name)
+		result, _ = provider.Eval(`return {a = 1, b = ARGV[1]}`, nil, []interface{}{"x"}, 0)
+		assert.Equal(t, map[string]interface{}{"a": float64(1), "b": "x"}, result, name)
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		_, err := provider.Eval(`return (`, nil, nil, 0)
+		assert.IsType(t, ScriptError{}, err, name)
+		_, err = provider.Eval(`return cacher.call("get", "other")`, []string{"test"}, nil, 0)
+		assert.Contains(t, err.Error(), "undeclared key 'other'", name)
+
+		// nothing is written if script fails
+		_, err = provider.Eval(`cacher.call("set", KEYS[1], 1) error("failed")`, []string{"test"}, nil, 0)
+		assert.Contains(t, err.Error(), "failed", name)
+		_, err = provider.Eval(`cacher.call("set", KEYS[1], 1) while true do end`, []string{"test"}, nil, 50*time.Millisecond)
+		assert.Equal(t, ScriptTimeoutError{50 * time.Millisecond}, err, name)
+		_, _, found, _ := provider.Get("test")
+		assert.False(t, found, name)
+
+		provider.HSet("hash", map[string]string{"a": "1"})
+		_, err = provider.Eval(`return cacher.call("get", KEYS[1])`, []string{"hash"}, nil, 0)
+		assert.Contains(t, err.Error(), WrongTypeError{}.Error(), name)
+	}
+}
+
+func TestSetWithOptions(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		version, _, err := provider.SetWithOptions("test", "a", 0, SetOptions{XX: true})
+		assert.Nil(t, err, name)
+		assert.Equal(t, uint64(0), version, name)
+		_, _, found, _ := provider.Get("test")
+		assert.False(t, found, name)
+
+		version, previous, _ := provider.SetWithOptions("test", "a", 3600, SetOptions{NX: true, Get: true})
+		assert.NotEqual(t, uint64(0), version, name)
+		assert.Nil(t, previous, name)
+		version, previous, _ = provider.SetWithOptions("test", "b", 0, SetOptions{NX: true, Get: true})
+		assert.Equal(t, uint64(0), version, name)
+		assert.Equal(t, "a", previous, name)
+
+		_, previous, _ = provider.SetWithOptions("test", "c", 0, SetOptions{XX: true, Get: true, KeepTTL: true})
+		assert.Equal(t, "a", previous, name)
+		value, expiredAt, _, _ := provider.Get("test")
+		assert.Equal(t, "c", value, name)
+		assert.InDelta(t, time.Now().Unix()+3600, expiredAt, 1, name)
+	}
+}
+
+func TestSetNXIsAtomic(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		var wg sync.WaitGroup
+		var stored int64
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				// distributed lock: only one of clients acquires it
+				version, _, _ := provider.SetWithOptions("lock", i, 60, SetOptions{NX: true})
+				if version != 0 {
+					atomic.AddInt64(&stored, 1)
+				}
+			}(i)
+		}
+		wg.Wait()
+		assert.Equal(t, int64(1), stored, name)
+	}
+}
+
+func TestStructureTTL(t *testing.T) {
+	for _, name := range providers {
+		provider, _ := New(name, log, false, 60, false)
+		provider.Set("test", types.Hash{"a": "1"}, 3600)
+		provider.HSet("test", map[string]string{"b": "2"})
+		value, expiredAt, _, _ := provider.Get("test")
+		assert.Equal(t, types.Hash{"a": "1", "b": "2"}, value, name)
+		assert.NotEqual(t, int64(0), expiredAt, name)
+	}
+}
+
+func TestRestoreStructures(t *testing.T) {
+	zset := types.NewSortedSet()
+	zset.Add("a", 1)
+	zset.Add("b", math.Inf(-1))
+	for _, structure := range []types.Structure{types.Hash{"a": "1"}, types.List{"a", "b"}, types.Set{"a": {}}, zset} {
+		// structures are restored from JSON kept by AOF and CDB
+		data, err := json.Marshal(structure)
+		assert.Nil(t, err)
+		var value interface{}
+		json.Unmarshal(data, &value)
+		restored, err := restoreValue(value, false, structure.Type())
+		assert.Nil(t, err)
+		assert.Equal(t, structure, restored)
+	}
+}
+
+func TestRestoreValueWithSpaces(t *testing.T) {
+	os.RemoveAll("./data/aof")
+	logger := l.New(ioutil.Discard, "", 0)
+	provider, _ := New("mutex-map", logger, false, 60, true)
+	provider.Set("test", "hello world", 0)
+	provider.Set("key with spaces", map[string]interface{}{"a b": "c d"}, 0)
+
+	restored, _ := New("mutex-map", logger, false, 60, true)
+	value, _, found, _ := restored.Get("test")
+	assert.True(t, found)
+	assert.Equal(t, "hello world", value)
+	value, _, found, _ = restored.Get("key with spaces")
+	assert.True(t, found)
+	assert.Equal(t, map[string]interface{}{"a b": "c d"}, value)
+}
+
+func TestRestoreCorruptedAOF(t *testing.T) {
+	os.RemoveAll("./data/aof")
+	logger := l.New(ioutil.Discard, "", 0)
+	provider, _ := New("mutex-map", logger, false, 60, true)
+	provider.Set("first", "value", 0)
+	info, err := os.Stat(aof.DefaultPath)
+	assert.Nil(t, err)
+	provider.Set("second", "value", 0)
+	aof.Close()
+
+	// damaged record and everything after it is skipped by reading
+	file, _ := os.OpenFile(aof.DefaultPath, os.O_RDWR, 0644)
+	file.WriteAt([]byte{0xff}, info.Size()+10)
+	file.Close()
+	records, err := aof.GetRecords(0)
+	assert.Equal(t, aof.ErrCorrupted, err)
+	assert.Len(t, records, 2)
+	count, valid, err := aof.Check(aof.DefaultPath)
+	assert.Equal(t, aof.ErrCorrupted, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, info.Size(), valid)
+
+	// corrupted record isn't truncated on start, it has to be repaired explicitly
+	_, err = New("mutex-map", logger, false, 60, true)
+	assert.NotNil(t, err)
+	assert.Nil(t, aof.Repair(aof.DefaultPath, valid))
+	restored, err := New("mutex-map", logger, false, 60, true)
+	assert.Nil(t, err)
+	_, _, found, _ := restored.Get("first")
+	assert.True(t, found)
+	_, _, found, _ = restored.Get("second")
+	assert.False(t, found)
+}
+
+func TestRestoreCorruptedLastRecordAOF(t *testing.T) {
+	logger := l.New(ioutil.Discard, "", 0)
+	for _, loadTruncated := range []bool{true, false} {
+		os.RemoveAll("./data/aof")
+		provider, _ := New("mutex-map", logger, false, 60, true)
+		provider.Set("first", "value", 0)
+		aof.Close()
+
+		// length of the last record was written, but its payload didn't reach disk
+		full, _ := os.Stat(aof.DefaultPath)
+		file, _ := os.OpenFile(aof.DefaultPath, os.O_RDWR, 0644)
+		file.WriteAt([]byte{0xff}, full.Size()-2)
+		file.Close()
+		count, valid, err := aof.Check(aof.DefaultPath)
+		assert.Equal(t, aof.ErrCorrupted, err)
+		assert.Equal(t, 1, count)
+
+		SetAOFLoadTruncated(loadTruncated)
+		restored, err := New("mutex-map", logger, false, 60, true)
+		if !loadTruncated {
+			assert.NotNil(t, err)
+			continue
+		}
+		assert.Nil(t, err)
+		truncated, _ := os.Stat(aof.DefaultPath)
+		assert.Equal(t, valid, truncated.Size())
+		records, err := aof.GetRecords(0)
+		assert.Nil(t, err)
+		assert.Len(t, records, count)
+		// only completion of the set was lost, so it's not restored
+		_, _, found, _ := restored.Get("first")
+		assert.False(t, found)
+	}
+	SetAOFLoadTruncated(true)
+}
+
+func TestRestoreTruncatedAOF(t *testing.T) {
+	logger := l.New(ioutil.Discard, "", 0)
+	for _, loadTruncated := range []bool{true, false} {
+		os.RemoveAll("./data/aof")
+		provider, _ := New("mutex-map", logger, false, 60, true)
+		provider.Set("first", "value", 0)
+		info, _ := os.Stat(aof.DefaultPath)
+		provider.Set("second", "value", 0)
+		aof.Close()
+
+		// process was killed while writing the last record
+		full, _ := os.Stat(aof.DefaultPath)
+		os.Truncate(aof.DefaultPath, full.Size()-3)
+		_, valid, err := aof.Check(aof.DefaultPath)
+		assert.Equal(t, aof.ErrTruncated, err)
+		assert.True(t, valid > info.Size())
+
+		SetAOFLoadTruncated(loadTruncated)
+		restored, err := New("mutex-map", logger, false, 60, true)
+		if !loadTruncated {
+			assert.NotNil(t, err)
+			continue
+		}
+		assert.Nil(t, err)
+		_, _, found, _ := restored.Get("first")
+		assert.True(t, found)
+		// completion of the last set was cut, so it's not restored
+		_, _, found, _ = restored.Get("second")
+		assert.False(t, found)
+		truncated, _ := os.Stat(aof.DefaultPath)
+		assert.Equal(t, valid, truncated.Size())
+		// records written after truncation are restored
+		restored.Set("third", "value", 0)
+		restored, _ = New("mutex-map", logger, false, 60, true)
+		_, _, found, _ = restored.Get("third")
+		assert.True(t, found)
+	}
+
+	// crash while writing header of a new file
+	SetAOFLoadTruncated(true)
+	os.RemoveAll("./data/aof")
+	os.MkdirAll("./data/aof", 0755)
+	ioutil.WriteFile(aof.DefaultPath, []byte("CACH"), 0644)
+	restored, err := New("mutex-map", logger, false, 60, true)
+	assert.Nil(t, err)
+	restored.Set("test", "value", 0)
+	_, _, err = aof.Check(aof.DefaultPath)
+	assert.Nil(t, err)
+
+	// file of another format is never truncated
+	ioutil.WriteFile(aof.DefaultPath, []byte("not AOF at all"), 0644)
+	_, err = New("mutex-map", logger, false, 60, true)
+	assert.Equal(t, aof.ErrNotAOF, err)
+}
+
+func TestMigrateLegacyAOF(t *testing.T) {
+	os.RemoveAll("./data/aof")
+	os.MkdirAll("./data/aof", 0755)
+	now := time.Now().Format("2006/01/02 15:04:05")
+	lines := []string{
+		now + "  set test \"hello world\" 0 7 - pending",
+		now + "  set test \"hello world\" 0 7 - completed",
+		now + "  set old 1 0 - completed",
+		now + "  set@ns test {\"a\":\"b c\"} 60 8 - completed",
+		now + "  sethash hash {\"a\":\"1\"} 0 9 - completed",
+		now + "  delete old - completed",
+		now + "  set trunc",
+	}
+	ioutil.WriteFile(aof.LegacyPath, []byte(strings.Join(lines, "\n")), 0644)
+	counter, skipped, err := aof.MigrateLegacy(aof.LegacyPath, aof.DefaultPath)
+	assert.Nil(t, err)
+	assert.NotNil(t, skipped)
+	assert.Equal(t, 6, counter)
+
+	records, err := aof.GetRecords(0)
+	assert.Nil(t, err)
+	assert.Equal(t, aof.Record{Op: aof.OpSet, State: aof.Completed, Timestamp: records[1].Timestamp, Key: "test",
+		Value: []byte(`"hello world"`), Version: 7}, records[1])
+	assert.Equal(t, aof.OpSetStructure, records[4].Op)
+	assert.Equal(t, "hash", records[4].Type)
+
+	logger := l.New(ioutil.Discard, "", 0)
+	restored, _ := New("mutex-map", logger, false, 60, true)
+	value, _, version, found, _ := restored.GetVersioned("test")
+	assert.True(t, found)
+	assert.Equal(t, "hello world", value)
+	assert.Equal(t, uint64(7), version)
+	_, _, found, _ = restored.Get("old")
+	assert.False(t, found)
+	hash, _ := restored.HGetAll("hash")
+	assert.Equal(t, map[string]string{"a": "1"}, hash)
+	namespace, _ := restored.NewNamespace("ns")
+	value, expiredAt, found, _ := namespace.Get("test")
+	assert.True(t, found)
+	assert.Equal(t, map[string]interface{}{"a": "b c"}, value)
+	assert.NotEqual(t, int64(0), expiredAt)
+
+	// key with spaces stops migration instead of being cut at the first space
+	for _, line := range []string{now + "  set user 5 7 0 3 - completed", now + "  set user 5 \"x\" 60 - completed"} {
+		ioutil.WriteFile(aof.LegacyPath, []byte(line+"\n"), 0644)
+		_, _, err = aof.MigrateLegacy(aof.LegacyPath, aof.DefaultPath)
+		assert.NotNil(t, err, line)
+	}
+}
+
+func TestAppendFsync(t *testing.T) {
+	assert.NotNil(t, SetAppendFsync("sometimes"))
+	defer SetAppendFsync(aof.FsyncEverySec)
+	logger := l.New(ioutil.Discard, "", 0)
+	for _, policy := range []string{aof.FsyncAlways, aof.FsyncEverySec, aof.FsyncNo} {
+		os.RemoveAll("./data/aof")
+		assert.Nil(t, SetAppendFsync(policy), policy)
+		provider, _ := New("mutex-map", logger, false, 60, true)
+		provider.Set("test", "value", 0)
+		assert.Nil(t, aof.Close(), policy)
+		// records aren't written after close
+		provider.Set("closed", "value", 0)
+
+		restored, _ := New("mutex-map", logger, false, 60, true)
+		_, _, found, _ := restored.Get("test")
+		assert.True(t, found, policy)
+		_, _, found, _ = restored.Get("closed")
+		assert.False(t, found, policy)
+	}
+}
+
+func TestRewriteAOF(t *testing.T) {
+	os.RemoveAll("./data/aof")
+	logger := l.New(ioutil.Discard, "", 0)
+	provider, _ := New("mutex-map", logger, false, 60, true)
+	namespace, _ := provider.NewNamespace("ns")
+	for i := 0; i < 100; i++ {
+		provider.Set("test", i, 0)
+		provider.Set("deleted_"+strconv.Itoa(i), i, 0)
+		provider.Delete("deleted_" + strconv.Itoa(i))
+	}
+	provider.Set("ttl", "value", 60)
+	provider.HSet("hash", map[string]string{"a": "1"})
+	provider.Set("raw", raw.Typed{ContentType: "text/plain", Data: []byte("text")}, 0)
+	namespace.Set("test", "namespaced", 0)
+	_, _, version, _, _ := provider.GetVersioned("test")
+	before, _ := os.Stat(aof.DefaultPath)
+
+	assert.Nil(t, RewriteAOF())
+	after, _ := os.Stat(aof.DefaultPath)
+	assert.True(t, after.Size() < before.Size()/10)
+	records, err := aof.GetRecords(0)
+	assert.Nil(t, err)
+	assert.Len(t, records, 5)
+	// writes after rewrite go to the new file
+	provider.Set("after", "value", 0)
+
+	restored, _ := New("mutex-map", logger, false, 60, true)
+	value, expiredAt, restoredVersion, found, _ := restored.GetVersioned("test")
+	assert.True(t, found)
+	assert.Equal(t, float64(99), value)
+	assert.Equal(t, version, restoredVersion)
+	_, expiredAt, found, _ = restored.Get("ttl")
+	assert.True(t, found)
+	assert.NotEqual(t, int64(0), expiredAt)
+	hash, _ := restored.HGetAll("hash")
+	assert.Equal(t, map[string]string{"a": "1"}, hash)
+	value, _, found, _ = restored.Get("raw")
+	assert.Equal(t, raw.Typed{ContentType: "text/plain", Data: []byte("text")}, value)
+	_, _, found, _ = restored.Get("after")
+	assert.True(t, found)
+	_, _, found, _ = restored.Get("deleted_1")
+	assert.False(t, found)
+	restoredNamespace, _ := restored.NewNamespace("ns")
+	value, _, found, _ = restoredNamespace.Get("test")
+	assert.True(t, found)
+	assert.Equal(t, "namespaced", value)
+}
+
+func TestRewriteAOFKeepsConcurrentWrites(t *testing.T) {
+	os.RemoveAll("./data/aof")
+	logger := l.New(ioutil.Discard, "", 0)
+	provider, _ := New("sharded-map", logger, false, 60, true)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 2000; i++ {
+			provider.Set("test_"+strconv.Itoa(i%100), i, 0)
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		err := RewriteAOF()
+		assert.True(t, err == nil || err == aof.ErrRewriteInProgress)
+	}
+	wg.Wait()
+
+	restored, _ := New("sharded-map", logger, false, 60, true)
+	for i := 0; i < 100; i++ {
+		value, _, found, _ := restored.Get("test_" + strconv.Itoa(i))
+		assert.True(t, found)
+		assert.Equal(t, float64(1900+i), value)
+	}
+}
+
+func TestTxAOFKeepsApplyOrder(t *testing.T) {
+	os.RemoveAll("./data/aof")
+	logger := l.New(ioutil.Discard, "", 0)
+	provider, _ := New("sharded-map", logger, false, 60, true)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				provider.Exec([]TxOp{{Op: TxSet, Key: "tx", Value: g*1000 + i}}, nil)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// completed records of key follow in order of its versions
+	records, err := aof.GetRecords(0)
+	assert.Nil(t, err)
+	var last uint64
+	for _, record := range records {
+		if record.Op != aof.OpTx || record.State != aof.Completed {
+			continue
+		}
+		entries, _ := aof.DecodeBatch(record.Value)
+		assert.True(t, entries[0].Version > last)
+		last = entries[0].Version
+	}
+
+	// failed transaction isn't restored
+	_, err = provider.Exec([]TxOp{{Op: TxSet, Key: "invalid", Value: raw.Typed{ContentType: raw.JSONType, Data: []byte("{")}}}, nil)
+	assert.NotNil(t, err)
+
+	value, _, _, _ := provider.Get("tx")
+	restored, _ := New("sharded-map", logger, false, 60, true)
+	restoredValue, _, _, _ := restored.Get("tx")
+	assert.Equal(t, value, restoredValue)
+	_, _, found, _ := restored.Get("invalid")
+	assert.False(t, found)
+}
+
+func TestAutoRewriteAOF(t *testing.T) {
+	os.RemoveAll("./data/aof")
+	SetAOFRewrite(100, 4096)
+	defer SetAOFRewrite(0, 0)
+	logger := l.New(ioutil.Discard, "", 0)
+	provider, _ := New("mutex-map", logger, false, 60, true)
+	for i := 0; i < 1000; i++ {
+		provider.Set("test", i, 0)
+	}
+	// the last rewrite may still be running
+	for RewriteAOF() == aof.ErrRewriteInProgress {
+		time.Sleep(time.Millisecond)
+	}
+	info, _ := os.Stat(aof.DefaultPath)
+	assert.True(t, info.Size() < 4096)
+
+	restored, _ := New("mutex-map", logger, false, 60, true)
+	value, _, _, _ := restored.Get("test")
+	assert.Equal(t, float64(999), value)
+}
+
+func TestRestoreKeepsExpiration(t *testing.T) {
+	os.RemoveAll("./data/aof")
+	logger := l.New(ioutil.Discard, "", 0)
+	provider, _ := New("mutex-map", logger, false, 60, true)
+	provider.Set("long", "value", 60)
+	provider.Set("short", "value", 1)
+	provider.MSet([]Item{{Key: "batch_long", Value: 1, TTL: 60}, {Key: "batch_short", Value: 1, TTL: 1}})
+	provider.Exec([]TxOp{{Op: TxSet, Key: "tx_short", Value: 1, TTL: 1}}, nil)
+	_, expiredAt, _, _ := provider.Get("long")
+	_, batchExpiredAt, _, _ := provider.Get("batch_long")
+	time.Sleep(2 * time.Second)
+
+	// TTL isn't counted again from restore, expired values are skipped
+	restored, _ := New("mutex-map", logger, false, 60, true)
+	_, restoredExpiredAt, found, _ := restored.Get("long")
+	assert.True(t, found)
+	assert.Equal(t, expiredAt, restoredExpiredAt)
+	_, restoredExpiredAt, found, _ = restored.Get("batch_long")
+	assert.True(t, found)
+	assert.Equal(t, batchExpiredAt, restoredExpiredAt)
+	keys, _ := restored.GetKeys()
+	assert.ElementsMatch(t, []string{"long", "batch_long"}, keys)
+}
+
+func TestRestoreReportsPending(t *testing.T) {
+	os.RemoveAll("./data/aof")
+	var output bytes.Buffer
+	logger := l.New(&output, "", 0)
+	provider, _ := New("mutex-map", logger, false, 60, true)
+	provider.Set("test", "value", 0)
+	// crash between pending record and operation, failed operations aren't restored either
+	aof.Namespace("").Write("pending", "value", 0, 1, aof.Pending)
+	aof.Namespace("").Write("failed", "value", 0, 2, aof.Pending)
+	aof.Namespace("").Write("failed", "value", 0, 2, aof.Failed)
+	aof.Namespace("").Delete("test", aof.Pending)
+
+	output.Reset()
+	restored, _ := New("mutex-map", logger, false, 60, true)
+	keys, _ := restored.GetKeys()
+	assert.Equal(t, []string{"test"}, keys)
+	assert.Contains(t, output.String(), "Restored 1 operations from AOF")
+	assert.Contains(t, output.String(), "set of key 'pending' written at")
+	assert.Contains(t, output.String(), "delete of key 'test' written at")
+	assert.NotContains(t, output.String(), "'failed'")
+	assert.Contains(t, output.String(), "2 operations of AOF were pending without completion")
+}
+
+// killedKey is a value of keyspace dumped before cache is killed
+type killedKey struct {
+	Value     json.RawMessage
+	ExpiredAt int64
+	Version   uint64
+}
+
+func dumpKeyspace(t *testing.T, managers ...*CacheManager) map[string]killedKey {
+	keyspace := map[string]killedKey{}
+	for _, cm := range managers {
+		keys, _ := cm.GetKeys()
+		for _, key := range keys {
+			value, expiredAt, version, found, err := cm.GetVersioned(key)
+			assert.Nil(t, err)
+			assert.True(t, found)
+			data, _ := json.Marshal(value)
+			keyspace[cm.Namespace()+"/"+key] = killedKey{data, expiredAt, version}
+		}
+	}
+	return keyspace
+}
+
+// runKilledCache writes to cache, dumps its keyspace and kills own process like 'kill -9'
+func runKilledCache(t *testing.T) {
+	logger := l.New(ioutil.Discard, "", 0)
+	provider, _ := New("sharded-map", logger, false, 60, true)
+	namespace, _ := provider.NewNamespace("ns")
+	for i := 0; i < 600; i++ {
+		key := "key_" + strconv.Itoa(i%50)
+		switch i % 7 {
+		case 0:
+			provider.Set(key, map[string]interface{}{"i": i, "text": "hello world"}, 0)
+		case 1:
+			provider.Set(key, i, 3600)
+		case 2:
+			provider.Delete(key)
+		case 3:
+			provider.MSet([]Item{{Key: key, Value: "a b", TTL: 60}, {Key: key + "_batch", Value: i}})
+		case 4:
+			provider.HSet("hash_"+key, map[string]string{"field": strconv.Itoa(i)})
+			provider.Set("raw_"+key, raw.Typed{ContentType: "text/plain", Data: []byte(key)}, 0)
+		case 5:
+			provider.Incr("counter", 1, 0)
+			provider.Exec([]TxOp{{Op: TxSet, Key: key, Value: i}, {Op: TxDelete, Key: key + "_batch"}}, nil)
+		case 6:
+			namespace.Set(key, i, 0)
+			provider.MDelete([]string{"raw_" + key, "hash_" + key})
+		}
+		if i == 300 {
+			assert.Nil(t, RewriteAOF())
+		}
+	}
+	data, _ := json.Marshal(dumpKeyspace(t, provider, namespace))
+	ioutil.WriteFile("./data/killed_keyspace.json", data, 0644)
+	// process is killed between pending record and operation
+	aof.Namespace("").Write("key_0", "lost", 0, 0, aof.Pending)
+	syscall.Kill(os.Getpid(), syscall.SIGKILL)
+}
+
+func TestKillAndRestore(t *testing.T) {
+	if os.Getenv("CACHER_KILLED_CACHE") == "1" {
+		runKilledCache(t)
+		return
+	}
+	os.RemoveAll("./data/aof")
+	os.Remove("./data/killed_keyspace.json")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestKillAndRestore$")
+	cmd.Env = append(os.Environ(), "CACHER_KILLED_CACHE=1")
+	err := cmd.Run()
+	assert.IsType(t, &exec.ExitError{}, err)
+
+	data, err := ioutil.ReadFile("./data/killed_keyspace.json")
+	assert.Nil(t, err)
+	var before map[string]killedKey
+	json.Unmarshal(data, &before)
+	assert.NotEmpty(t, before)
+
+	logger := l.New(ioutil.Discard, "", 0)
+	restored, _ := New("sharded-map", logger, false, 60, true)
+	namespace, _ := restored.NewNamespace("ns")
+	assert.Equal(t, before, dumpKeyspace(t, restored, namespace))
+}
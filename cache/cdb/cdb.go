@@ -4,8 +4,12 @@ import (
 	"encoding/json"
 	"log"
 	l "log"
+	"strings"
 	"time"
 
+	"../batch"
+	"../raw"
+	"../types"
 	"./leveldb"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 )
@@ -13,6 +17,18 @@ import (
 type Record struct {
 	Value     interface{}
 	ExpiredAt int64
+	// Value is raw.Bytes, JSON keeps it as base64 string, or raw.Typed kept as object
+	Opaque bool `json:",omitempty"`
+	// Type of structure kept in Value
+	Type string `json:",omitempty"`
+	// Version of the value, restored to keep CAS tokens valid
+	Version uint64 `json:",omitempty"`
+}
+
+// Keyspace keeps records of namespace, keys of named namespaces are prefixed by "\x00<namespace>\x00",
+// so they don't clash with keys of other namespaces
+type Keyspace struct {
+	prefix string
 }
 
 var (
@@ -21,6 +37,28 @@ var (
 	updatedAtTimestampKey = "--updated_at_timestamp--"
 )
 
+// Namespace returns keyspace of namespace, empty name is the default namespace
+func Namespace(name string) Keyspace {
+	if name == "" {
+		return Keyspace{}
+	}
+	return Keyspace{prefix: "\x00" + name + "\x00"}
+}
+
+// Key returns key of record stored at CDB key if it belongs to keyspace
+func (ks Keyspace) Key(cdbKey string) (string, bool) {
+	if IsServiceKey(cdbKey) {
+		return "", false
+	}
+	if ks.prefix == "" {
+		return cdbKey, !strings.HasPrefix(cdbKey, "\x00")
+	}
+	if !strings.HasPrefix(cdbKey, ks.prefix) {
+		return "", false
+	}
+	return cdbKey[len(ks.prefix):], true
+}
+
 func Init(period int, log *l.Logger) {
 	leveldb.InitConnection()
 
@@ -49,15 +87,12 @@ func initPeriodicBackup(period int) {
 
 }
 
-func Set(key string, value interface{}, ttl int64) (err error) {
-	record := Record{Value: value}
-	if ttl != 0 {
-		record.ExpiredAt = time.Now().Add(time.Second * time.Duration(ttl)).Unix()
-	}
-	data, err := json.Marshal(record)
+func (ks Keyspace) Set(key string, value interface{}, ttl int64, version uint64) (err error) {
+	data, err := encode(value, ttl, version)
 	if err != nil {
 		return err
 	}
+	key = ks.prefix + key
 
 	if directWrite == true {
 		refreshUpdatedAtTimestamp()
@@ -72,16 +107,53 @@ func Set(key string, value interface{}, ttl int64) (err error) {
 	return nil
 }
 
-func Delete(key string) (err error) {
-	data, err := json.Marshal(key)
+// SetBatch saves items of MSET as one LevelDB batch
+func (ks Keyspace) SetBatch(items []batch.Item) (err error) {
+	keys := make([]string, len(items))
+	values := make([][]byte, len(items))
+	for i, item := range items {
+		keys[i] = ks.prefix + item.Key
+		if values[i], err = encode(item.Value, item.TTL, item.Version); err != nil {
+			return err
+		}
+	}
+
+	if directWrite == true {
+		refreshUpdatedAtTimestamp()
+		err = leveldb.WriteBatch(keys, values)
+	} else {
+		for i, key := range keys {
+			leveldb.AddToBatch(key, values[i])
+		}
+	}
 	if err != nil {
+		log.Fatalf("Can't save batch to CDB. %s", err)
 		return err
 	}
+	return nil
+}
+
+func encode(value interface{}, ttl int64, version uint64) ([]byte, error) {
+	record := Record{Value: value, Version: version}
+	switch v := value.(type) {
+	case raw.Bytes, raw.Typed:
+		record.Opaque = true
+	case types.Structure:
+		record.Type = v.Type()
+	}
+	if ttl != 0 {
+		record.ExpiredAt = time.Now().Add(time.Second * time.Duration(ttl)).Unix()
+	}
+	return json.Marshal(record)
+}
+
+func (ks Keyspace) Delete(key string) (err error) {
+	key = ks.prefix + key
 	if directWrite == true {
 		refreshUpdatedAtTimestamp()
-		err = leveldb.DelKey(data)
+		err = leveldb.DelKey([]byte(key))
 	} else {
-		err = leveldb.RemoveFromBatch(data)
+		err = leveldb.RemoveFromBatch([]byte(key))
 	}
 
 	if err != nil {
@@ -90,8 +162,37 @@ func Delete(key string) (err error) {
 	return nil
 }
 
+// DeleteBatch removes keys of MDEL as one LevelDB batch
+func (ks Keyspace) DeleteBatch(keys []string) (err error) {
+	if ks.prefix != "" {
+		prefixed := make([]string, len(keys))
+		for i, key := range keys {
+			prefixed[i] = ks.prefix + key
+		}
+		keys = prefixed
+	}
+	if directWrite == true {
+		refreshUpdatedAtTimestamp()
+		err = leveldb.DelBatch(keys)
+	} else {
+		for _, key := range keys {
+			leveldb.RemoveFromBatch([]byte(key))
+		}
+	}
+
+	if err != nil {
+		log.Fatalf("Error while cleaning CDB keys %v: %s", keys, err)
+	}
+	return nil
+}
+
+// IsServiceKey reports whether key is used by CDB itself and isn't a cache record
+func IsServiceKey(key string) bool {
+	return key == updatedAtTimestampKey
+}
+
 func refreshUpdatedAtTimestamp() {
-	Set(updatedAtTimestampKey, time.Now().Unix(), 0)
+	Keyspace{}.Set(updatedAtTimestampKey, time.Now().Unix(), 0, 0)
 }
 
 func GetUpdatedAtTimestamp() int64 {
@@ -44,6 +44,15 @@ func WriteKey(key string, value []byte) (err error) {
 	return nil
 }
 
+// WriteBatch writes all keys at once
+func WriteBatch(keys []string, values [][]byte) error {
+	b := new(leveldb.Batch)
+	for i, key := range keys {
+		b.Put([]byte(key), values[i])
+	}
+	return dbi.Write(b, nil)
+}
+
 func AddToBatch(key string, value []byte) (err error) {
 	batch.Put([]byte(key), value)
 	return nil
@@ -57,6 +66,15 @@ func DelKey(key []byte) (err error) {
 	return nil
 }
 
+// DelBatch removes all keys at once
+func DelBatch(keys []string) error {
+	b := new(leveldb.Batch)
+	for _, key := range keys {
+		b.Delete([]byte(key))
+	}
+	return dbi.Write(b, nil)
+}
+
 func RemoveFromBatch(key []byte) (err error) {
 	batch.Delete(key)
 	return nil
@@ -0,0 +1,150 @@
+// Package counter implements arithmetic of INCR-like commands on plain cached values.
+package counter
+
+import (
+	"errors"
+	"math"
+	"strconv"
+
+	"../raw"
+)
+
+// Delta is an increment, integer one requires integer value and float one works with any number
+type Delta struct {
+	Integer int64
+	Float   float64
+	IsFloat bool
+}
+
+// MaxSize is the longest JSON of a counter value, used to reserve memory before increment
+const MaxSize = 32
+
+var (
+	ErrNotInteger = errors.New("value is not an integer or out of range")
+	ErrNotFloat   = errors.New("value is not a valid float")
+	ErrOverflow   = errors.New("increment or decrement would overflow")
+	ErrNaN        = errors.New("increment would produce NaN or Infinity")
+)
+
+// maxExactInteger is the biggest integer that JSON number (float64) keeps exactly
+const maxExactInteger = 1 << 53
+
+func Int(delta int64) Delta {
+	return Delta{Integer: delta}
+}
+
+func Float(delta float64) Delta {
+	return Delta{Float: delta, IsFloat: true}
+}
+
+// Add returns value increased by delta, missed value counts as zero. Result keeps representation of value:
+// numbers stay numbers, while strings and raw bytes, which are set over RESP and memcached, stay strings.
+// Typed bytes keep their content type.
+func Add(value interface{}, found bool, delta Delta) (interface{}, error) {
+	if !found {
+		if delta.IsFloat {
+			return checkFloat(delta.Float)
+		}
+		return delta.Integer, nil
+	}
+
+	switch v := value.(type) {
+	case float64:
+		if delta.IsFloat {
+			return checkFloat(v + delta.Float)
+		}
+		if v != math.Trunc(v) || math.Abs(v) > maxExactInteger {
+			return nil, ErrNotInteger
+		}
+		return addInt(int64(v), delta.Integer)
+	case string:
+		result, err := addString(v, delta)
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	case raw.Bytes:
+		result, err := addString(string(v), delta)
+		if err != nil {
+			return nil, err
+		}
+		return raw.Bytes(result), nil
+	case raw.Typed:
+		result, err := addString(string(v.Data), delta)
+		if err != nil {
+			return nil, err
+		}
+		return raw.Typed{ContentType: v.ContentType, Data: []byte(result)}, nil
+	}
+	if delta.IsFloat {
+		return nil, ErrNotFloat
+	}
+	return nil, ErrNotInteger
+}
+
+func addString(value string, delta Delta) (string, error) {
+	if delta.IsFloat {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", ErrNotFloat
+		}
+		result, err := checkFloat(number + delta.Float)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatFloat(result, 'f', -1, 64), nil
+	}
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return "", ErrNotInteger
+	}
+	result, err := addInt(number, delta.Integer)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(result, 10), nil
+}
+
+func addInt(value int64, delta int64) (int64, error) {
+	if (delta > 0 && value > math.MaxInt64-delta) || (delta < 0 && value < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+	return value + delta, nil
+}
+
+func checkFloat(value float64) (float64, error) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, ErrNaN
+	}
+	return value, nil
+}
+
+// ToInt converts result of integer increment to int64
+func ToInt(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case raw.Bytes:
+		return strconv.ParseInt(string(v), 10, 64)
+	case raw.Typed:
+		return strconv.ParseInt(string(v.Data), 10, 64)
+	}
+	return 0, ErrNotInteger
+}
+
+// ToFloat converts result of float increment to float64
+func ToFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	case raw.Bytes:
+		return strconv.ParseFloat(string(v), 64)
+	case raw.Typed:
+		return strconv.ParseFloat(string(v.Data), 64)
+	}
+	return 0, ErrNotFloat
+}
@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"math"
+
+	"./counter"
+)
+
+// Incr atomically increments integer stored at key by delta, missed key counts as zero.
+// Key keeps its TTL unless ttl isn't 0. The resulting value is persisted, not the delta.
+func (cm *CacheManager) Incr(key string, delta int64, ttl int64) (int64, error) {
+	value, err := cm.incr(key, counter.Int(delta), ttl)
+	if err != nil {
+		return 0, err
+	}
+	return counter.ToInt(value)
+}
+
+// Decr atomically decrements integer stored at key by delta
+func (cm *CacheManager) Decr(key string, delta int64, ttl int64) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, counter.ErrOverflow
+	}
+	return cm.Incr(key, -delta, ttl)
+}
+
+// IncrFloat atomically increments number stored at key by float delta
+func (cm *CacheManager) IncrFloat(key string, delta float64, ttl int64) (float64, error) {
+	value, err := cm.incr(key, counter.Float(delta), ttl)
+	if err != nil {
+		return 0, err
+	}
+	return counter.ToFloat(value)
+}
+
+func (cm *CacheManager) incr(key string, delta counter.Delta, ttl int64) (interface{}, error) {
+	if cm.evictor != nil {
+		// new value isn't known before increment, so room for the longest counter is reserved
+		// and the exact size is accounted afterwards
+		_, expiredAt, _, _, _ := cm.Provider.Get(key)
+		victims, err := cm.evictor.Reserve(key, counter.MaxSize, expiredAt)
+		cm.evict(victims)
+		if err != nil {
+			return nil, MemoryLimitError{cm.evictionPolicy}
+		}
+	}
+
+	unlock := cm.lockEvents(key)
+	value, expiredAt, version, err := cm.Provider.Incr(key, delta, ttl)
+	if err != nil {
+		unlock()
+		if cm.evictor != nil {
+			cm.reaccount(key)
+		}
+		return nil, err
+	}
+	var victims []string
+	if cm.evictor != nil {
+		// counter is not longer than reserved room, so nothing else is evicted
+		victims, _ = cm.account(key, value, expiredAt)
+	}
+	cm.persist(key, value, expiredAt, version)
+	unlock()
+	cm.evict(victims)
+	return value, nil
+}
+
+// reaccount restores accounted size of key after failed write
+func (cm *CacheManager) reaccount(key string) {
+	value, expiredAt, _, found, _ := cm.Provider.Get(key)
+	if !found {
+		cm.evictor.Remove(key)
+		return
+	}
+	victims, _ := cm.account(key, value, expiredAt)
+	cm.evict(victims)
+}
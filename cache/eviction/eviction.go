@@ -0,0 +1,197 @@
+package eviction
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type (
+	// Entry holds usage statistics of a single key
+	Entry struct {
+		Key        string
+		Size       int64
+		ExpiredAt  int64
+		AccessedAt int64
+		Hits       uint32
+	}
+
+	// Policy decides which of sampled keys should be evicted first
+	Policy interface {
+		// Volatile reports whether only keys with TTL could be evicted
+		Volatile() bool
+		// Prefer reports whether a should be evicted rather than b
+		Prefer(a, b *Entry, now int64) bool
+	}
+
+	// Evictor tracks memory used by the cache and chooses keys to evict when limits are reached.
+	// Like Redis it doesn't keep keys ordered, but samples a few of them and picks the best candidate.
+	Evictor struct {
+		mu         sync.Mutex
+		entries    map[string]*Entry
+		used       int64
+		maxMemory  int64
+		maxKeys    int
+		policy     Policy
+		sampleSize int
+	}
+
+	noEviction  struct{}
+	lru         struct{ volatile bool }
+	lfu         struct{}
+	volatileTTL struct{}
+	random      struct{}
+)
+
+// ErrOutOfMemory is returned when the limit is reached and the policy can't free enough space
+var ErrOutOfMemory = errors.New("memory limit reached")
+
+var policies = map[string]Policy{
+	"noeviction":   noEviction{},
+	"allkeys-lru":  lru{},
+	"allkeys-lfu":  lfu{},
+	"volatile-lru": lru{volatile: true},
+	"volatile-ttl": volatileTTL{},
+	"random":       random{},
+}
+
+// LFU counter is halved for every period the key wasn't accessed
+const lfuDecayPeriod = int64(time.Minute)
+
+// New returns evictor for the given limits, zero limit means unlimited
+func New(maxMemory int64, maxKeys int, policyName string, sampleSize int) (*Evictor, error) {
+	policy, ok := policies[policyName]
+	if !ok {
+		return nil, fmt.Errorf("Eviction policy '%s' is invalid.", policyName)
+	}
+	if sampleSize <= 0 {
+		return nil, fmt.Errorf("Eviction sample size should be positive, got %d.", sampleSize)
+	}
+	return &Evictor{
+		entries:    make(map[string]*Entry),
+		maxMemory:  maxMemory,
+		maxKeys:    maxKeys,
+		policy:     policy,
+		sampleSize: sampleSize,
+	}, nil
+}
+
+// Reserve makes room for storing size bytes under key. It returns keys that have to be evicted
+// from the cache, or ErrOutOfMemory if it isn't possible. Nothing is changed in case of error.
+func (e *Evictor) Reserve(key string, size int64, expiredAt int64) (victims []string, err error) {
+	now := time.Now().UnixNano()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	used := e.used + size
+	keys := len(e.entries) + 1
+	entry, found := e.entries[key]
+	if found {
+		used -= entry.Size
+		keys--
+	}
+
+	excluded := map[string]bool{key: true}
+	for e.exceeds(used, keys) {
+		victim := e.sample(excluded, now)
+		if victim == nil {
+			return nil, ErrOutOfMemory
+		}
+		excluded[victim.Key] = true
+		victims = append(victims, victim.Key)
+		used -= victim.Size
+		keys--
+	}
+
+	for _, victim := range victims {
+		delete(e.entries, victim)
+	}
+	if !found {
+		entry = &Entry{Key: key}
+		e.entries[key] = entry
+	}
+	entry.Size = size
+	entry.ExpiredAt = expiredAt
+	entry.AccessedAt = now
+	e.used = used
+	return victims, nil
+}
+
+// Touch updates access statistics of the key
+func (e *Evictor) Touch(key string) {
+	now := time.Now().UnixNano()
+	e.mu.Lock()
+	if entry, found := e.entries[key]; found {
+		entry.Hits = decayedHits(entry, now) + 1
+		entry.AccessedAt = now
+	}
+	e.mu.Unlock()
+}
+
+// Remove stops tracking of the key
+func (e *Evictor) Remove(key string) {
+	e.mu.Lock()
+	if entry, found := e.entries[key]; found {
+		e.used -= entry.Size
+		delete(e.entries, key)
+	}
+	e.mu.Unlock()
+}
+
+// Used returns amount of tracked memory and number of keys
+func (e *Evictor) Used() (memory int64, keys int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.used, len(e.entries)
+}
+
+func (e *Evictor) exceeds(used int64, keys int) bool {
+	return (e.maxMemory > 0 && used > e.maxMemory) || (e.maxKeys > 0 && keys > e.maxKeys)
+}
+
+// sample picks the best candidate among sampleSize keys, map iteration order makes the sample random
+func (e *Evictor) sample(excluded map[string]bool, now int64) (best *Entry) {
+	if _, ok := e.policy.(noEviction); ok {
+		return nil
+	}
+	sampled := 0
+	for key, entry := range e.entries {
+		if excluded[key] || (e.policy.Volatile() && entry.ExpiredAt == 0) {
+			continue
+		}
+		if best == nil || e.policy.Prefer(entry, best, now) {
+			best = entry
+		}
+		sampled++
+		if sampled == e.sampleSize {
+			break
+		}
+	}
+	return best
+}
+
+func decayedHits(entry *Entry, now int64) uint32 {
+	periods := (now - entry.AccessedAt) / lfuDecayPeriod
+	if periods >= 32 {
+		return 0
+	}
+	return entry.Hits >> uint(periods)
+}
+
+func (noEviction) Volatile() bool                     { return false }
+func (noEviction) Prefer(a, b *Entry, now int64) bool { return false }
+
+func (p lru) Volatile() bool                     { return p.volatile }
+func (p lru) Prefer(a, b *Entry, now int64) bool { return a.AccessedAt < b.AccessedAt }
+
+func (lfu) Volatile() bool { return false }
+func (lfu) Prefer(a, b *Entry, now int64) bool {
+	return decayedHits(a, now) < decayedHits(b, now)
+}
+
+func (volatileTTL) Volatile() bool                     { return true }
+func (volatileTTL) Prefer(a, b *Entry, now int64) bool { return a.ExpiredAt < b.ExpiredAt }
+
+func (random) Volatile() bool                     { return false }
+func (random) Prefer(a, b *Entry, now int64) bool { return false }
@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+)
+
+type (
+	// Expirer is implemented by providers that are able to actively remove expired records
+	Expirer interface {
+		// DeleteExpired checks up to sampleSize keys with TTL, removes the expired ones
+		// and returns their keys together with the number of checked keys.
+		DeleteExpired(sampleSize int) (expired []string, sampled int)
+	}
+)
+
+// expiration cycle is repeated while more than this share of sampled keys were expired
+const expiredThreshold = 0.25
+
+// StartExpiration launches background sweeper similar to Redis active expiry.
+// Every interval it samples keys with TTL and deletes expired ones. A single cycle
+// keeps sampling while the sample is mostly expired, but never takes more than
+// budget percents of the interval.
+func (cm *CacheManager) StartExpiration(interval time.Duration, sampleSize int, budget int) error {
+	if interval <= 0 {
+		return nil
+	}
+	if sampleSize <= 0 {
+		return fmt.Errorf("Expiration sample size should be positive, got %d.", sampleSize)
+	}
+	if budget <= 0 || budget > 100 {
+		return fmt.Errorf("Expiration CPU budget should be in range 1-100%%, got %d.", budget)
+	}
+	expirer, ok := cm.Provider.(Expirer)
+	if !ok {
+		return fmt.Errorf("Cache Provider doesn't support active expiration.")
+	}
+
+	cm.StopExpiration()
+	stop := make(chan struct{})
+	cm.expirationStop = stop
+	timeLimit := interval * time.Duration(budget) / 100
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				cm.expireCycle(expirer, sampleSize, timeLimit)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// StopExpiration stops background sweeper if it was started
+func (cm *CacheManager) StopExpiration() {
+	if cm.expirationStop != nil {
+		close(cm.expirationStop)
+		cm.expirationStop = nil
+	}
+}
+
+func (cm *CacheManager) expireCycle(expirer Expirer, sampleSize int, timeLimit time.Duration) (total int) {
+	start := time.Now()
+	for {
+		expired, sampled := expirer.DeleteExpired(sampleSize)
+		for _, key := range expired {
+			cm.dropExpired(key)
+		}
+		total += len(expired)
+
+		if sampled == 0 || float64(len(expired)) <= float64(sampled)*expiredThreshold {
+			return total
+		}
+		if time.Since(start) >= timeLimit {
+			return total
+		}
+	}
+}
+
+// dropExpired releases key removed by expirer, unless it was already stored again and its write was notified
+func (cm *CacheManager) dropExpired(key string) {
+	unlock := cm.lockEvents(key)
+	defer unlock()
+	if _, _, _, found, _ := cm.Provider.Get(key); found {
+		return
+	}
+	if cm.evictor != nil {
+		cm.evictor.Remove(key)
+	}
+	cm.dropPersisted(key)
+	cm.notify(EventExpired, key, nil, 0)
+}
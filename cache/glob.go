@@ -0,0 +1,25 @@
+package cache
+
+import "./glob"
+
+// InvalidPatternError is returned for glob pattern with unterminated [...] class or trailing backslash
+type InvalidPatternError = glob.InvalidPatternError
+
+// ValidatePattern checks glob pattern of KEYS, SCAN and keyspace events, see glob.Validate
+func ValidatePattern(pattern string) error {
+	return glob.Validate(pattern)
+}
+
+// globMatcher matches keys by glob pattern like KEYS command, empty pattern matches all keys.
+// Invalid pattern matches nothing.
+func globMatcher(pattern string) (func(key string) bool, error) {
+	if pattern == "" || pattern == "*" {
+		return func(string) bool { return true }, nil
+	}
+	if err := glob.Validate(pattern); err != nil {
+		return func(string) bool { return false }, err
+	}
+	return func(key string) bool {
+		return glob.Match(pattern, key)
+	}, nil
+}
@@ -0,0 +1,118 @@
+// Package glob matches keys and channels by Redis style glob patterns.
+package glob
+
+import "fmt"
+
+// InvalidPatternError is returned for glob pattern with unterminated [...] class or trailing backslash
+type InvalidPatternError struct {
+	pattern string
+}
+
+func (ipe InvalidPatternError) Error() string {
+	return fmt.Sprintf("Invalid pattern '%s'.", ipe.pattern)
+}
+
+// Validate checks glob pattern of KEYS, SCAN, keyspace events and PSUBSCRIBE. Like in Redis, '*' matches any bytes
+// including '/', '?' matches one byte, [...] matches a byte of class (with ranges and '^' negation)
+// and backslash escapes the next byte.
+func Validate(pattern string) error {
+	for i := 0; i < len(pattern); {
+		switch pattern[i] {
+		case '\\':
+			if i+1 == len(pattern) {
+				return InvalidPatternError{pattern}
+			}
+			i += 2
+		case '[':
+			_, width, ok := matchClass(pattern[i:], 0)
+			if !ok {
+				return InvalidPatternError{pattern}
+			}
+			i += width
+		default:
+			i++
+		}
+	}
+	return nil
+}
+
+// Match matches key by pattern which is checked by Validate, '*' is backtracked to the latest one only
+func Match(pattern string, key string) bool {
+	px, kx := 0, 0
+	// position of the latest '*' and of key byte it's going to take on mismatch
+	starPx, starKx := -1, -1
+	for px < len(pattern) || kx < len(key) {
+		if px < len(pattern) {
+			switch c := pattern[px]; c {
+			case '*':
+				starPx, starKx = px, kx+1
+				px++
+				continue
+			case '?':
+				if kx < len(key) {
+					px++
+					kx++
+					continue
+				}
+			case '[':
+				if kx < len(key) {
+					if matched, width, _ := matchClass(pattern[px:], key[kx]); matched {
+						px += width
+						kx++
+						continue
+					}
+				}
+			case '\\':
+				if kx < len(key) && key[kx] == pattern[px+1] {
+					px += 2
+					kx++
+					continue
+				}
+			default:
+				if kx < len(key) && key[kx] == c {
+					px++
+					kx++
+					continue
+				}
+			}
+		}
+		if starKx > 0 && starKx <= len(key) {
+			px, kx = starPx, starKx
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// matchClass matches byte by [...] class which pattern starts with and returns width of the class,
+// ok is false if the class isn't terminated
+func matchClass(pattern string, c byte) (matched bool, width int, ok bool) {
+	i := 1
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+	for ; i < len(pattern); i++ {
+		switch {
+		case pattern[i] == '\\':
+			i++
+			if i == len(pattern) {
+				return false, 0, false
+			}
+			matched = matched || pattern[i] == c
+		case pattern[i] == ']':
+			return matched != negate, i + 1, true
+		case i+2 < len(pattern) && pattern[i+1] == '-':
+			low, high := pattern[i], pattern[i+2]
+			if low > high {
+				low, high = high, low
+			}
+			matched = matched || (low <= c && c <= high)
+			i += 2
+		default:
+			matched = matched || pattern[i] == c
+		}
+	}
+	return false, 0, false
+}
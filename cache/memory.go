@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+
+	"./eviction"
+	"./raw"
+)
+
+type (
+	// MemoryLimitError is returned by Set when memory limit is reached and eviction policy can't free enough space
+	MemoryLimitError struct {
+		policy string
+	}
+)
+
+func (mle MemoryLimitError) Error() string {
+	return fmt.Sprintf("OOM command not allowed when used memory is over the limit (eviction policy '%s').", mle.policy)
+}
+
+// SetMemoryLimit enables eviction of keys with the given policy once maxMemory bytes of values
+// or maxKeys keys are stored. Zero value disables the limit. Records that are already in cache are accounted
+// and evicted if needed, so it's safe to call after restore.
+func (cm *CacheManager) SetMemoryLimit(maxMemory int64, maxKeys int, policy string, sampleSize int) error {
+	cm.evictor = nil
+	if maxMemory <= 0 && maxKeys <= 0 {
+		return nil
+	}
+	evictor, err := eviction.New(maxMemory, maxKeys, policy, sampleSize)
+	if err != nil {
+		return err
+	}
+	cm.evictor = evictor
+	cm.evictionPolicy = policy
+
+	keys, err := cm.Provider.GetKeys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		value, expiredAt, _, found, err := cm.Provider.Get(key)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		var ttl int64
+		if expiredAt != 0 {
+			ttl = expiredAt - time.Now().Unix()
+		}
+		err = cm.reserve(key, value, ttl)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MemoryUsage returns size of stored values and number of keys accounted by memory limit
+func (cm *CacheManager) MemoryUsage() (used int64, keys int) {
+	if cm.evictor == nil {
+		return 0, 0
+	}
+	return cm.evictor.Used()
+}
+
+// reserve makes room for a new value evicting other keys if needed
+func (cm *CacheManager) reserve(key string, value interface{}, ttl int64) error {
+	var expiredAt int64
+	if ttl != 0 {
+		expiredAt = time.Now().Add(time.Second * time.Duration(ttl)).Unix()
+	}
+	victims, err := cm.account(key, value, expiredAt)
+	cm.evict(victims)
+	return err
+}
+
+// account reserves memory for a value and returns keys which have to be evicted to make room for it
+func (cm *CacheManager) account(key string, value interface{}, expiredAt int64) ([]string, error) {
+	data, _, err := raw.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	victims, err := cm.evictor.Reserve(key, int64(len(data)), expiredAt)
+	if err != nil {
+		return nil, MemoryLimitError{cm.evictionPolicy}
+	}
+	return victims, nil
+}
+
+// evict removes keys chosen by eviction policy, caller must not hold locks of events
+func (cm *CacheManager) evict(victims []string) {
+	for _, victim := range victims {
+		unlock := cm.lockEvents(victim)
+		cm.Provider.Delete(victim)
+		cm.dropPersisted(victim)
+		cm.notify(EventEvicted, victim, nil, 0)
+		unlock()
+	}
+}
@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"time"
+
+	"./aof"
+	"./batch"
+)
+
+// Item is a key with its value used by multi-key operations
+type Item = batch.Item
+
+// MGet returns items of keys in the same order, Found is false for missed keys
+func (cm *CacheManager) MGet(keys []string) ([]Item, error) {
+	items, err := cm.Provider.MGet(keys)
+	if err != nil {
+		return nil, err
+	}
+	if cm.evictor != nil {
+		for _, item := range items {
+			if item.Found {
+				cm.evictor.Touch(item.Key)
+			}
+		}
+	}
+	return items, nil
+}
+
+// MSet sets Value with TTL of all items, nothing is set if any of them can't be stored.
+// Batch is logged to AOF as one record and saved to CDB as one LevelDB batch.
+func (cm *CacheManager) MSet(items []Item) error {
+	// versions are set on a copy to not change items of caller
+	items = append([]Item(nil), items...)
+	for i := range items {
+		items[i].Version = cm.Provider.NextVersion()
+		items[i].TTL = cm.ttlOrDefault(items[i].TTL)
+	}
+	return cm.mset(items)
+}
+
+func (cm *CacheManager) mset(items []Item) (err error) {
+	if cm.evictor != nil {
+		for i, item := range items {
+			if err = cm.reserve(item.Key, item.Value, item.TTL); err != nil {
+				// release room reserved for previous items
+				for _, reserved := range items[:i] {
+					cm.reaccount(reserved.Key)
+				}
+				return err
+			}
+		}
+	}
+	keys := make([]string, len(items))
+	for i, item := range items {
+		keys[i] = item.Key
+	}
+	unlock := cm.lockEvents(keys...)
+	if !cm.RestoreMode {
+		cm.aofLog.WriteBatch(items, aof.Pending)
+	}
+	err = cm.Provider.MSet(items)
+	if !cm.RestoreMode {
+		if err != nil {
+			cm.aofLog.WriteBatch(items, aof.Failed)
+		} else {
+			cm.aofLog.WriteBatch(items, aof.Completed)
+		}
+	}
+	if err == nil {
+		if cm.CDBEnabled {
+			cm.keyspace.SetBatch(items)
+		}
+		for _, item := range items {
+			var expiredAt int64
+			if item.TTL != 0 {
+				expiredAt = time.Now().Add(time.Second * time.Duration(item.TTL)).Unix()
+			}
+			cm.notify(EventSet, item.Key, item.Value, expiredAt)
+		}
+	}
+	unlock()
+	if cm.evictor != nil {
+		// reservation of an item could evict another item of the same batch, so all of them are accounted again
+		for _, item := range items {
+			cm.reaccount(item.Key)
+		}
+	}
+	return err
+}
+
+// MDelete removes keys and returns number of keys that existed. Batch is logged to AOF as one record.
+func (cm *CacheManager) MDelete(keys []string) (int, error) {
+	unlock := cm.lockEvents(keys...)
+	defer unlock()
+	if !cm.RestoreMode {
+		cm.aofLog.DeleteBatch(keys, aof.Pending)
+	}
+	deleted, err := cm.Provider.MDelete(keys)
+	if cm.evictor != nil {
+		for _, key := range keys {
+			cm.evictor.Remove(key)
+		}
+	}
+	if !cm.RestoreMode {
+		if err != nil {
+			cm.aofLog.DeleteBatch(keys, aof.Failed)
+		} else {
+			cm.aofLog.DeleteBatch(keys, aof.Completed)
+		}
+	}
+	if cm.CDBEnabled {
+		cm.keyspace.DeleteBatch(keys)
+	}
+	if err == nil {
+		for _, key := range keys {
+			cm.notify(EventDelete, key, nil, 0)
+		}
+	}
+	return deleted, err
+}
+
+// restoreBatch sets items of MSET record read from AOF, which was written at passed time.
+// Expired items are deleted, they could be restored from CDB.
+func (cm *CacheManager) restoreBatch(entries []aof.BatchEntry, written int64) error {
+	items := make([]Item, 0, len(entries))
+	var expired []string
+	for _, entry := range entries {
+		ttl, isExpired := restoredTTL(entry.ExpiredAt, entry.TTL, written)
+		if isExpired {
+			expired = append(expired, entry.Key)
+			continue
+		}
+		value, err := restoreValue(entry.Value, entry.Opaque, entry.Type)
+		if err != nil {
+			return err
+		}
+		item := Item{Key: entry.Key, Value: value, TTL: ttl, Version: entry.Version}
+		if item.Version == 0 {
+			item.Version = cm.Provider.NextVersion()
+		}
+		items = append(items, item)
+	}
+	if len(expired) > 0 {
+		cm.MDelete(expired)
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	return cm.mset(items)
+}
@@ -1,79 +1,344 @@
 package mutex_map
 
 import (
-	"encoding/json"
 	"sync"
 	"time"
+
+	"../batch"
+	"../counter"
+	"../raw"
+	"../record"
+	"../scan"
+	"../types"
+	"../version"
 )
 
 type (
-	Record struct {
-		Value     []byte
-		ExpiredAt int64
-	}
-
 	Storage struct {
 		mu     sync.RWMutex
-		values map[string]Record
+		values map[string]record.Record
+		// keys that have TTL, used by active expiration to sample candidates
+		expires map[string]struct{}
+		// keys ordered for Scan
+		keys     *scan.Index
+		versions version.Counter
 	}
 )
 
 func New() *Storage {
 	return &Storage{
-		values: make(map[string]Record),
+		values:  make(map[string]record.Record),
+		expires: make(map[string]struct{}),
+		keys:    scan.NewIndex(),
 	}
 }
 
-func (s *Storage) Set(key string, value interface{}, ttl int64) error {
-	data, err := json.Marshal(value)
+// NextVersion returns version for a record which is going to be written by Set or CompareAndSet
+func (s *Storage) NextVersion() uint64 {
+	return s.versions.Next()
+}
+
+// Set stores value with passed version, which is either got from NextVersion or restored from persistence
+func (s *Storage) Set(key string, value interface{}, ttl int64, version uint64) error {
+	r, err := record.New(value, ttl, version)
 	if err != nil {
 		return err
 	}
-
-	record := Record{Value: data}
-	if ttl != 0 {
-		record.ExpiredAt = time.Now().Add(time.Second * time.Duration(ttl)).Unix()
-	}
+	s.versions.Observe(version)
 
 	s.mu.Lock()
-	s.values[key] = record
+	s.store(key, r)
 	s.mu.Unlock()
 	return nil
 }
 
-func (s *Storage) Get(key string) (interface{}, int64, bool, error) {
+// CompareAndSet stores value only if the key has expected version, 0 means that key has to be missed
+func (s *Storage) CompareAndSet(key string, value interface{}, ttl int64, expected uint64, version uint64) (bool, error) {
+	r, err := record.New(value, ttl, version)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, found := s.values[key]
+	if !found || current.Expired(time.Now().Unix()) {
+		current = record.Record{}
+	}
+	if current.Version != expected {
+		return false, nil
+	}
+	s.store(key, r)
+	return true, nil
+}
+
+// store puts record to the map, caller holds the lock
+func (s *Storage) store(key string, r record.Record) {
+	if _, exists := s.values[key]; !exists {
+		s.keys.Add(key)
+	}
+	s.values[key] = r
+	if r.ExpiredAt > 0 {
+		s.expires[key] = struct{}{}
+	} else {
+		delete(s.expires, key)
+	}
+}
+
+// remove deletes key from the map, caller holds the lock
+func (s *Storage) remove(key string) {
+	if _, exists := s.values[key]; exists {
+		delete(s.values, key)
+		s.keys.Remove(key)
+	}
+	delete(s.expires, key)
+}
+
+// Get returns value, expiration time and version of the key
+func (s *Storage) Get(key string) (interface{}, int64, uint64, bool, error) {
 	s.mu.RLock()
-	record, found := s.values[key]
+	r, found := s.values[key]
 	s.mu.RUnlock()
 	if !found {
-		return nil, 0, false, nil
+		return nil, 0, 0, false, nil
+	}
+	return r.Decode(time.Now().Unix())
+}
+
+// GetRaw returns bytes kept for value of the key without decoding them, with expiration time and version
+func (s *Storage) GetRaw(key string) (raw.Typed, int64, uint64, bool, error) {
+	s.mu.RLock()
+	r, found := s.values[key]
+	s.mu.RUnlock()
+	if !found {
+		return raw.Typed{}, 0, 0, false, nil
+	}
+	return r.Raw(time.Now().Unix())
+}
+
+// MGet returns items of keys in the same order taking the lock once
+func (s *Storage) MGet(keys []string) ([]batch.Item, error) {
+	records := make([]record.Record, len(keys))
+	found := make([]bool, len(keys))
+	s.mu.RLock()
+	for i, key := range keys {
+		records[i], found[i] = s.values[key]
+	}
+	s.mu.RUnlock()
+
+	now := time.Now().Unix()
+	items := make([]batch.Item, len(keys))
+	for i, key := range keys {
+		items[i].Key = key
+		if !found[i] {
+			continue
+		}
+		var err error
+		items[i].Value, items[i].ExpiredAt, items[i].Version, items[i].Found, err = records[i].Decode(now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// MSet stores all items with their TTL and version taking the lock once, nothing is stored if any value is invalid
+func (s *Storage) MSet(items []batch.Item) error {
+	records := make([]record.Record, len(items))
+	for i, item := range items {
+		r, err := record.New(item.Value, item.TTL, item.Version)
+		if err != nil {
+			return err
+		}
+		records[i] = r
+		s.versions.Observe(item.Version)
+	}
+
+	s.mu.Lock()
+	for i, item := range items {
+		s.store(item.Key, records[i])
 	}
-	var data interface{}
-	err := json.Unmarshal(record.Value, &data)
+	s.mu.Unlock()
+	return nil
+}
+
+// Apply atomically replaces items of keys with ones returned by fn, fn and applied are called under the lock
+func (s *Storage) Apply(keys []string, fn batch.ApplyFunc, applied batch.AppliedFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().Unix()
+	current := make([]batch.Item, len(keys))
+	for i, key := range keys {
+		current[i].Key = key
+		if r, found := s.values[key]; found {
+			var err error
+			current[i].Value, current[i].ExpiredAt, current[i].Version, current[i].Found, err = r.Decode(now)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	writes, err := fn(current)
+	if err != nil {
+		return err
+	}
+
+	records := make([]record.Record, len(writes))
+	for i, item := range writes {
+		if !item.Found {
+			continue
+		}
+		r, err := record.New(item.Value, 0, item.Version)
+		if err != nil {
+			return err
+		}
+		r.ExpiredAt = item.ExpiredAt
+		records[i] = r
+		s.versions.Observe(item.Version)
+	}
+	for i, item := range writes {
+		if item.Found {
+			s.store(item.Key, records[i])
+		} else {
+			s.remove(item.Key)
+		}
+	}
+	if applied != nil {
+		applied()
+	}
+	return nil
+}
+
+// Update atomically replaces structure stored at key with the one returned by fn, keeping its TTL
+func (s *Storage) Update(key string, fn types.UpdateFunc) (stored types.Structure, expiredAt int64, version uint64, changed bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, found := s.values[key]
+	if found && r.Expired(time.Now().Unix()) {
+		found = false
+		r = record.Record{}
+	}
+	var current types.Structure
+	if r.Structure != nil {
+		current = r.Structure.Clone()
+	}
+	next, err := fn(current, r.ExpiredAt, found)
+	if err != nil || next == nil {
+		return nil, 0, 0, false, err
+	}
+	if next.Len() == 0 {
+		// empty structures are removed like in Redis
+		s.remove(key)
+		return nil, 0, 0, found, nil
+	}
+	updated := record.Record{Structure: next, ExpiredAt: r.ExpiredAt, Version: s.versions.Next()}
+	s.store(key, updated)
+	return next, updated.ExpiredAt, updated.Version, true, nil
+}
+
+// Incr atomically adds delta to the number stored at key, missed key counts as zero.
+// Key keeps its TTL unless ttl isn't 0.
+func (s *Storage) Incr(key string, delta counter.Delta, ttl int64) (interface{}, int64, uint64, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, found := s.values[key]
+	if found && r.Expired(now.Unix()) {
+		found = false
+		r = record.Record{}
+	}
+	if r.Structure != nil {
+		return nil, 0, 0, types.WrongTypeError{}
+	}
+	var current interface{}
+	if found {
+		var err error
+		if current, err = raw.Decode(r.Value, r.ContentType); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	result, err := counter.Add(current, found, delta)
 	if err != nil {
-		return nil, 0, false, err
+		return nil, 0, 0, err
 	}
-	// expire record if time has come
-	if record.ExpiredAt > 0 && (time.Now().Unix() >= record.ExpiredAt) {
-		return nil, 0, false, nil
+	data, contentType, err := raw.Encode(result)
+	if err != nil {
+		return nil, 0, 0, err
 	}
 
-	return data, record.ExpiredAt, true, nil
+	updated := record.Record{Value: data, ContentType: contentType, ExpiredAt: r.ExpiredAt, Version: s.versions.Next()}
+	if ttl != 0 {
+		updated.ExpiredAt = now.Add(time.Second * time.Duration(ttl)).Unix()
+	}
+	s.store(key, updated)
+	return result, updated.ExpiredAt, updated.Version, nil
 }
 
 func (s *Storage) Delete(key string) error {
 	s.mu.Lock()
-	delete(s.values, key)
+	s.remove(key)
 	s.mu.Unlock()
 	return nil
 }
 
+// MDelete removes keys taking the lock once and returns number of keys that existed
+func (s *Storage) MDelete(keys []string) (int, error) {
+	now := time.Now().Unix()
+	deleted := 0
+	s.mu.Lock()
+	for _, key := range keys {
+		if r, found := s.values[key]; found && !r.Expired(now) {
+			deleted++
+		}
+		s.remove(key)
+	}
+	s.mu.Unlock()
+	return deleted, nil
+}
+
 func (s *Storage) GetKeys() ([]string, error) {
 	keys := make([]string, 0)
+	now := time.Now().Unix()
 	s.mu.RLock()
-	for key := range s.values {
-		keys = append(keys, key)
+	for key, r := range s.values {
+		if !r.Expired(now) {
+			keys = append(keys, key)
+		}
 	}
 	s.mu.RUnlock()
 	return keys, nil
 }
+
+// Scan examines up to count keys of the ordered index after cursor and returns the matching ones with cursor
+// of the next call, so a page doesn't walk the whole keyspace. The map is the only shard of scan.
+func (s *Storage) Scan(cursor string, match func(key string) bool, count int) ([]string, string, error) {
+	return scan.Shards(1, cursor, count, func(_ int, from string, count int) ([]string, int, string, error) {
+		now := time.Now().Unix()
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		keys, examined, next := s.keys.Page(from, func(key string) bool {
+			r, found := s.values[key]
+			return found && !r.Expired(now) && match(key)
+		}, count)
+		return keys, examined, next, nil
+	})
+}
+
+// DeleteExpired checks up to sampleSize keys with TTL and removes the expired ones.
+// Map iteration order is random, so every call looks at a different sample.
+func (s *Storage) DeleteExpired(sampleSize int) (expired []string, sampled int) {
+	now := time.Now().Unix()
+	s.mu.Lock()
+	for key := range s.expires {
+		if sampled == sampleSize {
+			break
+		}
+		sampled++
+		if s.values[key].Expired(now) {
+			s.remove(key)
+			expired = append(expired, key)
+		}
+	}
+	s.mu.Unlock()
+	return expired, sampled
+}
@@ -0,0 +1,64 @@
+// Package raw defines values which are stored by providers as is, without JSON encoding.
+package raw
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Content types of values kept by providers
+const (
+	JSONType   = "application/json"
+	BinaryType = "application/octet-stream"
+)
+
+// MaxContentTypeLength limits declared content type of Typed value
+const MaxContentTypeLength = 255
+
+type (
+	// Bytes is an opaque value, providers store it without JSON marshaling and return it back as Bytes
+	Bytes []byte
+
+	// Typed is an opaque value with declared content type, e.g. text or image. Providers keep it like Bytes,
+	// values of BinaryType are returned back as Bytes and values of JSONType are kept as JSON.
+	Typed struct {
+		ContentType string `json:"content_type"`
+		Data        []byte `json:"data"`
+	}
+)
+
+// Encode returns data that providers keep for value and its content type
+func Encode(value interface{}) (data []byte, contentType string, err error) {
+	switch v := value.(type) {
+	case Bytes:
+		return v, BinaryType, nil
+	case Typed:
+		if len(v.ContentType) > MaxContentTypeLength {
+			return nil, "", fmt.Errorf("Content type is longer than %d bytes.", MaxContentTypeLength)
+		}
+		switch v.ContentType {
+		case "", BinaryType:
+			return v.Data, BinaryType, nil
+		case JSONType:
+			if !json.Valid(v.Data) {
+				return nil, "", fmt.Errorf("Value of content type '%s' is not valid JSON.", JSONType)
+			}
+		}
+		return v.Data, v.ContentType, nil
+	}
+	data, err = json.Marshal(value)
+	return data, JSONType, err
+}
+
+// Decode restores value from data kept by provider with its content type
+func Decode(data []byte, contentType string) (interface{}, error) {
+	switch contentType {
+	case JSONType:
+		var value interface{}
+		err := json.Unmarshal(data, &value)
+		return value, err
+	case BinaryType:
+		return Bytes(data), nil
+	}
+	return Typed{ContentType: contentType, Data: data}, nil
+}
@@ -0,0 +1,74 @@
+// Package record defines record of a key which map based storages keep in memory.
+package record
+
+import (
+	"encoding/json"
+	"time"
+
+	"../raw"
+	"../types"
+)
+
+type Record struct {
+	Value []byte
+	// Structure is set instead of Value for values with native type
+	Structure types.Structure
+	ExpiredAt int64
+	// ContentType of Value, raw.JSONType for values kept as JSON
+	ContentType string
+	// Version is changed by every write of the key, it's taken from storage counter, so it is never reused
+	Version uint64
+}
+
+// New encodes value to record, structures are kept as is
+func New(value interface{}, ttl int64, version uint64) (Record, error) {
+	record := Record{Version: version}
+	if structure, ok := value.(types.Structure); ok {
+		record.Structure = structure
+	} else {
+		data, contentType, err := raw.Encode(value)
+		if err != nil {
+			return record, err
+		}
+		record.Value, record.ContentType = data, contentType
+	}
+	if ttl != 0 {
+		record.ExpiredAt = time.Now().Add(time.Second * time.Duration(ttl)).Unix()
+	}
+	return record, nil
+}
+
+// Decode returns value of record unless it's expired
+func (r Record) Decode(now int64) (interface{}, int64, uint64, bool, error) {
+	// expire record if time has come
+	if r.Expired(now) {
+		return nil, 0, 0, false, nil
+	}
+	if r.Structure != nil {
+		return r.Structure, r.ExpiredAt, r.Version, true, nil
+	}
+	data, err := raw.Decode(r.Value, r.ContentType)
+	if err != nil {
+		return nil, 0, 0, false, err
+	}
+	return data, r.ExpiredAt, r.Version, true, nil
+}
+
+// Raw returns bytes kept for record with their content type unless it's expired, structures are marshaled to JSON
+func (r Record) Raw(now int64) (raw.Typed, int64, uint64, bool, error) {
+	if r.Expired(now) {
+		return raw.Typed{}, 0, 0, false, nil
+	}
+	if r.Structure != nil {
+		data, err := json.Marshal(r.Structure)
+		if err != nil {
+			return raw.Typed{}, 0, 0, false, err
+		}
+		return raw.Typed{ContentType: raw.JSONType, Data: data}, r.ExpiredAt, r.Version, true, nil
+	}
+	return raw.Typed{ContentType: r.ContentType, Data: r.Value}, r.ExpiredAt, r.Version, true, nil
+}
+
+func (r Record) Expired(now int64) bool {
+	return r.ExpiredAt > 0 && now >= r.ExpiredAt
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"sync"
+
+	"./aof"
+)
+
+// aofManagers are managers writing AOF by namespace, rewrite keeps their keyspaces
+var aofManagers = struct {
+	sync.Mutex
+	byNamespace map[string]*CacheManager
+}{byNamespace: map[string]*CacheManager{}}
+
+// SetAOFRewrite makes AOF rewritten in background when it grows by percentage since startup or the last rewrite
+// and it's at least minSize bytes. Zero percentage disables automatic rewrite.
+func SetAOFRewrite(percentage int, minSize int64) {
+	aof.SetAutoRewrite(percentage, minSize, snapshotAOF)
+}
+
+// RewriteAOF replaces AOF with a minimal one keeping current keyspace of all namespaces, like BGREWRITEAOF of Redis.
+// Writes aren't blocked meanwhile, it returns when the new file is in place.
+func RewriteAOF() error {
+	return aof.Rewrite(snapshotAOF)
+}
+
+// StartAOFRewrite starts RewriteAOF in background, it fails if AOF is disabled or rewrite is already running
+func StartAOFRewrite() error {
+	return aof.RewriteInBackground(snapshotAOF)
+}
+
+// registerAOF adds manager to keyspaces kept by AOF rewrite, New starts with the only default namespace
+func registerAOF(cm *CacheManager) {
+	aofManagers.Lock()
+	defer aofManagers.Unlock()
+	if cm.namespace == "" {
+		aofManagers.byNamespace = map[string]*CacheManager{}
+	}
+	aofManagers.byNamespace[cm.namespace] = cm
+}
+
+func snapshotAOF(snapshot *aof.Snapshot) error {
+	aofManagers.Lock()
+	managers := make([]*CacheManager, 0, len(aofManagers.byNamespace))
+	for _, cm := range aofManagers.byNamespace {
+		managers = append(managers, cm)
+	}
+	aofManagers.Unlock()
+
+	for _, cm := range managers {
+		keys, err := cm.Provider.GetKeys()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			value, expiredAt, version, found, err := cm.Provider.Get(key)
+			if err != nil {
+				return err
+			}
+			if found {
+				snapshot.Set(cm.namespace, key, value, expiredAt, version)
+			}
+		}
+	}
+	return nil
+}
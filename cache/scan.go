@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"./scan"
+)
+
+// DefaultScanCount is a number of keys examined by one Scan call unless other count is passed
+const DefaultScanCount = 10
+
+// ScanEnd is a cursor which starts scan and which is returned when scan is over
+const ScanEnd = "0"
+
+// InvalidCursorError is returned by Scan for cursor it didn't give out
+type InvalidCursorError struct {
+	cursor string
+}
+
+func (ice InvalidCursorError) Error() string {
+	return fmt.Sprintf("Invalid cursor '%s'.", ice.cursor)
+}
+
+// Scan examines up to count keys and returns the ones matching glob pattern (empty pattern matches all keys,
+// see ValidatePattern) with cursor of the next call. Like in Redis a call may return fewer keys than count or none,
+// scan is over only when ScanEnd is returned. Every key present during the whole scan is returned at least once,
+// keys added or deleted during scan may be missed.
+func (cm *CacheManager) Scan(cursor string, pattern string, count int) ([]string, string, error) {
+	// cursor of storage is encoded to be safe in URL and telnet line
+	var from string
+	if cursor != "" && cursor != ScanEnd {
+		decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || len(decoded) == 0 {
+			return nil, "", InvalidCursorError{cursor}
+		}
+		from = string(decoded)
+	}
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+	match, err := globMatcher(pattern)
+	if err != nil {
+		return nil, "", err
+	}
+	keys, next, err := cm.Provider.Scan(from, match, count)
+	if err == scan.ErrInvalidCursor {
+		return nil, "", InvalidCursorError{cursor}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if next == "" {
+		return keys, ScanEnd, nil
+	}
+	return keys, base64.RawURLEncoding.EncodeToString([]byte(next)), nil
+}
+
+// Keys returns all keys matching glob pattern, empty pattern matches all keys
+func (cm *CacheManager) Keys(pattern string) ([]string, error) {
+	match, err := globMatcher(pattern)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := cm.Provider.GetKeys()
+	if err != nil || pattern == "" || pattern == "*" {
+		return keys, err
+	}
+	matched := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if match(key) {
+			matched = append(matched, key)
+		}
+	}
+	return matched, nil
+}
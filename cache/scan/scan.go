@@ -0,0 +1,151 @@
+// Package scan keeps keys of storages in lexicographical order for cursor based iteration.
+package scan
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// maxLevel limits height of index nodes, it's enough for 4^32 keys
+const maxLevel = 32
+
+// ErrInvalidCursor is returned by storages for cursor they didn't give out
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type node struct {
+	key  string
+	next []*node
+}
+
+// Index is a skip list of keys. Storages add a key once it's created and remove it once it's deleted,
+// so a page costs O(log n + count) instead of a pass over all keys. Index isn't safe for concurrent use,
+// storages keep one per shard guarded by the lock of the shard.
+type Index struct {
+	head  node
+	level int
+	// seed of xorshift generating levels of nodes
+	seed uint64
+}
+
+// PageFunc examines up to count keys of shard starting at position from (empty one is the start of shard)
+// and returns the matching ones, number of examined keys and position of the next page, empty if shard is over
+type PageFunc func(shard int, from string, count int) (keys []string, examined int, next string, err error)
+
+// NewIndex returns an empty index
+func NewIndex() *Index {
+	return &Index{head: node{next: make([]*node, maxLevel)}, level: 1, seed: 0x9E3779B97F4A7C15}
+}
+
+// Add puts key to index, key which is already indexed is ignored
+func (idx *Index) Add(key string) {
+	var update [maxLevel]*node
+	x := idx.seek(key, &update)
+	if x != nil && x.key == key {
+		return
+	}
+	level := idx.randomLevel()
+	for i := idx.level; i < level; i++ {
+		update[i] = &idx.head
+	}
+	if level > idx.level {
+		idx.level = level
+	}
+	n := &node{key: key, next: make([]*node, level)}
+	for i := 0; i < level; i++ {
+		n.next[i], update[i].next[i] = update[i].next[i], n
+	}
+}
+
+// Remove drops key from index
+func (idx *Index) Remove(key string) {
+	var update [maxLevel]*node
+	x := idx.seek(key, &update)
+	if x == nil || x.key != key {
+		return
+	}
+	for i := range x.next {
+		update[i].next[i] = x.next[i]
+	}
+	for idx.level > 1 && idx.head.next[idx.level-1] == nil {
+		idx.level--
+	}
+}
+
+// Page examines up to count the smallest keys not less than from and returns the matching ones
+// with number of examined keys and the key next page starts from, empty if index is over
+func (idx *Index) Page(from string, match func(key string) bool, count int) (keys []string, examined int, next string) {
+	x := idx.seek(from, nil)
+	for ; x != nil && examined < count; x = x.next[0] {
+		examined++
+		if match(x.key) {
+			keys = append(keys, x.key)
+		}
+	}
+	if x == nil {
+		return keys, examined, ""
+	}
+	return keys, examined, x.key
+}
+
+// Shards examines up to count keys of shards in order starting at cursor (empty one starts scan) and returns
+// the matching ones with cursor of the next call, empty one means that scan is over. Cursor is the number of shard
+// and position in it, so shards are scanned one after another under their own locks.
+func Shards(shards int, cursor string, count int, page PageFunc) ([]string, string, error) {
+	shard, from := 0, ""
+	if cursor != "" {
+		i := strings.IndexByte(cursor, ':')
+		if i < 0 {
+			return nil, "", ErrInvalidCursor
+		}
+		n, err := strconv.Atoi(cursor[:i])
+		if err != nil || n < 0 || n >= shards {
+			return nil, "", ErrInvalidCursor
+		}
+		shard, from = n, cursor[i+1:]
+	}
+	keys := make([]string, 0)
+	for count > 0 {
+		matched, examined, next, err := page(shard, from, count)
+		if err != nil {
+			return nil, "", err
+		}
+		keys = append(keys, matched...)
+		count -= examined
+		if next != "" {
+			return keys, strconv.Itoa(shard) + ":" + next, nil
+		}
+		if shard++; shard == shards {
+			return keys, "", nil
+		}
+		from = ""
+	}
+	return keys, strconv.Itoa(shard) + ":", nil
+}
+
+// seek returns the first node not less than key and fills update with its predecessors on every level
+func (idx *Index) seek(key string, update *[maxLevel]*node) *node {
+	x := &idx.head
+	for i := idx.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key < key {
+			x = x.next[i]
+		}
+		if update != nil {
+			update[i] = x
+		}
+	}
+	return x.next[0]
+}
+
+// randomLevel returns level of a new node, every next level is taken with probability 1/4
+func (idx *Index) randomLevel() int {
+	idx.seed ^= idx.seed << 13
+	idx.seed ^= idx.seed >> 7
+	idx.seed ^= idx.seed << 17
+	level, bits := 1, idx.seed
+	for level < maxLevel && bits&3 == 0 {
+		level++
+		bits >>= 2
+	}
+	return level
+}
@@ -0,0 +1,123 @@
+// Package script compiles Lua scripts and converts values between Lua and cached values.
+package script
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"../raw"
+	"github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// SHA returns hex SHA1 digest of script source which identifies cached script
+func SHA(source string) string {
+	sum := sha1.Sum([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
+
+// Compile parses script source into function prototype which could be run by many states
+func Compile(source string, name string) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(source), name)
+	if err != nil {
+		return nil, err
+	}
+	return lua.Compile(chunk, name)
+}
+
+// NewState returns interpreter with base, table, string and math libraries only,
+// so scripts can't reach file system or load other code
+func NewState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	libs := []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	}
+	for _, lib := range libs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "module", "require"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	return L
+}
+
+// ToLua converts cached value to Lua, lists and maps become tables
+func ToLua(L *lua.LState, value interface{}) lua.LValue {
+	switch v := value.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case int64:
+		return lua.LNumber(v)
+	case int:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case raw.Bytes:
+		return lua.LString(v)
+	case raw.Typed:
+		return lua.LString(v.Data)
+	case []string:
+		table := L.CreateTable(len(v), 0)
+		for _, item := range v {
+			table.Append(lua.LString(item))
+		}
+		return table
+	case []interface{}:
+		table := L.CreateTable(len(v), 0)
+		for _, item := range v {
+			table.Append(ToLua(L, item))
+		}
+		return table
+	case map[string]interface{}:
+		table := L.CreateTable(0, len(v))
+		for key, item := range v {
+			table.RawSetString(key, ToLua(L, item))
+		}
+		return table
+	}
+	return lua.LString(fmt.Sprint(value))
+}
+
+// FromLua converts Lua value to the one which could be cached: table with keys 1..n becomes list, other tables
+// become maps. Functions and other values which have no JSON form are converted to nil.
+func FromLua(value lua.LValue) interface{} {
+	switch v := value.(type) {
+	case lua.LBool:
+		return bool(v)
+	case lua.LNumber:
+		return float64(v)
+	case lua.LString:
+		return string(v)
+	case *lua.LTable:
+		n := v.Len()
+		count := 0
+		v.ForEach(func(lua.LValue, lua.LValue) { count++ })
+		if count == n {
+			list := make([]interface{}, n)
+			for i := 1; i <= n; i++ {
+				list[i-1] = FromLua(v.RawGetInt(i))
+			}
+			return list
+		}
+		hash := make(map[string]interface{}, count)
+		v.ForEach(func(key lua.LValue, item lua.LValue) {
+			hash[key.String()] = FromLua(item)
+		})
+		return hash
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"./counter"
+	"./script"
+	"./types"
+	"github.com/yuin/gopher-lua"
+)
+
+// DefaultScriptTimeout limits execution of script unless other limit is set. Script holds locks of the storage
+// while it runs, which blocks the whole mutex-map, writes to sync-map and shards of its keys in others,
+// so the limit is kept short.
+const DefaultScriptTimeout = 250 * time.Millisecond
+
+type (
+	// scriptCache keeps compiled scripts by SHA1 of their source
+	scriptCache struct {
+		sync.RWMutex
+		protos  map[string]*lua.FunctionProto
+		timeout time.Duration
+	}
+
+	// ScriptNotFoundError is returned by EvalSHA for script which wasn't loaded
+	ScriptNotFoundError struct {
+		sha string
+	}
+
+	// ScriptTimeoutError is returned when script runs longer than its limit, nothing is written then
+	ScriptTimeoutError struct {
+		limit time.Duration
+	}
+
+	// ScriptError is an error raised by script or its compilation
+	ScriptError struct {
+		message string
+	}
+)
+
+func (snf ScriptNotFoundError) Error() string {
+	return fmt.Sprintf("Script '%s' not found, please use EVAL.", snf.sha)
+}
+
+func (ste ScriptTimeoutError) Error() string {
+	return fmt.Sprintf("Script exceeded time limit of %s.", ste.limit)
+}
+
+func (se ScriptError) Error() string {
+	return "Error running script: " + se.message
+}
+
+// SetScriptTimeout sets default time limit of scripts, 0 means DefaultScriptTimeout
+func (cm *CacheManager) SetScriptTimeout(timeout time.Duration) {
+	cm.scripts.Lock()
+	cm.scripts.timeout = timeout
+	cm.scripts.Unlock()
+}
+
+// ScriptLoad compiles and caches script, returned SHA1 could be passed to EvalSHA
+func (cm *CacheManager) ScriptLoad(source string) (string, error) {
+	sha := script.SHA(source)
+	proto, err := script.Compile(source, "@"+sha)
+	if err != nil {
+		return "", ScriptError{err.Error()}
+	}
+	cm.scripts.Lock()
+	if cm.scripts.protos == nil {
+		cm.scripts.protos = make(map[string]*lua.FunctionProto)
+	}
+	cm.scripts.protos[sha] = proto
+	cm.scripts.Unlock()
+	return sha, nil
+}
+
+// Eval caches script and runs it like EvalSHA
+func (cm *CacheManager) Eval(source string, keys []string, args []interface{}, timeout time.Duration) (interface{}, error) {
+	sha, err := cm.ScriptLoad(source)
+	if err != nil {
+		return nil, err
+	}
+	return cm.EvalSHA(sha, keys, args, timeout)
+}
+
+// EvalSHA atomically runs cached script with KEYS and ARGV globals. Script reaches cache by
+// cacher.call(op, key, ...) with get, set, del, incr and decr ops on declared keys only.
+// Script runs under the locks of storage Apply, so it blocks other operations until it ends or it's stopped
+// after timeout (0 means default limit). If script fails nothing is written,
+// otherwise its writes are logged to AOF as one record like transaction.
+func (cm *CacheManager) EvalSHA(sha string, keys []string, args []interface{}, timeout time.Duration) (interface{}, error) {
+	cm.scripts.RLock()
+	proto, found := cm.scripts.protos[sha]
+	if timeout == 0 {
+		timeout = cm.scripts.timeout
+	}
+	cm.scripts.RUnlock()
+	if !found {
+		return nil, ScriptNotFoundError{sha}
+	}
+	if timeout == 0 {
+		timeout = DefaultScriptTimeout
+	}
+
+	var result interface{}
+	declared := uniqueKeys(keys)
+	err := cm.apply(declared, func(current []Item) ([]Item, error) {
+		state := newTxStates(current)
+		L := script.NewState()
+		defer L.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		L.SetContext(ctx)
+
+		L.SetGlobal("KEYS", script.ToLua(L, keys))
+		L.SetGlobal("ARGV", script.ToLua(L, args))
+		cacher := L.NewTable()
+		L.SetField(cacher, "call", L.NewFunction(func(L *lua.LState) int {
+			L.Push(cm.scriptCall(L, state))
+			return 1
+		}))
+		L.SetGlobal("cacher", cacher)
+
+		L.Push(L.NewFunctionFromProto(proto))
+		if err := L.PCall(0, 1, nil); err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, ScriptTimeoutError{timeout}
+			}
+			if apiErr, ok := err.(*lua.ApiError); ok {
+				return nil, ScriptError{apiErr.Object.String()}
+			}
+			return nil, ScriptError{err.Error()}
+		}
+		result = script.FromLua(L.Get(-1))
+		return txWrites(declared, state), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// scriptCall runs cacher.call(op, key, ...) of script against state of declared keys
+func (cm *CacheManager) scriptCall(L *lua.LState, state map[string]*txState) lua.LValue {
+	op := TxOp{Key: L.CheckString(2)}
+	name := strings.ToLower(L.CheckString(1))
+	switch name {
+	case "get":
+		op.Op = TxGet
+	case "set":
+		op.Op, op.Value, op.TTL = TxSet, script.FromLua(L.CheckAny(3)), int64(L.OptInt(4, 0))
+	case "del", "delete":
+		op.Op = TxDelete
+	case "incr":
+		op.Op, op.TTL = TxIncr, int64(L.OptInt(4, 0))
+		delta := float64(L.OptNumber(3, 1))
+		if delta == math.Trunc(delta) && math.Abs(delta) < math.MaxInt64 {
+			op.Delta = counter.Int(int64(delta))
+		} else {
+			op.Delta = counter.Float(delta)
+		}
+	case "decr":
+		op.Op, op.TTL = TxIncr, int64(L.OptInt(4, 0))
+		op.Delta = counter.Int(-int64(L.OptInt(3, 1)))
+	default:
+		L.RaiseError("Unknown cacher.call operation '%s'.", name)
+	}
+
+	s, found := state[op.Key]
+	if !found {
+		L.RaiseError("Script accessed undeclared key '%s', pass it in KEYS.", op.Key)
+	}
+	result, err := cm.execOp(op, s)
+	if err == nil {
+		if _, ok := result.Value.(types.Structure); ok && op.Op == TxGet {
+			err = WrongTypeError{}
+		}
+	}
+	if err != nil {
+		L.RaiseError("%s", err.Error())
+	}
+
+	switch op.Op {
+	case TxSet:
+		return lua.LString("OK")
+	case TxDelete:
+		if result.Found {
+			return lua.LNumber(1)
+		}
+		return lua.LNumber(0)
+	}
+	return script.ToLua(L, result.Value)
+}
@@ -0,0 +1,412 @@
+// Package sharded_map spreads keys across independently locked maps, so writes of different keys
+// rarely wait for each other unlike with the single lock of mutex_map.
+package sharded_map
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"../batch"
+	"../counter"
+	"../raw"
+	"../record"
+	"../scan"
+	"../types"
+	"../version"
+)
+
+// DefaultShards is number of shards used when it isn't configured
+const DefaultShards = 32
+
+type (
+	shard struct {
+		mu     sync.RWMutex
+		values map[string]record.Record
+		// keys that have TTL, used by active expiration to sample candidates
+		expires map[string]struct{}
+		// keys of shard ordered for Scan
+		keys *scan.Index
+	}
+
+	Storage struct {
+		shards   []*shard
+		versions version.Counter
+		// next is the shard active expiration starts sampling from
+		next uint32
+	}
+)
+
+// New returns storage with n shards, DefaultShards is used if n isn't positive
+func New(n int) *Storage {
+	if n <= 0 {
+		n = DefaultShards
+	}
+	s := &Storage{shards: make([]*shard, n)}
+	for i := range s.shards {
+		s.shards[i] = &shard{
+			values:  make(map[string]record.Record),
+			expires: make(map[string]struct{}),
+			keys:    scan.NewIndex(),
+		}
+	}
+	return s
+}
+
+// NextVersion returns version for a record which is going to be written by Set or CompareAndSet
+func (s *Storage) NextVersion() uint64 {
+	return s.versions.Next()
+}
+
+func (s *Storage) shardIndex(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(s.shards)))
+}
+
+func (s *Storage) shardOf(key string) *shard {
+	return s.shards[s.shardIndex(key)]
+}
+
+// lockKeys locks shards of keys in ascending order, so concurrent batches can't deadlock, and returns unlock
+func (s *Storage) lockKeys(keys []string) func() {
+	indexes := make([]int, 0, len(keys))
+	seen := make(map[int]bool, len(keys))
+	for _, key := range keys {
+		if i := s.shardIndex(key); !seen[i] {
+			seen[i] = true
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		s.shards[i].mu.Lock()
+	}
+	return func() {
+		for _, i := range indexes {
+			s.shards[i].mu.Unlock()
+		}
+	}
+}
+
+// Set stores value with passed version, which is either got from NextVersion or restored from persistence
+func (s *Storage) Set(key string, value interface{}, ttl int64, version uint64) error {
+	r, err := record.New(value, ttl, version)
+	if err != nil {
+		return err
+	}
+	s.versions.Observe(version)
+
+	sh := s.shardOf(key)
+	sh.mu.Lock()
+	sh.store(key, r)
+	sh.mu.Unlock()
+	return nil
+}
+
+// CompareAndSet stores value only if the key has expected version, 0 means that key has to be missed
+func (s *Storage) CompareAndSet(key string, value interface{}, ttl int64, expected uint64, version uint64) (bool, error) {
+	r, err := record.New(value, ttl, version)
+	if err != nil {
+		return false, err
+	}
+
+	sh := s.shardOf(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	current, found := sh.values[key]
+	if !found || current.Expired(time.Now().Unix()) {
+		current = record.Record{}
+	}
+	if current.Version != expected {
+		return false, nil
+	}
+	sh.store(key, r)
+	return true, nil
+}
+
+// store puts record to the map, caller holds the lock of shard
+func (sh *shard) store(key string, r record.Record) {
+	if _, exists := sh.values[key]; !exists {
+		sh.keys.Add(key)
+	}
+	sh.values[key] = r
+	if r.ExpiredAt > 0 {
+		sh.expires[key] = struct{}{}
+	} else {
+		delete(sh.expires, key)
+	}
+}
+
+// remove deletes key from the map, caller holds the lock of shard
+func (sh *shard) remove(key string) {
+	if _, exists := sh.values[key]; exists {
+		delete(sh.values, key)
+		sh.keys.Remove(key)
+	}
+	delete(sh.expires, key)
+}
+
+// Get returns value, expiration time and version of the key
+func (s *Storage) Get(key string) (interface{}, int64, uint64, bool, error) {
+	sh := s.shardOf(key)
+	sh.mu.RLock()
+	r, found := sh.values[key]
+	sh.mu.RUnlock()
+	if !found {
+		return nil, 0, 0, false, nil
+	}
+	return r.Decode(time.Now().Unix())
+}
+
+// GetRaw returns bytes kept for value of the key without decoding them, with expiration time and version
+func (s *Storage) GetRaw(key string) (raw.Typed, int64, uint64, bool, error) {
+	sh := s.shardOf(key)
+	sh.mu.RLock()
+	r, found := sh.values[key]
+	sh.mu.RUnlock()
+	if !found {
+		return raw.Typed{}, 0, 0, false, nil
+	}
+	return r.Raw(time.Now().Unix())
+}
+
+// MGet returns items of keys in the same order, every key is read under the lock of its shard
+func (s *Storage) MGet(keys []string) ([]batch.Item, error) {
+	now := time.Now().Unix()
+	items := make([]batch.Item, len(keys))
+	for i, key := range keys {
+		items[i].Key = key
+		sh := s.shardOf(key)
+		sh.mu.RLock()
+		r, found := sh.values[key]
+		sh.mu.RUnlock()
+		if !found {
+			continue
+		}
+		var err error
+		items[i].Value, items[i].ExpiredAt, items[i].Version, items[i].Found, err = r.Decode(now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// MSet stores all items with their TTL and version holding locks of their shards at once,
+// nothing is stored if any value is invalid
+func (s *Storage) MSet(items []batch.Item) error {
+	records := make([]record.Record, len(items))
+	keys := make([]string, len(items))
+	for i, item := range items {
+		r, err := record.New(item.Value, item.TTL, item.Version)
+		if err != nil {
+			return err
+		}
+		records[i], keys[i] = r, item.Key
+		s.versions.Observe(item.Version)
+	}
+
+	unlock := s.lockKeys(keys)
+	for i, item := range items {
+		s.shardOf(item.Key).store(item.Key, records[i])
+	}
+	unlock()
+	return nil
+}
+
+// Apply atomically replaces items of keys with ones returned by fn, fn and applied are called under locks of their shards.
+// fn has to return items of passed keys only.
+func (s *Storage) Apply(keys []string, fn batch.ApplyFunc, applied batch.AppliedFunc) error {
+	unlock := s.lockKeys(keys)
+	defer unlock()
+	now := time.Now().Unix()
+	current := make([]batch.Item, len(keys))
+	for i, key := range keys {
+		current[i].Key = key
+		if r, found := s.shardOf(key).values[key]; found {
+			var err error
+			current[i].Value, current[i].ExpiredAt, current[i].Version, current[i].Found, err = r.Decode(now)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	writes, err := fn(current)
+	if err != nil {
+		return err
+	}
+
+	records := make([]record.Record, len(writes))
+	for i, item := range writes {
+		if !item.Found {
+			continue
+		}
+		r, err := record.New(item.Value, 0, item.Version)
+		if err != nil {
+			return err
+		}
+		r.ExpiredAt = item.ExpiredAt
+		records[i] = r
+		s.versions.Observe(item.Version)
+	}
+	for i, item := range writes {
+		if item.Found {
+			s.shardOf(item.Key).store(item.Key, records[i])
+		} else {
+			s.shardOf(item.Key).remove(item.Key)
+		}
+	}
+	if applied != nil {
+		applied()
+	}
+	return nil
+}
+
+// Update atomically replaces structure stored at key with the one returned by fn, keeping its TTL
+func (s *Storage) Update(key string, fn types.UpdateFunc) (stored types.Structure, expiredAt int64, version uint64, changed bool, err error) {
+	sh := s.shardOf(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	r, found := sh.values[key]
+	if found && r.Expired(time.Now().Unix()) {
+		found = false
+		r = record.Record{}
+	}
+	var current types.Structure
+	if r.Structure != nil {
+		current = r.Structure.Clone()
+	}
+	next, err := fn(current, r.ExpiredAt, found)
+	if err != nil || next == nil {
+		return nil, 0, 0, false, err
+	}
+	if next.Len() == 0 {
+		// empty structures are removed like in Redis
+		sh.remove(key)
+		return nil, 0, 0, found, nil
+	}
+	updated := record.Record{Structure: next, ExpiredAt: r.ExpiredAt, Version: s.versions.Next()}
+	sh.store(key, updated)
+	return next, updated.ExpiredAt, updated.Version, true, nil
+}
+
+// Incr atomically adds delta to the number stored at key, missed key counts as zero.
+// Key keeps its TTL unless ttl isn't 0.
+func (s *Storage) Incr(key string, delta counter.Delta, ttl int64) (interface{}, int64, uint64, error) {
+	now := time.Now()
+	sh := s.shardOf(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	r, found := sh.values[key]
+	if found && r.Expired(now.Unix()) {
+		found = false
+		r = record.Record{}
+	}
+	if r.Structure != nil {
+		return nil, 0, 0, types.WrongTypeError{}
+	}
+	var current interface{}
+	if found {
+		var err error
+		if current, err = raw.Decode(r.Value, r.ContentType); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	result, err := counter.Add(current, found, delta)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	data, contentType, err := raw.Encode(result)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	updated := record.Record{Value: data, ContentType: contentType, ExpiredAt: r.ExpiredAt, Version: s.versions.Next()}
+	if ttl != 0 {
+		updated.ExpiredAt = now.Add(time.Second * time.Duration(ttl)).Unix()
+	}
+	sh.store(key, updated)
+	return result, updated.ExpiredAt, updated.Version, nil
+}
+
+func (s *Storage) Delete(key string) error {
+	sh := s.shardOf(key)
+	sh.mu.Lock()
+	sh.remove(key)
+	sh.mu.Unlock()
+	return nil
+}
+
+// MDelete removes keys holding locks of their shards at once and returns number of keys that existed
+func (s *Storage) MDelete(keys []string) (int, error) {
+	now := time.Now().Unix()
+	deleted := 0
+	unlock := s.lockKeys(keys)
+	for _, key := range keys {
+		sh := s.shardOf(key)
+		if r, found := sh.values[key]; found && !r.Expired(now) {
+			deleted++
+		}
+		sh.remove(key)
+	}
+	unlock()
+	return deleted, nil
+}
+
+// GetKeys returns keys of all shards, every shard is read under its own lock
+func (s *Storage) GetKeys() ([]string, error) {
+	keys := make([]string, 0)
+	now := time.Now().Unix()
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for key, r := range sh.values {
+			if !r.Expired(now) {
+				keys = append(keys, key)
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	return keys, nil
+}
+
+// Scan examines up to count keys after cursor and returns the matching ones with cursor of the next call.
+// Every shard keeps its keys ordered, shards are scanned one after another under their own locks.
+func (s *Storage) Scan(cursor string, match func(key string) bool, count int) ([]string, string, error) {
+	return scan.Shards(len(s.shards), cursor, count, func(i int, from string, count int) ([]string, int, string, error) {
+		now := time.Now().Unix()
+		sh := s.shards[i]
+		sh.mu.RLock()
+		defer sh.mu.RUnlock()
+		keys, examined, next := sh.keys.Page(from, func(key string) bool {
+			r, found := sh.values[key]
+			return found && !r.Expired(now) && match(key)
+		}, count)
+		return keys, examined, next, nil
+	})
+}
+
+// DeleteExpired checks up to sampleSize keys with TTL and removes the expired ones.
+// Every call starts from the next shard, so all shards are sampled even with small sampleSize.
+func (s *Storage) DeleteExpired(sampleSize int) (expired []string, sampled int) {
+	now := time.Now().Unix()
+	start := int(atomic.AddUint32(&s.next, 1)) % len(s.shards)
+	for i := 0; i < len(s.shards) && sampled < sampleSize; i++ {
+		sh := s.shards[(start+i)%len(s.shards)]
+		sh.mu.Lock()
+		for key := range sh.expires {
+			if sampled == sampleSize {
+				break
+			}
+			sampled++
+			if sh.values[key].Expired(now) {
+				sh.remove(key)
+				expired = append(expired, key)
+			}
+		}
+		sh.mu.Unlock()
+	}
+	return expired, sampled
+}
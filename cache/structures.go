@@ -0,0 +1,327 @@
+package cache
+
+import (
+	"time"
+
+	"./aof"
+	"./types"
+)
+
+// WrongTypeError is returned when command is called on a key holding value of another type
+type WrongTypeError = types.WrongTypeError
+
+// structure returns structure stored at key or nil if key is missed.
+// Returned structure is shared with provider and must not be changed.
+func (cm *CacheManager) structure(key string, typeName string) (types.Structure, error) {
+	value, _, found, err := cm.Get(key)
+	if err != nil || !found {
+		return nil, err
+	}
+	structure, ok := value.(types.Structure)
+	if !ok || structure.Type() != typeName {
+		return nil, WrongTypeError{}
+	}
+	return structure, nil
+}
+
+// update atomically changes structure stored at key, fn gets a private copy of the structure (empty one for missed key)
+// and returns changed structure or nil if nothing was changed. Result is accounted by memory limit and persisted.
+func (cm *CacheManager) update(key string, typeName string, fn func(structure types.Structure) (types.Structure, error)) error {
+	var victims []string
+	unlock := cm.lockEvents(key)
+	stored, expiredAt, version, changed, err := cm.Provider.Update(key, func(current types.Structure, expiredAt int64, found bool) (types.Structure, error) {
+		victims = nil
+		if found && (current == nil || current.Type() != typeName) {
+			return nil, WrongTypeError{}
+		}
+		if !found {
+			current, _ = types.New(typeName)
+		}
+		next, err := fn(current)
+		if err != nil || next == nil {
+			return nil, err
+		}
+		if cm.evictor != nil && next.Len() > 0 {
+			victims, err = cm.account(key, next, expiredAt)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return next, nil
+	})
+	if err == nil && changed {
+		if stored == nil {
+			// the last element was removed, so key is gone
+			if cm.evictor != nil {
+				cm.evictor.Remove(key)
+			}
+			cm.dropPersisted(key)
+			cm.notify(EventDelete, key, nil, 0)
+		} else {
+			cm.persist(key, stored, expiredAt, version)
+		}
+	}
+	unlock()
+	cm.evict(victims)
+	return err
+}
+
+// persist logs value already stored in provider by atomic operation and notifies watchers
+func (cm *CacheManager) persist(key string, value interface{}, expiredAt int64, version uint64) {
+	ttl := remainingTTL(expiredAt)
+	if !cm.RestoreMode {
+		cm.aofLog.Write(key, value, ttl, version, aof.Pending)
+		cm.aofLog.Write(key, value, ttl, version, aof.Completed)
+	}
+	if cm.CDBEnabled {
+		cm.keyspace.Set(key, value, ttl, version)
+	}
+	cm.notify(EventSet, key, value, expiredAt)
+}
+
+// remainingTTL converts expiration time to TTL, which is 0 for key without expiration
+func remainingTTL(expiredAt int64) int64 {
+	if expiredAt == 0 {
+		return 0
+	}
+	ttl := expiredAt - time.Now().Unix()
+	if ttl <= 0 {
+		// key is about to expire, but it still has to have TTL
+		ttl = 1
+	}
+	return ttl
+}
+
+func changedOrNil(structure types.Structure, changed bool) types.Structure {
+	if !changed {
+		return nil
+	}
+	return structure
+}
+
+// HSet sets fields of hash and returns number of added fields
+func (cm *CacheManager) HSet(key string, fields map[string]string) (added int, err error) {
+	err = cm.update(key, types.HashType, func(structure types.Structure) (types.Structure, error) {
+		hash := structure.(types.Hash)
+		added = 0
+		changed := false
+		for field, value := range fields {
+			old, found := hash[field]
+			if !found {
+				added++
+			}
+			changed = changed || !found || old != value
+			hash[field] = value
+		}
+		return changedOrNil(hash, changed), nil
+	})
+	return added, err
+}
+
+// HGet returns value of hash field
+func (cm *CacheManager) HGet(key string, field string) (string, bool, error) {
+	structure, err := cm.structure(key, types.HashType)
+	if err != nil || structure == nil {
+		return "", false, err
+	}
+	value, found := structure.(types.Hash)[field]
+	return value, found, nil
+}
+
+// HDel removes fields of hash and returns number of removed ones
+func (cm *CacheManager) HDel(key string, fields ...string) (removed int, err error) {
+	err = cm.update(key, types.HashType, func(structure types.Structure) (types.Structure, error) {
+		hash := structure.(types.Hash)
+		removed = 0
+		for _, field := range fields {
+			if _, found := hash[field]; found {
+				delete(hash, field)
+				removed++
+			}
+		}
+		return changedOrNil(hash, removed > 0), nil
+	})
+	return removed, err
+}
+
+// HGetAll returns all fields of hash
+func (cm *CacheManager) HGetAll(key string) (map[string]string, error) {
+	structure, err := cm.structure(key, types.HashType)
+	if err != nil || structure == nil {
+		return map[string]string{}, err
+	}
+	return structure.Clone().(types.Hash), nil
+}
+
+// LPush prepends values to list and returns its length, values are inserted one by one like in Redis
+func (cm *CacheManager) LPush(key string, values ...string) (int, error) {
+	return cm.push(key, values, true)
+}
+
+// RPush appends values to list and returns its length
+func (cm *CacheManager) RPush(key string, values ...string) (int, error) {
+	return cm.push(key, values, false)
+}
+
+func (cm *CacheManager) push(key string, values []string, left bool) (length int, err error) {
+	err = cm.update(key, types.ListType, func(structure types.Structure) (types.Structure, error) {
+		list := structure.(types.List)
+		if left {
+			head := make(types.List, 0, len(list)+len(values))
+			for i := len(values) - 1; i >= 0; i-- {
+				head = append(head, values[i])
+			}
+			list = append(head, list...)
+		} else {
+			list = append(list, values...)
+		}
+		length = len(list)
+		return changedOrNil(list, len(values) > 0), nil
+	})
+	return length, err
+}
+
+// LPop removes and returns the first value of list
+func (cm *CacheManager) LPop(key string) (string, bool, error) {
+	return cm.pop(key, true)
+}
+
+// RPop removes and returns the last value of list
+func (cm *CacheManager) RPop(key string) (string, bool, error) {
+	return cm.pop(key, false)
+}
+
+func (cm *CacheManager) pop(key string, left bool) (value string, found bool, err error) {
+	err = cm.update(key, types.ListType, func(structure types.Structure) (types.Structure, error) {
+		list := structure.(types.List)
+		found = len(list) > 0
+		if !found {
+			return nil, nil
+		}
+		if left {
+			value, list = list[0], list[1:]
+		} else {
+			value, list = list[len(list)-1], list[:len(list)-1]
+		}
+		return list, nil
+	})
+	return value, found, err
+}
+
+// LRange returns values of list between start and stop positions inclusive, negative positions count from the end
+func (cm *CacheManager) LRange(key string, start, stop int) ([]string, error) {
+	structure, err := cm.structure(key, types.ListType)
+	if err != nil || structure == nil {
+		return []string{}, err
+	}
+	return structure.(types.List).Range(start, stop), nil
+}
+
+// SAdd adds members to set and returns number of added ones
+func (cm *CacheManager) SAdd(key string, members ...string) (added int, err error) {
+	err = cm.update(key, types.SetType, func(structure types.Structure) (types.Structure, error) {
+		set := structure.(types.Set)
+		added = 0
+		for _, member := range members {
+			if _, found := set[member]; !found {
+				set[member] = struct{}{}
+				added++
+			}
+		}
+		return changedOrNil(set, added > 0), nil
+	})
+	return added, err
+}
+
+// SRem removes members from set and returns number of removed ones
+func (cm *CacheManager) SRem(key string, members ...string) (removed int, err error) {
+	err = cm.update(key, types.SetType, func(structure types.Structure) (types.Structure, error) {
+		set := structure.(types.Set)
+		removed = 0
+		for _, member := range members {
+			if _, found := set[member]; found {
+				delete(set, member)
+				removed++
+			}
+		}
+		return changedOrNil(set, removed > 0), nil
+	})
+	return removed, err
+}
+
+// SMembers returns sorted members of set
+func (cm *CacheManager) SMembers(key string) ([]string, error) {
+	structure, err := cm.structure(key, types.SetType)
+	if err != nil || structure == nil {
+		return []string{}, err
+	}
+	return structure.(types.Set).Members(), nil
+}
+
+// SInter returns sorted members that present in all sets, missed key is treated as empty set
+func (cm *CacheManager) SInter(keys ...string) ([]string, error) {
+	sets := make([]types.Set, 0, len(keys))
+	for _, key := range keys {
+		structure, err := cm.structure(key, types.SetType)
+		if err != nil {
+			return nil, err
+		}
+		if structure == nil {
+			return []string{}, nil
+		}
+		sets = append(sets, structure.(types.Set))
+	}
+	if len(sets) == 0 {
+		return []string{}, nil
+	}
+	result := types.Set{}
+	for member := range sets[0] {
+		common := true
+		for _, set := range sets[1:] {
+			if _, found := set[member]; !found {
+				common = false
+				break
+			}
+		}
+		if common {
+			result[member] = struct{}{}
+		}
+	}
+	return result.Members(), nil
+}
+
+// ZAdd adds members to sorted set or updates their scores and returns number of added ones
+func (cm *CacheManager) ZAdd(key string, members ...types.ZMember) (added int, err error) {
+	err = cm.update(key, types.SortedSetType, func(structure types.Structure) (types.Structure, error) {
+		zset := structure.(*types.SortedSet)
+		added = 0
+		changed := false
+		for _, item := range members {
+			score, found := zset.Score(item.Member)
+			if zset.Add(item.Member, item.Score) {
+				added++
+			}
+			changed = changed || !found || score != item.Score
+		}
+		return changedOrNil(zset, changed), nil
+	})
+	return added, err
+}
+
+// ZRange returns members of sorted set between start and stop ranks inclusive, negative ranks count from the end
+func (cm *CacheManager) ZRange(key string, start, stop int) ([]types.ZMember, error) {
+	structure, err := cm.structure(key, types.SortedSetType)
+	if err != nil || structure == nil {
+		return []types.ZMember{}, err
+	}
+	return structure.(*types.SortedSet).Range(start, stop), nil
+}
+
+// ZRangeByScore returns members of sorted set with scores between min and max
+func (cm *CacheManager) ZRangeByScore(key string, min, max types.ScoreBound) ([]types.ZMember, error) {
+	structure, err := cm.structure(key, types.SortedSetType)
+	if err != nil || structure == nil {
+		return []types.ZMember{}, err
+	}
+	return structure.(*types.SortedSet).RangeByScore(min, max), nil
+}
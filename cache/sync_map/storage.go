@@ -1,71 +1,471 @@
 package sync_map
 
 import (
-	"encoding/json"
 	"sync"
 	"time"
+
+	"../batch"
+	"../counter"
+	"../raw"
+	"../record"
+	"../scan"
+	"../types"
+	"../version"
 )
 
 type (
-	Record struct {
-		Value     []byte
-		ExpiredAt int64
-	}
-
+	// Storage keeps pointers to records, so a record can be removed only if it
+	// wasn't replaced concurrently (see DeleteExpired).
 	Storage struct {
+		// mu is shared by all operations and taken exclusively by Apply only,
+		// so nobody sees a half applied batch
+		mu     sync.RWMutex
 		values *sync.Map
+		// keys that have TTL, used by active expiration to sample candidates
+		expires *sync.Map
+		// keys ordered for Scan split by hash, a key is added by the write creating it and removed by the one deleting it
+		keys     [indexShards]indexShard
+		versions version.Counter
+	}
+
+	// indexShard is a part of ordered index with its own lock, so writers creating keys don't wait for each other
+	indexShard struct {
+		mu   sync.Mutex
+		keys *scan.Index
 	}
 )
 
+// indexShards is a number of parts of ordered index
+const indexShards = 32
+
 func New() *Storage {
-	return &Storage{
-		values: &sync.Map{},
+	s := &Storage{
+		values:  &sync.Map{},
+		expires: &sync.Map{},
+	}
+	for i := range s.keys {
+		s.keys[i].keys = scan.NewIndex()
 	}
+	return s
+}
+
+// NextVersion returns version for a record which is going to be written by Set or CompareAndSet
+func (s *Storage) NextVersion() uint64 {
+	return s.versions.Next()
 }
 
-func (s *Storage) Set(key string, value interface{}, ttl int64) error {
-	data, err := json.Marshal(value)
+// Set stores value with passed version, which is either got from NextVersion or restored from persistence
+func (s *Storage) Set(key string, value interface{}, ttl int64, version uint64) error {
+	r, err := newRecord(value, ttl, version)
 	if err != nil {
 		return err
 	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.versions.Observe(version)
+	s.store(key, r)
+	return nil
+}
 
-	record := Record{Value: data}
-	if ttl != 0 {
-		record.ExpiredAt = time.Now().Add(time.Second * time.Duration(ttl)).Unix()
+// CompareAndSet stores value only if the key has expected version, 0 means that key has to be missed.
+// Comparison is retried if the record was replaced concurrently with the same version, e.g. expired one.
+func (s *Storage) CompareAndSet(key string, value interface{}, ttl int64, expected uint64, version uint64) (bool, error) {
+	r, err := newRecord(value, ttl, version)
+	if err != nil {
+		return false, err
 	}
-	s.values.Store(key, record)
-	return nil
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for {
+		item, loaded := s.values.Load(key)
+		var current uint64
+		if loaded && !item.(*record.Record).Expired(time.Now().Unix()) {
+			current = item.(*record.Record).Version
+		}
+		if current != expected {
+			return false, nil
+		}
+		if loaded {
+			if !s.values.CompareAndSwap(key, item, r) {
+				continue
+			}
+		} else {
+			if _, exists := s.values.LoadOrStore(key, r); exists {
+				continue
+			}
+			s.index(key)
+		}
+		s.trackExpiration(key, r)
+		return true, nil
+	}
+}
+
+// Get returns value, expiration time and version of the key
+func (s *Storage) Get(key string) (interface{}, int64, uint64, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	item, found := s.values.Load(key)
+	if !found {
+		return nil, 0, 0, false, nil
+	}
+	return item.(*record.Record).Decode(time.Now().Unix())
 }
 
-func (s *Storage) Get(key string) (interface{}, int64, bool, error) {
-	raw, found := s.values.Load(key)
+// GetRaw returns bytes kept for value of the key without decoding them, with expiration time and version
+func (s *Storage) GetRaw(key string) (raw.Typed, int64, uint64, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	item, found := s.values.Load(key)
 	if !found {
-		return nil, 0, false, nil
+		return raw.Typed{}, 0, 0, false, nil
 	}
-	record := raw.(Record)
-	var data interface{}
-	err := json.Unmarshal(record.Value, &data)
+	return item.(*record.Record).Raw(time.Now().Unix())
+}
+
+// MGet returns items of keys in the same order, keys are loaded one by one
+func (s *Storage) MGet(keys []string) ([]batch.Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now().Unix()
+	items := make([]batch.Item, len(keys))
+	for i, key := range keys {
+		items[i].Key = key
+		r, found := s.values.Load(key)
+		if !found {
+			continue
+		}
+		var err error
+		items[i].Value, items[i].ExpiredAt, items[i].Version, items[i].Found, err = r.(*record.Record).Decode(now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// MSet stores all items with their TTL and version, nothing is stored if any value is invalid
+func (s *Storage) MSet(items []batch.Item) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	records := make([]*record.Record, len(items))
+	for i, item := range items {
+		r, err := newRecord(item.Value, item.TTL, item.Version)
+		if err != nil {
+			return err
+		}
+		records[i] = r
+		s.versions.Observe(item.Version)
+	}
+	for i, item := range items {
+		s.store(item.Key, records[i])
+	}
+	return nil
+}
+
+// Apply atomically replaces items of keys with ones returned by fn, fn and applied are called while other operations wait
+func (s *Storage) Apply(keys []string, fn batch.ApplyFunc, applied batch.AppliedFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().Unix()
+	current := make([]batch.Item, len(keys))
+	for i, key := range keys {
+		current[i].Key = key
+		if r, found := s.values.Load(key); found {
+			var err error
+			current[i].Value, current[i].ExpiredAt, current[i].Version, current[i].Found, err = r.(*record.Record).Decode(now)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	writes, err := fn(current)
 	if err != nil {
-		return nil, 0, false, err
+		return err
+	}
+
+	records := make([]*record.Record, len(writes))
+	for i, item := range writes {
+		if !item.Found {
+			continue
+		}
+		r, err := newRecord(item.Value, 0, item.Version)
+		if err != nil {
+			return err
+		}
+		r.ExpiredAt = item.ExpiredAt
+		records[i] = r
+		s.versions.Observe(item.Version)
 	}
-	// expire record if time has come
-	if record.ExpiredAt > 0 && (time.Now().Unix() >= record.ExpiredAt) {
-		return nil, 0, false, nil
+	for i, item := range writes {
+		if item.Found {
+			s.store(item.Key, records[i])
+		} else {
+			s.values.Delete(item.Key)
+			s.untrack(item.Key)
+			s.unindex(item.Key)
+		}
+	}
+	if applied != nil {
+		applied()
+	}
+	return nil
+}
+
+// Update atomically replaces structure stored at key with the one returned by fn, keeping its TTL.
+// fn is called again if the record was replaced concurrently.
+func (s *Storage) Update(key string, fn types.UpdateFunc) (stored types.Structure, expiredAt int64, version uint64, changed bool, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for {
+		item, loaded := s.values.Load(key)
+		r := &record.Record{}
+		found := false
+		if loaded && !item.(*record.Record).Expired(time.Now().Unix()) {
+			r = item.(*record.Record)
+			found = true
+		}
+		var current types.Structure
+		if r.Structure != nil {
+			current = r.Structure.Clone()
+		}
+		next, err := fn(current, r.ExpiredAt, found)
+		if err != nil || next == nil {
+			return nil, 0, 0, false, err
+		}
+
+		if next.Len() == 0 {
+			// empty structures are removed like in Redis
+			if !loaded {
+				return nil, 0, 0, false, nil
+			}
+			if !s.values.CompareAndDelete(key, item) {
+				continue
+			}
+			s.untrack(key)
+			s.unindex(key)
+			return nil, 0, 0, found, nil
+		}
+
+		updated := &record.Record{Structure: next, ExpiredAt: r.ExpiredAt, Version: s.versions.Next()}
+		if loaded {
+			if !s.values.CompareAndSwap(key, item, updated) {
+				continue
+			}
+		} else {
+			if _, exists := s.values.LoadOrStore(key, updated); exists {
+				continue
+			}
+			s.index(key)
+		}
+		s.trackExpiration(key, updated)
+		return next, updated.ExpiredAt, updated.Version, true, nil
 	}
+}
+
+// Incr atomically adds delta to the number stored at key, missed key counts as zero.
+// Key keeps its TTL unless ttl isn't 0. Increment is retried if the record was replaced concurrently.
+func (s *Storage) Incr(key string, delta counter.Delta, ttl int64) (interface{}, int64, uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for {
+		now := time.Now()
+		item, loaded := s.values.Load(key)
+		r := &record.Record{}
+		found := false
+		if loaded && !item.(*record.Record).Expired(now.Unix()) {
+			r = item.(*record.Record)
+			found = true
+		}
+		if r.Structure != nil {
+			return nil, 0, 0, types.WrongTypeError{}
+		}
+		var current interface{}
+		if found {
+			var err error
+			if current, err = raw.Decode(r.Value, r.ContentType); err != nil {
+				return nil, 0, 0, err
+			}
+		}
+		result, err := counter.Add(current, found, delta)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		data, contentType, err := raw.Encode(result)
+		if err != nil {
+			return nil, 0, 0, err
+		}
 
-	return data, record.ExpiredAt, true, nil
+		updated := &record.Record{Value: data, ContentType: contentType, ExpiredAt: r.ExpiredAt, Version: s.versions.Next()}
+		if ttl != 0 {
+			updated.ExpiredAt = now.Add(time.Second * time.Duration(ttl)).Unix()
+		}
+		if loaded {
+			if !s.values.CompareAndSwap(key, item, updated) {
+				continue
+			}
+		} else {
+			if _, exists := s.values.LoadOrStore(key, updated); exists {
+				continue
+			}
+			s.index(key)
+		}
+		s.trackExpiration(key, updated)
+		return result, updated.ExpiredAt, updated.Version, nil
+	}
 }
 
 func (s *Storage) Delete(key string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	s.values.Delete(key)
+	s.untrack(key)
+	s.unindex(key)
 	return nil
 }
 
+// MDelete removes keys and returns number of keys that existed
+func (s *Storage) MDelete(keys []string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now().Unix()
+	deleted := 0
+	for _, key := range keys {
+		item, found := s.values.LoadAndDelete(key)
+		if found && !item.(*record.Record).Expired(now) {
+			deleted++
+		}
+		s.untrack(key)
+		if found {
+			s.unindex(key)
+		}
+	}
+	return deleted, nil
+}
+
 func (s *Storage) GetKeys() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	keys := make([]string, 0)
+	now := time.Now().Unix()
 	s.values.Range(func(k, v interface{}) bool {
-		keys = append(keys, k.(string))
+		if !v.(*record.Record).Expired(now) {
+			keys = append(keys, k.(string))
+		}
 		return true
 	})
 	return keys, nil
 }
+
+// Scan examines up to count keys of the ordered index after cursor and returns the matching ones with cursor
+// of the next call, so a page doesn't walk the whole keyspace. Parts of index are scanned one after another.
+func (s *Storage) Scan(cursor string, match func(key string) bool, count int) ([]string, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return scan.Shards(indexShards, cursor, count, func(i int, from string, count int) ([]string, int, string, error) {
+		now := time.Now().Unix()
+		sh := &s.keys[i]
+		sh.mu.Lock()
+		defer sh.mu.Unlock()
+		var missed []string
+		keys, examined, next := sh.keys.Page(from, func(key string) bool {
+			item, found := s.values.Load(key)
+			if !found {
+				missed = append(missed, key)
+			}
+			return found && !item.(*record.Record).Expired(now) && match(key)
+		}, count)
+		// key deleted while it was being created may be left in index
+		for _, key := range missed {
+			sh.unindex(s.values, key)
+		}
+		return keys, examined, next, nil
+	})
+}
+
+// DeleteExpired checks up to sampleSize keys with TTL and removes the expired ones.
+// A record replaced by a concurrent Set is left untouched.
+func (s *Storage) DeleteExpired(sampleSize int) (expired []string, sampled int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now().Unix()
+	s.expires.Range(func(k, _ interface{}) bool {
+		sampled++
+		item, found := s.values.Load(k)
+		if !found || item.(*record.Record).ExpiredAt == 0 {
+			s.untrack(k.(string))
+		} else if item.(*record.Record).Expired(now) && s.values.CompareAndDelete(k, item) {
+			s.untrack(k.(string))
+			s.unindex(k.(string))
+			expired = append(expired, k.(string))
+		}
+		return sampled < sampleSize
+	})
+	return expired, sampled
+}
+
+// store puts record to the map, key is indexed if it's created by the write
+func (s *Storage) store(key string, r *record.Record) {
+	if _, loaded := s.values.Swap(key, r); !loaded {
+		s.index(key)
+	}
+	s.trackExpiration(key, r)
+}
+
+// index adds created key to its part of index
+func (s *Storage) index(key string) {
+	sh := s.indexShardOf(key)
+	sh.mu.Lock()
+	sh.keys.Add(key)
+	sh.mu.Unlock()
+}
+
+// unindex removes deleted key from index
+func (s *Storage) unindex(key string) {
+	sh := s.indexShardOf(key)
+	sh.mu.Lock()
+	sh.unindex(s.values, key)
+	sh.mu.Unlock()
+}
+
+// indexShardOf returns part of index keeping key, it's chosen by FNV-1a hash of key
+func (s *Storage) indexShardOf(key string) *indexShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return &s.keys[h%indexShards]
+}
+
+// unindex removes key from index, caller holds the lock. Key created concurrently could be removed after it
+// was added, so it's added back if it exists.
+func (sh *indexShard) unindex(values *sync.Map, key string) {
+	sh.keys.Remove(key)
+	if _, found := values.Load(key); found {
+		sh.keys.Add(key)
+	}
+}
+
+// trackExpiration adds key of stored record to active expiration candidates if it has TTL
+func (s *Storage) trackExpiration(key string, r *record.Record) {
+	if r.ExpiredAt > 0 {
+		s.expires.Store(key, struct{}{})
+	} else {
+		s.untrack(key)
+	}
+}
+
+// untrack removes key from active expiration candidates. Record with TTL could be stored concurrently after
+// the caller replaced or deleted its record, so the key is added back if the stored record has TTL.
+func (s *Storage) untrack(key string) {
+	s.expires.Delete(key)
+	if item, found := s.values.Load(key); found && item.(*record.Record).ExpiredAt > 0 {
+		s.expires.Store(key, struct{}{})
+	}
+}
+
+// newRecord returns pointer to a new record, records are compared by identity on replacing
+func newRecord(value interface{}, ttl int64, version uint64) (*record.Record, error) {
+	r, err := record.New(value, ttl, version)
+	return &r, err
+}
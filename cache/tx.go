@@ -0,0 +1,304 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+
+	"./aof"
+	"./batch"
+	"./counter"
+	"./types"
+)
+
+// Operations of transaction
+const (
+	TxGet    = "get"
+	TxSet    = "set"
+	TxDelete = "delete"
+	TxIncr   = "incr"
+)
+
+type (
+	// TxOp is a command queued to transaction. Set uses Value and TTL, incr uses Delta and TTL (0 keeps TTL of key).
+	TxOp struct {
+		Op    string
+		Key   string
+		Value interface{}
+		TTL   int64
+		Delta counter.Delta
+	}
+
+	// TxResult is a state of key after its operation: value of get and incr, version of set and incr.
+	// Found is false for missed key of get and for key which didn't exist before delete.
+	TxResult struct {
+		Key       string
+		Value     interface{}
+		ExpiredAt int64
+		Version   uint64
+		Found     bool
+	}
+
+	// TxAbortedError is returned by Exec when a watched key was changed, nothing is executed then
+	TxAbortedError struct {
+		key string
+	}
+
+	// txState is a key as transaction sees it
+	txState struct {
+		value     interface{}
+		expiredAt int64
+		version   uint64
+		found     bool
+		dirty     bool
+	}
+)
+
+func (tae TxAbortedError) Error() string {
+	return fmt.Sprintf("Transaction aborted, key '%s' was changed.", tae.key)
+}
+
+// Versions returns current versions of keys to watch them, missed keys have version 0
+func (cm *CacheManager) Versions(keys []string) (map[string]uint64, error) {
+	items, err := cm.Provider.MGet(keys)
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[string]uint64, len(items))
+	for _, item := range items {
+		versions[item.Key] = item.Version
+	}
+	return versions, nil
+}
+
+// Exec atomically runs ops if watched keys still have passed versions, TxAbortedError is returned otherwise.
+// Ops see results of previous ones. If any op fails nothing is written. Writes are logged to AOF as one record.
+func (cm *CacheManager) Exec(ops []TxOp, watched map[string]uint64) ([]TxResult, error) {
+	for _, op := range ops {
+		switch op.Op {
+		case TxGet, TxSet, TxDelete, TxIncr:
+		default:
+			return nil, fmt.Errorf("Operation '%s' isn't supported in transaction.", op.Op)
+		}
+	}
+
+	keys := make([]string, 0, len(ops)+len(watched))
+	for key := range watched {
+		keys = append(keys, key)
+	}
+	for _, op := range ops {
+		keys = append(keys, op.Key)
+	}
+	keys = uniqueKeys(keys)
+
+	var results []TxResult
+	err := cm.apply(keys, func(current []Item) ([]Item, error) {
+		state := newTxStates(current)
+		for key, version := range watched {
+			if state[key].version != version {
+				return nil, TxAbortedError{key}
+			}
+		}
+
+		results = make([]TxResult, len(ops))
+		for i, op := range ops {
+			result, err := cm.execOp(op, state[op.Key])
+			if err != nil {
+				return nil, err
+			}
+			results[i] = result
+		}
+
+		return txWrites(keys, state), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// newTxStates returns state of keys read by Apply
+func newTxStates(current []Item) map[string]*txState {
+	state := make(map[string]*txState, len(current))
+	for _, item := range current {
+		state[item.Key] = &txState{value: item.Value, expiredAt: item.ExpiredAt, version: item.Version, found: item.Found}
+	}
+	return state
+}
+
+// txWrites returns items of keys changed by transaction
+func txWrites(keys []string, state map[string]*txState) []Item {
+	var writes []Item
+	for _, key := range keys {
+		if s := state[key]; s.dirty {
+			writes = append(writes, Item{Key: key, Value: s.value, ExpiredAt: s.expiredAt, Version: s.version, Found: s.found})
+		}
+	}
+	return writes
+}
+
+// uniqueKeys removes repeated keys keeping order
+func uniqueKeys(keys []string) []string {
+	unique := make([]string, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if !seen[key] {
+			unique = append(unique, key)
+			seen[key] = true
+		}
+	}
+	return unique
+}
+
+// execOp applies op to state of its key
+func (cm *CacheManager) execOp(op TxOp, s *txState) (TxResult, error) {
+	result := TxResult{Key: op.Key}
+	switch op.Op {
+	case TxGet:
+		result.Value, result.ExpiredAt, result.Version, result.Found = s.value, s.expiredAt, s.version, s.found
+		return result, nil
+	case TxDelete:
+		result.Found = s.found
+		if s.found {
+			*s = txState{dirty: true}
+		}
+		return result, nil
+	case TxSet:
+		s.value, s.expiredAt = op.Value, 0
+		if ttl := cm.ttlOrDefault(op.TTL); ttl != 0 {
+			s.expiredAt = time.Now().Add(time.Second * time.Duration(ttl)).Unix()
+		}
+	case TxIncr:
+		if _, ok := s.value.(types.Structure); ok {
+			return result, WrongTypeError{}
+		}
+		value, err := counter.Add(s.value, s.found, op.Delta)
+		if err != nil {
+			return result, err
+		}
+		s.value = value
+		if op.TTL != 0 {
+			s.expiredAt = time.Now().Add(time.Second * time.Duration(op.TTL)).Unix()
+		}
+	}
+	s.found, s.dirty, s.version = true, true, cm.Provider.NextVersion()
+	result.Value, result.ExpiredAt, result.Version, result.Found = s.value, s.expiredAt, s.version, true
+	return result, nil
+}
+
+// apply atomically writes items returned by fn, accounts them by memory limit and persists them as one AOF record.
+// Pending and completed records are written under locks of keys, so AOF keeps transactions in order they're applied.
+func (cm *CacheManager) apply(keys []string, fn batch.ApplyFunc) error {
+	var writes []Item
+	var accounted, victims []string
+	logged := false
+	unlock := cm.lockEvents(keys...)
+	err := cm.Provider.Apply(keys, func(current []Item) ([]Item, error) {
+		items, err := fn(current)
+		if err != nil {
+			return nil, err
+		}
+		if cm.evictor != nil {
+			for _, item := range items {
+				if !item.Found {
+					continue
+				}
+				evicted, err := cm.account(item.Key, item.Value, item.ExpiredAt)
+				if err != nil {
+					return nil, err
+				}
+				accounted = append(accounted, item.Key)
+				victims = append(victims, evicted...)
+			}
+		}
+		for i := range items {
+			if items[i].Found {
+				items[i].TTL = remainingTTL(items[i].ExpiredAt)
+			}
+		}
+		writes = items
+		if len(writes) > 0 && !cm.RestoreMode {
+			cm.aofLog.WriteTx(writes, aof.Pending)
+			logged = true
+		}
+		return items, nil
+	}, func() {
+		if logged {
+			cm.aofLog.WriteTx(writes, aof.Completed)
+		}
+	})
+	if err == nil {
+		cm.applied(writes)
+	} else if logged {
+		cm.aofLog.WriteTx(writes, aof.Failed)
+	}
+	unlock()
+	// victims are already released by evictor, so they're removed even if nothing was written
+	cm.evict(victims)
+	if err != nil {
+		// release room accounted for items which weren't written
+		for _, key := range accounted {
+			cm.reaccount(key)
+		}
+	}
+	return err
+}
+
+// applied saves writes stored by apply to CDB and notifies watchers, caller holds locks of events
+func (cm *CacheManager) applied(writes []Item) {
+	var sets []Item
+	var deletes []string
+	for _, item := range writes {
+		if item.Found {
+			sets = append(sets, item)
+		} else {
+			deletes = append(deletes, item.Key)
+			if cm.evictor != nil {
+				cm.evictor.Remove(item.Key)
+			}
+		}
+	}
+	if cm.CDBEnabled {
+		if sets != nil {
+			cm.keyspace.SetBatch(sets)
+		}
+		if deletes != nil {
+			cm.keyspace.DeleteBatch(deletes)
+		}
+	}
+	for _, item := range writes {
+		if item.Found {
+			cm.notify(EventSet, item.Key, item.Value, item.ExpiredAt)
+		} else {
+			cm.notify(EventDelete, item.Key, nil, 0)
+		}
+	}
+}
+
+// restoreTx applies writes of transaction record read from AOF as a whole, it was written at passed time.
+// Expired values are deleted.
+func (cm *CacheManager) restoreTx(entries []aof.BatchEntry, written int64) error {
+	keys := make([]string, len(entries))
+	items := make([]Item, len(entries))
+	for i, entry := range entries {
+		keys[i] = entry.Key
+		items[i] = Item{Key: entry.Key}
+		ttl, expired := restoredTTL(entry.ExpiredAt, entry.TTL, written)
+		if entry.Deleted || expired {
+			continue
+		}
+		value, err := restoreValue(entry.Value, entry.Opaque, entry.Type)
+		if err != nil {
+			return err
+		}
+		items[i].Value, items[i].Version, items[i].Found = value, entry.Version, true
+		if items[i].Version == 0 {
+			items[i].Version = cm.Provider.NextVersion()
+		}
+		if ttl != 0 {
+			items[i].ExpiredAt = time.Now().Unix() + ttl
+		}
+	}
+	return cm.apply(keys, func(current []Item) ([]Item, error) {
+		return items, nil
+	})
+}
@@ -0,0 +1,15 @@
+package types
+
+// Hash maps fields to values
+type Hash map[string]string
+
+func (h Hash) Type() string { return HashType }
+func (h Hash) Len() int     { return len(h) }
+
+func (h Hash) Clone() Structure {
+	clone := make(Hash, len(h))
+	for field, value := range h {
+		clone[field] = value
+	}
+	return clone
+}
@@ -0,0 +1,37 @@
+package types
+
+// List is a sequence of values
+type List []string
+
+func (l List) Type() string { return ListType }
+func (l List) Len() int     { return len(l) }
+
+func (l List) Clone() Structure {
+	return append(make(List, 0, len(l)), l...)
+}
+
+// Range returns values between start and stop positions inclusive, negative positions count from the end
+func (l List) Range(start, stop int) []string {
+	start, stop, ok := normalizeRange(start, stop, len(l))
+	if !ok {
+		return []string{}
+	}
+	return append([]string{}, l[start:stop+1]...)
+}
+
+// normalizeRange converts Redis-like inclusive range to valid slice indexes
+func normalizeRange(start, stop, length int) (int, int, bool) {
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	return start, stop, start <= stop && start < length
+}
@@ -0,0 +1,47 @@
+package types
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// Set is unordered collection of unique members
+type Set map[string]struct{}
+
+func (s Set) Type() string { return SetType }
+func (s Set) Len() int     { return len(s) }
+
+func (s Set) Clone() Structure {
+	clone := make(Set, len(s))
+	for member := range s {
+		clone[member] = struct{}{}
+	}
+	return clone
+}
+
+// Members returns sorted list of members
+func (s Set) Members() []string {
+	members := make([]string, 0, len(s))
+	for member := range s {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// MarshalJSON encodes set as array of members
+func (s Set) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Members())
+}
+
+func (s *Set) UnmarshalJSON(data []byte) error {
+	var members []string
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	*s = make(Set, len(members))
+	for _, member := range members {
+		(*s)[member] = struct{}{}
+	}
+	return nil
+}
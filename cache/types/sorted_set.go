@@ -0,0 +1,192 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// SortedSet is a set of members ordered by score, members with the same score are ordered lexicographically
+	SortedSet struct {
+		scores  map[string]float64
+		members []ZMember
+	}
+
+	ZMember struct {
+		Member string  `json:"member"`
+		Score  float64 `json:"score"`
+	}
+
+	// ScoreBound is min or max of score range, it's parsed from Redis syntax: 1.5, (1.5, -inf, +inf
+	ScoreBound struct {
+		Value     float64
+		Exclusive bool
+	}
+)
+
+func NewSortedSet() *SortedSet {
+	return &SortedSet{scores: make(map[string]float64)}
+}
+
+func (z *SortedSet) Type() string { return SortedSetType }
+func (z *SortedSet) Len() int     { return len(z.members) }
+
+func (z *SortedSet) Clone() Structure {
+	clone := &SortedSet{
+		scores:  make(map[string]float64, len(z.scores)),
+		members: append(make([]ZMember, 0, len(z.members)), z.members...),
+	}
+	for member, score := range z.scores {
+		clone.scores[member] = score
+	}
+	return clone
+}
+
+// Add inserts member or updates its score, it reports whether member is new
+func (z *SortedSet) Add(member string, score float64) bool {
+	old, found := z.scores[member]
+	if found {
+		if old == score {
+			return false
+		}
+		i := z.search(ZMember{member, old})
+		z.members = append(z.members[:i], z.members[i+1:]...)
+	}
+	z.scores[member] = score
+	item := ZMember{member, score}
+	i := z.search(item)
+	z.members = append(z.members, ZMember{})
+	copy(z.members[i+1:], z.members[i:])
+	z.members[i] = item
+	return !found
+}
+
+// Score returns score of member
+func (z *SortedSet) Score(member string) (float64, bool) {
+	score, found := z.scores[member]
+	return score, found
+}
+
+// Range returns members between start and stop ranks inclusive, negative ranks count from the end
+func (z *SortedSet) Range(start, stop int) []ZMember {
+	start, stop, ok := normalizeRange(start, stop, len(z.members))
+	if !ok {
+		return []ZMember{}
+	}
+	return append([]ZMember{}, z.members[start:stop+1]...)
+}
+
+// RangeByScore returns members with scores between min and max
+func (z *SortedSet) RangeByScore(min, max ScoreBound) []ZMember {
+	start := sort.Search(len(z.members), func(i int) bool {
+		return !min.below(z.members[i].Score)
+	})
+	result := []ZMember{}
+	for _, item := range z.members[start:] {
+		if max.above(item.Score) {
+			break
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// search returns position of item in ordered members
+func (z *SortedSet) search(item ZMember) int {
+	return sort.Search(len(z.members), func(i int) bool {
+		other := z.members[i]
+		return other.Score > item.Score || other.Score == item.Score && other.Member >= item.Member
+	})
+}
+
+// MarshalJSON encodes sorted set as ordered array of members with scores
+func (z *SortedSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(z.members)
+}
+
+func (z *SortedSet) UnmarshalJSON(data []byte) error {
+	var members []ZMember
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	*z = *NewSortedSet()
+	for _, item := range members {
+		z.Add(item.Member, item.Score)
+	}
+	return nil
+}
+
+// MarshalJSON keeps infinite scores as strings, JSON numbers can't hold them
+func (m ZMember) MarshalJSON() ([]byte, error) {
+	var score interface{} = m.Score
+	if math.IsInf(m.Score, 0) {
+		score = FormatScore(m.Score)
+	}
+	return json.Marshal(map[string]interface{}{"member": m.Member, "score": score})
+}
+
+func (m *ZMember) UnmarshalJSON(data []byte) error {
+	var item struct {
+		Member string
+		Score  json.RawMessage
+	}
+	if err := json.Unmarshal(data, &item); err != nil {
+		return err
+	}
+	score := strings.Trim(string(item.Score), `"`)
+	value, err := ParseScore(score)
+	if err != nil {
+		return err
+	}
+	m.Member, m.Score = item.Member, value
+	return nil
+}
+
+// ParseScore parses score, infinity is allowed but NaN isn't
+func ParseScore(s string) (float64, error) {
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, fmt.Errorf("score '%s' is not a valid float", s)
+	}
+	return score, nil
+}
+
+// FormatScore formats score the way Redis does
+func FormatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// ParseScoreBound parses min or max of score range
+func ParseScoreBound(s string) (ScoreBound, error) {
+	var bound ScoreBound
+	if strings.HasPrefix(s, "(") {
+		bound.Exclusive = true
+		s = s[1:]
+	}
+	score, err := ParseScore(s)
+	if err != nil {
+		return bound, fmt.Errorf("min or max is not a float")
+	}
+	bound.Value = score
+	return bound, nil
+}
+
+// below reports whether score is lower than min bound
+func (b ScoreBound) below(score float64) bool {
+	return score < b.Value || b.Exclusive && score == b.Value
+}
+
+// above reports whether score is greater than max bound
+func (b ScoreBound) above(score float64) bool {
+	return score > b.Value || b.Exclusive && score == b.Value
+}
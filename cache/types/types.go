@@ -0,0 +1,85 @@
+// Package types implements Redis-like data structures which providers store natively instead of JSON blobs.
+// Stored structures are never changed in place: providers update a copy and replace the record atomically,
+// so a structure returned by Get could be read without locks but must not be modified.
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Names of structure types
+const (
+	HashType      = "hash"
+	ListType      = "list"
+	SetType       = "set"
+	SortedSetType = "zset"
+)
+
+type (
+	// Structure is a value with native type
+	Structure interface {
+		Type() string
+		Len() int
+		Clone() Structure
+	}
+
+	// UpdateFunc changes a private copy of the current structure and returns it back to be stored.
+	// current is nil if key isn't found or holds a plain value, found tells them apart.
+	// Returned nil means nothing has changed, empty structure means key has to be deleted.
+	// Providers may call it more than once, if the key was changed concurrently.
+	UpdateFunc func(current Structure, expiredAt int64, found bool) (Structure, error)
+
+	// InvalidTypeError is returned for unknown structure type
+	InvalidTypeError struct {
+		typeName string
+	}
+
+	// WrongTypeError is returned when command is called on a key holding value of another type
+	WrongTypeError struct{}
+)
+
+func (WrongTypeError) Error() string {
+	return "WRONGTYPE Operation against a key holding the wrong kind of value"
+}
+
+func (ite InvalidTypeError) Error() string {
+	return fmt.Sprintf("Structure type '%s' is invalid.", ite.typeName)
+}
+
+// New returns empty structure of the given type
+func New(typeName string) (Structure, error) {
+	switch typeName {
+	case HashType:
+		return Hash{}, nil
+	case ListType:
+		return List{}, nil
+	case SetType:
+		return Set{}, nil
+	case SortedSetType:
+		return NewSortedSet(), nil
+	}
+	return nil, InvalidTypeError{typeName}
+}
+
+// Unmarshal restores structure of the given type from JSON
+func Unmarshal(typeName string, data []byte) (Structure, error) {
+	structure, err := New(typeName)
+	if err != nil {
+		return nil, err
+	}
+	switch s := structure.(type) {
+	case Hash:
+		err = json.Unmarshal(data, &s)
+		structure = s
+	case List:
+		err = json.Unmarshal(data, &s)
+		structure = s
+	case Set:
+		err = json.Unmarshal(data, &s)
+		structure = s
+	default:
+		err = json.Unmarshal(data, structure)
+	}
+	return structure, err
+}
@@ -0,0 +1,24 @@
+// Package version generates versions of cache records, which are used as CAS tokens.
+package version
+
+import "sync/atomic"
+
+// Counter gives out increasing versions, zero value is ready to use and zero version is never given out
+type Counter struct {
+	last uint64
+}
+
+// Next returns a new version
+func (c *Counter) Next() uint64 {
+	return atomic.AddUint64(&c.last, 1)
+}
+
+// Observe moves counter past version restored from persistence, so it isn't given out again
+func (c *Counter) Observe(version uint64) {
+	for {
+		last := atomic.LoadUint64(&c.last)
+		if version <= last || atomic.CompareAndSwapUint64(&c.last, last, version) {
+			return
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package cache
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Types of key change events
+const (
+	EventSet     = "set"
+	EventDelete  = "del"
+	EventExpired = "expired"
+	EventEvicted = "evicted"
+)
+
+// watchBuffer is a number of events kept for a watcher that doesn't read them,
+// when it is exceeded the watcher is dropped
+const watchBuffer = 1024
+
+// DefaultWatchHistory is a number of the latest events kept to resume watching from cursor
+const DefaultWatchHistory = 1024
+
+// watchStripes is a number of locks which keep events of a key in order of its writes
+const watchStripes = 256
+
+type (
+	// Event describes change of a key, Value and ExpiredAt are set only for EventSet.
+	// Seq increases by one with every event, so it could be used as cursor to resume watching.
+	// Events of a key get Seq in order its writes are stored, but events of concurrent writes
+	// may come to a watcher out of Seq order.
+	Event struct {
+		Seq       uint64
+		Type      string
+		Key       string
+		Value     interface{}
+		ExpiredAt int64
+		Timestamp time.Time
+	}
+
+	watcher struct {
+		match  func(key string) bool
+		events chan Event
+		// mu guards sending against closing of events
+		mu     sync.Mutex
+		closed bool
+	}
+
+	// watchHub delivers events to watchers without blocking writers and keeps history of the latest events
+	watchHub struct {
+		mu sync.Mutex
+		// watchers is replaced on every change, so events are sent to its copy without the lock
+		watchers []*watcher
+		seq      uint64
+		// history is a ring of the latest events without values, start is index of the oldest one
+		history []Event
+		start   int
+		limit   int
+		// active is 1 while there are watchers or history is kept, otherwise events aren't recorded
+		active int32
+		// stripes are held by writers from storing keys until notifying, see lockEvents
+		stripes [watchStripes]sync.Mutex
+	}
+)
+
+// Watch subscribes to changes of keys starting with prefix. Returned channel is closed when
+// cancel is called or when the watcher doesn't keep up with events.
+func (cm *CacheManager) Watch(prefix string) (events <-chan Event, cancel func()) {
+	_, events, cancel, _ = cm.watch.subscribe(func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	}, nil)
+	return events, cancel
+}
+
+// WatchFrom subscribes to changes of keys matching glob pattern (see ValidatePattern, invalid one matches nothing)
+// after cursor. Events after cursor kept in history are returned as backlog, the channel gets the following ones.
+// Backlog events don't have values, history doesn't keep them. Missed is true if some events after cursor
+// aren't kept anymore, then backlog starts from the oldest kept event.
+func (cm *CacheManager) WatchFrom(pattern string, cursor uint64) (backlog []Event, events <-chan Event, cancel func(), missed bool) {
+	match, _ := globMatcher(pattern)
+	return cm.watch.subscribe(match, &cursor)
+}
+
+// Events returns events of keys matching glob pattern after cursor kept in history, see WatchFrom
+func (cm *CacheManager) Events(pattern string, cursor uint64) (events []Event, missed bool) {
+	match, _ := globMatcher(pattern)
+	hub := &cm.watch
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	return hub.since(match, cursor)
+}
+
+// EventCursor returns Seq of the latest event, watching from it gets only new events
+func (cm *CacheManager) EventCursor() uint64 {
+	hub := &cm.watch
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	return hub.seq
+}
+
+// SetWatchHistory sets number of the latest events kept to resume watching, 0 disables history
+func (cm *CacheManager) SetWatchHistory(limit int) {
+	if limit < 0 {
+		limit = 0
+	}
+	hub := &cm.watch
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	events, _ := hub.since(func(string) bool { return true }, 0)
+	if len(events) > limit {
+		events = events[len(events)-limit:]
+	}
+	hub.history, hub.start, hub.limit = events, 0, limit
+	hub.updateActive()
+}
+
+// notify records event and sends it to all watchers interested in the key. Watchers which don't keep up
+// are dropped instead of waiting for them, so writers are never blocked. Without watchers and history it does nothing.
+func (cm *CacheManager) notify(eventType string, key string, value interface{}, expiredAt int64) {
+	hub := &cm.watch
+	if cm.RestoreMode || atomic.LoadInt32(&hub.active) == 0 {
+		return
+	}
+	hub.mu.Lock()
+	hub.seq++
+	event := Event{Seq: hub.seq, Type: eventType, Key: key, Value: value, ExpiredAt: expiredAt, Timestamp: time.Now()}
+	hub.record(event)
+	watchers := hub.watchers
+	hub.mu.Unlock()
+
+	for _, w := range watchers {
+		if w.match(key) && !w.send(event) {
+			hub.remove(w)
+		}
+	}
+}
+
+// lockEvents locks stripes of keys, so Seq of their events follows order of writes. It has to be taken before
+// the write is stored and released after notify. Caller must not evict keys before unlock, since a victim
+// could share a stripe with locked keys.
+func (cm *CacheManager) lockEvents(keys ...string) (unlock func()) {
+	if cm.RestoreMode {
+		return func() {}
+	}
+	hub := &cm.watch
+	if len(keys) == 1 {
+		stripe := &hub.stripes[watchStripe(keys[0])]
+		stripe.Lock()
+		return stripe.Unlock
+	}
+	// stripes are locked in ascending order, so writers of overlapping keys don't deadlock
+	stripes := make([]int, 0, len(keys))
+	seen := make(map[int]bool, len(keys))
+	for _, key := range keys {
+		if i := watchStripe(key); !seen[i] {
+			seen[i] = true
+			stripes = append(stripes, i)
+		}
+	}
+	sort.Ints(stripes)
+	for _, i := range stripes {
+		hub.stripes[i].Lock()
+	}
+	return func() {
+		for _, i := range stripes {
+			hub.stripes[i].Unlock()
+		}
+	}
+}
+
+// watchStripe returns index of stripe of key by FNV-1a hash
+func watchStripe(key string) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return int(hash % watchStripes)
+}
+
+// subscribe adds watcher, events after cursor kept in history are returned if cursor is passed
+func (hub *watchHub) subscribe(match func(key string) bool, cursor *uint64) (backlog []Event, events chan Event, cancel func(), missed bool) {
+	w := &watcher{match: match, events: make(chan Event, watchBuffer)}
+	hub.mu.Lock()
+	watchers := make([]*watcher, len(hub.watchers), len(hub.watchers)+1)
+	copy(watchers, hub.watchers)
+	hub.watchers = append(watchers, w)
+	hub.updateActive()
+	if cursor != nil {
+		backlog, missed = hub.since(match, *cursor)
+	}
+	hub.mu.Unlock()
+
+	return backlog, w.events, func() {
+		hub.remove(w)
+	}, missed
+}
+
+// updateActive tells notify whether events have to be recorded, caller holds the lock
+func (hub *watchHub) updateActive() {
+	var active int32
+	if len(hub.watchers) > 0 || hub.limit > 0 {
+		active = 1
+	}
+	atomic.StoreInt32(&hub.active, active)
+}
+
+// record adds event to history ring, caller holds the lock. Value isn't kept, so history doesn't hold
+// values which are already removed from storage and aren't counted by memory limit.
+func (hub *watchHub) record(event Event) {
+	if hub.limit == 0 {
+		return
+	}
+	event.Value = nil
+	if len(hub.history) < hub.limit {
+		hub.history = append(hub.history, event)
+		return
+	}
+	hub.history[hub.start] = event
+	hub.start = (hub.start + 1) % len(hub.history)
+}
+
+// since returns matching events of history after cursor, caller holds the lock.
+// Cursor from future (e.g. given out before restart) is missed too.
+func (hub *watchHub) since(match func(key string) bool, cursor uint64) (events []Event, missed bool) {
+	if cursor > hub.seq {
+		return nil, true
+	}
+	if len(hub.history) > 0 && hub.history[hub.start].Seq > cursor+1 {
+		missed = true
+	}
+	for i := range hub.history {
+		event := hub.history[(hub.start+i)%len(hub.history)]
+		if event.Seq > cursor && match(event.Key) {
+			events = append(events, event)
+		}
+	}
+	return events, missed
+}
+
+// remove unsubscribes watcher and closes its channel
+func (hub *watchHub) remove(w *watcher) {
+	hub.mu.Lock()
+	for i, other := range hub.watchers {
+		if other == w {
+			watchers := make([]*watcher, 0, len(hub.watchers)-1)
+			hub.watchers = append(append(watchers, hub.watchers[:i]...), hub.watchers[i+1:]...)
+			break
+		}
+	}
+	hub.updateActive()
+	hub.mu.Unlock()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.events)
+	}
+}
+
+// send passes event to watcher without waiting, false means that watcher doesn't keep up
+func (w *watcher) send(event Event) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return true
+	}
+	select {
+	case w.events <- event:
+		return true
+	default:
+		return false
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	l "log"
 	"os"
 	"os/signal"
@@ -8,12 +9,15 @@ import (
 	"time"
 
 	"./cache"
+	"./cache/raw"
 	"./config"
+	"./pubsub"
 	"github.com/google/logger"
 )
 
 var (
-	cacheManager cache.Cache
+	cacheManager *cache.CacheManager
+	pubSub       *pubsub.Hub
 	logfile      *os.File
 	log          *l.Logger
 )
@@ -26,17 +30,42 @@ const (
 func main() {
 	prepareLogger()
 	cacheProvider := *config.CacheType
-	var err error
-	cacheManager, err = cache.New(cacheProvider, log, *config.CDBEnabled, *config.CDBPeriod, *config.AOFEnabled)
+	cache.SetShards(*config.Shards)
+	if err := cache.SetAppendFsync(*config.AppendFsync); err != nil {
+		log.Fatalf("Error while initializing cache manager: %s", err)
+	}
+	cache.SetAOFLoadTruncated(*config.AOFLoadTruncated)
+	cache.SetAOFRewrite(*config.AOFRewritePercentage, int64(*config.AOFRewriteMinSize))
+	manager, err := cache.New(cacheProvider, log, *config.CDBEnabled, *config.CDBPeriod, *config.AOFEnabled)
 	if err != nil {
 		log.Fatalf("Error while initializing cache manager: %s", err)
 	}
+	err = manager.SetMemoryLimit(int64(*config.MaxMemory), *config.MaxKeys, *config.EvictionPolicy, *config.EvictionSample)
+	if err != nil {
+		log.Fatalf("Error while setting memory limit: %s", err)
+	}
+	manager.SetScriptTimeout(time.Duration(*config.ScriptTimeout) * time.Millisecond)
+	manager.SetWatchHistory(*config.WatchHistory)
+	expireInterval := time.Duration(*config.ExpireInterval) * time.Millisecond
+	err = manager.StartExpiration(expireInterval, *config.ExpireSample, *config.ExpireBudget)
+	if err != nil {
+		log.Fatalf("Error while starting active expiration: %s", err)
+	}
+	cacheManager = manager
+	pubSub = pubsub.New(*config.PubSubBuffer)
+	if *config.CDBEnabled || *config.AOFEnabled {
+		if err = loadNamespaces(); err != nil {
+			log.Fatalf("Error while restoring namespaces: %s", err)
+		}
+	}
 
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		for range signals {
 			log.Println("Shutting down Cacher...")
+			manager.StopExpiration()
+			stopNamespaces()
 			cache.Close()
 			logfile.Close()
 			time.Sleep(2 * time.Second)
@@ -44,9 +73,16 @@ func main() {
 		}
 	}()
 
-	if *config.Interface == "http" {
+	switch *config.Interface {
+	case "http":
 		startHTTPServer()
-	} else {
+	case "resp":
+		startRESPServer()
+	case "memcached":
+		startMemcachedServer()
+	case "grpc":
+		startGRPCServer()
+	default:
 		startTelNetServer()
 	}
 }
@@ -66,6 +102,30 @@ func prepareLogger() {
 	log = l.New(logfile, "Cacher: ", l.LstdFlags)
 }
 
+// valueBytes converts cached value for protocols which aren't based on JSON:
+// opaque bytes and strings are returned as is and other values as JSON.
+func valueBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case raw.Bytes:
+		return v
+	case raw.Typed:
+		return v.Data
+	case string:
+		return []byte(v)
+	}
+	data, _ := json.Marshal(value)
+	return data
+}
+
+// rawResultValue returns value read by GetRaw for JSON results: JSON is written as is without decoding,
+// other values are written as base64 string together with their content type.
+func rawResultValue(value raw.Typed) (interface{}, string) {
+	if value.ContentType == raw.JSONType {
+		return json.RawMessage(value.Data), ""
+	}
+	return value.Data, value.ContentType
+}
+
 func fileExists(name string) bool {
 	if _, err := os.Stat(name); err != nil {
 		if os.IsNotExist(err) {
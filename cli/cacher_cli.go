@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
+	"../cache/aof"
 	"github.com/ddliu/go-httpclient"
 	"github.com/reiver/go-telnet"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -22,10 +24,18 @@ var (
 	serverIP   = http.Flag("server", "Server address.").Short('a').Default("127.0.0.1").IP()
 	serverPort = http.Flag("port", "Server port.").Short('p').Default("1323").String()
 	authToken  = http.Flag("auth_token", "Bearer Authentication Token.").Short('t').Required().String()
-	command    = http.Arg("command", "Command for Cacher.").HintOptions("get", "set", "delete", "keys").Required().String()
-	key        = http.Arg("key", "Cache key.").String()
-	value      = http.Arg("value", "Cache value. Should be in JSON form. Only for 'set' command!").String()
+	command    = http.Arg("command", "Command for Cacher.").HintOptions("get", "set", "delete", "keys", "mget", "mset", "mdel").Required().String()
+	key        = http.Arg("key", "Cache key. Comma separated keys for 'mget', 'mset' and 'mdel' commands.").String()
+	value      = http.Arg("value", "Cache value. Should be in JSON form, JSON list of values for 'mset'. Only for 'set' and 'mset' commands!").String()
 	ttl        = http.Arg("ttl", "Cache pait TTL in seconds.").Int64()
+
+	aofMigrate     = app.Command("aof-migrate", "Convert log formatted AOF of previous versions into binary AOF. Cacher has to be stopped.")
+	aofLegacyPath  = aofMigrate.Arg("source", "Log formatted AOF file.").Default(aof.LegacyPath).String()
+	aofDestination = aofMigrate.Arg("destination", "Binary AOF file, its records are kept after converted ones.").Default(aof.DefaultPath).String()
+
+	aofCheck     = app.Command("aof-check", "Validate AOF file and cut its damaged tail with --fix. Cacher has to be stopped.")
+	aofCheckFix  = aofCheck.Flag("fix", "Truncate records after the last valid one.").Bool()
+	aofCheckPath = aofCheck.Arg("file", "AOF file.").Default(aof.DefaultPath).String()
 )
 
 type payload struct {
@@ -64,7 +74,23 @@ func main() {
 			handleDeleteCommand()
 		} else if *command == "keys" {
 			handleKeysCommand()
+		} else if *command == "mget" || *command == "mdel" {
+			if *key == "" {
+				kingpin.Fatalf("Command '%s' requires one param: comma separated 'keys'.", *command)
+			}
+			handleBatchKeysCommand()
+		} else if *command == "mset" {
+			if *key == "" || *value == "" {
+				kingpin.Fatalf("Command 'mset' requires at least two params: comma separated 'keys' and JSON list of 'values'. Third param 'ttl' is optinal.")
+			}
+			handleMSetCommand()
 		}
+
+	case aofMigrate.FullCommand():
+		handleAOFMigrateCommand()
+
+	case aofCheck.FullCommand():
+		handleAOFCheckCommand()
 	}
 }
 
@@ -78,6 +104,40 @@ func startTelNetClient() {
 	}
 }
 
+func handleAOFMigrateCommand() {
+	counter, skipped, err := aof.MigrateLegacy(*aofLegacyPath, *aofDestination)
+	if err != nil {
+		kingpin.Fatalf("Migration failed after %d records: %s", counter, err)
+	}
+	if skipped != nil {
+		fmt.Printf("Skipped the last line written partially. %s\n", skipped)
+	}
+	fmt.Printf("Converted %d records from %s to %s\n", counter, *aofLegacyPath, *aofDestination)
+}
+
+func handleAOFCheckCommand() {
+	records, valid, err := aof.Check(*aofCheckPath)
+	if err == nil {
+		fmt.Printf("AOF is valid: %d records, %d bytes\n", records, valid)
+		return
+	}
+	if err != aof.ErrTruncated && err != aof.ErrCorrupted {
+		kingpin.Fatalf("%s", err)
+	}
+	info, statErr := os.Stat(*aofCheckPath)
+	if statErr != nil {
+		kingpin.Fatalf("%s", statErr)
+	}
+	fmt.Printf("%s %d valid records, %d of %d bytes are damaged at offset %d\n", err, records, info.Size()-valid, info.Size(), valid)
+	if !*aofCheckFix {
+		kingpin.Fatalf("AOF is damaged, run with --fix to truncate it.")
+	}
+	if err = aof.Repair(*aofCheckPath, valid); err != nil {
+		kingpin.Fatalf("Repair failed: %s", err)
+	}
+	fmt.Printf("AOF is truncated to %d bytes\n", valid)
+}
+
 func handleGetCommand() {
 	url := fmt.Sprintf("http://%s:%s/%s", *serverIP, *serverPort, *key)
 	handleHTTPResponse(httpclient.Get(url))
@@ -114,6 +174,40 @@ func handleKeysCommand() {
 	handleHTTPResponse(httpclient.Get(url))
 }
 
+// handleBatchKeysCommand sends mget or mdel command
+func handleBatchKeysCommand() {
+	url := fmt.Sprintf("http://%s:%s/_%s", *serverIP, *serverPort, *command)
+	body, err := json.Marshal(map[string][]string{"keys": strings.Split(*key, ",")})
+	if err != nil {
+		kingpin.Fatalf("Error occurred while marshing keys '%s': %+v", *key, err)
+	}
+	handleHTTPResponse(httpclient.PostJson(url, string(body)))
+}
+
+func handleMSetCommand() {
+	url := fmt.Sprintf("http://%s:%s/_mset", *serverIP, *serverPort)
+
+	keys := strings.Split(*key, ",")
+	var values []interface{}
+	err := json.Unmarshal([]byte(*value), &values)
+	if err != nil {
+		kingpin.Fatalf("Error occurred while unmarshing values '%s': %+v", *value, err)
+	}
+	if len(values) != len(keys) {
+		kingpin.Fatalf("Got %d keys but %d values.", len(keys), len(values))
+	}
+	items := make([]payload, len(keys))
+	for i, key := range keys {
+		items[i] = payload{Key: key, Value: values[i], TTL: *ttl}
+	}
+	body, err := json.Marshal(map[string][]payload{"items": items})
+	if err != nil {
+		kingpin.Fatalf("Error occurred while marshing payload %+v: %+v", items, err)
+	}
+
+	handleHTTPResponse(httpclient.PostJson(url, string(body)))
+}
+
 func handleHTTPResponse(res *httpclient.Response, err error) {
 	if err != nil {
 		kingpin.Fatalf("Error occurred while getting key '%s': %+v", *key, err)
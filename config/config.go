@@ -10,22 +10,28 @@ var (
 	version = "1.0.0"
 	app     = kingpin.New("cacher", "In-memory Redis-like cache.")
 
-	Interface = app.Flag("interface", "Either http or telnet interface enable.").
+	Interface = app.Flag("interface", "Interface to enable: http, telnet, resp (Redis protocol), memcached or grpc.").
 			Short('i').
 			Default("http").
-			HintOptions("http", "telnet").
+			HintOptions("http", "telnet", "resp", "memcached", "grpc").
 			String()
 
 	ServerIP   = app.Flag("server", "Server address.").Short('a').Default("127.0.0.1").IP()
 	ServerPort = app.Flag("port", "Server port.").Short('p').Default("1323").String()
 	AuthToken  = app.Flag("auth_token", "Bearer Authentication Token.").String()
 
+	MemcachedOpaque = app.Flag("memcached_opaque", "Store values received over memcached as opaque bytes instead of JSON strings.").
+			Bool()
+
 	// cache options
 	CacheType = app.Flag("cache_type", "Select cache implementation.").
 			Short('t').
 			Default("mutex-map").
-			HintOptions("mutex-map", "sync-map").
+			HintOptions("mutex-map", "sync-map", "sharded-map", "arena-map").
 			String()
+	Shards = app.Flag("shards", "Number of independently locked shards of sharded-map and arena-map.").
+		Default("32").
+		Int()
 	CDBEnabled = app.Flag("cdb", "Enable or disable save on disk using CDB.").
 			Default("true").
 			Bool()
@@ -35,6 +41,43 @@ var (
 	AOFEnabled = app.Flag("appendonly", "Enable or disable Append-only file.").
 			Default("true").
 			Bool()
+	AppendFsync = app.Flag("appendfsync", "When Append-only file is synced to disk: always, everysec or no (left to OS).").
+			Default("everysec").
+			HintOptions("always", "everysec", "no").
+			String()
+	AOFRewritePercentage = app.Flag("aof_rewrite_percentage", "Growth in percents of Append-only file since the last rewrite that triggers background rewrite. 0 disables it.").
+				Default("100").
+				Int()
+	AOFLoadTruncated = app.Flag("aof_load_truncated", "Truncate partially written or corrupted last record of Append-only file on start instead of refusing to start.").
+				Default("true").
+				Bool()
+	AOFRewriteMinSize = app.Flag("aof_rewrite_min_size", "Min size of Append-only file to be rewritten automatically.").Default("64MB").Bytes()
+
+	// active expiration options
+	ExpireInterval = app.Flag("expire_interval", "Period in milliseconds of active expiration cycle. 0 disables it.").
+			Default("100").
+			Int()
+	ExpireSample = app.Flag("expire_sample", "Number of keys with TTL checked per expiration step.").Default("20").Int()
+	ExpireBudget = app.Flag("expire_budget", "Max percent of expiration period that a single cycle may take.").Default("25").Int()
+
+	// memory limit options
+	MaxMemory      = app.Flag("max_memory", "Max size of stored values, e.g. 512MB. 0 means unlimited.").Default("0").Bytes()
+	MaxKeys        = app.Flag("max_keys", "Max number of stored keys. 0 means unlimited.").Default("0").Int()
+	EvictionPolicy = app.Flag("eviction_policy", "Policy of choosing keys to evict when memory limit is reached.").
+			Default("noeviction").
+			HintOptions("noeviction", "allkeys-lru", "allkeys-lfu", "volatile-lru", "volatile-ttl", "random").
+			String()
+	EvictionSample = app.Flag("eviction_sample", "Number of keys sampled to choose one for eviction.").Default("5").Int()
+
+	PubSubBuffer = app.Flag("pubsub_buffer", "Max number of undelivered messages of subscriber, slower subscribers are disconnected.").
+			Default("1024").
+			Int()
+
+	WatchHistory = app.Flag("watch_history", "Number of the latest keyspace events kept to resume watching from cursor, 0 disables history.").
+			Default("1024").
+			Int()
+
+	ScriptTimeout = app.Flag("script_timeout", "Max execution time of Lua script in milliseconds, script blocks writes to the storage while it runs.").Default("250").Int()
 )
 
 func init() {
@@ -49,6 +92,12 @@ func init() {
 
 	case "telnet":
 		// telnet
+	case "resp":
+		// Redis protocol, clients authenticate with AUTH command if auth_token is set
+	case "memcached":
+		// memcached text and binary protocols
+	case "grpc":
+		// clients pass auth_token in "authorization" metadata
 	default:
 		kingpin.Fatalf("Unknown Interface type: %s", *Interface)
 	}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"./cache"
+	"./config"
+	"./pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type grpcServer struct{}
+
+var grpcEventTypes = map[string]pb.Event_Type{
+	cache.EventSet:     pb.Event_SET,
+	cache.EventDelete:  pb.Event_DELETE,
+	cache.EventExpired: pb.Event_EXPIRED,
+	cache.EventEvicted: pb.Event_EVICTED,
+}
+
+func startGRPCServer() {
+	address := fmt.Sprintf("%s:%s", *config.ServerIP, *config.ServerPort)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Fatalf("Error while launching gRPC server: %s", err)
+	}
+	log.Printf("gRPC server launched: %s", address)
+	err = newGRPCServer().Serve(listener)
+	if err != nil {
+		log.Fatalf("Error while serving gRPC: %s", err)
+	}
+}
+
+func newGRPCServer() *grpc.Server {
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := grpcAuthorize(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := grpcAuthorize(stream.Context()); err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	)
+	pb.RegisterCacherServer(server, grpcServer{})
+	return server
+}
+
+// grpcAuthorize checks bearer token passed in "authorization" metadata, the same one as in HTTP interface
+func grpcAuthorize(ctx context.Context) error {
+	if *config.AuthToken == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if tokenMatches(value, "Bearer "+*config.AuthToken) {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid or missing auth token")
+}
+
+func (grpcServer) Get(ctx context.Context, request *pb.GetRequest) (*pb.Item, error) {
+	return grpcGet(request.Key)
+}
+
+func (grpcServer) Set(ctx context.Context, request *pb.SetRequest) (*pb.SetResponse, error) {
+	value, err := grpcDecodeValue(request)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.SetResponse{}, grpcSet(request.Key, value, request.Ttl)
+}
+
+func (grpcServer) Delete(ctx context.Context, request *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	err := cacheManager.Delete(request.Key)
+	if err != nil {
+		return nil, grpcCacheError(err)
+	}
+	return &pb.DeleteResponse{}, nil
+}
+
+func (grpcServer) Keys(ctx context.Context, request *pb.KeysRequest) (*pb.KeysResponse, error) {
+	keys, err := cacheManager.GetKeys()
+	if err != nil {
+		return nil, grpcCacheError(err)
+	}
+	return &pb.KeysResponse{Keys: keys}, nil
+}
+
+func (grpcServer) MGet(ctx context.Context, request *pb.MGetRequest) (*pb.MGetResponse, error) {
+	items, err := cacheManager.MGet(request.Keys)
+	if err != nil {
+		return nil, grpcCacheError(err)
+	}
+	response := &pb.MGetResponse{Items: make([]*pb.Item, 0, len(items))}
+	for _, item := range items {
+		message, err := grpcItem(item.Key, item.Value, item.ExpiredAt, item.Found)
+		if err != nil {
+			return nil, err
+		}
+		response.Items = append(response.Items, message)
+	}
+	return response, nil
+}
+
+// MSet validates all values before storing any of them, so bad request doesn't leave partial result
+func (grpcServer) MSet(ctx context.Context, request *pb.MSetRequest) (*pb.MSetResponse, error) {
+	items := make([]cache.Item, len(request.Items))
+	for i, item := range request.Items {
+		value, err := grpcDecodeValue(item)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = cache.Item{Key: item.Key, Value: value, TTL: item.Ttl}
+	}
+	if err := cacheManager.MSet(items); err != nil {
+		return nil, grpcCacheError(err)
+	}
+	return &pb.MSetResponse{}, nil
+}
+
+func (grpcServer) Watch(request *pb.WatchRequest, stream pb.Cacher_WatchServer) error {
+	events, cancel := cacheManager.Watch(request.Prefix)
+	defer cancel()
+	// header lets client know that changes are watched from now on
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "watcher is too slow, events were dropped")
+			}
+			message := &pb.Event{
+				Type:      grpcEventTypes[event.Type],
+				Key:       event.Key,
+				ExpiredAt: event.ExpiredAt,
+			}
+			if event.Type == cache.EventSet {
+				message.Value, _ = json.Marshal(event.Value)
+			}
+			if err := stream.Send(message); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func grpcGet(key string) (*pb.Item, error) {
+	value, expiredAt, found, err := cacheManager.Get(key)
+	if err != nil {
+		return nil, grpcCacheError(err)
+	}
+	return grpcItem(key, value, expiredAt, found)
+}
+
+func grpcItem(key string, value interface{}, expiredAt int64, found bool) (*pb.Item, error) {
+	item := &pb.Item{Key: key, Found: found}
+	if found {
+		var err error
+		item.ExpiredAt = expiredAt
+		item.Value, err = json.Marshal(value)
+		if err != nil {
+			return nil, grpcCacheError(err)
+		}
+	}
+	return item, nil
+}
+
+func grpcSet(key string, value interface{}, ttl int64) error {
+	err := cacheManager.Set(key, value, ttl)
+	if err != nil {
+		return grpcCacheError(err)
+	}
+	return nil
+}
+
+func grpcDecodeValue(request *pb.SetRequest) (interface{}, error) {
+	if request.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	var value interface{}
+	err := json.Unmarshal(request.Value, &value)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "value of key '%s' is not valid JSON: %s", request.Key, err)
+	}
+	return value, nil
+}
+
+func grpcCacheError(err error) error {
+	if _, ok := err.(cache.MemoryLimitError); ok {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"./cache"
+	"./config"
+	"./pb"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Port Cacher gRPC server listens on for testing
+const GRPCPort = "8084"
+
+var _ = Describe("grpc", func() {
+
+	var conn *grpc.ClientConn
+	var client pb.CacherClient
+	var ctx context.Context
+	var cancel context.CancelFunc
+
+	BeforeEach(func() {
+		cacheManager, _ = cache.New("mutex-map", log, false, 60, false)
+		var err error
+		conn, err = grpc.Dial("localhost:"+GRPCPort, grpc.WithInsecure(), grpc.WithPerRPCCredentials(pb.TokenAuth(authToken)))
+		Expect(err).NotTo(HaveOccurred())
+		client = pb.NewCacherClient(conn)
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	})
+
+	AfterEach(func() {
+		cancel()
+		conn.Close()
+	})
+
+	It("sets and gets values", func() {
+		_, err := client.Set(ctx, &pb.SetRequest{Key: "test", Value: []byte(`{"a":[1,2]}`), Ttl: 100})
+		Expect(err).NotTo(HaveOccurred())
+		value, _, _, _ := cacheManager.Get("test")
+		Ω(value).Should(Equal(map[string]interface{}{"a": []interface{}{float64(1), float64(2)}}))
+
+		item, err := client.Get(ctx, &pb.GetRequest{Key: "test"})
+		Expect(err).NotTo(HaveOccurred())
+		Ω(item.Found).Should(BeTrue())
+		Ω(item.Value).Should(MatchJSON(`{"a":[1,2]}`))
+		Ω(item.ExpiredAt).Should(BeNumerically("~", time.Now().Unix()+100, 1))
+
+		item, err = client.Get(ctx, &pb.GetRequest{Key: "missed"})
+		Expect(err).NotTo(HaveOccurred())
+		Ω(item.Found).Should(BeFalse())
+	})
+
+	It("rejects invalid values", func() {
+		_, err := client.Set(ctx, &pb.SetRequest{Key: "test", Value: []byte("wrong_json")})
+		Ω(status.Code(err)).Should(Equal(codes.InvalidArgument))
+	})
+
+	It("deletes and lists keys", func() {
+		cacheManager.Set("test", 1, 0)
+		cacheManager.Set("other", 2, 0)
+		_, err := client.Delete(ctx, &pb.DeleteRequest{Key: "test"})
+		Expect(err).NotTo(HaveOccurred())
+		keys, err := client.Keys(ctx, &pb.KeysRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		Ω(keys.Keys).Should(Equal([]string{"other"}))
+	})
+
+	It("supports batches", func() {
+		_, err := client.MSet(ctx, &pb.MSetRequest{Items: []*pb.SetRequest{
+			{Key: "one", Value: []byte("1")},
+			{Key: "two", Value: []byte(`"2"`)},
+		}})
+		Expect(err).NotTo(HaveOccurred())
+
+		response, err := client.MGet(ctx, &pb.MGetRequest{Keys: []string{"one", "missed", "two"}})
+		Expect(err).NotTo(HaveOccurred())
+		Ω(response.Items).Should(HaveLen(3))
+		Ω(string(response.Items[0].Value)).Should(Equal("1"))
+		Ω(response.Items[1].Found).Should(BeFalse())
+		Ω(string(response.Items[2].Value)).Should(Equal(`"2"`))
+
+		// nothing is stored if one of values is invalid
+		_, err = client.MSet(ctx, &pb.MSetRequest{Items: []*pb.SetRequest{
+			{Key: "three", Value: []byte("3")},
+			{Key: "four", Value: []byte("wrong_json")},
+		}})
+		Ω(status.Code(err)).Should(Equal(codes.InvalidArgument))
+		_, _, found, _ := cacheManager.Get("three")
+		Ω(found).Should(BeFalse())
+	})
+
+	It("streams key changes", func() {
+		stream, err := client.Watch(ctx, &pb.WatchRequest{Prefix: "user:"})
+		Expect(err).NotTo(HaveOccurred())
+		// header is sent once watcher is registered
+		_, err = stream.Header()
+		Expect(err).NotTo(HaveOccurred())
+
+		cacheManager.Set("other", 1, 0)
+		cacheManager.Set("user:1", "name", 0)
+		cacheManager.Delete("user:1")
+
+		event, err := stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+		Ω(event.Type).Should(Equal(pb.Event_SET))
+		Ω(event.Key).Should(Equal("user:1"))
+		Ω(string(event.Value)).Should(Equal(`"name"`))
+
+		event, err = stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+		Ω(event.Type).Should(Equal(pb.Event_DELETE))
+		Ω(event.Key).Should(Equal("user:1"))
+	})
+
+	It("checks auth token", func() {
+		*config.AuthToken = authToken
+		defer func() { *config.AuthToken = "" }()
+		_, err := client.Keys(ctx, &pb.KeysRequest{})
+		Expect(err).NotTo(HaveOccurred())
+
+		other, err := grpc.Dial("localhost:"+GRPCPort, grpc.WithInsecure(), grpc.WithPerRPCCredentials(pb.TokenAuth("wrong")))
+		Expect(err).NotTo(HaveOccurred())
+		defer other.Close()
+		_, err = pb.NewCacherClient(other).Keys(ctx, &pb.KeysRequest{})
+		Ω(status.Code(err)).Should(Equal(codes.Unauthenticated))
+	})
+})
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"time"
+
+	"./cache"
+	"github.com/labstack/echo"
+)
+
+type (
+	KeysPayload struct {
+		Keys []string `json:"keys"`
+	}
+
+	MSetPayload struct {
+		Items []Payload `json:"items"`
+	}
+
+	// BatchItem is a value returned by MGET, missed keys have only key
+	BatchItem struct {
+		Key       string      `json:"key"`
+		Found     bool        `json:"found"`
+		Value     interface{} `json:"value,omitempty"`
+		ExpiredAt string      `json:"expired_at,omitempty"`
+		Version   uint64      `json:"version,omitempty"`
+	}
+)
+
+// registerBatchRoutes adds multi-key routes and transactions, they're prefixed with "_" to not clash with keys
+func registerBatchRoutes(e *echo.Echo) {
+	e.POST("/_mget", batchGet)
+	e.POST("/_mset", batchSet)
+	e.POST("/_mdel", batchDelete)
+	e.POST("/_tx", execTransaction)
+}
+
+func batchGet(c echo.Context) error {
+	payload := new(KeysPayload)
+	if err := c.Bind(payload); err != nil {
+		return errorResponse(c, "Unprocessable request payload. Error: "+err.Error())
+	}
+	items, err := managerOf(c).MGet(payload.Keys)
+	if err != nil {
+		return structureResponse(c, nil, err)
+	}
+	values := make([]BatchItem, len(items))
+	for i, item := range items {
+		values[i] = BatchItem{Key: item.Key, Found: item.Found, Value: item.Value, Version: item.Version}
+		if item.ExpiredAt != 0 {
+			values[i].ExpiredAt = time.Unix(item.ExpiredAt, 0).Format("2006-01-02 15:04:05")
+		}
+	}
+	return structureResponse(c, values, nil)
+}
+
+func batchSet(c echo.Context) error {
+	payload := new(MSetPayload)
+	if err := c.Bind(payload); err != nil {
+		return errorResponse(c, "Unprocessable request payload. Error: "+err.Error())
+	}
+	items := make([]cache.Item, len(payload.Items))
+	for i, item := range payload.Items {
+		items[i] = cache.Item{Key: item.Key, Value: item.Value, TTL: item.TTL}
+	}
+	return structureResponse(c, nil, managerOf(c).MSet(items))
+}
+
+func batchDelete(c echo.Context) error {
+	payload := new(KeysPayload)
+	if err := c.Bind(payload); err != nil {
+		return errorResponse(c, "Unprocessable request payload. Error: "+err.Error())
+	}
+	deleted, err := managerOf(c).MDelete(payload.Keys)
+	return structureResponse(c, deleted, err)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"./cache"
+	"github.com/labstack/echo"
+)
+
+// eventsPollTimeout is default time long-poll waits for events
+const eventsPollTimeout = 30 * time.Second
+
+const slowWatcherMessage = "Watcher can't keep up with events, resume from the last cursor."
+
+type (
+	// KeyspaceEvent is a change of key, Value and ExpiredAt are set only for "set" events
+	KeyspaceEvent struct {
+		Cursor    uint64      `json:"cursor"`
+		Op        string      `json:"op"`
+		Key       string      `json:"key"`
+		Value     interface{} `json:"value,omitempty"`
+		ExpiredAt string      `json:"expired_at,omitempty"`
+		Timestamp string      `json:"timestamp"`
+	}
+
+	// EventsPage is a result of long-poll, Cursor should be passed to get the following events.
+	// Missed is true when some events after passed cursor aren't kept anymore.
+	EventsPage struct {
+		Cursor uint64          `json:"cursor"`
+		Events []KeyspaceEvent `json:"events"`
+		Missed bool            `json:"missed,omitempty"`
+	}
+)
+
+// registerEventRoutes adds keyspace events by long-poll and Server-Sent Events
+func registerEventRoutes(e *echo.Echo) {
+	e.GET("/_events", pollEvents)
+	e.GET("/_events/stream", streamEvents)
+}
+
+func keyspaceEvent(event cache.Event) KeyspaceEvent {
+	result := KeyspaceEvent{
+		Cursor:    event.Seq,
+		Op:        event.Type,
+		Key:       event.Key,
+		Value:     event.Value,
+		Timestamp: event.Timestamp.Format("2006-01-02 15:04:05.000"),
+	}
+	if event.ExpiredAt != 0 {
+		result.ExpiredAt = time.Unix(event.ExpiredAt, 0).Format("2006-01-02 15:04:05")
+	}
+	return result
+}
+
+// eventsCursor returns cursor passed by client, without it events are watched from now
+func eventsCursor(cm *cache.CacheManager, param string) (uint64, error) {
+	if param == "" {
+		return cm.EventCursor(), nil
+	}
+	cursor, err := strconv.ParseUint(param, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Cursor '%s' is not a number.", param)
+	}
+	return cursor, nil
+}
+
+// pollEvents returns events of keys matching pattern after cursor. If there are no such events yet,
+// it waits for them up to timeout query param in milliseconds.
+func pollEvents(c echo.Context) error {
+	cursor, err := eventsCursor(managerOf(c), c.QueryParam("cursor"))
+	if err != nil {
+		return errorResponse(c, err.Error())
+	}
+	timeout := eventsPollTimeout
+	if param := c.QueryParam("timeout"); param != "" {
+		ms, err := strconv.ParseUint(param, 10, 32)
+		if err != nil {
+			return errorResponse(c, fmt.Sprintf("Timeout '%s' is not a number.", param))
+		}
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+
+	if err := cache.ValidatePattern(c.QueryParam("pattern")); err != nil {
+		return errorResponse(c, err.Error())
+	}
+	backlog, events, cancel, missed := managerOf(c).WatchFrom(c.QueryParam("pattern"), cursor)
+	defer cancel()
+	if len(backlog) == 0 && !missed {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-timer.C:
+		case event, ok := <-events:
+			if ok {
+				backlog = append(backlog, event)
+			} else {
+				missed = true
+			}
+		}
+	}
+	// take events which already came along with the first one
+	for collecting := true; collecting; {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				missed, collecting = true, false
+				break
+			}
+			backlog = append(backlog, event)
+		default:
+			collecting = false
+		}
+	}
+
+	page := EventsPage{Cursor: cursor, Events: make([]KeyspaceEvent, len(backlog)), Missed: missed}
+	for i, event := range backlog {
+		page.Events[i] = keyspaceEvent(event)
+	}
+	if len(backlog) > 0 {
+		page.Cursor = backlog[len(backlog)-1].Seq
+	} else if missed {
+		page.Cursor = managerOf(c).EventCursor()
+	}
+	return structureResponse(c, page, nil)
+}
+
+// streamEvents streams events of keys matching pattern as Server-Sent Events with cursor as event id,
+// so reconnected client resumes from Last-Event-ID. Slow watcher gets "error" event and is disconnected.
+func streamEvents(c echo.Context) error {
+	param := c.Request().Header.Get("Last-Event-ID")
+	if param == "" {
+		param = c.QueryParam("cursor")
+	}
+	cursor, err := eventsCursor(managerOf(c), param)
+	if err != nil {
+		return errorResponse(c, err.Error())
+	}
+	if err := cache.ValidatePattern(c.QueryParam("pattern")); err != nil {
+		return errorResponse(c, err.Error())
+	}
+	backlog, events, cancel, missed := managerOf(c).WatchFrom(c.QueryParam("pattern"), cursor)
+	defer cancel()
+
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	response.WriteHeader(http.StatusOK)
+	if missed {
+		fmt.Fprint(response, "event: missed\ndata: {}\n\n")
+	}
+	for _, event := range backlog {
+		writeEventSSE(response, event)
+	}
+	response.Flush()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				fmt.Fprintf(response, "event: error\ndata: %s\n\n", slowWatcherMessage)
+				response.Flush()
+				return nil
+			}
+			writeEventSSE(response, event)
+			response.Flush()
+		}
+	}
+}
+
+func writeEventSSE(response *echo.Response, event cache.Event) {
+	data, _ := json.Marshal(keyspaceEvent(event))
+	fmt.Fprintf(response, "id: %d\nevent: keyspace\ndata: %s\n\n", event.Seq, data)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"./cache"
+	"./config"
+	"./pubsub"
+	"github.com/labstack/echo"
+)
+
+// namespaceHeader selects namespace of request, path prefix /_ns/<namespace>/ sets it too
+const namespaceHeader = "X-Cacher-Namespace"
+
+const (
+	namespaceContextKey = "namespace"
+	adminContextKey     = "admin"
+)
+
+// registerNamespaceRoutes adds admin routes of namespaces, they require auth_token of server
+func registerNamespaceRoutes(e *echo.Echo) {
+	e.GET("/_namespaces", getNamespaces)
+	e.POST("/_namespaces", postNamespace)
+	e.POST("/_namespaces/:name/flush", flushNamespace)
+}
+
+// rewriteNamespacePath turns /_ns/<namespace>/<path> into /<path> with namespace header, it runs before routing
+func rewriteNamespacePath(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		request := c.Request()
+		if strings.HasPrefix(request.URL.Path, "/_ns/") {
+			parts := strings.SplitN(strings.TrimPrefix(request.URL.Path, "/_ns/"), "/", 2)
+			request.Header.Set(namespaceHeader, parts[0])
+			request.URL.Path = "/"
+			if len(parts) == 2 {
+				request.URL.Path += parts[1]
+			}
+			request.URL.RawPath = ""
+		}
+		return next(c)
+	}
+}
+
+// authorizeKey accepts auth_token of server for all namespaces and admin routes, token of namespace only for it.
+// Empty auth_token disables auth of the default namespace like in RESP and gRPC.
+func authorizeKey(key string, c echo.Context) (bool, error) {
+	if *config.AuthToken == "" || tokenMatches(key, *config.AuthToken) {
+		c.Set(adminContextKey, true)
+	}
+	ns, found := lookupNamespace(c.Request().Header.Get(namespaceHeader))
+	if found && ns != nil && ns.config.AuthToken != "" {
+		return namespaceTokenMatches(ns, key), nil
+	}
+	return c.Get(adminContextKey) == true, nil
+}
+
+// selectNamespace keeps cache manager of selected namespace in context, unknown namespace is rejected
+func selectNamespace(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name := c.Request().Header.Get(namespaceHeader)
+		ns, found := lookupNamespace(name)
+		if !found {
+			return errorResponseWithStatus(c, http.StatusNotFound, fmt.Sprintf("Namespace '%s' not found.", name))
+		}
+		c.Set(namespaceContextKey, managerOfNamespace(ns))
+		return next(c)
+	}
+}
+
+// managerOf returns cache manager of namespace selected by request
+func managerOf(c echo.Context) *cache.CacheManager {
+	if manager, ok := c.Get(namespaceContextKey).(*cache.CacheManager); ok {
+		return manager
+	}
+	return cacheManager
+}
+
+// pubSubOf returns pub/sub hub of namespace selected by request
+func pubSubOf(c echo.Context) *pubsub.Hub {
+	ns, _ := lookupNamespace(c.Request().Header.Get(namespaceHeader))
+	return pubSubOfNamespace(ns)
+}
+
+func requireAdmin(c echo.Context) error {
+	if c.Get(adminContextKey) != true {
+		return errorResponseWithStatus(c, http.StatusForbidden, "Namespaces are managed only with auth_token of server.")
+	}
+	return nil
+}
+
+func getNamespaces(c echo.Context) error {
+	if err := requireAdmin(c); err != nil {
+		return err
+	}
+	return structureResponse(c, listNamespaces(), nil)
+}
+
+func postNamespace(c echo.Context) error {
+	if err := requireAdmin(c); err != nil {
+		return err
+	}
+	payload := new(NamespaceConfig)
+	if err := c.Bind(payload); err != nil {
+		return errorResponse(c, "Unprocessable request payload. Error: "+err.Error())
+	}
+	err := createNamespace(*payload)
+	if _, ok := err.(NamespaceExistsError); ok {
+		return errorResponseWithStatus(c, http.StatusConflict, err.Error())
+	}
+	return structureResponse(c, nil, err)
+}
+
+// flushNamespace removes all keys of namespace and returns their number
+func flushNamespace(c echo.Context) error {
+	if err := requireAdmin(c); err != nil {
+		return err
+	}
+	name := c.Param("name")
+	ns, found := lookupNamespace(name)
+	if !found {
+		return errorResponseWithStatus(c, http.StatusNotFound, fmt.Sprintf("Namespace '%s' not found.", name))
+	}
+	flushed, err := managerOfNamespace(ns).Flush()
+	return structureResponse(c, flushed, err)
+}
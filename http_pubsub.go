@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo"
+)
+
+type (
+	PublishPayload struct {
+		Channel string `json:"channel"`
+		Message string `json:"message"`
+	}
+
+	// PubSubMessage is data of Server-Sent Event, Pattern is set for messages delivered by pattern subscription
+	PubSubMessage struct {
+		Pattern string `json:"pattern,omitempty"`
+		Channel string `json:"channel"`
+		Message string `json:"message"`
+	}
+)
+
+// registerPubSubRoutes adds publishing and subscribing by Server-Sent Events
+func registerPubSubRoutes(e *echo.Echo) {
+	e.POST("/_publish", publishMessage)
+	e.GET("/_subscribe", subscribeChannels)
+}
+
+// publishMessage returns number of subscribers which got message
+func publishMessage(c echo.Context) error {
+	payload := new(PublishPayload)
+	if err := c.Bind(payload); err != nil {
+		return errorResponse(c, "Unprocessable request payload. Error: "+err.Error())
+	}
+	if payload.Channel == "" {
+		return errorResponse(c, "Payload requires 'channel'.")
+	}
+	return structureResponse(c, pubSubOf(c).Publish(payload.Channel, payload.Message), nil)
+}
+
+// subscribeChannels streams messages of channel and pattern query params as Server-Sent Events until client
+// disconnects. Slow subscriber gets "error" event and is disconnected.
+func subscribeChannels(c echo.Context) error {
+	channels := c.QueryParams()["channel"]
+	patterns := c.QueryParams()["pattern"]
+	if len(channels)+len(patterns) == 0 {
+		return errorResponse(c, "At least one 'channel' or 'pattern' query param is required.")
+	}
+	subscriber := pubSubOf(c).Subscriber()
+	defer subscriber.Close()
+	if _, err := subscriber.PSubscribe(patterns...); err != nil {
+		return errorResponse(c, err.Error())
+	}
+	subscriber.Subscribe(channels...)
+
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	response.WriteHeader(http.StatusOK)
+	response.Flush()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case message, ok := <-subscriber.Messages():
+			if !ok {
+				fmt.Fprintf(response, "event: error\ndata: %s\n\n", slowSubscriberMessage)
+				response.Flush()
+				return nil
+			}
+			data, _ := json.Marshal(PubSubMessage{Pattern: message.Pattern, Channel: message.Channel, Message: message.Payload})
+			fmt.Fprintf(response, "event: message\ndata: %s\n\n", data)
+			response.Flush()
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"./cache"
+	"./cache/raw"
+	"github.com/labstack/echo"
+)
+
+// registerRawRoutes adds PUT of values with content type, GET /:key returns them as is
+func registerRawRoutes(e *echo.Echo) {
+	e.PUT("/:key", putValue)
+}
+
+// putValue stores request body as is with its Content-Type, binary data is stored without base64 wrapping.
+// Query params ttl, nx, xx and keep_ttl work like fields of POST payload, If-Match header makes it conditional.
+// Body of application/json content type has to be valid JSON, it's stored as a regular JSON value.
+func putValue(c echo.Context) error {
+	key := c.Param("key")
+	data, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return errorResponse(c, "Unprocessable request body. Error: "+err.Error())
+	}
+	contentType := c.Request().Header.Get(echo.HeaderContentType)
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && mediaType == raw.JSONType {
+		contentType = raw.JSONType
+	}
+
+	var ttl int64
+	if param := c.QueryParam("ttl"); param != "" {
+		if ttl, err = strconv.ParseInt(param, 10, 64); err != nil {
+			return errorResponse(c, fmt.Sprintf("TTL '%s' is not a number.", param))
+		}
+	}
+	var options cache.SetOptions
+	for name, option := range map[string]*bool{"nx": &options.NX, "xx": &options.XX, "keep_ttl": &options.KeepTTL} {
+		if param := c.QueryParam(name); param != "" {
+			if *option, err = strconv.ParseBool(param); err != nil {
+				return errorResponse(c, fmt.Sprintf("Option '%s' is not a boolean.", name))
+			}
+		}
+	}
+	if options.NX && options.XX {
+		return errorResponse(c, "Options 'nx' and 'xx' can't be used together.")
+	}
+	return setValueResponse(c, key, raw.Typed{ContentType: contentType, Data: data}, ttl, options)
+}
+
+// acceptsEnvelope tells whether client asked for JSON response explicitly, so value is wrapped in it
+func acceptsEnvelope(c echo.Context) bool {
+	return strings.Contains(c.Request().Header.Get(echo.HeaderAccept), raw.JSONType)
+}
+
+// rawValueResponse writes value as is with its content type, TTL is passed in Cache-Control and Expires headers
+func rawValueResponse(c echo.Context, value raw.Typed, expiredAt int64) error {
+	if expiredAt != 0 {
+		maxAge := expiredAt - time.Now().Unix()
+		if maxAge < 0 {
+			maxAge = 0
+		}
+		c.Response().Header().Set("Cache-Control", "max-age="+strconv.FormatInt(maxAge, 10))
+		c.Response().Header().Set("Expires", time.Unix(expiredAt, 0).UTC().Format(http.TimeFormat))
+	}
+	return c.Blob(http.StatusOK, value.ContentType, value.Data)
+}
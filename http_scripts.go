@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"./cache"
+	"github.com/labstack/echo"
+)
+
+type (
+	// ScriptPayload runs script passed as source or cached one by its SHA1, timeout is in milliseconds
+	ScriptPayload struct {
+		Script  string        `json:"script"`
+		SHA     string        `json:"sha"`
+		Keys    []string      `json:"keys"`
+		Args    []interface{} `json:"args"`
+		Timeout int64         `json:"timeout"`
+	}
+)
+
+// registerScriptRoutes adds EVAL, EVALSHA and SCRIPT LOAD routes
+func registerScriptRoutes(e *echo.Echo) {
+	e.POST("/_eval", evalScript)
+	e.POST("/_evalsha", evalScript)
+	e.POST("/_script/load", loadScript)
+}
+
+func evalScript(c echo.Context) error {
+	payload := new(ScriptPayload)
+	if err := c.Bind(payload); err != nil {
+		return errorResponse(c, "Unprocessable request payload. Error: "+err.Error())
+	}
+	timeout := time.Duration(payload.Timeout) * time.Millisecond
+	var result interface{}
+	var err error
+	if c.Path() == "/_evalsha" {
+		result, err = managerOf(c).EvalSHA(payload.SHA, payload.Keys, payload.Args, timeout)
+	} else {
+		result, err = managerOf(c).Eval(payload.Script, payload.Keys, payload.Args, timeout)
+	}
+	if _, ok := err.(cache.ScriptNotFoundError); ok {
+		return errorResponseWithStatus(c, http.StatusNotFound, err.Error())
+	}
+	return structureResponse(c, result, err)
+}
+
+// loadScript caches script and returns its SHA1
+func loadScript(c echo.Context) error {
+	payload := new(ScriptPayload)
+	if err := c.Bind(payload); err != nil {
+		return errorResponse(c, "Unprocessable request payload. Error: "+err.Error())
+	}
+	sha, err := managerOf(c).ScriptLoad(payload.Script)
+	return structureResponse(c, sha, err)
+}
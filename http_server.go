@@ -1,10 +1,15 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"./cache"
+	"./cache/raw"
 	"./config"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
@@ -15,12 +20,33 @@ type (
 		Key   string      `json:"key" form:"key" query:"key"`
 		Value interface{} `json:"value" form:"value" query:"value"`
 		TTL   int64       `json:"ttl" form:"ttl" query:"ttl"`
+		// conditional set options, see cache.SetOptions
+		NX      bool `json:"nx" form:"nx" query:"nx"`
+		XX      bool `json:"xx" form:"xx" query:"xx"`
+		Get     bool `json:"get" form:"get" query:"get"`
+		KeepTTL bool `json:"keep_ttl" form:"keep_ttl" query:"keep_ttl"`
+		// ContentType declares type of string value, e.g. "text/plain", it's stored as is instead of JSON
+		ContentType string `json:"content_type" form:"content_type" query:"content_type"`
+	}
+
+	// CounterPayload is increment of a number, delta with fraction (e.g. 0.5) makes float increment.
+	// Missed delta means 1 and missed ttl keeps TTL of the key.
+	CounterPayload struct {
+		Delta json.Number `json:"delta" form:"delta" query:"delta"`
+		TTL   int64       `json:"ttl" form:"ttl" query:"ttl"`
+	}
+
+	// ScanPage is a page of keys, Cursor should be passed to get the next page, "0" means scan is over
+	ScanPage struct {
+		Cursor string   `json:"cursor"`
+		Keys   []string `json:"keys"`
 	}
 
 	Response struct {
 		Status       string      `json:"status"`
 		Value        interface{} `json:"value,omitempty"`
 		ExpiredAt    string      `json:"expired_at,omitempty"`
+		ContentType  string      `json:"content_type,omitempty"`
 		ErrorMessage string      `json:"error_message,omitempty"`
 	}
 )
@@ -31,16 +57,25 @@ func startHTTPServer() {
 		Output: log.Writer(),
 	}))
 	e.Use(middleware.Recover())
-	e.Use(middleware.KeyAuth(func(key string, c echo.Context) (bool, error) {
-		return key == *config.AuthToken, nil
-	}))
+	e.Pre(rewriteNamespacePath)
+	e.Use(middleware.KeyAuth(authorizeKey))
+	e.Use(selectNamespace)
 
 	// Routes
 	e.GET("/", healthCheck)
 	e.GET("/:key", getValue)
 	e.POST("/", setValue)
+	e.POST("/:key/incr", incrValue)
 	e.DELETE("/:key", deleteValue)
 	e.GET("/keys", getAllKeys)
+	e.GET("/_scan", scanKeys)
+	registerRawRoutes(e)
+	registerStructureRoutes(e)
+	registerBatchRoutes(e)
+	registerScriptRoutes(e)
+	registerPubSubRoutes(e)
+	registerEventRoutes(e)
+	registerNamespaceRoutes(e)
 
 	// Start server
 	address := fmt.Sprintf("%s:%s", *config.ServerIP, *config.ServerPort)
@@ -51,8 +86,12 @@ func healthCheck(c echo.Context) error {
 	return successResponse(c, "")
 }
 
+// getAllKeys returns keys matching glob "match" query param, all keys by default
 func getAllKeys(c echo.Context) error {
-	keys, err := cacheManager.GetKeys()
+	keys, err := managerOf(c).Keys(c.QueryParam("match"))
+	if _, ok := err.(cache.InvalidPatternError); ok {
+		return errorResponse(c, err.Error())
+	}
 	if err != nil {
 		return errorResponse(c, "Error occured while collecting cache keys.")
 	}
@@ -63,9 +102,25 @@ func getAllKeys(c echo.Context) error {
 	})
 }
 
+// scanKeys returns a page of keys matching "match" query param after "cursor", "count" limits size of page
+func scanKeys(c echo.Context) error {
+	count := 0
+	if param := c.QueryParam("count"); param != "" {
+		var err error
+		if count, err = strconv.Atoi(param); err != nil || count <= 0 {
+			return errorResponse(c, fmt.Sprintf("Count '%s' is not a positive number.", param))
+		}
+	}
+	keys, cursor, err := managerOf(c).Scan(c.QueryParam("cursor"), c.QueryParam("match"), count)
+	if err != nil {
+		return errorResponse(c, err.Error())
+	}
+	return structureResponse(c, ScanPage{Cursor: cursor, Keys: keys}, nil)
+}
+
 func getValue(c echo.Context) error {
 	key := c.Param("key")
-	value, expiredAt, found, err := cacheManager.Get(key)
+	value, expiredAt, version, found, err := managerOf(c).GetRaw(key)
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error occured while Get value '%s' from cache.", key)
 		return errorResponse(c, errorMessage)
@@ -76,9 +131,14 @@ func getValue(c echo.Context) error {
 		return errorResponse(c, errorMessage)
 	}
 
+	c.Response().Header().Set("ETag", formatETag(version))
+	if value.ContentType != raw.JSONType && !acceptsEnvelope(c) {
+		return rawValueResponse(c, value, expiredAt)
+	}
+
 	response := Response{Status: "ok"}
-	if value != "" {
-		response.Value = value
+	if string(value.Data) != `""` {
+		response.Value, response.ContentType = rawResultValue(value)
 		if expiredAt != 0 {
 			response.ExpiredAt = time.Unix(expiredAt, 0).Format("2006-01-02 15:04:05")
 		}
@@ -94,18 +154,103 @@ func setValue(c echo.Context) error {
 		return errorResponse(c, "Unprocessable request payload. Error: "+err.Error())
 	}
 
-	error := cacheManager.Set(payload.Key, payload.Value, payload.TTL)
+	options := cache.SetOptions{NX: payload.NX, XX: payload.XX, Get: payload.Get, KeepTTL: payload.KeepTTL}
+	if options.NX && options.XX {
+		return errorResponse(c, "Options 'nx' and 'xx' can't be used together.")
+	}
+
+	value := payload.Value
+	if payload.ContentType != "" {
+		text, ok := payload.Value.(string)
+		if !ok {
+			return errorResponse(c, "Value with content type has to be a string.")
+		}
+		value = raw.Typed{ContentType: payload.ContentType, Data: []byte(text)}
+	}
+	return setValueResponse(c, payload.Key, value, payload.TTL, options)
+}
+
+// setValueResponse sets value with options or, if If-Match header is passed, only if key has that version
+func setValueResponse(c echo.Context, key string, value interface{}, ttl int64, options cache.SetOptions) error {
+	var version uint64
+	var previous interface{}
+	var error error
+	if ifMatch := c.Request().Header.Get("If-Match"); ifMatch != "" {
+		// conditional set, ETag of missed key is "0"
+		if options != (cache.SetOptions{}) {
+			return errorResponse(c, "If-Match header can't be used together with set options.")
+		}
+		expected, err := parseETag(ifMatch)
+		if err != nil {
+			return errorResponse(c, fmt.Sprintf("If-Match header '%s' is not a version.", ifMatch))
+		}
+		version, error = managerOf(c).CompareAndSet(key, value, ttl, expected)
+	} else {
+		version, previous, error = managerOf(c).SetWithOptions(key, value, ttl, options)
+	}
+	if _, ok := error.(cache.MemoryLimitError); ok {
+		return errorResponseWithStatus(c, http.StatusInsufficientStorage, error.Error())
+	}
+	if _, ok := error.(cache.VersionConflictError); ok {
+		return errorResponseWithStatus(c, http.StatusPreconditionFailed, error.Error())
+	}
 	if error != nil {
-		errorMessage := fmt.Sprintf("Error occured while adding new key/value pair: %s - %s", payload.Key, payload.Value)
+		errorMessage := fmt.Sprintf("Error occured while adding new key/value pair: %s - %s", key, value)
 		return errorResponse(c, errorMessage)
 	}
+	if version == 0 {
+		// previous value is returned even if new one wasn't stored
+		return c.JSON(http.StatusPreconditionFailed, Response{
+			Status:       "error",
+			Value:        previous,
+			ErrorMessage: setConditionMessage(key, options),
+		})
+	}
 
-	return successResponse(c, "")
+	c.Response().Header().Set("ETag", formatETag(version))
+	return c.JSON(http.StatusOK, Response{Status: "ok", Value: previous})
+}
+
+// setConditionMessage explains why value wasn't stored by SetWithOptions
+func setConditionMessage(key string, options cache.SetOptions) string {
+	if options.NX {
+		return fmt.Sprintf("Key '%s' already exists.", key)
+	}
+	return fmt.Sprintf("Key '%s' not found in cache.", key)
+}
+
+// formatETag returns version as strong entity tag
+func formatETag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+func parseETag(etag string) (uint64, error) {
+	return strconv.ParseUint(strings.Trim(etag, `"`), 10, 64)
+}
+
+func incrValue(c echo.Context) error {
+	payload := &CounterPayload{Delta: "1"}
+	if c.Request().ContentLength != 0 {
+		if err := c.Bind(payload); err != nil {
+			return errorResponse(c, "Unprocessable request payload. Error: "+err.Error())
+		}
+	}
+
+	var value interface{}
+	var err error
+	if delta, parseErr := payload.Delta.Int64(); parseErr == nil {
+		value, err = managerOf(c).Incr(c.Param("key"), delta, payload.TTL)
+	} else if delta, parseErr := payload.Delta.Float64(); parseErr == nil {
+		value, err = managerOf(c).IncrFloat(c.Param("key"), delta, payload.TTL)
+	} else {
+		return errorResponse(c, fmt.Sprintf("Delta '%s' is not a number.", payload.Delta))
+	}
+	return structureResponse(c, value, err)
 }
 
 func deleteValue(c echo.Context) error {
 	key := c.Param("key")
-	error := cacheManager.Delete(key)
+	error := managerOf(c).Delete(key)
 	if error != nil {
 		log.Printf("Error occured while deleting key: %s", key)
 	}
@@ -121,8 +266,12 @@ func successResponse(c echo.Context, value string) error {
 }
 
 func errorResponse(c echo.Context, message string) error {
+	return errorResponseWithStatus(c, http.StatusBadRequest, message)
+}
+
+func errorResponseWithStatus(c echo.Context, status int, message string) error {
 	log.Println(message)
-	return c.JSON(http.StatusBadRequest, Response{
+	return c.JSON(status, Response{
 		Status:       "error",
 		ErrorMessage: message,
 	})
@@ -1,17 +1,25 @@
 package main
 
 import (
+	"bufio"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	l "log"
+	"net"
 	"net/http"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"./cache"
+	"./cache/raw"
+	"./pubsub"
 	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -28,9 +36,26 @@ var authToken = "token"
 // Run once for all tests
 var _ = BeforeSuite(func() {
 	prepareLogger()
+	pubSub = pubsub.New(16)
 	l.SetOutput(os.Stdout)
 	server := httpServer()
-	go server.Start("localhost:" + Port)
+	// listen before starting to serve, so requests don't race with server start
+	httpListener, err := net.Listen("tcp", "localhost:"+Port)
+	Expect(err).NotTo(HaveOccurred())
+	server.Listener = httpListener
+	go server.Start("")
+
+	listener, err := net.Listen("tcp", "localhost:"+RESPPort)
+	Expect(err).NotTo(HaveOccurred())
+	go acceptRESP(listener)
+
+	listener, err = net.Listen("tcp", "localhost:"+MemcachedPort)
+	Expect(err).NotTo(HaveOccurred())
+	go acceptMemcached(listener)
+
+	listener, err = net.Listen("tcp", "localhost:"+GRPCPort)
+	Expect(err).NotTo(HaveOccurred())
+	go newGRPCServer().Serve(listener)
 })
 
 func TestHTTPServer(t *testing.T) {
@@ -122,6 +147,47 @@ var _ = Describe("keys", func() {
 		})
 	})
 
+	Describe("scanning keys", func() {
+		BeforeEach(func() {
+			for _, key := range []string{"user:3", "user:1", "other", "user:2"} {
+				cacheManager.Set(key, 1, 0)
+			}
+		})
+
+		It("lists keys matching pattern", func() {
+			response, err = client.Get("/keys?match=user:*")
+			Expect(err).NotTo(HaveOccurred())
+			var result struct {
+				Value []string `json:"value"`
+			}
+			Expect(json.Unmarshal([]byte(response.Body), &result)).To(Succeed())
+			Ω(result.Value).Should(ConsistOf("user:1", "user:2", "user:3"))
+		})
+
+		It("returns pages of keys by cursor", func() {
+			var page struct {
+				Value ScanPage `json:"value"`
+			}
+			response, err = client.Get("/_scan?match=user:*&count=2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(json.Unmarshal([]byte(response.Body), &page)).To(Succeed())
+			// count limits examined keys, so "other" takes a place in the first page
+			Ω(page.Value.Keys).Should(Equal([]string{"user:1"}))
+			Ω(page.Value.Cursor).ShouldNot(Equal("0"))
+
+			response, err = client.Get("/_scan?match=user:*&count=2&cursor=" + page.Value.Cursor)
+			Expect(json.Unmarshal([]byte(response.Body), &page)).To(Succeed())
+			Ω(page.Value.Keys).Should(Equal([]string{"user:2", "user:3"}))
+			Ω(page.Value.Cursor).Should(Equal("0"))
+		})
+
+		It("rejects invalid cursor", func() {
+			response, err = client.Get("/_scan?cursor=!")
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Status).Should(Equal(400))
+		})
+	})
+
 	Describe("getting key", func() {
 		BeforeEach(func() {
 			cacheManager.Set("test", 3, 0)
@@ -197,6 +263,32 @@ var _ = Describe("keys", func() {
 		})
 	})
 
+	Describe("setting key over memory limit", func() {
+		BeforeEach(func() {
+			cacheManager.Set("test", 3, 0)
+			cacheManager.SetMemoryLimit(0, 1, "noeviction", 5)
+			response, err = client.Post("/", "{\"key\":\"test_string\",\"value\":4,\"ttl\":0}")
+		})
+
+		It("no error occured", func() {
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns 507 status code", func() {
+			Ω(response.Status).Should(Equal(507))
+		})
+
+		It("return error message", func() {
+			r := Response{
+				Status:       "error",
+				ErrorMessage: "OOM command not allowed when used memory is over the limit (eviction policy 'noeviction').",
+			}
+			expected, err := json.Marshal(r)
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Body).Should(MatchJSON(expected))
+		})
+	})
+
 	Describe("setting unprocessable data", func() {
 		BeforeEach(func() {
 			response, err = client.Post("/", "wrong_json")
@@ -207,6 +299,464 @@ var _ = Describe("keys", func() {
 		})
 	})
 
+	Describe("setting key with options", func() {
+		BeforeEach(func() {
+			cacheManager.Set("test", 3, 3600)
+		})
+
+		It("doesn't overwrite existing key with nx", func() {
+			response, err = client.Post("/", `{"key":"test","value":4,"nx":true,"get":true}`)
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Status).Should(Equal(412))
+			Ω(response.Body).Should(MatchJSON(`{"status":"error","value":3,"error_message":"Key 'test' already exists."}`))
+		})
+
+		It("doesn't create missed key with xx", func() {
+			response, err = client.Post("/", `{"key":"missed","value":4,"xx":true}`)
+			Ω(response.Status).Should(Equal(412))
+			_, _, found, _ := cacheManager.Get("missed")
+			Expect(found).To(BeFalse())
+		})
+
+		It("returns previous value and keeps TTL", func() {
+			response, err = client.Post("/", `{"key":"test","value":4,"xx":true,"get":true,"keep_ttl":true}`)
+			Ω(response.Status).Should(Equal(200))
+			Ω(response.Body).Should(MatchJSON(`{"status":"ok","value":3}`))
+			value, expiredAt, _, _ := cacheManager.Get("test")
+			Ω(value).Should(Equal(float64(4)))
+			Ω(expiredAt).Should(BeNumerically("~", time.Now().Unix()+3600, 1))
+		})
+	})
+
+	Describe("setting key with content type", func() {
+		It("stores string as is and returns it with content type", func() {
+			response, err = client.Post("/", `{"key":"test","value":"<b>hi</b>","content_type":"text/html"}`)
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Status).Should(Equal(200))
+			value, _, _, _ := cacheManager.Get("test")
+			Ω(value).Should(Equal(raw.Typed{ContentType: "text/html", Data: []byte("<b>hi</b>")}))
+
+			response, err = client.GetWithHeaders("/test", map[string]string{"Accept": "application/json"})
+			Expect(err).NotTo(HaveOccurred())
+			encoded := base64.StdEncoding.EncodeToString([]byte("<b>hi</b>"))
+			Ω(response.Body).Should(MatchJSON(`{"status":"ok","value":"` + encoded + `","content_type":"text/html"}`))
+		})
+
+		It("returns JSON value as it was stored", func() {
+			cacheManager.Set("test", map[string]interface{}{"list": []int{1, 2}}, 0)
+			response, err = client.Get("/test")
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Body).Should(MatchJSON(`{"status":"ok","value":{"list":[1,2]}}`))
+		})
+
+		It("rejects value which is not a string", func() {
+			response, err = client.Post("/", `{"key":"test","value":1,"content_type":"text/plain"}`)
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Status).Should(Equal(400))
+		})
+	})
+
+	Describe("putting raw value", func() {
+		It("stores body with content type and returns it as is", func() {
+			body := "\x89PNG\x00\xff"
+			response, err = client.PutWithHeaders("/image?ttl=60", body, map[string]string{"Content-Type": "image/png"})
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Status).Should(Equal(200))
+			Ω(response.Headers.Get("ETag")).ShouldNot(BeEmpty())
+
+			response, err = client.Get("/image")
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Status).Should(Equal(200))
+			Ω(response.Body).Should(Equal(body))
+			Ω(response.Headers.Get("Content-Type")).Should(Equal("image/png"))
+			Ω(response.Headers.Get("Cache-Control")).Should(MatchRegexp(`^max-age=(59|60)$`))
+			expires, err := http.ParseTime(response.Headers.Get("Expires"))
+			Expect(err).NotTo(HaveOccurred())
+			Ω(expires.Unix()).Should(BeNumerically("~", time.Now().Unix()+60, 1))
+		})
+
+		It("stores body without content type as binary", func() {
+			response, err = client.PutWithHeaders("/blob", "data", map[string]string{"Content-Type": ""})
+			Ω(response.Status).Should(Equal(200))
+			value, _, _, _ := cacheManager.Get("blob")
+			Ω(value).Should(Equal(raw.Bytes("data")))
+
+			response, err = client.Get("/blob")
+			Ω(response.Body).Should(Equal("data"))
+			Ω(response.Headers.Get("Content-Type")).Should(Equal("application/octet-stream"))
+			Ω(response.Headers.Get("Cache-Control")).Should(BeEmpty())
+		})
+
+		It("stores JSON body as JSON value", func() {
+			response, err = client.PutWithHeaders("/json", `{"a":[1,2]}`, map[string]string{"Content-Type": "application/json; charset=utf-8"})
+			Ω(response.Status).Should(Equal(200))
+			response, err = client.Get("/json")
+			Ω(response.Body).Should(MatchJSON(`{"status":"ok","value":{"a":[1,2]}}`))
+
+			response, err = client.PutWithHeaders("/json", `{"a":`, map[string]string{"Content-Type": "application/json"})
+			Ω(response.Status).Should(Equal(400))
+		})
+
+		It("supports set options and If-Match", func() {
+			cacheManager.Set("text", "value", 0)
+			response, err = client.PutWithHeaders("/text?nx=true", "other", map[string]string{"Content-Type": "text/plain"})
+			Ω(response.Status).Should(Equal(412))
+			response, err = client.PutWithHeaders("/text", "other", map[string]string{"Content-Type": "text/plain", "If-Match": `"999"`})
+			Ω(response.Status).Should(Equal(412))
+			response, err = client.PutWithHeaders("/text?ttl=x", "other", map[string]string{"Content-Type": "text/plain"})
+			Ω(response.Status).Should(Equal(400))
+		})
+	})
+
+	Describe("conditional setting key", func() {
+		var etag string
+
+		BeforeEach(func() {
+			cacheManager.Set("test", 3, 0)
+			response, err = client.Get("/test")
+			etag = response.Headers.Get("ETag")
+		})
+
+		It("returns version as ETag", func() {
+			_, _, version, _, _ := cacheManager.GetVersioned("test")
+			Ω(etag).Should(Equal(fmt.Sprintf(`"%d"`, version)))
+		})
+
+		It("sets key if version matches", func() {
+			response, err = client.PostWithHeaders("/", `{"key":"test","value":4}`, map[string]string{"If-Match": etag})
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Status).Should(Equal(200))
+			Ω(response.Headers.Get("ETag")).ShouldNot(Equal(etag))
+			value, _, _, _ := cacheManager.Get("test")
+			Ω(value).Should(Equal(float64(4)))
+		})
+
+		It("returns 412 status code if key was changed", func() {
+			cacheManager.Set("test", 3, 0)
+			response, err = client.PostWithHeaders("/", `{"key":"test","value":4}`, map[string]string{"If-Match": etag})
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Status).Should(Equal(412))
+			value, _, _, _ := cacheManager.Get("test")
+			Ω(value).Should(Equal(float64(3)))
+		})
+
+		It("creates missed key with zero version only", func() {
+			response, err = client.PostWithHeaders("/", `{"key":"new","value":1}`, map[string]string{"If-Match": `"0"`})
+			Ω(response.Status).Should(Equal(200))
+			response, err = client.PostWithHeaders("/", `{"key":"new","value":2}`, map[string]string{"If-Match": `"0"`})
+			Ω(response.Status).Should(Equal(412))
+		})
+	})
+
+	Describe("incrementing key", func() {
+		BeforeEach(func() {
+			cacheManager.Set("test", 3, 3600)
+			response, err = client.Post("/test/incr", "{\"delta\":2}")
+		})
+
+		It("returns 200 status code", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Status).Should(Equal(200))
+		})
+
+		It("returns new value and keeps TTL", func() {
+			Ω(response.Body).Should(MatchJSON(`{"status":"ok","value":5}`))
+			value, expiredAt, _, _ := cacheManager.Get("test")
+			Ω(value).Should(Equal(float64(5)))
+			Ω(expiredAt).ShouldNot(BeZero())
+		})
+
+		It("increments by one and by float delta", func() {
+			response, err = client.Post("/counter/incr", "")
+			Ω(response.Body).Should(MatchJSON(`{"status":"ok","value":1}`))
+			response, err = client.Post("/counter/incr", "{\"delta\":-0.5,\"ttl\":60}")
+			Ω(response.Body).Should(MatchJSON(`{"status":"ok","value":0.5}`))
+			_, expiredAt, _, _ := cacheManager.Get("counter")
+			Ω(expiredAt).ShouldNot(BeZero())
+		})
+
+		It("rejects non numeric values", func() {
+			cacheManager.Set("text", "abc", 0)
+			response, err = client.Post("/text/incr", "")
+			Ω(response.Status).Should(Equal(400))
+			Ω(response.Body).Should(MatchJSON(`{"status":"error","error_message":"value is not an integer or out of range"}`))
+		})
+	})
+
+	Describe("batch operations", func() {
+		It("sets, gets and deletes several keys", func() {
+			response, err = client.Post("/_mset", `{"items":[{"key":"a","value":1},{"key":"b","value":"2","ttl":3600}]}`)
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Status).Should(Equal(200))
+			value, _, _, _ := cacheManager.Get("b")
+			Ω(value).Should(Equal("2"))
+
+			response, err = client.Post("/_mget", `{"keys":["a","missed"]}`)
+			Expect(err).NotTo(HaveOccurred())
+			_, _, version, _, _ := cacheManager.GetVersioned("a")
+			expected := fmt.Sprintf(`{"status":"ok","value":[{"key":"a","found":true,"value":1,"version":%d},{"key":"missed","found":false}]}`, version)
+			Ω(response.Body).Should(MatchJSON(expected))
+
+			response, err = client.Post("/_mdel", `{"keys":["a","b","missed"]}`)
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Body).Should(MatchJSON(`{"status":"ok","value":2}`))
+			_, _, found, _ := cacheManager.Get("a")
+			Expect(found).To(BeFalse())
+		})
+	})
+
+	Describe("transaction", func() {
+		BeforeEach(func() {
+			cacheManager.Set("from", 3, 0)
+		})
+
+		It("executes ops atomically", func() {
+			response, err = client.Post("/_tx", `{"ops":[{"op":"get","key":"from"},{"op":"set","key":"to","value":3},{"op":"delete","key":"from"},{"op":"incr","key":"to","delta":2}]}`)
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Status).Should(Equal(200))
+			_, _, version, _, _ := cacheManager.GetVersioned("to")
+			expected := fmt.Sprintf(`{"status":"ok","value":[{"key":"from","found":true,"value":3,"version":%d},`+
+				`{"key":"to","found":true,"value":3,"version":%d},{"key":"from","found":true},{"key":"to","found":true,"value":5,"version":%d}]}`,
+				version-2, version-1, version)
+			Ω(response.Body).Should(MatchJSON(expected))
+			_, _, found, _ := cacheManager.Get("from")
+			Expect(found).To(BeFalse())
+		})
+
+		It("returns 412 status code if watched key was changed", func() {
+			_, _, version, _, _ := cacheManager.GetVersioned("from")
+			cacheManager.Set("from", 4, 0)
+			response, err = client.Post("/_tx", fmt.Sprintf(`{"watch":{"from":%d},"ops":[{"op":"delete","key":"from"}]}`, version))
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Status).Should(Equal(412))
+			_, _, found, _ := cacheManager.Get("from")
+			Expect(found).To(BeTrue())
+		})
+
+		It("rejects unknown ops", func() {
+			response, err = client.Post("/_tx", `{"ops":[{"op":"hset","key":"from"}]}`)
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Status).Should(Equal(400))
+		})
+	})
+
+	Describe("scripts", func() {
+		It("loads and runs script", func() {
+			response, err = client.Post("/_script/load", `{"script":"return cacher.call('incr', KEYS[1], ARGV[1])"}`)
+			Expect(err).NotTo(HaveOccurred())
+			var loaded Response
+			json.Unmarshal([]byte(response.Body), &loaded)
+			sha := loaded.Value.(string)
+
+			response, err = client.Post("/_evalsha", fmt.Sprintf(`{"sha":"%s","keys":["counter"],"args":[5]}`, sha))
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Body).Should(MatchJSON(`{"status":"ok","value":5}`))
+			response, err = client.Post("/_eval", `{"script":"return {cacher.call('get', KEYS[1]), ARGV[1]}","keys":["counter"],"args":["a"]}`)
+			Ω(response.Body).Should(MatchJSON(`{"status":"ok","value":[5,"a"]}`))
+		})
+
+		It("returns 404 status code for unknown script", func() {
+			response, err = client.Post("/_evalsha", `{"sha":"missed"}`)
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Status).Should(Equal(404))
+		})
+
+		It("stops long scripts", func() {
+			response, err = client.Post("/_eval", `{"script":"while true do end","timeout":20}`)
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Body).Should(MatchJSON(`{"status":"error","error_message":"Script exceeded time limit of 20ms."}`))
+		})
+	})
+
+	Describe("pub/sub", func() {
+		It("streams published messages as events", func() {
+			request, _ := http.NewRequest("GET", "http://localhost:"+Port+"/_subscribe?channel=news&pattern=news.*", nil)
+			request.Header.Add("Authorization", "Bearer "+authToken)
+			stream, err := http.DefaultClient.Do(request)
+			Expect(err).NotTo(HaveOccurred())
+			defer stream.Body.Close()
+			Ω(stream.Header.Get("Content-Type")).Should(Equal("text/event-stream"))
+
+			// subscription is made before headers are sent
+			response, err = client.Post("/_publish", `{"channel":"news.sport","message":"goal"}`)
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Body).Should(MatchJSON(`{"status":"ok","value":1}`))
+			reader := bufio.NewReader(stream.Body)
+			line, _ := reader.ReadString('\n')
+			Ω(line).Should(Equal("event: message\n"))
+			line, _ = reader.ReadString('\n')
+			Ω(line).Should(Equal(`data: {"pattern":"news.*","channel":"news.sport","message":"goal"}` + "\n"))
+		})
+
+		It("requires channel", func() {
+			response, err = client.Get("/_subscribe")
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Status).Should(Equal(400))
+			response, err = client.Post("/_publish", `{"message":"goal"}`)
+			Ω(response.Status).Should(Equal(400))
+		})
+
+		It("keeps channels of namespaces apart", func() {
+			response, err = client.Post("/_namespaces", `{"name":"chat"}`)
+			Ω(response.Status).Should(Equal(200))
+			defer func() {
+				namespaces.Lock()
+				delete(namespaces.byName, "chat")
+				namespaces.Unlock()
+			}()
+			request, _ := http.NewRequest("GET", "http://localhost:"+Port+"/_ns/chat/_subscribe?channel=room", nil)
+			request.Header.Add("Authorization", "Bearer "+authToken)
+			stream, err := http.DefaultClient.Do(request)
+			Expect(err).NotTo(HaveOccurred())
+			defer stream.Body.Close()
+
+			response, err = client.Post("/_publish", `{"channel":"room","message":"hello"}`)
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Body).Should(MatchJSON(`{"status":"ok","value":0}`))
+			response, err = client.Post("/_ns/chat/_publish", `{"channel":"room","message":"hello"}`)
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Body).Should(MatchJSON(`{"status":"ok","value":1}`))
+		})
+
+		It("rejects invalid pattern", func() {
+			response, err = client.Get("/_subscribe?pattern=news.[")
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Status).Should(Equal(400))
+			Ω(response.Body).Should(ContainSubstring("Invalid pattern 'news.['."))
+		})
+	})
+
+	Describe("keyspace events", func() {
+		It("long-polls events after cursor", func() {
+			cursor := cacheManager.EventCursor()
+			cacheManager.Set("event:1", "a", 0)
+			cacheManager.Set("other", "b", 0)
+			cacheManager.Delete("event:1")
+
+			response, err = client.Get(fmt.Sprintf("/_events?pattern=event:*&cursor=%d", cursor))
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Status).Should(Equal(200))
+			var page struct {
+				Value EventsPage `json:"value"`
+			}
+			Expect(json.Unmarshal([]byte(response.Body), &page)).To(Succeed())
+			Ω(page.Value.Events).Should(HaveLen(2))
+			Ω(page.Value.Events[0].Op).Should(Equal("set"))
+			// history doesn't keep values
+			Ω(page.Value.Events[0].Value).Should(BeNil())
+			Ω(page.Value.Events[1].Op).Should(Equal("del"))
+			Ω(page.Value.Cursor).Should(Equal(cursor + 3))
+			Ω(page.Value.Missed).Should(BeFalse())
+
+			// waits for the next event
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				cacheManager.Set("event:2", "c", 0)
+			}()
+			response, err = client.Get(fmt.Sprintf("/_events?pattern=event:*&cursor=%d", page.Value.Cursor))
+			Expect(json.Unmarshal([]byte(response.Body), &page)).To(Succeed())
+			Ω(page.Value.Events).Should(HaveLen(1))
+			Ω(page.Value.Events[0].Key).Should(Equal("event:2"))
+		})
+
+		It("returns empty page after timeout", func() {
+			response, err = client.Get("/_events?pattern=nothing&timeout=10")
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Body).Should(MatchJSON(fmt.Sprintf(`{"status":"ok","value":{"cursor":%d,"events":[]}}`, cacheManager.EventCursor())))
+		})
+
+		It("streams events resuming from Last-Event-ID", func() {
+			cursor := cacheManager.EventCursor()
+			cacheManager.Set("stream:1", 1, 0)
+			request, _ := http.NewRequest("GET", "http://localhost:"+Port+"/_events/stream?pattern=stream:*", nil)
+			request.Header.Add("Authorization", "Bearer "+authToken)
+			request.Header.Add("Last-Event-ID", fmt.Sprint(cursor))
+			stream, err := http.DefaultClient.Do(request)
+			Expect(err).NotTo(HaveOccurred())
+			defer stream.Body.Close()
+			Ω(stream.Header.Get("Content-Type")).Should(Equal("text/event-stream"))
+
+			cacheManager.Delete("stream:1")
+			reader := bufio.NewReader(stream.Body)
+			for _, op := range []string{"set", "del"} {
+				cursor++
+				line, _ := reader.ReadString('\n')
+				Ω(line).Should(Equal(fmt.Sprintf("id: %d\n", cursor)))
+				line, _ = reader.ReadString('\n')
+				Ω(line).Should(Equal("event: keyspace\n"))
+				line, _ = reader.ReadString('\n')
+				Ω(line).Should(ContainSubstring(`"op":"` + op + `","key":"stream:1"`))
+				reader.ReadString('\n')
+			}
+		})
+
+		It("requires numeric cursor", func() {
+			response, err = client.Get("/_events?cursor=abc")
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Status).Should(Equal(400))
+		})
+
+		It("rejects invalid pattern", func() {
+			response, err = client.Get("/_events?pattern=user:[&timeout=10")
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Status).Should(Equal(400))
+			Ω(response.Body).Should(ContainSubstring("Invalid pattern 'user:['."))
+		})
+	})
+
+	Describe("namespaces", func() {
+		It("keeps keys of namespaces apart", func() {
+			response, err = client.Post("/_namespaces", `{"name":"team","default_ttl":100,"max_keys":2,"eviction_policy":"allkeys-lru"}`)
+			Expect(err).NotTo(HaveOccurred())
+			Ω(response.Body).Should(MatchJSON(`{"status":"ok"}`))
+			response, err = client.Post("/_namespaces", `{"name":"team"}`)
+			Ω(response.Status).Should(Equal(409))
+
+			cacheManager.Set("key", "default", 0)
+			response, err = client.Post("/_ns/team/", `{"key":"key","value":"team"}`)
+			Ω(response.Status).Should(Equal(200))
+			response, err = client.Get("/_ns/team/key")
+			Ω(response.Body).Should(ContainSubstring(`"value":"team"`))
+			Ω(response.Body).Should(ContainSubstring(`"expired_at"`))
+			response, err = client.doWithHeaders("GET", "/key", "", map[string]string{namespaceHeader: "team"})
+			Ω(response.Body).Should(ContainSubstring(`"value":"team"`))
+			response, err = client.Get("/key")
+			Ω(response.Body).Should(MatchJSON(`{"status":"ok","value":"default"}`))
+
+			// memory quota of namespace evicts its own keys only
+			client.Post("/_ns/team/", `{"key":"a","value":1}`)
+			client.Post("/_ns/team/", `{"key":"b","value":1}`)
+			response, err = client.Get("/_ns/team/keys")
+			var keys struct {
+				Value []string `json:"value"`
+			}
+			Expect(json.Unmarshal([]byte(response.Body), &keys)).To(Succeed())
+			Ω(keys.Value).Should(ConsistOf("a", "b"))
+
+			response, err = client.Get("/_namespaces")
+			Ω(response.Body).Should(MatchJSON(`{"status":"ok","value":[{"name":"team","default_ttl":100,"max_keys":2,"eviction_policy":"allkeys-lru"}]}`))
+			response, err = client.Post("/_namespaces/team/flush", "")
+			Ω(response.Body).Should(MatchJSON(`{"status":"ok","value":2}`))
+			response, err = client.Get("/_ns/team/keys")
+			Ω(response.Body).Should(MatchJSON(`{"status":"ok","value":[]}`))
+			response, err = client.Get("/key")
+			Ω(response.Status).Should(Equal(200))
+		})
+
+		It("checks auth token of namespace", func() {
+			response, err = client.Post("/_namespaces", `{"name":"private","auth_token":"secret"}`)
+			Ω(response.Status).Should(Equal(200))
+			response, err = client.Get("/_ns/private/keys")
+			Ω(response.Status).Should(Equal(401))
+			response, err = client.doWithHeaders("GET", "/_ns/private/keys", "", map[string]string{"Authorization": "Bearer secret"})
+			Ω(response.Status).Should(Equal(200))
+			response, err = client.doWithHeaders("GET", "/_namespaces", "", map[string]string{"Authorization": "Bearer secret"})
+			Ω(response.Status).Should(Equal(200))
+			response, err = client.Get("/_ns/missed/keys")
+			Ω(response.Status).Should(Equal(404))
+		})
+	})
+
 	Describe("deliting key", func() {
 		BeforeEach(func() {
 			cacheManager.Set("test", 3, 0)
@@ -251,6 +801,21 @@ func (c *CacherClient) Post(url, body string) (*HTTPResponse, error) {
 	return c.do("POST", url, body)
 }
 
+// Send GET request with additional headers
+func (c *CacherClient) GetWithHeaders(url string, headers map[string]string) (*HTTPResponse, error) {
+	return c.doWithHeaders("GET", url, "", headers)
+}
+
+// Send PUT request with additional headers
+func (c *CacherClient) PutWithHeaders(url, body string, headers map[string]string) (*HTTPResponse, error) {
+	return c.doWithHeaders("PUT", url, body, headers)
+}
+
+// Send POST request with additional headers
+func (c *CacherClient) PostWithHeaders(url, body string, headers map[string]string) (*HTTPResponse, error) {
+	return c.doWithHeaders("POST", url, body, headers)
+}
+
 // Send DELETE request
 func (c *CacherClient) Delete(url string) (*HTTPResponse, error) {
 	return c.do("DELETE", url, "")
@@ -258,6 +823,10 @@ func (c *CacherClient) Delete(url string) (*HTTPResponse, error) {
 
 // Helper generic send request method
 func (c *CacherClient) do(verb, url, body string) (*HTTPResponse, error) {
+	return c.doWithHeaders(verb, url, body, nil)
+}
+
+func (c *CacherClient) doWithHeaders(verb, url, body string, headers map[string]string) (*HTTPResponse, error) {
 	var reader io.Reader
 	if body != "" {
 		reader = strings.NewReader(body)
@@ -269,6 +838,9 @@ func (c *CacherClient) do(verb, url, body string) (*HTTPResponse, error) {
 
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Authorization", "Bearer "+authToken)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
 	resp, err := c.client.Do(req)
 	if err != nil {
 		return nil, err
@@ -289,13 +861,25 @@ func (c *CacherClient) do(verb, url, body string) (*HTTPResponse, error) {
 // Makes it possible to do integration testing.
 func httpServer() *echo.Echo {
 	e := echo.New()
+	e.Pre(rewriteNamespacePath)
+	e.Use(middleware.KeyAuth(authorizeKey))
+	e.Use(selectNamespace)
 
 	// Routes
 	// e.GET("/", healthCheck)
 	e.GET("/:key", getValue)
 	e.POST("/", setValue)
+	e.POST("/:key/incr", incrValue)
 	e.DELETE("/:key", deleteValue)
 	e.GET("/keys", getAllKeys)
+	e.GET("/_scan", scanKeys)
+	registerRawRoutes(e)
+	registerStructureRoutes(e)
+	registerBatchRoutes(e)
+	registerScriptRoutes(e)
+	registerPubSubRoutes(e)
+	registerEventRoutes(e)
+	registerNamespaceRoutes(e)
 
 	return e
 }
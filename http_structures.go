@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"./cache"
+	"./cache/types"
+	"github.com/labstack/echo"
+)
+
+type (
+	HashPayload struct {
+		Fields map[string]string `json:"fields"`
+	}
+
+	ListPayload struct {
+		Values []string `json:"values"`
+	}
+
+	SetPayload struct {
+		Members []string `json:"members"`
+	}
+
+	SortedSetPayload struct {
+		Members []types.ZMember `json:"members"`
+	}
+)
+
+// registerStructureRoutes adds routes for hashes, lists, sets and sorted sets
+func registerStructureRoutes(e *echo.Echo) {
+	e.GET("/hash/:key", hashGetAll)
+	e.GET("/hash/:key/:field", hashGet)
+	e.POST("/hash/:key", hashSet)
+	e.DELETE("/hash/:key/:field", hashDelete)
+
+	e.GET("/list/:key", listRange)
+	e.POST("/list/:key/lpush", listPush)
+	e.POST("/list/:key/rpush", listPush)
+	e.POST("/list/:key/lpop", listPop)
+	e.POST("/list/:key/rpop", listPop)
+
+	e.GET("/set/:key", setMembers)
+	e.GET("/set/:key/inter", setInter)
+	e.POST("/set/:key", setAdd)
+	e.DELETE("/set/:key/:member", setRemove)
+
+	e.GET("/zset/:key", sortedSetRange)
+	e.GET("/zset/:key/byscore", sortedSetRangeByScore)
+	e.POST("/zset/:key", sortedSetAdd)
+}
+
+func hashGetAll(c echo.Context) error {
+	hash, err := managerOf(c).HGetAll(c.Param("key"))
+	return structureResponse(c, hash, err)
+}
+
+func hashGet(c echo.Context) error {
+	key, field := c.Param("key"), c.Param("field")
+	value, found, err := managerOf(c).HGet(key, field)
+	if err == nil && !found {
+		return errorResponse(c, fmt.Sprintf("Field '%s' not found in hash '%s'.", field, key))
+	}
+	return structureResponse(c, value, err)
+}
+
+func hashSet(c echo.Context) error {
+	payload := new(HashPayload)
+	if err := c.Bind(payload); err != nil {
+		return errorResponse(c, "Unprocessable request payload. Error: "+err.Error())
+	}
+	added, err := managerOf(c).HSet(c.Param("key"), payload.Fields)
+	return structureResponse(c, added, err)
+}
+
+func hashDelete(c echo.Context) error {
+	removed, err := managerOf(c).HDel(c.Param("key"), c.Param("field"))
+	return structureResponse(c, removed, err)
+}
+
+// listRange returns values between start and stop query params, the whole list by default
+func listRange(c echo.Context) error {
+	start, stop, err := rangeParams(c)
+	if err != nil {
+		return errorResponse(c, err.Error())
+	}
+	values, err := managerOf(c).LRange(c.Param("key"), start, stop)
+	return structureResponse(c, values, err)
+}
+
+func listPush(c echo.Context) error {
+	payload := new(ListPayload)
+	if err := c.Bind(payload); err != nil {
+		return errorResponse(c, "Unprocessable request payload. Error: "+err.Error())
+	}
+	push := managerOf(c).RPush
+	if strings.HasSuffix(c.Path(), "/lpush") {
+		push = managerOf(c).LPush
+	}
+	length, err := push(c.Param("key"), payload.Values...)
+	return structureResponse(c, length, err)
+}
+
+func listPop(c echo.Context) error {
+	key := c.Param("key")
+	pop := managerOf(c).RPop
+	if strings.HasSuffix(c.Path(), "/lpop") {
+		pop = managerOf(c).LPop
+	}
+	value, found, err := pop(key)
+	if err == nil && !found {
+		return errorResponse(c, fmt.Sprintf("Key '%s' not found in cache.", key))
+	}
+	return structureResponse(c, value, err)
+}
+
+func setMembers(c echo.Context) error {
+	members, err := managerOf(c).SMembers(c.Param("key"))
+	return structureResponse(c, members, err)
+}
+
+// setInter intersects set with sets passed in comma separated "keys" query param
+func setInter(c echo.Context) error {
+	keys := []string{c.Param("key")}
+	if others := c.QueryParam("keys"); others != "" {
+		keys = append(keys, strings.Split(others, ",")...)
+	}
+	members, err := managerOf(c).SInter(keys...)
+	return structureResponse(c, members, err)
+}
+
+func setAdd(c echo.Context) error {
+	payload := new(SetPayload)
+	if err := c.Bind(payload); err != nil {
+		return errorResponse(c, "Unprocessable request payload. Error: "+err.Error())
+	}
+	added, err := managerOf(c).SAdd(c.Param("key"), payload.Members...)
+	return structureResponse(c, added, err)
+}
+
+func setRemove(c echo.Context) error {
+	removed, err := managerOf(c).SRem(c.Param("key"), c.Param("member"))
+	return structureResponse(c, removed, err)
+}
+
+func sortedSetRange(c echo.Context) error {
+	start, stop, err := rangeParams(c)
+	if err != nil {
+		return errorResponse(c, err.Error())
+	}
+	members, err := managerOf(c).ZRange(c.Param("key"), start, stop)
+	return structureResponse(c, members, err)
+}
+
+// sortedSetRangeByScore returns members with scores between min and max query params, (1.5 means exclusive bound
+func sortedSetRangeByScore(c echo.Context) error {
+	min, err := scoreBoundParam(c, "min", "-inf")
+	if err != nil {
+		return errorResponse(c, err.Error())
+	}
+	max, err := scoreBoundParam(c, "max", "+inf")
+	if err != nil {
+		return errorResponse(c, err.Error())
+	}
+	members, err := managerOf(c).ZRangeByScore(c.Param("key"), min, max)
+	return structureResponse(c, members, err)
+}
+
+func sortedSetAdd(c echo.Context) error {
+	payload := new(SortedSetPayload)
+	if err := c.Bind(payload); err != nil {
+		return errorResponse(c, "Unprocessable request payload. Error: "+err.Error())
+	}
+	added, err := managerOf(c).ZAdd(c.Param("key"), payload.Members...)
+	return structureResponse(c, added, err)
+}
+
+func rangeParams(c echo.Context) (start int, stop int, err error) {
+	start, stop = 0, -1
+	if value := c.QueryParam("start"); value != "" {
+		if start, err = strconv.Atoi(value); err != nil {
+			return 0, 0, fmt.Errorf("Query param 'start' is invalid: %s", value)
+		}
+	}
+	if value := c.QueryParam("stop"); value != "" {
+		if stop, err = strconv.Atoi(value); err != nil {
+			return 0, 0, fmt.Errorf("Query param 'stop' is invalid: %s", value)
+		}
+	}
+	return start, stop, nil
+}
+
+func scoreBoundParam(c echo.Context, name string, defaultValue string) (types.ScoreBound, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		value = defaultValue
+	}
+	bound, err := types.ParseScoreBound(value)
+	if err != nil {
+		return bound, fmt.Errorf("Query param '%s' is invalid: %s", name, value)
+	}
+	return bound, nil
+}
+
+func structureResponse(c echo.Context, value interface{}, err error) error {
+	if _, ok := err.(cache.MemoryLimitError); ok {
+		return errorResponseWithStatus(c, http.StatusInsufficientStorage, err.Error())
+	}
+	if err != nil {
+		return errorResponse(c, err.Error())
+	}
+	return c.JSON(http.StatusOK, Response{
+		Status: "ok",
+		Value:  value,
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+
+	"./cache"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("structures", func() {
+
+	var client *CacherClient
+
+	// decode value of successful response
+	expectValue := func(response *HTTPResponse, err error, expected string) {
+		Expect(err).NotTo(HaveOccurred())
+		Ω(response.Status).Should(Equal(200))
+		var r map[string]interface{}
+		Expect(json.Unmarshal([]byte(response.Body), &r)).To(Succeed())
+		value, _ := json.Marshal(r["value"])
+		Ω(value).Should(MatchJSON(expected))
+	}
+
+	BeforeEach(func() {
+		client = NewCacherClient()
+		cacheManager, _ = cache.New("mutex-map", log, false, 60, false)
+	})
+
+	It("manages hashes", func() {
+		response, err := client.Post("/hash/test", `{"fields":{"a":"1","b":"2"}}`)
+		expectValue(response, err, "2")
+		response, err = client.Get("/hash/test/a")
+		expectValue(response, err, `"1"`)
+		response, err = client.Delete("/hash/test/a")
+		expectValue(response, err, "1")
+		response, err = client.Get("/hash/test")
+		expectValue(response, err, `{"b":"2"}`)
+
+		response, err = client.Get("/hash/test/a")
+		Expect(err).NotTo(HaveOccurred())
+		Ω(response.Status).Should(Equal(400))
+	})
+
+	It("manages lists", func() {
+		response, err := client.Post("/list/test/rpush", `{"values":["b","c"]}`)
+		expectValue(response, err, "2")
+		response, err = client.Post("/list/test/lpush", `{"values":["a"]}`)
+		expectValue(response, err, "3")
+		response, err = client.Get("/list/test?start=1")
+		expectValue(response, err, `["b","c"]`)
+		response, err = client.Post("/list/test/lpop", "")
+		expectValue(response, err, `"a"`)
+		response, err = client.Post("/list/test/rpop", "")
+		expectValue(response, err, `"c"`)
+	})
+
+	It("manages sets", func() {
+		response, err := client.Post("/set/test", `{"members":["a","b"]}`)
+		expectValue(response, err, "2")
+		client.Post("/set/other", `{"members":["b","c"]}`)
+		response, err = client.Get("/set/test/inter?keys=other")
+		expectValue(response, err, `["b"]`)
+		response, err = client.Delete("/set/test/a")
+		expectValue(response, err, "1")
+		response, err = client.Get("/set/test")
+		expectValue(response, err, `["b"]`)
+	})
+
+	It("manages sorted sets", func() {
+		response, err := client.Post("/zset/test", `{"members":[{"member":"a","score":2},{"member":"b","score":1}]}`)
+		expectValue(response, err, "2")
+		response, err = client.Get("/zset/test")
+		expectValue(response, err, `[{"member":"b","score":1},{"member":"a","score":2}]`)
+		response, err = client.Get("/zset/test/byscore?min=(1")
+		expectValue(response, err, `[{"member":"a","score":2}]`)
+		// structure is returned by generic get too
+		response, err = client.Get("/test")
+		expectValue(response, err, `[{"member":"b","score":1},{"member":"a","score":2}]`)
+	})
+
+	It("rejects commands on keys of wrong type", func() {
+		cacheManager.Set("test", "value", 0)
+		response, err := client.Post("/hash/test", `{"fields":{"a":"1"}}`)
+		Expect(err).NotTo(HaveOccurred())
+		Ω(response.Status).Should(Equal(400))
+		Ω(response.Body).Should(ContainSubstring("WRONGTYPE"))
+	})
+})
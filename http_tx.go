@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"./cache"
+	"./cache/counter"
+	"github.com/labstack/echo"
+)
+
+type (
+	// TxPayload is a transaction: ops are executed only if watched keys still have passed versions (0 for missed key)
+	TxPayload struct {
+		Watch map[string]uint64 `json:"watch"`
+		Ops   []TxOpPayload     `json:"ops"`
+	}
+
+	// TxOpPayload is one of get, set, delete or incr operations
+	TxOpPayload struct {
+		Op    string      `json:"op"`
+		Key   string      `json:"key"`
+		Value interface{} `json:"value"`
+		TTL   int64       `json:"ttl"`
+		Delta json.Number `json:"delta"`
+	}
+)
+
+// execTransaction runs ops atomically, 412 status code is returned if a watched key was changed
+func execTransaction(c echo.Context) error {
+	payload := new(TxPayload)
+	if err := c.Bind(payload); err != nil {
+		return errorResponse(c, "Unprocessable request payload. Error: "+err.Error())
+	}
+	ops := make([]cache.TxOp, len(payload.Ops))
+	for i, op := range payload.Ops {
+		ops[i] = cache.TxOp{Op: op.Op, Key: op.Key, Value: op.Value, TTL: op.TTL}
+		if op.Op != cache.TxIncr {
+			continue
+		}
+		if op.Delta == "" {
+			ops[i].Delta = counter.Int(1)
+		} else if delta, err := op.Delta.Int64(); err == nil {
+			ops[i].Delta = counter.Int(delta)
+		} else if delta, err := op.Delta.Float64(); err == nil {
+			ops[i].Delta = counter.Float(delta)
+		} else {
+			return errorResponse(c, fmt.Sprintf("Delta '%s' is not a number.", op.Delta))
+		}
+	}
+
+	results, err := managerOf(c).Exec(ops, payload.Watch)
+	if _, ok := err.(cache.TxAbortedError); ok {
+		return errorResponseWithStatus(c, http.StatusPreconditionFailed, err.Error())
+	}
+	if err != nil {
+		return structureResponse(c, nil, err)
+	}
+	return structureResponse(c, txResultItems(results), nil)
+}
+
+// txResultItems returns results of transaction in the same form as MGET items
+func txResultItems(results []cache.TxResult) []BatchItem {
+	values := make([]BatchItem, len(results))
+	for i, result := range results {
+		values[i] = BatchItem{Key: result.Key, Found: result.Found, Value: result.Value, Version: result.Version}
+		if result.ExpiredAt != 0 {
+			values[i].ExpiredAt = time.Unix(result.ExpiredAt, 0).Format("2006-01-02 15:04:05")
+		}
+	}
+	return values
+}
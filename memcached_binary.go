@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"strconv"
+
+	"./cache"
+)
+
+type memcachedBinaryHeader struct {
+	opcode       byte
+	keyLength    uint16
+	extrasLength byte
+	bodyLength   uint32
+	opaque       uint32
+	cas          uint64
+}
+
+const (
+	memcachedBinaryRequestMagic  = 0x80
+	memcachedBinaryResponseMagic = 0x81
+	memcachedBinaryHeaderLength  = 24
+
+	memcachedStatusOK             = 0x00
+	memcachedStatusKeyNotFound    = 0x01
+	memcachedStatusKeyExists      = 0x02
+	memcachedStatusValueTooLarge  = 0x03
+	memcachedStatusInvalidArgs    = 0x04
+	memcachedStatusNotStored      = 0x05
+	memcachedStatusNonNumeric     = 0x06
+	memcachedStatusUnknownCommand = 0x81
+	memcachedStatusOutOfMemory    = 0x82
+	memcachedStatusInternalError  = 0x84
+)
+
+// binary opcodes, quiet versions don't send reply on success (and on miss for get commands)
+var memcachedBinaryCommands = map[byte]struct {
+	name  string
+	quiet bool
+}{
+	0x00: {"get", false},
+	0x01: {"set", false},
+	0x02: {"add", false},
+	0x03: {"replace", false},
+	0x04: {"delete", false},
+	0x05: {"incr", false},
+	0x06: {"decr", false},
+	0x07: {"quit", false},
+	0x08: {"flush_all", false},
+	0x09: {"get", true},
+	0x0a: {"noop", false},
+	0x0b: {"version", false},
+	0x0c: {"getk", false},
+	0x0d: {"getk", true},
+	0x0e: {"append", false},
+	0x0f: {"prepend", false},
+	0x10: {"stats", false},
+	0x11: {"set", true},
+	0x12: {"add", true},
+	0x13: {"replace", true},
+	0x14: {"delete", true},
+	0x15: {"incr", true},
+	0x16: {"decr", true},
+	0x17: {"quit", true},
+	0x18: {"flush_all", true},
+	0x19: {"append", true},
+	0x1a: {"prepend", true},
+	0x1c: {"touch", false},
+}
+
+func serveMemcachedBinary(reader *bufio.Reader, writer *bufio.Writer) {
+	packet := make([]byte, memcachedBinaryHeaderLength)
+	for {
+		if _, err := io.ReadFull(reader, packet); err != nil {
+			return
+		}
+		if packet[0] != memcachedBinaryRequestMagic {
+			return
+		}
+		request := memcachedBinaryHeader{
+			opcode:       packet[1],
+			keyLength:    binary.BigEndian.Uint16(packet[2:4]),
+			extrasLength: packet[4],
+			bodyLength:   binary.BigEndian.Uint32(packet[8:12]),
+			opaque:       binary.BigEndian.Uint32(packet[12:16]),
+			cas:          binary.BigEndian.Uint64(packet[16:24]),
+		}
+		if int(request.keyLength)+int(request.extrasLength) > int(request.bodyLength) ||
+			request.bodyLength > memcachedMaxItemSize+memcachedMaxKeyLength+64 {
+			return
+		}
+		body := make([]byte, request.bodyLength)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return
+		}
+		extras := body[:request.extrasLength]
+		key := string(body[request.extrasLength : int(request.extrasLength)+int(request.keyLength)])
+		value := body[int(request.extrasLength)+int(request.keyLength):]
+
+		quit := memcachedBinaryCommand(writer, request, extras, key, value)
+		if reader.Buffered() == 0 || quit {
+			if err := writer.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+func memcachedBinaryCommand(writer *bufio.Writer, request memcachedBinaryHeader, extras []byte, key string, value []byte) (quit bool) {
+	command, ok := memcachedBinaryCommands[request.opcode]
+	if !ok {
+		memcachedBinaryError(writer, request, memcachedStatusUnknownCommand, "Unknown command")
+		return false
+	}
+	// reply is sent for errors even for quiet commands
+	reply := func(extras []byte, key string, value []byte, cas uint64) {
+		if !command.quiet {
+			memcachedBinaryReply(writer, request, memcachedStatusOK, extras, key, value, cas)
+		}
+	}
+
+	switch command.name {
+	case "get", "getk":
+		item, found, err := memcachedGet(key)
+		if err != nil {
+			memcachedBinaryCacheError(writer, request, err)
+			return false
+		}
+		if !found {
+			if !command.quiet {
+				memcachedBinaryError(writer, request, memcachedStatusKeyNotFound, "Not found")
+			}
+			return false
+		}
+		flags := make([]byte, 4)
+		binary.BigEndian.PutUint32(flags, item.flags)
+		if command.name == "get" {
+			key = ""
+		}
+		reply(flags, key, item.data, item.cas)
+
+	case "set", "add", "replace", "append", "prepend":
+		var flags uint32
+		var exptime int64
+		if command.name == "set" || command.name == "add" || command.name == "replace" {
+			if len(extras) != 8 {
+				memcachedBinaryError(writer, request, memcachedStatusInvalidArgs, "Invalid arguments")
+				return false
+			}
+			flags = binary.BigEndian.Uint32(extras[0:4])
+			exptime = int64(binary.BigEndian.Uint32(extras[4:8]))
+		}
+		if len(value) > memcachedMaxItemSize {
+			memcachedBinaryError(writer, request, memcachedStatusValueTooLarge, "Too large")
+			return false
+		}
+		result, cas, err := memcachedStore(command.name, key, flags, exptime, value, request.cas)
+		if err != nil {
+			memcachedBinaryCacheError(writer, request, err)
+			return false
+		}
+		if !memcachedBinaryResult(writer, request, result) {
+			return false
+		}
+		reply(nil, "", nil, cas)
+
+	case "delete":
+		result, err := memcachedDelete(key)
+		if err != nil {
+			memcachedBinaryCacheError(writer, request, err)
+			return false
+		}
+		if !memcachedBinaryResult(writer, request, result) {
+			return false
+		}
+		reply(nil, "", nil, 0)
+
+	case "incr", "decr":
+		if len(extras) != 20 {
+			memcachedBinaryError(writer, request, memcachedStatusInvalidArgs, "Invalid arguments")
+			return false
+		}
+		delta := binary.BigEndian.Uint64(extras[0:8])
+		initial := binary.BigEndian.Uint64(extras[8:16])
+		exptime := binary.BigEndian.Uint32(extras[16:20])
+		counter, result, err := memcachedIncr(key, delta, command.name == "incr")
+		if err == nil && result == memcachedNotFound && exptime != 0xffffffff {
+			// missed counter is created with initial value unless expiration is all ones
+			counter = initial
+			result, _, err = memcachedStore("add", key, 0, int64(exptime), []byte(strconv.FormatUint(initial, 10)), 0)
+			if result == memcachedStored {
+				result = ""
+			}
+		}
+		if err != nil {
+			memcachedBinaryCacheError(writer, request, err)
+			return false
+		}
+		if result != "" && !memcachedBinaryResult(writer, request, result) {
+			return false
+		}
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint64(data, counter)
+		reply(nil, "", data, 0)
+
+	case "touch":
+		if len(extras) != 4 {
+			memcachedBinaryError(writer, request, memcachedStatusInvalidArgs, "Invalid arguments")
+			return false
+		}
+		result, err := memcachedTouch(key, int64(binary.BigEndian.Uint32(extras)))
+		if err != nil {
+			memcachedBinaryCacheError(writer, request, err)
+			return false
+		}
+		if !memcachedBinaryResult(writer, request, result) {
+			return false
+		}
+		reply(nil, "", nil, 0)
+
+	case "flush_all":
+		var delay int64
+		if len(extras) == 4 {
+			delay = int64(binary.BigEndian.Uint32(extras))
+		}
+		memcachedFlush(delay)
+		reply(nil, "", nil, 0)
+
+	case "stats":
+		for _, stat := range memcachedStatsList() {
+			memcachedBinaryReply(writer, request, memcachedStatusOK, nil, stat[0], []byte(stat[1]), 0)
+		}
+		memcachedBinaryReply(writer, request, memcachedStatusOK, nil, "", nil, 0)
+
+	case "version":
+		reply(nil, "", []byte(memcachedVersion), 0)
+
+	case "noop":
+		reply(nil, "", nil, 0)
+
+	case "quit":
+		reply(nil, "", nil, 0)
+		return true
+	}
+	return false
+}
+
+// memcachedBinaryResult sends error reply for text protocol result if it isn't successful
+func memcachedBinaryResult(writer *bufio.Writer, request memcachedBinaryHeader, result string) bool {
+	switch result {
+	case memcachedStored, memcachedDeleted, memcachedTouched:
+		return true
+	case memcachedNotFound:
+		memcachedBinaryError(writer, request, memcachedStatusKeyNotFound, "Not found")
+	case memcachedExists:
+		memcachedBinaryError(writer, request, memcachedStatusKeyExists, "Data exists for key.")
+	case memcachedNotStored:
+		memcachedBinaryError(writer, request, memcachedStatusNotStored, "Not stored.")
+	case memcachedNonNumeric:
+		memcachedBinaryError(writer, request, memcachedStatusNonNumeric, "Non-numeric server-side value for incr or decr")
+	default:
+		memcachedBinaryError(writer, request, memcachedStatusInternalError, result)
+	}
+	return false
+}
+
+func memcachedBinaryCacheError(writer *bufio.Writer, request memcachedBinaryHeader, err error) {
+	if _, ok := err.(cache.MemoryLimitError); ok {
+		memcachedBinaryError(writer, request, memcachedStatusOutOfMemory, "Out of memory")
+		return
+	}
+	memcachedBinaryError(writer, request, memcachedStatusInternalError, err.Error())
+}
+
+func memcachedBinaryError(writer *bufio.Writer, request memcachedBinaryHeader, status uint16, message string) {
+	memcachedBinaryReply(writer, request, status, nil, "", []byte(message), 0)
+}
+
+func memcachedBinaryReply(writer *bufio.Writer, request memcachedBinaryHeader, status uint16, extras []byte, key string, value []byte, cas uint64) {
+	header := make([]byte, memcachedBinaryHeaderLength)
+	header[0] = memcachedBinaryResponseMagic
+	header[1] = request.opcode
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(key)))
+	header[4] = byte(len(extras))
+	binary.BigEndian.PutUint16(header[6:8], status)
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(header[12:16], request.opaque)
+	binary.BigEndian.PutUint64(header[16:24], cas)
+	writer.Write(header)
+	writer.Write(extras)
+	writer.WriteString(key)
+	writer.Write(value)
+}
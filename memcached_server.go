@@ -0,0 +1,552 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"./cache"
+	"./cache/raw"
+	"./config"
+)
+
+type (
+	memcachedItem struct {
+		data      []byte
+		flags     uint32
+		cas       uint64
+		expiredAt int64
+	}
+
+	memcachedStats struct {
+		currConnections  int64
+		totalConnections int64
+		cmdGet           int64
+		getHits          int64
+		getMisses        int64
+		cmdSet           int64
+	}
+)
+
+const (
+	memcachedVersion = "1.6.0-cacher"
+	// exptime greater than 30 days is an absolute unix timestamp
+	memcachedMaxRelativeExptime = 60 * 60 * 24 * 30
+	memcachedMaxItemSize        = 1024 * 1024
+	memcachedMaxKeyLength       = 250
+
+	memcachedStored     = "STORED"
+	memcachedNotStored  = "NOT_STORED"
+	memcachedExists     = "EXISTS"
+	memcachedNotFound   = "NOT_FOUND"
+	memcachedDeleted    = "DELETED"
+	memcachedTouched    = "TOUCHED"
+	memcachedNonNumeric = "CLIENT_ERROR cannot increment or decrement non-numeric value"
+
+	// memcachedFlagsType is content type of values stored with non zero client flags, flags are its parameter.
+	// Flags belong to the stored value, so they're kept by persistence and reset once key is changed by any other interface.
+	memcachedFlagsType = "application/x-memcached; flags="
+)
+
+var (
+	memcachedStatistic memcachedStats
+	memcachedStartedAt = time.Now()
+)
+
+func startMemcachedServer() {
+	address := fmt.Sprintf("%s:%s", *config.ServerIP, *config.ServerPort)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Fatalf("Error while launching Memcached server: %s", err)
+	}
+	log.Printf("Memcached server launched: %s", address)
+	acceptMemcached(listener)
+}
+
+func acceptMemcached(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("Error while accepting Memcached connection: %s", err)
+			return
+		}
+		go serveMemcached(conn)
+	}
+}
+
+// serveMemcached detects protocol by the first byte, binary requests start with magic byte
+func serveMemcached(conn net.Conn) {
+	defer conn.Close()
+	atomic.AddInt64(&memcachedStatistic.currConnections, 1)
+	atomic.AddInt64(&memcachedStatistic.totalConnections, 1)
+	defer atomic.AddInt64(&memcachedStatistic.currConnections, -1)
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	first, err := reader.Peek(1)
+	if err != nil {
+		return
+	}
+	if first[0] == memcachedBinaryRequestMagic {
+		serveMemcachedBinary(reader, writer)
+	} else {
+		serveMemcachedText(reader, writer)
+	}
+}
+
+func serveMemcachedText(reader *bufio.Reader, writer *bufio.Writer) {
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			writer.WriteString("CLIENT_ERROR line is too long\r\n")
+			writer.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		quit := memcachedTextCommand(reader, writer, strings.Fields(string(line)))
+		// replies to pipelined commands are sent together
+		if reader.Buffered() == 0 || quit {
+			if err := writer.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+func memcachedTextCommand(reader *bufio.Reader, writer *bufio.Writer, fields []string) (quit bool) {
+	if len(fields) == 0 {
+		writer.WriteString("ERROR\r\n")
+		return false
+	}
+	command, args := fields[0], fields[1:]
+	noreply := len(args) > 0 && args[len(args)-1] == "noreply"
+	if noreply {
+		args = args[:len(args)-1]
+	}
+	reply := func(message string) {
+		if !noreply {
+			writer.WriteString(message + "\r\n")
+		}
+	}
+
+	switch command {
+	case "get", "gets":
+		if len(args) == 0 {
+			writer.WriteString("ERROR\r\n")
+			return false
+		}
+		for _, key := range args {
+			item, found, err := memcachedGet(key)
+			if err != nil {
+				writer.WriteString(memcachedServerError(err) + "\r\n")
+				return false
+			}
+			if !found {
+				continue
+			}
+			if command == "gets" {
+				fmt.Fprintf(writer, "VALUE %s %d %d %d\r\n", key, item.flags, len(item.data), item.cas)
+			} else {
+				fmt.Fprintf(writer, "VALUE %s %d %d\r\n", key, item.flags, len(item.data))
+			}
+			writer.Write(item.data)
+			writer.WriteString("\r\n")
+		}
+		writer.WriteString("END\r\n")
+
+	case "set", "add", "replace", "append", "prepend", "cas":
+		// <command> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+		expected := 4
+		if command == "cas" {
+			expected = 5
+		}
+		if len(args) != expected {
+			writer.WriteString("ERROR\r\n")
+			return false
+		}
+		flags, errFlags := strconv.ParseUint(args[1], 10, 32)
+		exptime, errExptime := strconv.ParseInt(args[2], 10, 64)
+		length, errLength := strconv.Atoi(args[3])
+		var cas uint64
+		var errCAS error
+		if command == "cas" {
+			cas, errCAS = strconv.ParseUint(args[4], 10, 64)
+		}
+		if errFlags != nil || errExptime != nil || errLength != nil || errCAS != nil || length < 0 {
+			writer.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return false
+		}
+		if len(args[0]) > memcachedMaxKeyLength {
+			writer.WriteString("CLIENT_ERROR key is too long\r\n")
+			io.CopyN(ioutil.Discard, reader, int64(length+2))
+			return false
+		}
+		if length > memcachedMaxItemSize {
+			writer.WriteString("SERVER_ERROR object too large for cache\r\n")
+			io.CopyN(ioutil.Discard, reader, int64(length+2))
+			return false
+		}
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return true
+		}
+		if data[length] != '\r' || data[length+1] != '\n' {
+			writer.WriteString("CLIENT_ERROR bad data chunk\r\n")
+			return false
+		}
+		result, _, err := memcachedStore(command, args[0], uint32(flags), exptime, data[:length], cas)
+		if err != nil {
+			reply(memcachedServerError(err))
+			return false
+		}
+		reply(result)
+
+	case "delete":
+		if len(args) != 1 {
+			writer.WriteString("ERROR\r\n")
+			return false
+		}
+		result, err := memcachedDelete(args[0])
+		if err != nil {
+			reply(memcachedServerError(err))
+			return false
+		}
+		reply(result)
+
+	case "incr", "decr":
+		if len(args) != 2 {
+			writer.WriteString("ERROR\r\n")
+			return false
+		}
+		delta, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			writer.WriteString("CLIENT_ERROR invalid numeric delta argument\r\n")
+			return false
+		}
+		value, result, err := memcachedIncr(args[0], delta, command == "incr")
+		if err != nil {
+			reply(memcachedServerError(err))
+			return false
+		}
+		if result != "" {
+			reply(result)
+			return false
+		}
+		reply(strconv.FormatUint(value, 10))
+
+	case "touch":
+		if len(args) != 2 {
+			writer.WriteString("ERROR\r\n")
+			return false
+		}
+		exptime, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			writer.WriteString("CLIENT_ERROR invalid exptime argument\r\n")
+			return false
+		}
+		result, err := memcachedTouch(args[0], exptime)
+		if err != nil {
+			reply(memcachedServerError(err))
+			return false
+		}
+		reply(result)
+
+	case "flush_all":
+		var delay int64
+		if len(args) > 0 {
+			var err error
+			delay, err = strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				writer.WriteString("CLIENT_ERROR bad command line format\r\n")
+				return false
+			}
+		}
+		memcachedFlush(delay)
+		reply("OK")
+
+	case "stats":
+		for _, stat := range memcachedStatsList() {
+			fmt.Fprintf(writer, "STAT %s %s\r\n", stat[0], stat[1])
+		}
+		writer.WriteString("END\r\n")
+
+	case "version":
+		writer.WriteString("VERSION " + memcachedVersion + "\r\n")
+
+	case "quit":
+		return true
+
+	default:
+		writer.WriteString("ERROR\r\n")
+	}
+	return false
+}
+
+func memcachedServerError(err error) string {
+	if _, ok := err.(cache.MemoryLimitError); ok {
+		return "SERVER_ERROR out of memory storing object"
+	}
+	return "SERVER_ERROR " + err.Error()
+}
+
+// memcachedGet serves get commands and counts hits and misses
+func memcachedGet(key string) (item memcachedItem, found bool, err error) {
+	atomic.AddInt64(&memcachedStatistic.cmdGet, 1)
+	item, found, err = memcachedLoad(key)
+	if found {
+		atomic.AddInt64(&memcachedStatistic.getHits, 1)
+	} else {
+		atomic.AddInt64(&memcachedStatistic.getMisses, 1)
+	}
+	return item, found, err
+}
+
+func memcachedLoad(key string) (item memcachedItem, found bool, err error) {
+	// CAS unique is version of the key, so it changes whenever the value is changed by any interface
+	value, expiredAt, version, found, err := cacheManager.GetVersioned(key)
+	if err != nil || !found {
+		return item, false, err
+	}
+	item.data = valueBytes(value)
+	item.cas = version
+	item.expiredAt = expiredAt
+	if typed, ok := value.(raw.Typed); ok && strings.HasPrefix(typed.ContentType, memcachedFlagsType) {
+		flags, err := strconv.ParseUint(strings.TrimPrefix(typed.ContentType, memcachedFlagsType), 10, 32)
+		if err == nil {
+			item.flags = uint32(flags)
+		}
+	}
+	return item, true, nil
+}
+
+// memcachedValue converts received data to cached value, in opaque mode data is stored as is without JSON encoding.
+// Data with flags is stored as typed bytes keeping flags in content type.
+func memcachedValue(data []byte, flags uint32) interface{} {
+	if flags != 0 {
+		return raw.Typed{ContentType: memcachedFlagsType + strconv.FormatUint(uint64(flags), 10), Data: data}
+	}
+	if *config.MemcachedOpaque {
+		return raw.Bytes(data)
+	}
+	return string(data)
+}
+
+// memcachedTTL converts memcached exptime to TTL, negative exptime or timestamp in the past means already expired item
+func memcachedTTL(exptime int64) (ttl int64, expired bool) {
+	if exptime < 0 {
+		return 0, true
+	}
+	if exptime > memcachedMaxRelativeExptime {
+		ttl = exptime - time.Now().Unix()
+		return ttl, ttl <= 0
+	}
+	return exptime, false
+}
+
+// memcachedRemainingTTL returns TTL which keeps existing expiration time of the item
+func memcachedRemainingTTL(item memcachedItem) int64 {
+	if item.expiredAt == 0 {
+		return 0
+	}
+	ttl := item.expiredAt - time.Now().Unix()
+	if ttl <= 0 {
+		ttl = 1
+	}
+	return ttl
+}
+
+// memcachedStore implements set/add/replace/append/prepend/cas commands. Non zero cas makes any
+// of them conditional as in binary protocol. It returns text protocol reply and CAS of the stored item.
+func memcachedStore(command string, key string, flags uint32, exptime int64, data []byte, cas uint64) (string, uint64, error) {
+	atomic.AddInt64(&memcachedStatistic.cmdSet, 1)
+	for {
+		result, newCAS, err := memcachedTryStore(command, key, flags, exptime, data, cas)
+		if _, ok := err.(cache.VersionConflictError); ok {
+			// key was changed after it was loaded, check conditions again
+			continue
+		}
+		return result, newCAS, err
+	}
+}
+
+func memcachedTryStore(command string, key string, flags uint32, exptime int64, data []byte, cas uint64) (string, uint64, error) {
+	item, found, err := memcachedLoad(key)
+	if err != nil {
+		return "", 0, err
+	}
+	switch command {
+	case "add":
+		if found {
+			return memcachedNotStored, 0, nil
+		}
+	case "replace", "append", "prepend":
+		if !found {
+			return memcachedNotStored, 0, nil
+		}
+	case "cas":
+		if !found {
+			return memcachedNotFound, 0, nil
+		}
+	}
+	if cas != 0 {
+		if !found {
+			return memcachedNotFound, 0, nil
+		}
+		if item.cas != cas {
+			return memcachedExists, 0, nil
+		}
+	}
+
+	ttl, expired := memcachedTTL(exptime)
+	switch command {
+	case "append", "prepend":
+		// append and prepend keep flags and expiration time of the item
+		if command == "append" {
+			data = append(append([]byte{}, item.data...), data...)
+		} else {
+			data = append(append([]byte{}, data...), item.data...)
+		}
+		flags = item.flags
+		ttl, expired = memcachedRemainingTTL(item), false
+	}
+
+	if expired {
+		return memcachedStored, 0, cacheManager.Delete(key)
+	}
+	// everything except plain set depends on the loaded item, so it's stored only if the item wasn't changed since
+	conditional := command != "set" || cas != 0
+	newCAS, err := memcachedPut(key, flags, ttl, data, conditional, item.cas)
+	if err != nil {
+		return "", 0, err
+	}
+	return memcachedStored, newCAS, nil
+}
+
+// memcachedPut stores data and returns its CAS. Conditional put succeeds only if key still has expected CAS,
+// 0 stands for missed key.
+func memcachedPut(key string, flags uint32, ttl int64, data []byte, conditional bool, expected uint64) (cas uint64, err error) {
+	if conditional {
+		return cacheManager.CompareAndSet(key, memcachedValue(data, flags), ttl, expected)
+	}
+	return cacheManager.SetVersioned(key, memcachedValue(data, flags), ttl)
+}
+
+func memcachedDelete(key string) (string, error) {
+	_, found, err := memcachedLoad(key)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return memcachedNotFound, nil
+	}
+	return memcachedDeleted, cacheManager.Delete(key)
+}
+
+// memcachedIncr changes 64-bit unsigned counter: incr wraps around and decr stops at 0.
+// Non empty result is returned instead of value when counter can't be changed.
+func memcachedIncr(key string, delta uint64, incr bool) (value uint64, result string, err error) {
+	for {
+		item, found, err := memcachedLoad(key)
+		if err != nil {
+			return 0, "", err
+		}
+		if !found {
+			return 0, memcachedNotFound, nil
+		}
+		value, err = strconv.ParseUint(strings.TrimSpace(string(item.data)), 10, 64)
+		if err != nil {
+			return 0, memcachedNonNumeric, nil
+		}
+		if incr {
+			value += delta
+		} else if delta > value {
+			value = 0
+		} else {
+			value -= delta
+		}
+
+		data := []byte(strconv.FormatUint(value, 10))
+		_, err = memcachedPut(key, item.flags, memcachedRemainingTTL(item), data, true, item.cas)
+		if _, ok := err.(cache.VersionConflictError); ok {
+			// counter was changed concurrently, increment the new value
+			continue
+		}
+		return value, "", err
+	}
+}
+
+// memcachedTouch changes expiration time of the item keeping its data and flags
+func memcachedTouch(key string, exptime int64) (string, error) {
+	for {
+		item, found, err := memcachedLoad(key)
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			return memcachedNotFound, nil
+		}
+		ttl, expired := memcachedTTL(exptime)
+		if expired {
+			return memcachedTouched, cacheManager.Delete(key)
+		}
+		_, err = memcachedPut(key, item.flags, ttl, item.data, true, item.cas)
+		if _, ok := err.(cache.VersionConflictError); ok {
+			// item was changed concurrently, touch the new one
+			continue
+		}
+		return memcachedTouched, err
+	}
+}
+
+// memcachedFlush removes all keys now or after delay in seconds
+func memcachedFlush(delay int64) {
+	flush := func() {
+		keys, err := cacheManager.GetKeys()
+		if err != nil {
+			log.Printf("Error while flushing keys: %s", err)
+			return
+		}
+		for _, key := range keys {
+			cacheManager.Delete(key)
+		}
+	}
+	if delay > 0 {
+		time.AfterFunc(time.Duration(delay)*time.Second, flush)
+	} else {
+		flush()
+	}
+}
+
+func memcachedStatsList() [][2]string {
+	keys, _ := cacheManager.GetKeys()
+	usedMemory, _ := cacheManager.MemoryUsage()
+	now := time.Now()
+	return [][2]string{
+		{"pid", strconv.Itoa(os.Getpid())},
+		{"uptime", strconv.FormatInt(int64(now.Sub(memcachedStartedAt).Seconds()), 10)},
+		{"time", strconv.FormatInt(now.Unix(), 10)},
+		{"version", memcachedVersion},
+		{"pointer_size", strconv.Itoa(32 << (^uint(0) >> 63))},
+		{"curr_connections", strconv.FormatInt(atomic.LoadInt64(&memcachedStatistic.currConnections), 10)},
+		{"total_connections", strconv.FormatInt(atomic.LoadInt64(&memcachedStatistic.totalConnections), 10)},
+		{"cmd_get", strconv.FormatInt(atomic.LoadInt64(&memcachedStatistic.cmdGet), 10)},
+		{"cmd_set", strconv.FormatInt(atomic.LoadInt64(&memcachedStatistic.cmdSet), 10)},
+		{"get_hits", strconv.FormatInt(atomic.LoadInt64(&memcachedStatistic.getHits), 10)},
+		{"get_misses", strconv.FormatInt(atomic.LoadInt64(&memcachedStatistic.getMisses), 10)},
+		{"curr_items", strconv.Itoa(len(keys))},
+		{"bytes", strconv.FormatInt(usedMemory, 10)},
+		{"limit_maxbytes", strconv.FormatInt(int64(*config.MaxMemory), 10)},
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"./cache"
+	"./cache/raw"
+	"./config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Port Cacher Memcached server listens on for testing
+const MemcachedPort = "8083"
+
+var _ = Describe("memcached", func() {
+
+	var conn net.Conn
+	var reader *bufio.Reader
+
+	// send raw request and check that expected reply is received
+	expectReply := func(request string, expected string) {
+		_, err := conn.Write([]byte(request))
+		Expect(err).NotTo(HaveOccurred())
+		reply := make([]byte, len(expected))
+		_, err = io.ReadFull(reader, reply)
+		Expect(err).NotTo(HaveOccurred())
+		Ω(string(reply)).Should(Equal(expected))
+	}
+
+	// binary request with header and body
+	binaryRequest := func(opcode byte, extras []byte, key string, value string, cas uint64) []byte {
+		header := make([]byte, 24)
+		header[0] = 0x80
+		header[1] = opcode
+		binary.BigEndian.PutUint16(header[2:4], uint16(len(key)))
+		header[4] = byte(len(extras))
+		binary.BigEndian.PutUint32(header[8:12], uint32(len(extras)+len(key)+len(value)))
+		binary.BigEndian.PutUint32(header[12:16], 42)
+		binary.BigEndian.PutUint64(header[16:24], cas)
+		return append(append(append(header, extras...), key...), value...)
+	}
+
+	// read binary response and return status, cas and body
+	binaryResponse := func() (uint16, uint64, []byte) {
+		header := make([]byte, 24)
+		_, err := io.ReadFull(reader, header)
+		Expect(err).NotTo(HaveOccurred())
+		Ω(header[0]).Should(Equal(byte(0x81)))
+		Ω(binary.BigEndian.Uint32(header[12:16])).Should(Equal(uint32(42)))
+		body := make([]byte, binary.BigEndian.Uint32(header[8:12]))
+		_, err = io.ReadFull(reader, body)
+		Expect(err).NotTo(HaveOccurred())
+		return binary.BigEndian.Uint16(header[6:8]), binary.BigEndian.Uint64(header[16:24]), body
+	}
+
+	BeforeEach(func() {
+		cacheManager, _ = cache.New("mutex-map", log, false, 60, false)
+		var err error
+		conn, err = net.Dial("tcp", "localhost:"+MemcachedPort)
+		Expect(err).NotTo(HaveOccurred())
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		reader = bufio.NewReader(conn)
+	})
+
+	AfterEach(func() {
+		conn.Close()
+	})
+
+	It("stores and gets values", func() {
+		expectReply("set test 5 0 11\r\nhello world\r\n", "STORED\r\n")
+		expectReply("get test missed\r\n", "VALUE test 5 11\r\nhello world\r\nEND\r\n")
+		value, _, _, _ := cacheManager.Get("test")
+		Ω(value).Should(Equal(raw.Typed{ContentType: "application/x-memcached; flags=5", Data: []byte("hello world")}))
+
+		// flags are kept with the value
+		expectReply("touch test 100\r\n", "TOUCHED\r\n")
+		expectReply("get test\r\n", "VALUE test 5 11\r\nhello world\r\nEND\r\n")
+		_, expiredAt, _, _ := cacheManager.Get("test")
+		Ω(expiredAt).Should(BeNumerically("~", time.Now().Unix()+100, 1))
+
+		// flags are reset when value is changed by another interface
+		cacheManager.Set("test", "other", 0)
+		expectReply("get test\r\n", "VALUE test 0 5\r\nother\r\nEND\r\n")
+	})
+
+	It("supports storage commands", func() {
+		expectReply("add test 0 0 1\r\na\r\n", "STORED\r\n")
+		expectReply("add test 0 0 1\r\nb\r\n", "NOT_STORED\r\n")
+		expectReply("replace missed 0 0 1\r\nb\r\n", "NOT_STORED\r\n")
+		expectReply("append test 0 0 1\r\nc\r\n", "STORED\r\n")
+		expectReply("prepend test 0 0 1\r\nd\r\n", "STORED\r\n")
+		expectReply("set other 0 0 1 noreply\r\nx\r\nget test other\r\n",
+			"VALUE test 0 3\r\ndac\r\nVALUE other 0 1\r\nx\r\nEND\r\n")
+	})
+
+	It("supports cas", func() {
+		version, _ := cacheManager.SetVersioned("test", "value", 0)
+		cas := strconv.FormatUint(version, 10)
+		expectReply("gets test\r\n", "VALUE test 0 5 "+cas+"\r\nvalue\r\nEND\r\n")
+		expectReply("cas missed 0 0 1 "+cas+"\r\na\r\n", "NOT_FOUND\r\n")
+		expectReply("cas test 0 0 1 "+cas+"\r\na\r\n", "STORED\r\n")
+		expectReply("cas test 0 0 1 "+cas+"\r\nb\r\n", "EXISTS\r\n")
+		// CAS is changed by writes over other interfaces even if value is the same
+		_, _, version, _, _ = cacheManager.GetVersioned("test")
+		cacheManager.Set("test", "a", 0)
+		cas = strconv.FormatUint(version, 10)
+		expectReply("cas test 0 0 1 "+cas+"\r\nb\r\n", "EXISTS\r\n")
+	})
+
+	It("maps exptime to TTL", func() {
+		expectReply("set test 0 100 1\r\na\r\n", "STORED\r\n")
+		_, expiredAt, _, _ := cacheManager.Get("test")
+		Ω(expiredAt).Should(BeNumerically("~", time.Now().Unix()+100, 1))
+
+		absolute := strconv.FormatInt(time.Now().Unix()+3600, 10)
+		expectReply("touch test "+absolute+"\r\n", "TOUCHED\r\n")
+		_, expiredAt, _, _ = cacheManager.Get("test")
+		Ω(expiredAt).Should(BeNumerically("~", time.Now().Unix()+3600, 1))
+
+		expectReply("set test 0 -1 1\r\na\r\n", "STORED\r\n")
+		_, _, found, _ := cacheManager.Get("test")
+		Ω(found).Should(BeFalse())
+	})
+
+	It("changes counters", func() {
+		expectReply("incr test 1\r\n", "NOT_FOUND\r\n")
+		expectReply("set test 0 0 2\r\n10\r\n", "STORED\r\n")
+		expectReply("incr test 5\r\n", "15\r\n")
+		expectReply("decr test 20\r\n", "0\r\n")
+		expectReply("set test 0 0 1\r\na\r\n", "STORED\r\n")
+		expectReply("incr test 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+	})
+
+	It("deletes and flushes keys", func() {
+		cacheManager.Set("test", "value", 0)
+		cacheManager.Set("other", "value", 0)
+		expectReply("delete test\r\n", "DELETED\r\n")
+		expectReply("delete test\r\n", "NOT_FOUND\r\n")
+		expectReply("flush_all\r\n", "OK\r\n")
+		keys, _ := cacheManager.GetKeys()
+		Ω(keys).Should(BeEmpty())
+		expectReply("unknown\r\n", "ERROR\r\n")
+	})
+
+	It("stores opaque bytes", func() {
+		*config.MemcachedOpaque = true
+		defer func() { *config.MemcachedOpaque = false }()
+		expectReply("set test 0 0 3\r\n\xff\x00\xfe\r\n", "STORED\r\n")
+		value, _, _, _ := cacheManager.Get("test")
+		Ω(value).Should(Equal(raw.Bytes{0xff, 0x00, 0xfe}))
+		expectReply("get test\r\n", "VALUE test 0 3\r\n\xff\x00\xfe\r\nEND\r\n")
+	})
+
+	It("speaks binary protocol", func() {
+		extras := make([]byte, 8)
+		binary.BigEndian.PutUint32(extras[0:4], 7)
+		binary.BigEndian.PutUint32(extras[4:8], 100)
+		conn.Write(binaryRequest(0x01, extras, "test", "value", 0))
+		status, cas, _ := binaryResponse()
+		Ω(status).Should(Equal(uint16(0)))
+		_, _, version, _, _ := cacheManager.GetVersioned("test")
+		Ω(cas).Should(Equal(version))
+
+		// quiet get of missed key is followed by noop
+		conn.Write(append(binaryRequest(0x09, nil, "missed", "", 0), binaryRequest(0x0a, nil, "", "", 0)...))
+		conn.Write(binaryRequest(0x00, nil, "test", "", 0))
+		status, _, body := binaryResponse()
+		Ω(status).Should(Equal(uint16(0)))
+		Ω(body).Should(BeEmpty())
+		status, _, body = binaryResponse()
+		Ω(status).Should(Equal(uint16(0)))
+		Ω(binary.BigEndian.Uint32(body[0:4])).Should(Equal(uint32(7)))
+		Ω(string(body[4:])).Should(Equal("value"))
+
+		// set with stale cas
+		conn.Write(binaryRequest(0x01, extras, "test", "other", cas+1))
+		status, _, _ = binaryResponse()
+		Ω(status).Should(Equal(uint16(0x02)))
+
+		conn.Write(binaryRequest(0x04, nil, "missed", "", 0))
+		status, _, _ = binaryResponse()
+		Ω(status).Should(Equal(uint16(0x01)))
+	})
+})
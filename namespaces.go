@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"./cache"
+	"./config"
+	"./pubsub"
+)
+
+// defaultNamespace selects the default namespace, which is used unless other one is selected
+const defaultNamespace = "default"
+
+var namespacesPath = "./data/namespaces.json"
+
+type (
+	// NamespaceConfig describes namespace created by admin. Memory quota and eviction policy work like
+	// max_memory, max_keys and eviction_policy flags, DefaultTTL is applied to values set without TTL.
+	// Namespace with AuthToken is accessed only with this token or with auth_token of server.
+	NamespaceConfig struct {
+		Name           string `json:"name"`
+		DefaultTTL     int64  `json:"default_ttl,omitempty"`
+		MaxMemory      int64  `json:"max_memory,omitempty"`
+		MaxKeys        int    `json:"max_keys,omitempty"`
+		EvictionPolicy string `json:"eviction_policy,omitempty"`
+		AuthToken      string `json:"auth_token,omitempty"`
+	}
+
+	// namespace is a keyspace with own cache manager and pub/sub channels
+	namespace struct {
+		config  NamespaceConfig
+		manager *cache.CacheManager
+		pubSub  *pubsub.Hub
+	}
+
+	// NamespaceExistsError is returned when namespace is created twice
+	NamespaceExistsError struct {
+		name string
+	}
+)
+
+var namespaces = struct {
+	sync.RWMutex
+	byName map[string]*namespace
+	// persisted namespaces are saved to namespacesPath and created again on start
+	persisted bool
+}{byName: make(map[string]*namespace)}
+
+func (nee NamespaceExistsError) Error() string {
+	return fmt.Sprintf("Namespace '%s' already exists.", nee.name)
+}
+
+// lookupNamespace returns namespace by name, nil stands for the default namespace
+func lookupNamespace(name string) (*namespace, bool) {
+	if name == "" || name == defaultNamespace {
+		return nil, true
+	}
+	namespaces.RLock()
+	defer namespaces.RUnlock()
+	ns, found := namespaces.byName[name]
+	return ns, found
+}
+
+// managerOfNamespace returns cache manager of namespace, nil namespace is the default one
+func managerOfNamespace(ns *namespace) *cache.CacheManager {
+	if ns == nil {
+		return cacheManager
+	}
+	return ns.manager
+}
+
+// pubSubOfNamespace returns pub/sub hub of namespace, so tenants don't share channels. Nil namespace is the default one.
+func pubSubOfNamespace(ns *namespace) *pubsub.Hub {
+	if ns == nil {
+		return pubSub
+	}
+	return ns.pubSub
+}
+
+// namespaceTokenMatches tells whether token is the one of namespace or auth_token of server
+func namespaceTokenMatches(ns *namespace, token string) bool {
+	return tokenMatches(token, ns.config.AuthToken) || (*config.AuthToken != "" && tokenMatches(token, *config.AuthToken))
+}
+
+// createNamespace creates namespace with own storage, its records are restored from persistence
+func createNamespace(nsConfig NamespaceConfig) error {
+	if nsConfig.Name == defaultNamespace {
+		return NamespaceExistsError{nsConfig.Name}
+	}
+	if nsConfig.EvictionPolicy == "" {
+		nsConfig.EvictionPolicy = "noeviction"
+	}
+	if nsConfig.DefaultTTL < 0 {
+		return fmt.Errorf("Default TTL of namespace can't be negative.")
+	}
+
+	namespaces.Lock()
+	defer namespaces.Unlock()
+	if _, found := namespaces.byName[nsConfig.Name]; found {
+		return NamespaceExistsError{nsConfig.Name}
+	}
+	manager, err := cacheManager.NewNamespace(nsConfig.Name)
+	if err != nil {
+		return err
+	}
+	err = manager.SetMemoryLimit(nsConfig.MaxMemory, nsConfig.MaxKeys, nsConfig.EvictionPolicy, *config.EvictionSample)
+	if err != nil {
+		return err
+	}
+	manager.SetDefaultTTL(nsConfig.DefaultTTL)
+	manager.SetScriptTimeout(time.Duration(*config.ScriptTimeout) * time.Millisecond)
+	manager.SetWatchHistory(*config.WatchHistory)
+	expireInterval := time.Duration(*config.ExpireInterval) * time.Millisecond
+	if err = manager.StartExpiration(expireInterval, *config.ExpireSample, *config.ExpireBudget); err != nil {
+		return err
+	}
+
+	namespaces.byName[nsConfig.Name] = &namespace{config: nsConfig, manager: manager, pubSub: pubsub.New(*config.PubSubBuffer)}
+	if namespaces.persisted {
+		return saveNamespaces()
+	}
+	return nil
+}
+
+// listNamespaces returns configs of namespaces sorted by name without auth tokens
+func listNamespaces() []NamespaceConfig {
+	namespaces.RLock()
+	defer namespaces.RUnlock()
+	list := make([]NamespaceConfig, 0, len(namespaces.byName))
+	for _, ns := range namespaces.byName {
+		nsConfig := ns.config
+		nsConfig.AuthToken = ""
+		list = append(list, nsConfig)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// saveNamespaces writes configs of namespaces, caller holds the lock
+func saveNamespaces() error {
+	list := make([]NamespaceConfig, 0, len(namespaces.byName))
+	for _, ns := range namespaces.byName {
+		list = append(list, ns.config)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(filepath.Dir(namespacesPath), os.ModePerm)
+	return ioutil.WriteFile(namespacesPath, data, 0600)
+}
+
+// loadNamespaces creates namespaces saved before restart, so their records are restored
+func loadNamespaces() error {
+	namespaces.persisted = true
+	data, err := ioutil.ReadFile(namespacesPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []NamespaceConfig
+	if err = json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	for _, nsConfig := range list {
+		if err = createNamespace(nsConfig); err != nil {
+			return err
+		}
+	}
+	log.Printf("Restored %d namespaces", len(list))
+	return nil
+}
+
+// stopNamespaces stops active expiration of namespaces on shutdown
+func stopNamespaces() {
+	namespaces.RLock()
+	defer namespaces.RUnlock()
+	for _, ns := range namespaces.byName {
+		ns.manager.StopExpiration()
+	}
+}
+
+// tokenMatches compares auth token in constant time, so the token can't be guessed by response time
+func tokenMatches(given string, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
@@ -0,0 +1,16 @@
+package pb
+
+import "context"
+
+// TokenAuth passes Cacher bearer auth token with every RPC, use it with grpc.WithPerRPCCredentials
+type TokenAuth string
+
+// GetRequestMetadata implements credentials.PerRPCCredentials
+func (t TokenAuth) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials, Cacher doesn't provide TLS
+func (t TokenAuth) RequireTransportSecurity() bool {
+	return false
+}
@@ -0,0 +1,175 @@
+// Package pubsub broadcasts messages published to channels to subscribers of the channels or of matching patterns.
+package pubsub
+
+import (
+	"sync"
+
+	"../cache/glob"
+)
+
+type (
+	// Message is delivered to subscriber, Pattern is set if subscriber got it by pattern subscription
+	Message struct {
+		Pattern string
+		Channel string
+		Payload string
+	}
+
+	// Hub keeps subscribers, each of them has a buffer of Limit messages.
+	// Subscriber which doesn't keep up with messages is dropped, so publishers never wait.
+	Hub struct {
+		mu          sync.Mutex
+		limit       int
+		subscribers map[*Subscriber]struct{}
+	}
+
+	// Subscriber receives messages of its channels and patterns until it's closed or dropped
+	Subscriber struct {
+		hub      *Hub
+		messages chan Message
+		channels map[string]struct{}
+		patterns map[string]struct{}
+		closed   bool
+		dropped  bool
+	}
+)
+
+// New returns hub which keeps up to limit undelivered messages per subscriber
+func New(limit int) *Hub {
+	if limit < 1 {
+		limit = 1
+	}
+	return &Hub{limit: limit, subscribers: make(map[*Subscriber]struct{})}
+}
+
+// Subscriber returns a new subscriber without subscriptions
+func (h *Hub) Subscriber() *Subscriber {
+	s := &Subscriber{
+		hub:      h,
+		messages: make(chan Message, h.limit),
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+	h.mu.Lock()
+	h.subscribers[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+// Publish sends payload to subscribers of channel and returns number of deliveries,
+// subscriber of both channel and matching patterns gets the message once per subscription
+func (h *Hub) Publish(channel string, payload string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delivered := 0
+	for s := range h.subscribers {
+		var messages []Message
+		if _, ok := s.channels[channel]; ok {
+			messages = append(messages, Message{Channel: channel, Payload: payload})
+		}
+		for pattern := range s.patterns {
+			if glob.Match(pattern, channel) {
+				messages = append(messages, Message{Pattern: pattern, Channel: channel, Payload: payload})
+			}
+		}
+		for _, message := range messages {
+			select {
+			case s.messages <- message:
+				delivered++
+			default:
+				s.dropped = true
+				h.remove(s)
+			}
+			if s.dropped {
+				break
+			}
+		}
+	}
+	return delivered
+}
+
+// remove closes messages of subscriber, caller holds the lock
+func (h *Hub) remove(s *Subscriber) {
+	if !s.closed {
+		s.closed = true
+		delete(h.subscribers, s)
+		close(s.messages)
+	}
+}
+
+// Messages returns channel of delivered messages, it's closed when subscriber is closed or dropped
+func (s *Subscriber) Messages() <-chan Message {
+	return s.messages
+}
+
+// Subscribe adds channels and returns number of subscriptions
+func (s *Subscriber) Subscribe(channels ...string) int {
+	return s.change(s.channels, channels, true)
+}
+
+// PSubscribe adds patterns of channels and returns number of subscriptions. Patterns are Redis style globs
+// like in KEYS, nothing is added if any of them is invalid.
+func (s *Subscriber) PSubscribe(patterns ...string) (int, error) {
+	for _, pattern := range patterns {
+		if err := glob.Validate(pattern); err != nil {
+			return 0, err
+		}
+	}
+	return s.change(s.patterns, patterns, true), nil
+}
+
+// Unsubscribe removes passed channels or all of them and returns number of left subscriptions
+func (s *Subscriber) Unsubscribe(channels ...string) int {
+	return s.change(s.channels, channels, false)
+}
+
+// PUnsubscribe removes passed patterns or all of them and returns number of left subscriptions
+func (s *Subscriber) PUnsubscribe(patterns ...string) int {
+	return s.change(s.patterns, patterns, false)
+}
+
+// Channels returns subscribed channels and patterns
+func (s *Subscriber) Channels() (channels []string, patterns []string) {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	for channel := range s.channels {
+		channels = append(channels, channel)
+	}
+	for pattern := range s.patterns {
+		patterns = append(patterns, pattern)
+	}
+	return channels, patterns
+}
+
+// Dropped reports whether subscriber was dropped because its buffer was full
+func (s *Subscriber) Dropped() bool {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.dropped
+}
+
+// Close removes all subscriptions and closes messages
+func (s *Subscriber) Close() {
+	s.hub.mu.Lock()
+	s.hub.remove(s)
+	s.hub.mu.Unlock()
+}
+
+func (s *Subscriber) change(set map[string]struct{}, names []string, add bool) int {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if add {
+		for _, name := range names {
+			set[name] = struct{}{}
+		}
+	} else if len(names) == 0 {
+		for name := range set {
+			delete(set, name)
+		}
+	} else {
+		for _, name := range names {
+			delete(set, name)
+		}
+	}
+	return len(s.channels) + len(s.patterns)
+}
@@ -0,0 +1,74 @@
+package pubsub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublish(t *testing.T) {
+	hub := New(10)
+	subscriber := hub.Subscriber()
+	assert.Equal(t, 1, subscriber.Subscribe("news"))
+	count, err := subscriber.PSubscribe("news.*")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	other := hub.Subscriber()
+	other.PSubscribe("*")
+
+	assert.Equal(t, 2, hub.Publish("news", "a"))
+	assert.Equal(t, 2, hub.Publish("news.sport", "b"))
+	assert.Equal(t, 1, hub.Publish("weather", "c"))
+
+	assert.Equal(t, Message{Channel: "news", Payload: "a"}, <-subscriber.Messages())
+	assert.Equal(t, Message{Pattern: "news.*", Channel: "news.sport", Payload: "b"}, <-subscriber.Messages())
+	assert.Equal(t, 3, len(other.Messages()))
+
+	assert.Equal(t, 1, subscriber.Unsubscribe())
+	assert.Equal(t, 0, subscriber.PUnsubscribe("news.*"))
+	assert.Equal(t, 1, hub.Publish("news", "d"))
+	subscriber.Close()
+	_, ok := <-subscriber.Messages()
+	assert.False(t, ok)
+	assert.False(t, subscriber.Dropped())
+}
+
+func TestPatterns(t *testing.T) {
+	hub := New(10)
+	subscriber := hub.Subscriber()
+	// patterns are Redis globs, '*' matches '/' too
+	count, err := subscriber.PSubscribe("user/*", "h[ae]llo", "log\\*")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+	assert.Equal(t, 1, hub.Publish("user/1/profile", "a"))
+	assert.Equal(t, 1, hub.Publish("hallo", "b"))
+	assert.Equal(t, 0, hub.Publish("hillo", "c"))
+	assert.Equal(t, 1, hub.Publish("log*", "d"))
+	assert.Equal(t, 0, hub.Publish("logs", "e"))
+
+	// invalid pattern is rejected with the rest of patterns
+	_, err = subscriber.PSubscribe("news", "news.[")
+	assert.Equal(t, "Invalid pattern 'news.['.", err.Error())
+	_, patterns := subscriber.Channels()
+	assert.Len(t, patterns, 3)
+	subscriber.Close()
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	hub := New(2)
+	slow := hub.Subscriber()
+	slow.Subscribe("news")
+	fast := hub.Subscriber()
+	fast.Subscribe("news")
+
+	for i := 0; i < 3; i++ {
+		hub.Publish("news", "message")
+		<-fast.Messages()
+	}
+	assert.True(t, slow.Dropped())
+	assert.False(t, fast.Dropped())
+	// buffered messages are still delivered before channel is closed
+	assert.Equal(t, 2, len(slow.Messages()))
+	assert.Equal(t, 1, hub.Publish("news", "message"))
+	fast.Close()
+}
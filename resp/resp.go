@@ -0,0 +1,169 @@
+// Package resp implements Redis serialization protocol (RESP2 and RESP3) used by Redis clients.
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Reader reads client commands, both RESP arrays of bulk strings and inline commands
+	Reader struct {
+		rd *bufio.Reader
+	}
+
+	// Writer writes replies in RESP2 or RESP3 depending on the negotiated protocol version
+	Writer struct {
+		wr *bufio.Writer
+		// Protocol is either 2 or 3, switched by HELLO command
+		Protocol int
+	}
+
+	// ProtocolError is returned when client sends malformed request
+	ProtocolError struct {
+		message string
+	}
+)
+
+const (
+	maxBulkLength  = 512 * 1024 * 1024
+	maxArrayLength = 1024 * 1024
+)
+
+func (pe ProtocolError) Error() string {
+	return "Protocol error: " + pe.message
+}
+
+func NewReader(rd io.Reader) *Reader {
+	return &Reader{rd: bufio.NewReader(rd)}
+}
+
+// Buffered reports whether there are pipelined commands which are already received
+func (r *Reader) Buffered() bool {
+	return r.rd.Buffered() > 0
+}
+
+// ReadCommand returns the next command with its arguments
+func (r *Reader) ReadCommand() ([][]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+	if line[0] != '*' {
+		// inline command, e.g. sent by telnet
+		var args [][]byte
+		for _, field := range strings.Fields(string(line)) {
+			args = append(args, []byte(field))
+		}
+		return args, nil
+	}
+
+	count, err := parseLength(line[1:], maxArrayLength)
+	if err != nil {
+		return nil, err
+	}
+	args := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		line, err = r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, ProtocolError{fmt.Sprintf("expected '$', got '%s'", line)}
+		}
+		length, err := parseLength(line[1:], maxBulkLength)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, length+2)
+		_, err = io.ReadFull(r.rd, data)
+		if err != nil {
+			return nil, err
+		}
+		if data[length] != '\r' || data[length+1] != '\n' {
+			return nil, ProtocolError{"bulk string is not terminated by CRLF"}
+		}
+		args = append(args, data[:length])
+	}
+	return args, nil
+}
+
+func (r *Reader) readLine() ([]byte, error) {
+	line, err := r.rd.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+func parseLength(data []byte, max int) (int, error) {
+	length, err := strconv.Atoi(string(data))
+	if err != nil || length < 0 || length > max {
+		return 0, ProtocolError{fmt.Sprintf("invalid length '%s'", data)}
+	}
+	return length, nil
+}
+
+func NewWriter(wr io.Writer) *Writer {
+	return &Writer{wr: bufio.NewWriter(wr), Protocol: 2}
+}
+
+// Flush sends buffered replies to client
+func (w *Writer) Flush() error {
+	return w.wr.Flush()
+}
+
+func (w *Writer) WriteSimpleString(s string) {
+	w.wr.WriteString("+" + s + "\r\n")
+}
+
+// WriteError writes error reply, message should start with error code like ERR or WRONGTYPE
+func (w *Writer) WriteError(message string) {
+	w.wr.WriteString("-" + strings.NewReplacer("\r", " ", "\n", " ").Replace(message) + "\r\n")
+}
+
+func (w *Writer) WriteInt(n int64) {
+	w.wr.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w *Writer) WriteBulk(data []byte) {
+	w.wr.WriteString("$" + strconv.Itoa(len(data)) + "\r\n")
+	w.wr.Write(data)
+	w.wr.WriteString("\r\n")
+}
+
+func (w *Writer) WriteBulkString(s string) {
+	w.WriteBulk([]byte(s))
+}
+
+func (w *Writer) WriteNull() {
+	if w.Protocol == 3 {
+		w.wr.WriteString("_\r\n")
+	} else {
+		w.wr.WriteString("$-1\r\n")
+	}
+}
+
+// WriteArray writes array header, n elements have to follow
+func (w *Writer) WriteArray(n int) {
+	w.wr.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// WriteMap writes map header, n key/value pairs have to follow. RESP2 has no maps, so flat array is used instead.
+func (w *Writer) WriteMap(n int) {
+	if w.Protocol == 3 {
+		w.wr.WriteString("%" + strconv.Itoa(n) + "\r\n")
+	} else {
+		w.WriteArray(n * 2)
+	}
+}
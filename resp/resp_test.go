@@ -0,0 +1,66 @@
+package resp
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCommand(t *testing.T) {
+	reader := NewReader(strings.NewReader("*3\r\n$3\r\nSET\r\n$4\r\ntest\r\n$11\r\nhello world\r\nPING\r\nGET  test\n"))
+
+	args, err := reader.ReadCommand()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("SET"), []byte("test"), []byte("hello world")}, args)
+	// pipelined commands are already buffered
+	assert.True(t, reader.Buffered())
+
+	// inline commands
+	args, err = reader.ReadCommand()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("PING")}, args)
+	args, err = reader.ReadCommand()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("GET"), []byte("test")}, args)
+	assert.False(t, reader.Buffered())
+
+	_, err = reader.ReadCommand()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReadMalformedCommand(t *testing.T) {
+	for _, request := range []string{"*x\r\n", "*1\r\n:1\r\n", "*1\r\n$-5\r\n", "*1\r\n$3\r\nGETX\r\n"} {
+		_, err := NewReader(strings.NewReader(request)).ReadCommand()
+		assert.IsType(t, ProtocolError{}, err, request)
+	}
+
+	// connection closed in the middle of command
+	_, err := NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n")).ReadCommand()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestWriter(t *testing.T) {
+	var buffer bytes.Buffer
+	writer := NewWriter(&buffer)
+	writer.WriteSimpleString("OK")
+	writer.WriteError("ERR bad\r\nthing")
+	writer.WriteInt(-2)
+	writer.WriteBulkString("hello world")
+	writer.WriteNull()
+	writer.WriteMap(1)
+	writer.WriteBulkString("key")
+	writer.WriteArray(0)
+	assert.Equal(t, "", buffer.String())
+	writer.Flush()
+	assert.Equal(t, "+OK\r\n-ERR bad  thing\r\n:-2\r\n$11\r\nhello world\r\n$-1\r\n*2\r\n$3\r\nkey\r\n*0\r\n", buffer.String())
+
+	buffer.Reset()
+	writer.Protocol = 3
+	writer.WriteNull()
+	writer.WriteMap(1)
+	writer.Flush()
+	assert.Equal(t, "_\r\n%1\r\n", buffer.String())
+}
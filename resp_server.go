@@ -0,0 +1,590 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"./cache"
+	"./cache/raw"
+	"./cache/types"
+	"./config"
+	"./resp"
+)
+
+type (
+	respClient struct {
+		reader        *resp.Reader
+		writer        *resp.Writer
+		authenticated bool
+		quit          bool
+	}
+
+	respCommand struct {
+		handler func(client *respClient, args [][]byte)
+		// number of arguments including command name, negative value means "at least"
+		arity int
+	}
+)
+
+// redis version reported to clients, some of them adjust used commands to it
+const respRedisVersion = "7.0.0"
+
+var respCommands map[string]respCommand
+
+func init() {
+	respCommands = map[string]respCommand{
+		"ping":    {respPing, -1},
+		"auth":    {respAuth, -2},
+		"hello":   {respHello, -1},
+		"quit":    {respQuit, 1},
+		"select":  {respSelect, 2},
+		"client":  {respClientCommand, -2},
+		"command": {respCommandCommand, -1},
+		"info":    {respInfo, -1},
+		"get":     {respGet, 2},
+		"set":     {respSet, -3},
+		"del":     {respDel, -2},
+		"mget":    {respMGet, -2},
+		"mset":    {respMSet, -3},
+		"exists":  {respExists, -2},
+		"keys":    {respKeys, 2},
+		"scan":    {respScan, -2},
+		"ttl":     {respTTL, 2},
+		"pttl":    {respPTTL, 2},
+		"expire":  {respExpire, 3},
+		"type":    {respType, 2},
+
+		"incr":        {respIncr, 2},
+		"decr":        {respDecr, 2},
+		"incrby":      {respIncrBy, 3},
+		"decrby":      {respDecrBy, 3},
+		"incrbyfloat": {respIncrByFloat, 3},
+
+		"hset":          {respHSet, -4},
+		"hget":          {respHGet, 3},
+		"hdel":          {respHDel, -3},
+		"hgetall":       {respHGetAll, 2},
+		"lpush":         {respLPush, -3},
+		"rpush":         {respRPush, -3},
+		"lpop":          {respLPop, 2},
+		"rpop":          {respRPop, 2},
+		"lrange":        {respLRange, 4},
+		"sadd":          {respSAdd, -3},
+		"srem":          {respSRem, -3},
+		"smembers":      {respSMembers, 2},
+		"sinter":        {respSInter, -2},
+		"zadd":          {respZAdd, -4},
+		"zrange":        {respZRange, -4},
+		"zrangebyscore": {respZRangeByScore, -4},
+
+		"bgrewriteaof": {respBgRewriteAOF, 1},
+	}
+}
+
+func startRESPServer() {
+	address := fmt.Sprintf("%s:%s", *config.ServerIP, *config.ServerPort)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Fatalf("Error while launching RESP server: %s", err)
+	}
+	log.Printf("RESP server launched: %s", address)
+	acceptRESP(listener)
+}
+
+func acceptRESP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("Error while accepting RESP connection: %s", err)
+			return
+		}
+		go serveRESP(conn)
+	}
+}
+
+func serveRESP(conn net.Conn) {
+	defer conn.Close()
+	client := &respClient{
+		reader:        resp.NewReader(conn),
+		writer:        resp.NewWriter(conn),
+		authenticated: *config.AuthToken == "",
+	}
+	for !client.quit {
+		args, err := client.reader.ReadCommand()
+		if err != nil {
+			if _, ok := err.(resp.ProtocolError); ok {
+				client.writer.WriteError("ERR " + err.Error())
+				client.writer.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		client.execute(args)
+		// replies to pipelined commands are sent together
+		if !client.reader.Buffered() || client.quit {
+			if err := client.writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (client *respClient) execute(args [][]byte) {
+	name := strings.ToLower(string(args[0]))
+	command, ok := respCommands[name]
+	if !ok {
+		client.writer.WriteError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return
+	}
+	if !client.authenticated && name != "auth" && name != "hello" && name != "quit" {
+		client.writer.WriteError("NOAUTH Authentication required.")
+		return
+	}
+	if (command.arity > 0 && len(args) != command.arity) || (command.arity < 0 && len(args) < -command.arity) {
+		client.writer.WriteError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return
+	}
+	command.handler(client, args[1:])
+}
+
+func (client *respClient) writeCacheError(err error) {
+	switch err.(type) {
+	case cache.MemoryLimitError, cache.WrongTypeError:
+		// message already starts with error code
+		client.writer.WriteError(err.Error())
+	case cache.InvalidPatternError:
+		client.writer.WriteError("ERR invalid pattern")
+	default:
+		client.writer.WriteError("ERR " + err.Error())
+	}
+}
+
+func respPing(client *respClient, args [][]byte) {
+	if len(args) > 0 {
+		client.writer.WriteBulk(args[0])
+	} else {
+		client.writer.WriteSimpleString("PONG")
+	}
+}
+
+func respAuth(client *respClient, args [][]byte) {
+	if *config.AuthToken == "" {
+		client.writer.WriteError("ERR AUTH called without any password configured.")
+		return
+	}
+	// AUTH <password> or AUTH <username> <password>, username is ignored
+	if !tokenMatches(string(args[len(args)-1]), *config.AuthToken) {
+		client.authenticated = false
+		client.writer.WriteError("WRONGPASS invalid username-password pair.")
+		return
+	}
+	client.authenticated = true
+	client.writer.WriteSimpleString("OK")
+}
+
+func respHello(client *respClient, args [][]byte) {
+	protocol := client.writer.Protocol
+	if len(args) > 0 {
+		version, err := strconv.Atoi(string(args[0]))
+		if err != nil || version < 2 || version > 3 {
+			client.writer.WriteError("NOPROTO unsupported protocol version")
+			return
+		}
+		protocol = version
+	}
+	for i := 1; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		if option == "auth" && i+2 < len(args) {
+			if *config.AuthToken != "" && !tokenMatches(string(args[i+2]), *config.AuthToken) {
+				client.writer.WriteError("WRONGPASS invalid username-password pair.")
+				return
+			}
+			client.authenticated = true
+			i += 2
+		} else if option == "setname" && i+1 < len(args) {
+			i++
+		} else {
+			client.writer.WriteError("ERR syntax error")
+			return
+		}
+	}
+	if !client.authenticated {
+		client.writer.WriteError("NOAUTH HELLO must be called with the client already authenticated.")
+		return
+	}
+
+	client.writer.Protocol = protocol
+	client.writer.WriteMap(6)
+	client.writer.WriteBulkString("server")
+	client.writer.WriteBulkString("redis")
+	client.writer.WriteBulkString("version")
+	client.writer.WriteBulkString(respRedisVersion)
+	client.writer.WriteBulkString("proto")
+	client.writer.WriteInt(int64(protocol))
+	client.writer.WriteBulkString("mode")
+	client.writer.WriteBulkString("standalone")
+	client.writer.WriteBulkString("role")
+	client.writer.WriteBulkString("master")
+	client.writer.WriteBulkString("modules")
+	client.writer.WriteArray(0)
+}
+
+func respQuit(client *respClient, args [][]byte) {
+	client.quit = true
+	client.writer.WriteSimpleString("OK")
+}
+
+func respSelect(client *respClient, args [][]byte) {
+	if string(args[0]) != "0" {
+		client.writer.WriteError("ERR DB index is out of range")
+		return
+	}
+	client.writer.WriteSimpleString("OK")
+}
+
+// respClientCommand accepts CLIENT SETNAME/SETINFO sent by client libraries on connect
+func respClientCommand(client *respClient, args [][]byte) {
+	client.writer.WriteSimpleString("OK")
+}
+
+func respCommandCommand(client *respClient, args [][]byte) {
+	client.writer.WriteArray(0)
+}
+
+func respBgRewriteAOF(client *respClient, args [][]byte) {
+	if err := cache.StartAOFRewrite(); err != nil {
+		client.writer.WriteError("ERR " + err.Error())
+		return
+	}
+	client.writer.WriteSimpleString("Background append only file rewriting started")
+}
+
+func respInfo(client *respClient, args [][]byte) {
+	keys, err := cacheManager.GetKeys()
+	if err != nil {
+		client.writeCacheError(err)
+		return
+	}
+	expires := 0
+	for _, key := range keys {
+		_, expiredAt, found, _ := cacheManager.Get(key)
+		if found && expiredAt != 0 {
+			expires++
+		}
+	}
+	usedMemory, _ := cacheManager.MemoryUsage()
+
+	var info strings.Builder
+	info.WriteString("# Server\r\n")
+	info.WriteString("redis_version:" + respRedisVersion + "\r\n")
+	info.WriteString("redis_mode:standalone\r\n")
+	info.WriteString("tcp_port:" + *config.ServerPort + "\r\n")
+	info.WriteString("\r\n# Memory\r\n")
+	info.WriteString(fmt.Sprintf("used_memory:%d\r\n", usedMemory))
+	info.WriteString(fmt.Sprintf("maxmemory:%d\r\n", int64(*config.MaxMemory)))
+	info.WriteString("maxmemory_policy:" + *config.EvictionPolicy + "\r\n")
+	info.WriteString("\r\n# Keyspace\r\n")
+	if len(keys) > 0 {
+		info.WriteString(fmt.Sprintf("db0:keys=%d,expires=%d,avg_ttl=0\r\n", len(keys), expires))
+	}
+	client.writer.WriteBulkString(info.String())
+}
+
+func respGet(client *respClient, args [][]byte) {
+	value, _, found, err := cacheManager.Get(string(args[0]))
+	if err != nil {
+		client.writeCacheError(err)
+		return
+	}
+	if !found {
+		client.writer.WriteNull()
+		return
+	}
+	if _, ok := value.(types.Structure); ok {
+		client.writeCacheError(cache.WrongTypeError{})
+		return
+	}
+	client.writer.WriteBulk(valueBytes(value))
+}
+
+// respSet supports SET key value [EX seconds|PX milliseconds] [NX|XX]
+func respSet(client *respClient, args [][]byte) {
+	key := string(args[0])
+	var ttl int64
+	var options cache.SetOptions
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "nx":
+			options.NX = true
+		case "xx":
+			options.XX = true
+		case "get":
+			options.Get = true
+		case "keepttl":
+			options.KeepTTL = true
+		case "ex", "px":
+			if ttl != 0 || i+1 == len(args) {
+				client.writer.WriteError("ERR syntax error")
+				return
+			}
+			expire, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil || expire <= 0 {
+				client.writer.WriteError("ERR invalid expire time in 'set' command")
+				return
+			}
+			ttl = expire
+			if strings.ToLower(string(args[i])) == "px" {
+				// TTL has seconds precision, round up to not expire the key too early
+				ttl = (expire + 999) / 1000
+			}
+			i++
+		default:
+			client.writer.WriteError("ERR syntax error")
+			return
+		}
+	}
+	if (options.NX && options.XX) || (options.KeepTTL && ttl != 0) {
+		client.writer.WriteError("ERR syntax error")
+		return
+	}
+	if options.Get {
+		// like Redis, GET option doesn't change keys of other types
+		if value, _, _, _ := cacheManager.Get(key); value != nil {
+			if _, ok := value.(types.Structure); ok {
+				client.writeCacheError(cache.WrongTypeError{})
+				return
+			}
+		}
+	}
+
+	version, previous, err := cacheManager.SetWithOptions(key, respValue(args[1]), ttl, options)
+	if err != nil {
+		client.writeCacheError(err)
+		return
+	}
+	switch {
+	case options.Get && previous == nil:
+		client.writer.WriteNull()
+	case options.Get:
+		client.writer.WriteBulk(valueBytes(previous))
+	case version == 0:
+		client.writer.WriteNull()
+	default:
+		client.writer.WriteSimpleString("OK")
+	}
+}
+
+func respDel(client *respClient, args [][]byte) {
+	deleted, err := cacheManager.MDelete(respStrings(args))
+	client.writeIntResult(int64(deleted), err)
+}
+
+func respMGet(client *respClient, args [][]byte) {
+	items, err := cacheManager.MGet(respStrings(args))
+	if err != nil {
+		client.writeCacheError(err)
+		return
+	}
+	client.writer.WriteArray(len(items))
+	for _, item := range items {
+		if _, ok := item.Value.(types.Structure); ok || !item.Found {
+			// like Redis, MGET replies with nil for keys of other types
+			client.writer.WriteNull()
+		} else {
+			client.writer.WriteBulk(valueBytes(item.Value))
+		}
+	}
+}
+
+func respMSet(client *respClient, args [][]byte) {
+	if len(args)%2 != 0 {
+		client.writer.WriteError("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+	items := make([]cache.Item, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		items = append(items, cache.Item{Key: string(args[i]), Value: respValue(args[i+1])})
+	}
+	if err := cacheManager.MSet(items); err != nil {
+		client.writeCacheError(err)
+		return
+	}
+	client.writer.WriteSimpleString("OK")
+}
+
+// respValue keeps argument as opaque bytes, so binary values are returned unchanged. Argument is copied because
+// it may share buffer of request.
+func respValue(arg []byte) raw.Bytes {
+	return raw.Bytes(append([]byte(nil), arg...))
+}
+
+func respExists(client *respClient, args [][]byte) {
+	var existing int64
+	for _, arg := range args {
+		_, _, found, err := cacheManager.Get(string(arg))
+		if err != nil {
+			client.writeCacheError(err)
+			return
+		}
+		if found {
+			existing++
+		}
+	}
+	client.writer.WriteInt(existing)
+}
+
+func respKeys(client *respClient, args [][]byte) {
+	keys, err := cacheManager.Keys(string(args[0]))
+	if err != nil {
+		client.writeCacheError(err)
+		return
+	}
+	client.writer.WriteArray(len(keys))
+	for _, key := range keys {
+		client.writer.WriteBulkString(key)
+	}
+}
+
+// respScan supports SCAN cursor [MATCH pattern] [COUNT count], reply is the next cursor and a page of keys
+func respScan(client *respClient, args [][]byte) {
+	pattern, count := "", 0
+	for i := 1; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			client.writer.WriteError("ERR syntax error")
+			return
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			pattern = string(args[i+1])
+		case "count":
+			n, err := strconv.Atoi(string(args[i+1]))
+			if err != nil || n <= 0 {
+				client.writer.WriteError("ERR value is not an integer or out of range")
+				return
+			}
+			count = n
+		default:
+			client.writer.WriteError("ERR syntax error")
+			return
+		}
+	}
+	keys, cursor, err := cacheManager.Scan(string(args[0]), pattern, count)
+	if _, ok := err.(cache.InvalidCursorError); ok {
+		client.writer.WriteError("ERR invalid cursor")
+		return
+	}
+	if err != nil {
+		client.writeCacheError(err)
+		return
+	}
+	client.writer.WriteArray(2)
+	client.writer.WriteBulkString(cursor)
+	client.writer.WriteArray(len(keys))
+	for _, key := range keys {
+		client.writer.WriteBulkString(key)
+	}
+}
+
+// respTTL replies with remaining TTL in seconds, -1 if key has no TTL and -2 if key doesn't exist
+func respTTL(client *respClient, args [][]byte) {
+	ttl, err := respRemainingTTL(string(args[0]))
+	if err != nil {
+		client.writeCacheError(err)
+		return
+	}
+	client.writer.WriteInt(ttl)
+}
+
+func respPTTL(client *respClient, args [][]byte) {
+	ttl, err := respRemainingTTL(string(args[0]))
+	if err != nil {
+		client.writeCacheError(err)
+		return
+	}
+	if ttl > 0 {
+		ttl *= 1000
+	}
+	client.writer.WriteInt(ttl)
+}
+
+func respRemainingTTL(key string) (int64, error) {
+	_, expiredAt, found, err := cacheManager.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return -2, nil
+	}
+	if expiredAt == 0 {
+		return -1, nil
+	}
+	ttl := expiredAt - time.Now().Unix()
+	if ttl < 0 {
+		ttl = 0
+	}
+	return ttl, nil
+}
+
+func respExpire(client *respClient, args [][]byte) {
+	seconds, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		client.writer.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+	found, err := cacheManager.Expire(string(args[0]), seconds)
+	if err != nil {
+		client.writeCacheError(err)
+		return
+	}
+	if found {
+		client.writer.WriteInt(1)
+	} else {
+		client.writer.WriteInt(0)
+	}
+}
+
+func respIncr(client *respClient, args [][]byte) {
+	client.writeIntResult(cacheManager.Incr(string(args[0]), 1, 0))
+}
+
+func respDecr(client *respClient, args [][]byte) {
+	client.writeIntResult(cacheManager.Decr(string(args[0]), 1, 0))
+}
+
+func respIncrBy(client *respClient, args [][]byte) {
+	delta, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		client.writer.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+	client.writeIntResult(cacheManager.Incr(string(args[0]), delta, 0))
+}
+
+func respDecrBy(client *respClient, args [][]byte) {
+	delta, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		client.writer.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+	client.writeIntResult(cacheManager.Decr(string(args[0]), delta, 0))
+}
+
+// respIncrByFloat replies with bulk string like Redis does
+func respIncrByFloat(client *respClient, args [][]byte) {
+	delta, err := strconv.ParseFloat(string(args[1]), 64)
+	if err != nil {
+		client.writer.WriteError("ERR value is not a valid float")
+		return
+	}
+	value, err := cacheManager.IncrFloat(string(args[0]), delta, 0)
+	if err != nil {
+		client.writeCacheError(err)
+		return
+	}
+	client.writer.WriteBulkString(strconv.FormatFloat(value, 'f', -1, 64))
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"./cache"
+	"./cache/aof"
+	"./cache/raw"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Port Cacher RESP server listens on for testing
+const RESPPort = "8082"
+
+var _ = Describe("resp", func() {
+
+	var conn net.Conn
+	var reader *bufio.Reader
+
+	// send raw request and check that expected reply is received
+	expectReply := func(request string, expected string) {
+		_, err := conn.Write([]byte(request))
+		Expect(err).NotTo(HaveOccurred())
+		reply := make([]byte, len(expected))
+		_, err = io.ReadFull(reader, reply)
+		Expect(err).NotTo(HaveOccurred())
+		Ω(string(reply)).Should(Equal(expected))
+	}
+
+	command := func(args ...string) string {
+		request := "*" + strconv.Itoa(len(args)) + "\r\n"
+		for _, arg := range args {
+			request += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+		}
+		return request
+	}
+
+	BeforeEach(func() {
+		cacheManager, _ = cache.New("mutex-map", log, false, 60, false)
+		var err error
+		conn, err = net.Dial("tcp", "localhost:"+RESPPort)
+		Expect(err).NotTo(HaveOccurred())
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		reader = bufio.NewReader(conn)
+	})
+
+	AfterEach(func() {
+		conn.Close()
+	})
+
+	It("answers ping", func() {
+		expectReply("PING\r\n", "+PONG\r\n")
+		expectReply(command("PING", "hello"), "$5\r\nhello\r\n")
+	})
+
+	It("sets and gets value", func() {
+		expectReply(command("SET", "test", "hello world"), "+OK\r\n")
+		expectReply(command("GET", "test"), "$11\r\nhello world\r\n")
+		expectReply(command("GET", "missed"), "$-1\r\n")
+		value, _, _, _ := cacheManager.Get("test")
+		Ω(value).Should(Equal(raw.Bytes("hello world")))
+	})
+
+	It("keeps binary values unchanged", func() {
+		binary := "\xff\x00\xfe\r\n"
+		expectReply(command("SET", "test", binary), "+OK\r\n")
+		expectReply(command("GET", "test"), "$5\r\n"+binary+"\r\n")
+		expectReply(command("MSET", "other", binary), "+OK\r\n")
+		expectReply(command("MGET", "other"), "*1\r\n$5\r\n"+binary+"\r\n")
+	})
+
+	It("returns JSON for non string values", func() {
+		cacheManager.Set("test", []interface{}{1, "2"}, 0)
+		expectReply(command("GET", "test"), "$7\r\n[1,\"2\"]\r\n")
+	})
+
+	It("supports set options", func() {
+		expectReply(command("SET", "test", "1", "XX"), "$-1\r\n")
+		expectReply(command("SET", "test", "1", "NX", "EX", "100"), "+OK\r\n")
+		expectReply(command("SET", "test", "2", "NX"), "$-1\r\n")
+		expectReply(command("TTL", "test"), ":100\r\n")
+		expectReply(command("SET", "test", "3", "PX", "1500"), "+OK\r\n")
+		expectReply(command("PTTL", "test"), ":2000\r\n")
+		expectReply(command("SET", "test", "3", "EX", "0"), "-ERR invalid expire time in 'set' command\r\n")
+		expectReply(command("SET", "test", "3", "NX", "XX"), "-ERR syntax error\r\n")
+	})
+
+	It("supports GET and KEEPTTL set options", func() {
+		expectReply(command("SET", "test", "1", "GET"), "$-1\r\n")
+		expectReply(command("SET", "test", "2", "GET", "EX", "100"), "$1\r\n1\r\n")
+		expectReply(command("SET", "test", "3", "KEEPTTL"), "+OK\r\n")
+		expectReply(command("TTL", "test"), ":100\r\n")
+		expectReply(command("SET", "test", "4", "NX", "GET"), "$1\r\n3\r\n")
+		expectReply(command("GET", "test"), "$1\r\n3\r\n")
+		expectReply(command("SET", "test", "4", "KEEPTTL", "EX", "10"), "-ERR syntax error\r\n")
+		expectReply(command("SADD", "set", "a"), ":1\r\n")
+		expectReply(command("SET", "set", "4", "GET"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	})
+
+	It("deletes and checks keys", func() {
+		cacheManager.Set("test_1", 1, 0)
+		cacheManager.Set("test_2", 2, 0)
+		expectReply(command("EXISTS", "test_1", "test_2", "test_3"), ":2\r\n")
+		expectReply(command("DEL", "test_1", "test_3"), ":1\r\n")
+		expectReply(command("KEYS", "test_*"), "*1\r\n$6\r\ntest_2\r\n")
+		expectReply(command("KEYS", "test_\\"), "-ERR invalid pattern\r\n")
+	})
+
+	It("scans keys by cursor", func() {
+		for _, key := range []string{"scan:3", "scan:1", "other", "scan:2"} {
+			cacheManager.Set(key, 1, 0)
+		}
+		// COUNT limits examined keys, so "other" takes a place in the first page
+		expectReply(command("SCAN", "0", "MATCH", "scan:*", "COUNT", "2"),
+			"*2\r\n$11\r\nMDpzY2FuOjI\r\n*1\r\n$6\r\nscan:1\r\n")
+		expectReply(command("SCAN", "MDpzY2FuOjI", "MATCH", "scan:*", "COUNT", "2"),
+			"*2\r\n$1\r\n0\r\n*2\r\n$6\r\nscan:2\r\n$6\r\nscan:3\r\n")
+		expectReply(command("SCAN", "!"), "-ERR invalid cursor\r\n")
+		expectReply(command("SCAN", "0", "COUNT"), "-ERR syntax error\r\n")
+		expectReply(command("SCAN", "0", "MATCH", "[a-"), "-ERR invalid pattern\r\n")
+	})
+
+	It("sets and gets several keys", func() {
+		expectReply(command("MSET", "a", "1", "b", "2"), "+OK\r\n")
+		expectReply(command("MGET", "a", "missed", "b"), "*3\r\n$1\r\n1\r\n$-1\r\n$1\r\n2\r\n")
+		expectReply(command("MSET", "a", "1", "b"), "-ERR wrong number of arguments for 'mset' command\r\n")
+		expectReply(command("DEL", "a", "b", "missed"), ":2\r\n")
+	})
+
+	It("manages TTL", func() {
+		cacheManager.Set("test", 1, 0)
+		expectReply(command("TTL", "missed"), ":-2\r\n")
+		expectReply(command("TTL", "test"), ":-1\r\n")
+		_, _, version, _, _ := cacheManager.GetVersioned("test")
+		expectReply(command("EXPIRE", "test", "50"), ":1\r\n")
+		expectReply(command("TTL", "test"), ":50\r\n")
+		value, _, newVersion, _, _ := cacheManager.GetVersioned("test")
+		Ω(value).Should(Equal(1.0))
+		Ω(newVersion).Should(Equal(version))
+		expectReply(command("EXPIRE", "missed", "50"), ":0\r\n")
+		expectReply(command("EXPIRE", "test", "0"), ":1\r\n")
+		expectReply(command("EXISTS", "test"), ":0\r\n")
+	})
+
+	It("answers pipelined commands", func() {
+		request := command("SET", "test", "1") + command("GET", "test") + command("DEL", "test")
+		expectReply(request, "+OK\r\n$1\r\n1\r\n:1\r\n")
+	})
+
+	It("switches protocol version", func() {
+		hello := "%6\r\n$6\r\nserver\r\n$5\r\nredis\r\n$7\r\nversion\r\n$5\r\n7.0.0\r\n$5\r\nproto\r\n:3\r\n" +
+			"$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n$7\r\nmodules\r\n*0\r\n"
+		expectReply(command("HELLO", "3"), hello)
+		expectReply(command("GET", "missed"), "_\r\n")
+		expectReply(command("HELLO", "4"), "-NOPROTO unsupported protocol version\r\n")
+	})
+
+	It("replies with errors", func() {
+		expectReply(command("UNKNOWN"), "-ERR unknown command 'UNKNOWN'\r\n")
+		expectReply(command("GET"), "-ERR wrong number of arguments for 'get' command\r\n")
+
+		cacheManager.Set("test", 1, 0)
+		cacheManager.SetMemoryLimit(0, 1, "noeviction", 5)
+		conn.Write([]byte(command("SET", "other", "1")))
+		reply, err := reader.ReadString('\n')
+		Expect(err).NotTo(HaveOccurred())
+		Ω(reply).Should(HavePrefix("-OOM "))
+	})
+
+	It("rewrites AOF in background", func() {
+		os.RemoveAll("./data/aof")
+		defer os.RemoveAll("./data/aof")
+		cacheManager, _ = cache.New("mutex-map", log, false, 60, true)
+		cacheManager.Set("test", 1, 0)
+		cacheManager.Set("test", 2, 0)
+		expectReply(command("BGREWRITEAOF"), "+Background append only file rewriting started\r\n")
+		// rewrite can be started again once the background one is done
+		Eventually(cache.RewriteAOF).Should(Succeed())
+
+		restored, _ := cache.New("mutex-map", log, false, 60, true)
+		value, _, found, _ := restored.Get("test")
+		Ω(found).Should(BeTrue())
+		Ω(value).Should(Equal(2.0))
+		aof.Close()
+		expectReply(command("BGREWRITEAOF"), "-ERR Append only file is not opened.\r\n")
+	})
+
+	It("supports hashes", func() {
+		expectReply(command("HSET", "test", "a", "1", "b", "2"), ":2\r\n")
+		expectReply(command("HGET", "test", "a"), "$1\r\n1\r\n")
+		expectReply(command("HGET", "test", "missed"), "$-1\r\n")
+		expectReply(command("HDEL", "test", "a", "missed"), ":1\r\n")
+		expectReply(command("HGETALL", "test"), "*2\r\n$1\r\nb\r\n$1\r\n2\r\n")
+		expectReply(command("TYPE", "test"), "+hash\r\n")
+		expectReply(command("HSET", "test", "a"), "-ERR wrong number of arguments for 'hset' command\r\n")
+	})
+
+	It("supports lists", func() {
+		expectReply(command("RPUSH", "test", "b", "c"), ":2\r\n")
+		expectReply(command("LPUSH", "test", "a"), ":3\r\n")
+		expectReply(command("LRANGE", "test", "0", "-1"), "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n")
+		expectReply(command("LPOP", "test"), "$1\r\na\r\n")
+		expectReply(command("RPOP", "test"), "$1\r\nc\r\n")
+		expectReply(command("RPOP", "test"), "$1\r\nb\r\n")
+		expectReply(command("RPOP", "test"), "$-1\r\n")
+		expectReply(command("TYPE", "test"), "+none\r\n")
+	})
+
+	It("supports sets", func() {
+		expectReply(command("SADD", "test", "a", "b", "a"), ":2\r\n")
+		expectReply(command("SADD", "other", "b", "c"), ":2\r\n")
+		expectReply(command("SINTER", "test", "other"), "*1\r\n$1\r\nb\r\n")
+		expectReply(command("SREM", "test", "b"), ":1\r\n")
+		expectReply(command("SMEMBERS", "test"), "*1\r\n$1\r\na\r\n")
+	})
+
+	It("supports sorted sets", func() {
+		expectReply(command("ZADD", "test", "2", "b", "1", "a", "+inf", "c"), ":3\r\n")
+		expectReply(command("ZRANGE", "test", "0", "1"), "*2\r\n$1\r\na\r\n$1\r\nb\r\n")
+		expectReply(command("ZRANGEBYSCORE", "test", "(1", "+inf", "WITHSCORES"),
+			"*4\r\n$1\r\nb\r\n$1\r\n2\r\n$1\r\nc\r\n$3\r\ninf\r\n")
+		expectReply(command("ZADD", "test", "x", "a"), "-ERR value is not a valid float\r\n")
+	})
+
+	It("rejects commands on keys of wrong type", func() {
+		expectReply(command("SET", "test", "1"), "+OK\r\n")
+		wrongType := "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+		expectReply(command("LPUSH", "test", "1"), wrongType)
+		expectReply(command("SADD", "set", "1"), ":1\r\n")
+		expectReply(command("GET", "set"), wrongType)
+		expectReply(command("HGET", "set", "a"), wrongType)
+	})
+	It("increments counters", func() {
+		expectReply(command("INCR", "counter"), ":1\r\n")
+		expectReply(command("INCRBY", "counter", "10"), ":11\r\n")
+		expectReply(command("DECRBY", "counter", "3"), ":8\r\n")
+		expectReply(command("DECR", "counter"), ":7\r\n")
+		expectReply(command("INCRBYFLOAT", "counter", "0.5"), "$3\r\n7.5\r\n")
+		expectReply(command("INCR", "counter"), "-ERR value is not an integer or out of range\r\n")
+		expectReply(command("SET", "test", "41"), "+OK\r\n")
+		expectReply(command("INCR", "test"), ":42\r\n")
+		expectReply(command("GET", "test"), "$2\r\n42\r\n")
+		expectReply(command("SET", "test", "9223372036854775807"), "+OK\r\n")
+		expectReply(command("INCR", "test"), "-ERR increment or decrement would overflow\r\n")
+		expectReply(command("SADD", "set", "1"), ":1\r\n")
+		expectReply(command("INCR", "set"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	})
+})
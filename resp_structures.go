@@ -0,0 +1,257 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"./cache/types"
+)
+
+// respType replies with type of value, plain values are reported as strings
+func respType(client *respClient, args [][]byte) {
+	value, _, found, err := cacheManager.Get(string(args[0]))
+	if err != nil {
+		client.writeCacheError(err)
+		return
+	}
+	switch v := value.(type) {
+	case types.Structure:
+		client.writer.WriteSimpleString(v.Type())
+	default:
+		if found {
+			client.writer.WriteSimpleString("string")
+		} else {
+			client.writer.WriteSimpleString("none")
+		}
+	}
+}
+
+func respHSet(client *respClient, args [][]byte) {
+	if len(args)%2 != 1 {
+		client.writer.WriteError("ERR wrong number of arguments for 'hset' command")
+		return
+	}
+	fields := make(map[string]string, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		fields[string(args[i])] = string(args[i+1])
+	}
+	added, err := cacheManager.HSet(string(args[0]), fields)
+	client.writeIntResult(int64(added), err)
+}
+
+func respHGet(client *respClient, args [][]byte) {
+	value, found, err := cacheManager.HGet(string(args[0]), string(args[1]))
+	if err != nil {
+		client.writeCacheError(err)
+		return
+	}
+	if !found {
+		client.writer.WriteNull()
+		return
+	}
+	client.writer.WriteBulkString(value)
+}
+
+func respHDel(client *respClient, args [][]byte) {
+	removed, err := cacheManager.HDel(string(args[0]), respStrings(args[1:])...)
+	client.writeIntResult(int64(removed), err)
+}
+
+func respHGetAll(client *respClient, args [][]byte) {
+	hash, err := cacheManager.HGetAll(string(args[0]))
+	if err != nil {
+		client.writeCacheError(err)
+		return
+	}
+	fields := make([]string, 0, len(hash))
+	for field := range hash {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	client.writer.WriteMap(len(fields))
+	for _, field := range fields {
+		client.writer.WriteBulkString(field)
+		client.writer.WriteBulkString(hash[field])
+	}
+}
+
+func respLPush(client *respClient, args [][]byte) {
+	length, err := cacheManager.LPush(string(args[0]), respStrings(args[1:])...)
+	client.writeIntResult(int64(length), err)
+}
+
+func respRPush(client *respClient, args [][]byte) {
+	length, err := cacheManager.RPush(string(args[0]), respStrings(args[1:])...)
+	client.writeIntResult(int64(length), err)
+}
+
+func respLPop(client *respClient, args [][]byte) {
+	client.writePopResult(cacheManager.LPop(string(args[0])))
+}
+
+func respRPop(client *respClient, args [][]byte) {
+	client.writePopResult(cacheManager.RPop(string(args[0])))
+}
+
+func respLRange(client *respClient, args [][]byte) {
+	start, stop, ok := respRange(client, args[1], args[2])
+	if !ok {
+		return
+	}
+	values, err := cacheManager.LRange(string(args[0]), start, stop)
+	client.writeStringsResult(values, err)
+}
+
+func respSAdd(client *respClient, args [][]byte) {
+	added, err := cacheManager.SAdd(string(args[0]), respStrings(args[1:])...)
+	client.writeIntResult(int64(added), err)
+}
+
+func respSRem(client *respClient, args [][]byte) {
+	removed, err := cacheManager.SRem(string(args[0]), respStrings(args[1:])...)
+	client.writeIntResult(int64(removed), err)
+}
+
+func respSMembers(client *respClient, args [][]byte) {
+	members, err := cacheManager.SMembers(string(args[0]))
+	client.writeStringsResult(members, err)
+}
+
+func respSInter(client *respClient, args [][]byte) {
+	members, err := cacheManager.SInter(respStrings(args)...)
+	client.writeStringsResult(members, err)
+}
+
+// respZAdd supports ZADD key score member [score member ...]
+func respZAdd(client *respClient, args [][]byte) {
+	if len(args)%2 != 1 {
+		client.writer.WriteError("ERR syntax error")
+		return
+	}
+	members := make([]types.ZMember, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		score, err := types.ParseScore(string(args[i]))
+		if err != nil {
+			client.writer.WriteError("ERR value is not a valid float")
+			return
+		}
+		members = append(members, types.ZMember{Member: string(args[i+1]), Score: score})
+	}
+	added, err := cacheManager.ZAdd(string(args[0]), members...)
+	client.writeIntResult(int64(added), err)
+}
+
+// respZRange supports ZRANGE key start stop [WITHSCORES]
+func respZRange(client *respClient, args [][]byte) {
+	withScores, ok := respWithScores(client, args[3:])
+	if !ok {
+		return
+	}
+	start, stop, ok := respRange(client, args[1], args[2])
+	if !ok {
+		return
+	}
+	members, err := cacheManager.ZRange(string(args[0]), start, stop)
+	client.writeZMembersResult(members, withScores, err)
+}
+
+// respZRangeByScore supports ZRANGEBYSCORE key min max [WITHSCORES]
+func respZRangeByScore(client *respClient, args [][]byte) {
+	withScores, ok := respWithScores(client, args[3:])
+	if !ok {
+		return
+	}
+	min, err := types.ParseScoreBound(string(args[1]))
+	if err != nil {
+		client.writer.WriteError("ERR " + err.Error())
+		return
+	}
+	max, err := types.ParseScoreBound(string(args[2]))
+	if err != nil {
+		client.writer.WriteError("ERR " + err.Error())
+		return
+	}
+	members, err := cacheManager.ZRangeByScore(string(args[0]), min, max)
+	client.writeZMembersResult(members, withScores, err)
+}
+
+func respWithScores(client *respClient, options [][]byte) (withScores bool, ok bool) {
+	if len(options) == 0 {
+		return false, true
+	}
+	if len(options) == 1 && strings.ToLower(string(options[0])) == "withscores" {
+		return true, true
+	}
+	client.writer.WriteError("ERR syntax error")
+	return false, false
+}
+
+func respRange(client *respClient, startArg, stopArg []byte) (start int, stop int, ok bool) {
+	start, err := strconv.Atoi(string(startArg))
+	if err == nil {
+		stop, err = strconv.Atoi(string(stopArg))
+	}
+	if err != nil {
+		client.writer.WriteError("ERR value is not an integer or out of range")
+		return 0, 0, false
+	}
+	return start, stop, true
+}
+
+func respStrings(args [][]byte) []string {
+	values := make([]string, len(args))
+	for i, arg := range args {
+		values[i] = string(arg)
+	}
+	return values
+}
+
+func (client *respClient) writeIntResult(value int64, err error) {
+	if err != nil {
+		client.writeCacheError(err)
+		return
+	}
+	client.writer.WriteInt(value)
+}
+
+func (client *respClient) writePopResult(value string, found bool, err error) {
+	if err != nil {
+		client.writeCacheError(err)
+		return
+	}
+	if !found {
+		client.writer.WriteNull()
+		return
+	}
+	client.writer.WriteBulkString(value)
+}
+
+func (client *respClient) writeStringsResult(values []string, err error) {
+	if err != nil {
+		client.writeCacheError(err)
+		return
+	}
+	client.writer.WriteArray(len(values))
+	for _, value := range values {
+		client.writer.WriteBulkString(value)
+	}
+}
+
+func (client *respClient) writeZMembersResult(members []types.ZMember, withScores bool, err error) {
+	if err != nil {
+		client.writeCacheError(err)
+		return
+	}
+	if !withScores {
+		client.writer.WriteArray(len(members))
+	} else {
+		client.writer.WriteArray(len(members) * 2)
+	}
+	for _, item := range members {
+		client.writer.WriteBulkString(item.Member)
+		if withScores {
+			client.writer.WriteBulkString(types.FormatScore(item.Score))
+		}
+	}
+}
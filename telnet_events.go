@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+
+	"./cache"
+	"github.com/reiver/go-oi"
+	"github.com/reiver/go-telnet"
+	"github.com/reiver/go-telnet/telsh"
+)
+
+// telnetEventsReply is a line written to connection watching events
+type telnetEventsReply struct {
+	Type    string         `json:"type"`
+	Event   *KeyspaceEvent `json:"event,omitempty"`
+	Message string         `json:"message,omitempty"`
+}
+
+func registerTelnetEventCommands(shellHandler *telsh.ShellHandler) {
+	shellHandler.Register("events", telnetTxProducer(telsh.ProducerFunc(eventsPruducer)))
+}
+
+func eventsPruducer(ctx telnet.Context, name string, args ...string) telsh.Handler {
+	log.Printf("Telnet %s with args: %+v", strings.ToUpper(name), args)
+	return telsh.PromoteHandlerFunc(eventsHandler(telnetSessionOf(ctx)), args...)
+}
+
+// eventsHandler switches connection to streaming mode: events of keys matching pattern after cursor
+// are written as they come until "stop" returns to shell or "exit" closes connection.
+// Slow watcher gets error and its connection is closed like the one of slow subscriber, since a line is being
+// read meanwhile. Reconnected client could resume from the last cursor.
+func eventsHandler(session *telnetSession) telsh.HandlerFunc {
+	return func(stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error {
+		if len(args) == 0 || len(args) > 2 {
+			oi.LongWriteString(stdout, "Usage: events <pattern> [<cursor>]\n\r")
+			return nil
+		}
+		cm := session.manager()
+		cursor := cm.EventCursor()
+		if len(args) == 2 {
+			var err error
+			if cursor, err = strconv.ParseUint(args[1], 10, 64); err != nil {
+				oi.LongWriteString(stdout, "Cursor '"+args[1]+"' is not a number.\n\r")
+				return nil
+			}
+		}
+		if err := cache.ValidatePattern(args[0]); err != nil {
+			oi.LongWriteString(stdout, err.Error()+"\n\r")
+			return nil
+		}
+		backlog, events, cancel, missed := cm.WatchFrom(args[0], cursor)
+		defer cancel()
+		if missed {
+			writeTelnetEventsReply(stdout, telnetEventsReply{Type: "missed"})
+		}
+		for _, event := range backlog {
+			e := keyspaceEvent(event)
+			writeTelnetEventsReply(stdout, telnetEventsReply{Type: "event", Event: &e})
+		}
+
+		lines, readLine := session.lineReader()
+		readLine()
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					writeTelnetEventsReply(stdout, telnetEventsReply{Type: "error", Message: slowWatcherMessage})
+					session.closed = true
+					return nil
+				}
+				e := keyspaceEvent(event)
+				writeTelnetEventsReply(stdout, telnetEventsReply{Type: "event", Event: &e})
+			case line, ok := <-lines:
+				if !ok {
+					session.closed = true
+					return nil
+				}
+				switch strings.ToLower(strings.TrimSpace(line)) {
+				case "":
+				case "stop":
+					return nil
+				case "exit", "quit":
+					session.closed = true
+					return nil
+				default:
+					writeTelnetEventsReply(stdout, telnetEventsReply{Type: "error",
+						Message: "Only stop and exit are allowed while watching events."})
+				}
+				readLine()
+			}
+		}
+	}
+}
+
+func writeTelnetEventsReply(stdout io.WriteCloser, reply telnetEventsReply) {
+	b, _ := json.Marshal(reply)
+	oi.LongWriteString(stdout, string(b)+"\n\r")
+}
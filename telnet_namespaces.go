@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/reiver/go-oi"
+	"github.com/reiver/go-telnet"
+	"github.com/reiver/go-telnet/telsh"
+)
+
+func registerTelnetNamespaceCommands(shellHandler *telsh.ShellHandler) {
+	shellHandler.Register("select", telsh.ProducerFunc(selectPruducer))
+}
+
+func selectPruducer(ctx telnet.Context, name string, args ...string) telsh.Handler {
+	log.Printf("Telnet SELECT with args: %+v", args)
+	return telsh.PromoteHandlerFunc(selectHandler(telnetSessionOf(ctx)), args...)
+}
+
+// selectHandler switches connection to namespace, namespace with auth token requires it as the second param.
+// Keys watched for transaction belong to the previous namespace, so they're unwatched.
+func selectHandler(session *telnetSession) telsh.HandlerFunc {
+	return func(stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error {
+		if len(args) != 1 && len(args) != 2 {
+			oi.LongWriteString(stdout, "Usage: select <namespace> [<auth_token>]\n\r")
+			return nil
+		}
+		if session.tx.multi {
+			oi.LongWriteString(stdout, "SELECT inside MULTI is not allowed.\n\r")
+			return nil
+		}
+		ns, found := lookupNamespace(args[0])
+		if !found {
+			writeTelnetResult(stdout, Result{Status: "error", ErrorMessage: fmt.Sprintf("Namespace '%s' not found.", args[0])})
+			return nil
+		}
+		if ns != nil && ns.config.AuthToken != "" && (len(args) == 1 || !namespaceTokenMatches(ns, args[1])) {
+			writeTelnetResult(stdout, Result{Status: "error", ErrorMessage: "Invalid auth token of namespace."})
+			return nil
+		}
+		session.namespace = ns
+		session.tx = telnetTx{}
+		writeTelnetResult(stdout, Result{Status: "ok"})
+		return nil
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	"./cache"
+	"./pubsub"
+	"github.com/reiver/go-oi"
+	"github.com/reiver/go-telnet"
+	"github.com/reiver/go-telnet/telsh"
+)
+
+// telnetPubSubReply is a line written to subscribed connection
+type telnetPubSubReply struct {
+	Type    string `json:"type"`
+	Pattern string `json:"pattern,omitempty"`
+	Channel string `json:"channel,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Count is number of subscriptions left after (un)subscribe
+	Count *int `json:"count,omitempty"`
+}
+
+const slowSubscriberMessage = "Subscriber can't keep up with messages, disconnecting."
+
+func registerTelnetPubSubCommands(shellHandler *telsh.ShellHandler) {
+	for _, name := range []string{"subscribe", "psubscribe"} {
+		shellHandler.Register(name, telnetTxProducer(telsh.ProducerFunc(subscribePruducer)))
+	}
+	for _, name := range []string{"unsubscribe", "punsubscribe"} {
+		shellHandler.Register(name, telnetTxProducer(telsh.ProducerFunc(unsubscribePruducer)))
+	}
+}
+
+func subscribePruducer(ctx telnet.Context, name string, args ...string) telsh.Handler {
+	log.Printf("Telnet %s with args: %+v", strings.ToUpper(name), args)
+	return telsh.PromoteHandlerFunc(subscribeHandler(telnetSessionOf(ctx), name), args...)
+}
+
+// subscribeHandler switches connection to streaming mode: messages are written as they come and only
+// (p)subscribe, (p)unsubscribe and ping commands are read. Connection returns to shell once it has no subscriptions.
+// Slow subscriber is dropped by hub and its connection is closed.
+func subscribeHandler(session *telnetSession, name string) telsh.HandlerFunc {
+	return func(stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error {
+		if len(args) == 0 {
+			oi.LongWriteString(stdout, "Usage: "+name+" <channel> [<channel> ...]\n\r")
+			return nil
+		}
+		subscriber := pubSubOfNamespace(session.namespace).Subscriber()
+		defer subscriber.Close()
+		if telnetSubscriptionCommand(stdout, subscriber, name, args) == 0 {
+			return nil
+		}
+
+		lines, readLine := session.lineReader()
+		readLine()
+		for {
+			select {
+			case message, ok := <-subscriber.Messages():
+				if !ok {
+					writeTelnetPubSubReply(stdout, telnetPubSubReply{Type: "error", Message: slowSubscriberMessage})
+					session.closed = true
+					return nil
+				}
+				reply := telnetPubSubReply{Type: "message", Channel: message.Channel, Message: message.Payload}
+				if message.Pattern != "" {
+					reply.Type, reply.Pattern = "pmessage", message.Pattern
+				}
+				writeTelnetPubSubReply(stdout, reply)
+			case line, ok := <-lines:
+				if !ok {
+					session.closed = true
+					return nil
+				}
+				fields := strings.Fields(line)
+				if len(fields) == 0 {
+					readLine()
+					continue
+				}
+				command := strings.ToLower(fields[0])
+				if command == "exit" || command == "quit" {
+					session.closed = true
+					return nil
+				}
+				if telnetSubscriptionCommand(stdout, subscriber, command, fields[1:]) == 0 {
+					return nil
+				}
+				readLine()
+			}
+		}
+	}
+}
+
+// telnetSubscriptionCommand runs command allowed in streaming mode and returns number of subscriptions
+func telnetSubscriptionCommand(stdout io.WriteCloser, subscriber *pubsub.Subscriber, command string, args []string) int {
+	channels, patterns := subscriber.Channels()
+	count := len(channels) + len(patterns)
+	switch command {
+	case "subscribe", "psubscribe":
+		for _, channel := range args {
+			if command == "subscribe" {
+				count = subscriber.Subscribe(channel)
+			} else if subscribed, err := subscriber.PSubscribe(channel); err != nil {
+				writeTelnetPubSubReply(stdout, telnetPubSubReply{Type: "error", Message: err.Error()})
+				continue
+			} else {
+				count = subscribed
+			}
+			writeTelnetPubSubReply(stdout, telnetPubSubReply{Type: command, Channel: channel, Count: &count})
+		}
+	case "unsubscribe", "punsubscribe":
+		if len(args) == 0 {
+			args = channels
+			if command == "punsubscribe" {
+				args = patterns
+			}
+		}
+		for _, channel := range args {
+			if command == "unsubscribe" {
+				count = subscriber.Unsubscribe(channel)
+			} else {
+				count = subscriber.PUnsubscribe(channel)
+			}
+			left := count
+			writeTelnetPubSubReply(stdout, telnetPubSubReply{Type: command, Channel: channel, Count: &left})
+		}
+	case "ping":
+		writeTelnetPubSubReply(stdout, telnetPubSubReply{Type: "pong", Message: strings.Join(args, " ")})
+	default:
+		writeTelnetPubSubReply(stdout, telnetPubSubReply{Type: "error",
+			Message: "Only (p)subscribe, (p)unsubscribe, ping and exit are allowed in subscribed mode."})
+	}
+	return count
+}
+
+func writeTelnetPubSubReply(stdout io.WriteCloser, reply telnetPubSubReply) {
+	b, _ := json.Marshal(reply)
+	oi.LongWriteString(stdout, string(b)+"\n\r")
+}
+
+func unsubscribePruducer(ctx telnet.Context, name string, args ...string) telsh.Handler {
+	log.Printf("Telnet %s with args: %+v", strings.ToUpper(name), args)
+	return telsh.PromoteHandlerFunc(func(stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error {
+		oi.LongWriteString(stdout, "Connection is not subscribed.\n\r")
+		return nil
+	}, args...)
+}
+
+// telnetPublish sends the rest of line to subscribers of channel in namespace of cm and returns number of deliveries
+func telnetPublish(cm *cache.CacheManager, args []string) (interface{}, error) {
+	ns, _ := lookupNamespace(cm.Namespace())
+	return pubSubOfNamespace(ns).Publish(args[0], strings.Join(args[1:], " ")), nil
+}
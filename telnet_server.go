@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
 	"time"
 
+	"./cache"
 	"./config"
 	"github.com/reiver/go-oi"
 	"github.com/reiver/go-telnet"
@@ -17,6 +19,8 @@ type Result struct {
 	Status       string      `json:"status"`
 	Value        interface{} `json:"value,omitempty"`
 	ExpiredAt    string      `json:"expired_at,omitempty"`
+	Version      uint64      `json:"version,omitempty"`
+	ContentType  string      `json:"content_type,omitempty"`
 	ErrorMessage string      `json:"error_message,omitempty"`
 }
 
@@ -35,29 +39,37 @@ func startTelNetServer() {
 
 	commandName := "get"
 	commandProducer := telsh.ProducerFunc(getValuePruducer)
-	shellHandler.Register(commandName, commandProducer)
+	shellHandler.Register(commandName, telnetTxProducer(commandProducer))
 	commandName = "set"
 	commandProducer = telsh.ProducerFunc(setValuePruducer)
-	shellHandler.Register(commandName, commandProducer)
+	shellHandler.Register(commandName, telnetTxProducer(commandProducer))
+	commandName = "cas"
+	commandProducer = telsh.ProducerFunc(casValuePruducer)
+	shellHandler.Register(commandName, telnetTxProducer(commandProducer))
 	commandName = "delete"
 	commandProducer = telsh.ProducerFunc(deleteValuePruducer)
-	shellHandler.Register(commandName, commandProducer)
+	shellHandler.Register(commandName, telnetTxProducer(commandProducer))
 
 	commandName = "keys"
 	commandProducer = telsh.ProducerFunc(getKeysPruducer)
-	shellHandler.Register(commandName, commandProducer)
+	shellHandler.Register(commandName, telnetTxProducer(commandProducer))
+	registerTelnetStructureCommands(shellHandler)
+	registerTelnetTxCommands(shellHandler)
+	registerTelnetPubSubCommands(shellHandler)
+	registerTelnetEventCommands(shellHandler)
+	registerTelnetNamespaceCommands(shellHandler)
 
 	address := fmt.Sprintf("%s:%s", *config.ServerIP, *config.ServerPort)
 	log.Printf("Telnet server launched: %s", address)
-	if err := telnet.ListenAndServe(address, shellHandler); nil != err {
+	if err := telnet.ListenAndServe(address, telnetSessionHandler{shellHandler}); nil != err {
 		log.Fatalf("Error while launching Telnet server: %s", err)
 	}
 }
 
-func getValueHandler(stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error {
+func getValueHandler(cm *cache.CacheManager, stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error {
 	if len(args) == 1 {
 		key := args[0]
-		value, expiredAt, found, err := cacheManager.Get(key)
+		value, expiredAt, version, found, err := cm.GetRaw(key)
 		if err != nil {
 			errorMessage := fmt.Sprintf("Error occured while Get value '%s' from cache.\n\r", key)
 			oi.LongWriteString(stdout, errorMessage)
@@ -69,9 +81,9 @@ func getValueHandler(stdin io.ReadCloser, stdout io.WriteCloser, stderr io.Write
 			return nil
 		}
 
-		result := Result{Status: "ok"}
-		if value != "" {
-			result.Value = value
+		result := Result{Status: "ok", Version: version}
+		if string(value.Data) != `""` {
+			result.Value, result.ContentType = rawResultValue(value)
 			if expiredAt != 0 {
 				result.ExpiredAt = time.Unix(expiredAt, 0).Format("2006-01-02 15:04:05")
 			}
@@ -94,66 +106,142 @@ func getValueHandler(stdin io.ReadCloser, stdout io.WriteCloser, stderr io.Write
 	return nil
 }
 
+// bindTelnetManager passes cache manager of namespace selected by connection to handler
+func bindTelnetManager(cm *cache.CacheManager, handler func(cm *cache.CacheManager, stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error) telsh.HandlerFunc {
+	return func(stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error {
+		return handler(cm, stdin, stdout, stderr, args...)
+	}
+}
+
 func getValuePruducer(ctx telnet.Context, name string, args ...string) telsh.Handler {
 	log.Printf("Telnet GET with args: %+v", args)
-	return telsh.PromoteHandlerFunc(getValueHandler, args...)
+	return telsh.PromoteHandlerFunc(bindTelnetManager(telnetSessionOf(ctx).manager(), getValueHandler), args...)
 }
 
-func setValueHandler(stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error {
-	if len(args) == 2 || len(args) == 3 {
-		key := args[0]
-		value := args[1]
-		var ttl int64
-		if len(args) == 3 {
-			var err error
-			ttl, err = strconv.ParseInt(args[2], 10, 64)
-			if err != nil {
-				oi.LongWriteString(stdout, "TTL value is invalid!\n\r")
-				return nil
-			}
+// setValueHandler serves set <key> <value> [<ttl>] [nx|xx] [get] [keepttl]
+func setValueHandler(cm *cache.CacheManager, stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error {
+	if len(args) < 2 {
+		oi.LongWriteString(stdout, "Command Set requires two params: 'Key' and 'Value'. Optional params are 'TTL' and flags nx, xx, get, keepttl.")
+		return nil
+	}
+	var options cache.SetOptions
+	ttlArgs := args[2:]
+	if len(ttlArgs) > 0 {
+		if _, err := strconv.ParseInt(ttlArgs[0], 10, 64); err == nil {
+			ttlArgs = ttlArgs[:1]
+		} else {
+			ttlArgs = nil
 		}
-
-		var rawValue interface{}
-		err := json.Unmarshal([]byte(value), &rawValue)
-		if err != nil {
-			errorMessage := fmt.Sprintf("Error occured while adding new key/value pair: %s - %s\n\r", key, value)
-			oi.LongWriteString(stdout, errorMessage)
+	}
+	for _, flag := range args[2+len(ttlArgs):] {
+		switch strings.ToLower(flag) {
+		case "nx":
+			options.NX = true
+		case "xx":
+			options.XX = true
+		case "get":
+			options.Get = true
+		case "keepttl":
+			options.KeepTTL = true
+		default:
+			oi.LongWriteString(stdout, fmt.Sprintf("Unknown set flag '%s'.\n\r", flag))
 			return nil
 		}
-		error := cacheManager.Set(key, rawValue, ttl)
-		if error != nil {
-			fmt.Println(err)
-			errorMessage := fmt.Sprintf("Error occured while adding new key/value pair: %s - %s\n\r", key, value)
-			oi.LongWriteString(stdout, errorMessage)
+	}
+	if options.NX && options.XX {
+		oi.LongWriteString(stdout, "Flags nx and xx can't be used together.\n\r")
+		return nil
+	}
+	storeValue(cm, stdout, append(args[:2:2], ttlArgs...), nil, options)
+	return nil
+}
+
+// casValueHandler sets value only if key still has passed version, version 0 means that key has to be missed
+func casValueHandler(cm *cache.CacheManager, stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error {
+	if len(args) == 3 || len(args) == 4 {
+		version, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			oi.LongWriteString(stdout, "Version value is invalid!\n\r")
 			return nil
 		}
+		storeValue(cm, stdout, append([]string{args[0]}, args[2:]...), &version, cache.SetOptions{})
+	} else {
+		oi.LongWriteString(stdout, "Command CAS requires three params: 'Key', 'Version' and 'Value'. Optional param is 'TTL'.")
+	}
+	return nil
+}
 
-		result := Result{Status: "Ok"}
-		b, err := json.Marshal(result)
+// storeValue sets key, value and optional TTL passed in args and writes result with the new version.
+// Non nil expected version makes set conditional, otherwise options are applied.
+func storeValue(cm *cache.CacheManager, stdout io.WriteCloser, args []string, expected *uint64, options cache.SetOptions) {
+	key := args[0]
+	value := args[1]
+	var ttl int64
+	if len(args) == 3 {
+		var err error
+		ttl, err = strconv.ParseInt(args[2], 10, 64)
 		if err != nil {
-			fmt.Println(err)
-			errorMessage := fmt.Sprintf("Error occured while adding new key/value pair: %s - %s\n\r", key, value)
-			oi.LongWriteString(stdout, errorMessage)
-			return nil
+			oi.LongWriteString(stdout, "TTL value is invalid!\n\r")
+			return
 		}
-		oi.LongWriteString(stdout, string(b)+"\n\r")
+	}
 
+	var rawValue interface{}
+	err := json.Unmarshal([]byte(value), &rawValue)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error occured while adding new key/value pair: %s - %s\n\r", key, value)
+		oi.LongWriteString(stdout, errorMessage)
+		return
+	}
+	var version uint64
+	var previous interface{}
+	var error error
+	if expected != nil {
+		version, error = cm.CompareAndSet(key, rawValue, ttl, *expected)
 	} else {
-		oi.LongWriteString(stdout, "Command Set requires two params: 'Key' and 'Value'. Optional param is 'TTL'.")
+		version, previous, error = cm.SetWithOptions(key, rawValue, ttl, options)
 	}
-	return nil
+	switch error.(type) {
+	case cache.MemoryLimitError, cache.VersionConflictError:
+		oi.LongWriteString(stdout, error.Error()+"\n\r")
+		return
+	}
+	if error != nil {
+		fmt.Println(err)
+		errorMessage := fmt.Sprintf("Error occured while adding new key/value pair: %s - %s\n\r", key, value)
+		oi.LongWriteString(stdout, errorMessage)
+		return
+	}
+
+	result := Result{Status: "Ok", Value: previous, Version: version}
+	if version == 0 {
+		result = Result{Status: "error", Value: previous, ErrorMessage: setConditionMessage(key, options)}
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		fmt.Println(err)
+		errorMessage := fmt.Sprintf("Error occured while adding new key/value pair: %s - %s\n\r", key, value)
+		oi.LongWriteString(stdout, errorMessage)
+		return
+	}
+	oi.LongWriteString(stdout, string(b)+"\n\r")
 }
 
 func setValuePruducer(ctx telnet.Context, name string, args ...string) telsh.Handler {
 	log.Printf("Telnet SET with args: %+v", args)
-	return telsh.PromoteHandlerFunc(setValueHandler, args...)
+	return telsh.PromoteHandlerFunc(bindTelnetManager(telnetSessionOf(ctx).manager(), setValueHandler), args...)
 }
 
-func deleteValueHandler(stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error {
+func casValuePruducer(ctx telnet.Context, name string, args ...string) telsh.Handler {
+	log.Printf("Telnet CAS with args: %+v", args)
+	return telsh.PromoteHandlerFunc(bindTelnetManager(telnetSessionOf(ctx).manager(), casValueHandler), args...)
+}
+
+func deleteValueHandler(cm *cache.CacheManager, stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error {
 	if len(args) == 1 {
 		//TODO: validate that key exists
 		key := args[0]
-		err := cacheManager.Delete(key)
+		err := cm.Delete(key)
 		if err != nil {
 			errorMessage := fmt.Sprintf("Error occured while deleting key: %s", key)
 			oi.LongWriteString(stdout, errorMessage)
@@ -179,12 +267,20 @@ func deleteValueHandler(stdin io.ReadCloser, stdout io.WriteCloser, stderr io.Wr
 
 func deleteValuePruducer(ctx telnet.Context, name string, args ...string) telsh.Handler {
 	log.Printf("Telnet DELETE with args: %+v", args)
-	return telsh.PromoteHandlerFunc(deleteValueHandler, args...)
+	return telsh.PromoteHandlerFunc(bindTelnetManager(telnetSessionOf(ctx).manager(), deleteValueHandler), args...)
 }
 
-func getKeysHandler(stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error {
-	if len(args) == 0 {
-		keys, err := cacheManager.GetKeys()
+func getKeysHandler(cm *cache.CacheManager, stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error {
+	if len(args) <= 1 {
+		pattern := ""
+		if len(args) == 1 {
+			pattern = args[0]
+		}
+		keys, err := cm.Keys(pattern)
+		if _, ok := err.(cache.InvalidPatternError); ok {
+			oi.LongWriteString(stdout, err.Error())
+			return nil
+		}
 		if err != nil {
 			oi.LongWriteString(stdout, "Error occured while collecting cache keys.")
 			return nil
@@ -203,7 +299,7 @@ func getKeysHandler(stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteC
 		oi.LongWriteString(stdout, string(b)+"\n\r")
 
 	} else {
-		oi.LongWriteString(stdout, "Command KEYS doesn't consume params.")
+		oi.LongWriteString(stdout, "Usage: keys [<pattern>]\n\r")
 	}
 
 	return nil
@@ -211,5 +307,5 @@ func getKeysHandler(stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteC
 
 func getKeysPruducer(ctx telnet.Context, name string, args ...string) telsh.Handler {
 	log.Printf("Telnet KEYS with args: %+v", args)
-	return telsh.PromoteHandlerFunc(getKeysHandler, args...)
+	return telsh.PromoteHandlerFunc(bindTelnetManager(telnetSessionOf(ctx).manager(), getKeysHandler), args...)
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"sync"
+
+	"./cache"
+	"github.com/reiver/go-telnet"
+	"github.com/reiver/go-telnet/telsh"
+)
+
+type (
+	// telnetSession keeps state of telnet connection between commands
+	telnetSession struct {
+		tx telnetTx
+		// reader is input of connection, subscribed connection reads commands itself while shell waits
+		reader telnet.Reader
+		// closed session makes shell see the end of input, so the connection is closed
+		closed bool
+		// namespace selected by connection, nil is the default one
+		namespace *namespace
+	}
+
+	// telnetSessionHandler keeps session of connection while it's served by shell
+	telnetSessionHandler struct {
+		*telsh.ShellHandler
+	}
+
+	// telnetSessionReader is input of shell which ends once session is closed
+	telnetSessionReader struct {
+		session *telnetSession
+	}
+)
+
+var telnetSessions = struct {
+	sync.Mutex
+	sessions map[telnet.Context]*telnetSession
+}{sessions: make(map[telnet.Context]*telnetSession)}
+
+func (h telnetSessionHandler) ServeTELNET(ctx telnet.Context, w telnet.Writer, r telnet.Reader) {
+	session := telnetSessionOf(ctx)
+	session.reader = r
+	h.ShellHandler.ServeTELNET(ctx, w, telnetSessionReader{session})
+	telnetSessions.Lock()
+	delete(telnetSessions.sessions, ctx)
+	telnetSessions.Unlock()
+}
+
+func (r telnetSessionReader) Read(p []byte) (int, error) {
+	if r.session.closed {
+		return 0, io.EOF
+	}
+	return r.session.reader.Read(p)
+}
+
+// telnetSessionOf returns session of connection
+func telnetSessionOf(ctx telnet.Context) *telnetSession {
+	telnetSessions.Lock()
+	defer telnetSessions.Unlock()
+	session, found := telnetSessions.sessions[ctx]
+	if !found {
+		session = &telnetSession{}
+		telnetSessions.sessions[ctx] = session
+	}
+	return session
+}
+
+// manager returns cache manager of namespace selected by connection
+func (session *telnetSession) manager() *cache.CacheManager {
+	return managerOfNamespace(session.namespace)
+}
+
+// lineReader reads lines of connection in streaming mode, next line is read once readLine is called.
+// Line is read only when the previous one is handled, so nothing is read after leaving streaming mode by a command.
+// Handler leaving it for other reason has a pending read, which would take the next command, so it closes
+// the connection. Lines channel is closed when connection ends.
+func (session *telnetSession) lineReader() (lines <-chan string, readLine func()) {
+	ch := make(chan string, 1)
+	return ch, func() {
+		go func() {
+			line, err := readTelnetLine(session.reader)
+			if err != nil {
+				close(ch)
+				return
+			}
+			ch <- line
+		}()
+	}
+}
+
+// readTelnetLine reads input byte by byte like shell does, so nothing of the next line is consumed
+func readTelnetLine(reader telnet.Reader) (string, error) {
+	var line []byte
+	p := make([]byte, 1)
+	for {
+		n, err := reader.Read(p)
+		if n > 0 {
+			if p[0] == '\n' {
+				return strings.TrimRight(string(line), "\r"), nil
+			}
+			line = append(line, p[0])
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
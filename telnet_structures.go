@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"./cache"
+	"./cache/types"
+	"github.com/reiver/go-oi"
+	"github.com/reiver/go-telnet"
+	"github.com/reiver/go-telnet/telsh"
+)
+
+type telnetStructureCommand struct {
+	handler func(cm *cache.CacheManager, args []string) (interface{}, error)
+	usage   string
+	// minimal number of arguments
+	arity int
+}
+
+var telnetStructureCommands = map[string]telnetStructureCommand{
+	"hset":          {telnetHSet, "hset <key> <field> <value> [<field> <value> ...]", 3},
+	"hget":          {telnetHGet, "hget <key> <field>", 2},
+	"hdel":          {telnetHDel, "hdel <key> <field> [<field> ...]", 2},
+	"hgetall":       {telnetHGetAll, "hgetall <key>", 1},
+	"lpush":         {telnetLPush, "lpush <key> <value> [<value> ...]", 2},
+	"rpush":         {telnetRPush, "rpush <key> <value> [<value> ...]", 2},
+	"lpop":          {telnetLPop, "lpop <key>", 1},
+	"rpop":          {telnetRPop, "rpop <key>", 1},
+	"lrange":        {telnetLRange, "lrange <key> <start> <stop>", 3},
+	"sadd":          {telnetSAdd, "sadd <key> <member> [<member> ...]", 2},
+	"srem":          {telnetSRem, "srem <key> <member> [<member> ...]", 2},
+	"smembers":      {telnetSMembers, "smembers <key>", 1},
+	"sinter":        {telnetSInter, "sinter <key> [<key> ...]", 1},
+	"zadd":          {telnetZAdd, "zadd <key> <score> <member> [<score> <member> ...]", 3},
+	"zrange":        {telnetZRange, "zrange <key> <start> <stop>", 3},
+	"zrangebyscore": {telnetZRangeByScore, "zrangebyscore <key> <min> <max>", 3},
+	"incr":          {telnetIncr, "incr <key> [<delta> [<ttl>]]", 1},
+	"decr":          {telnetDecr, "decr <key> [<delta> [<ttl>]]", 1},
+	"mget":          {telnetMGet, "mget <key> [<key> ...]", 1},
+	"mset":          {telnetMSet, "mset <key> <value> [<key> <value> ...]", 2},
+	"mdel":          {telnetMDel, "mdel <key> [<key> ...]", 1},
+	"scan":          {telnetScan, "scan <cursor> [<pattern> [<count>]]", 1},
+	"eval":          {telnetEval, "eval <script> <numkeys> [<key> ...] [<arg> ...]", 2},
+	"evalsha":       {telnetEvalSHA, "evalsha <sha> <numkeys> [<key> ...] [<arg> ...]", 2},
+	"script":        {telnetScript, "script load <script>", 2},
+	"publish":       {telnetPublish, "publish <channel> <message>", 2},
+}
+
+var errTelnetSyntax = errors.New("syntax error")
+
+func registerTelnetStructureCommands(shellHandler *telsh.ShellHandler) {
+	for name := range telnetStructureCommands {
+		shellHandler.Register(name, telnetTxProducer(telsh.ProducerFunc(structureCommandProducer)))
+	}
+}
+
+func structureCommandProducer(ctx telnet.Context, name string, args ...string) telsh.Handler {
+	log.Printf("Telnet %s with args: %+v", name, args)
+	command := telnetStructureCommands[name]
+	cm := telnetSessionOf(ctx).manager()
+	return telsh.PromoteHandlerFunc(func(stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error {
+		if len(args) < command.arity {
+			oi.LongWriteString(stdout, "Usage: "+command.usage+"\n\r")
+			return nil
+		}
+		value, err := command.handler(cm, args)
+		if err == errTelnetSyntax {
+			oi.LongWriteString(stdout, "Usage: "+command.usage+"\n\r")
+			return nil
+		}
+		if err != nil {
+			oi.LongWriteString(stdout, err.Error()+"\n\r")
+			return nil
+		}
+		b, err := json.Marshal(Result{Status: "ok", Value: value})
+		if err != nil {
+			oi.LongWriteString(stdout, fmt.Sprintf("Error occured while running %s command: %s\n\r", name, err))
+			return nil
+		}
+		oi.LongWriteString(stdout, string(b)+"\n\r")
+		return nil
+	}, args...)
+}
+
+func telnetHSet(cm *cache.CacheManager, args []string) (interface{}, error) {
+	if len(args)%2 != 1 {
+		return nil, errTelnetSyntax
+	}
+	fields := make(map[string]string, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		fields[args[i]] = args[i+1]
+	}
+	return cm.HSet(args[0], fields)
+}
+
+func telnetHGet(cm *cache.CacheManager, args []string) (interface{}, error) {
+	value, found, err := cm.HGet(args[0], args[1])
+	if err == nil && !found {
+		return nil, fmt.Errorf("Field '%s' not found.", args[1])
+	}
+	return value, err
+}
+
+func telnetHDel(cm *cache.CacheManager, args []string) (interface{}, error) {
+	return cm.HDel(args[0], args[1:]...)
+}
+
+func telnetHGetAll(cm *cache.CacheManager, args []string) (interface{}, error) {
+	return cm.HGetAll(args[0])
+}
+
+func telnetLPush(cm *cache.CacheManager, args []string) (interface{}, error) {
+	return cm.LPush(args[0], args[1:]...)
+}
+
+func telnetRPush(cm *cache.CacheManager, args []string) (interface{}, error) {
+	return cm.RPush(args[0], args[1:]...)
+}
+
+func telnetLPop(cm *cache.CacheManager, args []string) (interface{}, error) {
+	return telnetPopResult(args[0], cm.LPop)
+}
+
+func telnetRPop(cm *cache.CacheManager, args []string) (interface{}, error) {
+	return telnetPopResult(args[0], cm.RPop)
+}
+
+func telnetPopResult(key string, pop func(key string) (string, bool, error)) (interface{}, error) {
+	value, found, err := pop(key)
+	if err == nil && !found {
+		return nil, fmt.Errorf("Key '%s' not found.", key)
+	}
+	return value, err
+}
+
+func telnetLRange(cm *cache.CacheManager, args []string) (interface{}, error) {
+	start, stop, err := telnetRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	return cm.LRange(args[0], start, stop)
+}
+
+func telnetSAdd(cm *cache.CacheManager, args []string) (interface{}, error) {
+	return cm.SAdd(args[0], args[1:]...)
+}
+
+func telnetSRem(cm *cache.CacheManager, args []string) (interface{}, error) {
+	return cm.SRem(args[0], args[1:]...)
+}
+
+func telnetSMembers(cm *cache.CacheManager, args []string) (interface{}, error) {
+	return cm.SMembers(args[0])
+}
+
+func telnetSInter(cm *cache.CacheManager, args []string) (interface{}, error) {
+	return cm.SInter(args...)
+}
+
+func telnetZAdd(cm *cache.CacheManager, args []string) (interface{}, error) {
+	if len(args)%2 != 1 {
+		return nil, errTelnetSyntax
+	}
+	members := make([]types.ZMember, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		score, err := types.ParseScore(args[i])
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, types.ZMember{Member: args[i+1], Score: score})
+	}
+	return cm.ZAdd(args[0], members...)
+}
+
+func telnetZRange(cm *cache.CacheManager, args []string) (interface{}, error) {
+	start, stop, err := telnetRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	return cm.ZRange(args[0], start, stop)
+}
+
+func telnetZRangeByScore(cm *cache.CacheManager, args []string) (interface{}, error) {
+	min, err := types.ParseScoreBound(args[1])
+	if err != nil {
+		return nil, err
+	}
+	max, err := types.ParseScoreBound(args[2])
+	if err != nil {
+		return nil, err
+	}
+	return cm.ZRangeByScore(args[0], min, max)
+}
+
+func telnetRange(startArg, stopArg string) (int, int, error) {
+	start, err := strconv.Atoi(startArg)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Start '%s' is not an integer.", startArg)
+	}
+	stop, err := strconv.Atoi(stopArg)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Stop '%s' is not an integer.", stopArg)
+	}
+	return start, stop, nil
+}
+
+// telnetIncr increments number at key, delta with fraction makes float increment
+func telnetIncr(cm *cache.CacheManager, args []string) (interface{}, error) {
+	if len(args) > 3 {
+		return nil, errTelnetSyntax
+	}
+	ttl, err := telnetCounterTTL(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 1 {
+		return cm.Incr(args[0], 1, ttl)
+	}
+	if delta, err := strconv.ParseInt(args[1], 10, 64); err == nil {
+		return cm.Incr(args[0], delta, ttl)
+	}
+	delta, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return nil, errTelnetSyntax
+	}
+	return cm.IncrFloat(args[0], delta, ttl)
+}
+
+func telnetDecr(cm *cache.CacheManager, args []string) (interface{}, error) {
+	if len(args) > 3 {
+		return nil, errTelnetSyntax
+	}
+	ttl, err := telnetCounterTTL(args)
+	if err != nil {
+		return nil, err
+	}
+	delta := int64(1)
+	if len(args) > 1 {
+		if delta, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+			return nil, errTelnetSyntax
+		}
+	}
+	return cm.Decr(args[0], delta, ttl)
+}
+
+// telnetCounterTTL returns optional TTL passed after delta, 0 keeps TTL of the key
+func telnetCounterTTL(args []string) (int64, error) {
+	if len(args) < 3 {
+		return 0, nil
+	}
+	ttl, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return 0, errTelnetSyntax
+	}
+	return ttl, nil
+}
+
+// telnetMGet returns map of found keys to their values
+func telnetMGet(cm *cache.CacheManager, args []string) (interface{}, error) {
+	items, err := cm.MGet(args)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{}, len(items))
+	for _, item := range items {
+		if item.Found {
+			values[item.Key] = item.Value
+		}
+	}
+	return values, nil
+}
+
+// telnetMSet sets JSON values like set command does
+func telnetMSet(cm *cache.CacheManager, args []string) (interface{}, error) {
+	if len(args)%2 != 0 {
+		return nil, errTelnetSyntax
+	}
+	items := make([]cache.Item, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		var value interface{}
+		if err := json.Unmarshal([]byte(args[i+1]), &value); err != nil {
+			return nil, fmt.Errorf("Value of key '%s' is not JSON: %s", args[i], err)
+		}
+		items = append(items, cache.Item{Key: args[i], Value: value})
+	}
+	return nil, cm.MSet(items)
+}
+
+func telnetMDel(cm *cache.CacheManager, args []string) (interface{}, error) {
+	return cm.MDelete(args)
+}
+
+// telnetScan returns a page of keys with cursor of the next one, cursor 0 starts and ends scan
+func telnetScan(cm *cache.CacheManager, args []string) (interface{}, error) {
+	pattern, count := "", 0
+	if len(args) > 1 {
+		pattern = args[1]
+	}
+	if len(args) > 2 {
+		var err error
+		if count, err = strconv.Atoi(args[2]); err != nil || count <= 0 {
+			return nil, errTelnetSyntax
+		}
+	}
+	if len(args) > 3 {
+		return nil, errTelnetSyntax
+	}
+	keys, cursor, err := cm.Scan(args[0], pattern, count)
+	if err != nil {
+		return nil, err
+	}
+	return ScanPage{Cursor: cursor, Keys: keys}, nil
+}
+
+func telnetEval(cm *cache.CacheManager, args []string) (interface{}, error) {
+	keys, scriptArgs, err := telnetScriptArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return cm.Eval(args[0], keys, scriptArgs, 0)
+}
+
+func telnetEvalSHA(cm *cache.CacheManager, args []string) (interface{}, error) {
+	keys, scriptArgs, err := telnetScriptArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return cm.EvalSHA(args[0], keys, scriptArgs, 0)
+}
+
+// telnetScriptArgs splits arguments after script into KEYS and ARGV by number of keys
+func telnetScriptArgs(args []string) ([]string, []interface{}, error) {
+	numKeys, err := strconv.Atoi(args[1])
+	if err != nil || numKeys < 0 || numKeys > len(args)-2 {
+		return nil, nil, errTelnetSyntax
+	}
+	keys := args[2 : 2+numKeys]
+	scriptArgs := make([]interface{}, 0, len(args)-2-numKeys)
+	for _, arg := range args[2+numKeys:] {
+		scriptArgs = append(scriptArgs, arg)
+	}
+	return keys, scriptArgs, nil
+}
+
+// telnetScript serves script load, telnet splits line by spaces, so the rest of line is joined back into script
+func telnetScript(cm *cache.CacheManager, args []string) (interface{}, error) {
+	if strings.ToLower(args[0]) != "load" {
+		return nil, errTelnetSyntax
+	}
+	return cm.ScriptLoad(strings.Join(args[1:], " "))
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"./cache"
+	"./cache/counter"
+	"github.com/reiver/go-oi"
+	"github.com/reiver/go-telnet"
+	"github.com/reiver/go-telnet/telsh"
+)
+
+// telnetTx is transaction state of telnet connection
+type telnetTx struct {
+	multi   bool
+	ops     []cache.TxOp
+	watched map[string]uint64
+}
+
+func registerTelnetTxCommands(shellHandler *telsh.ShellHandler) {
+	shellHandler.Register("multi", telsh.ProducerFunc(multiPruducer))
+	shellHandler.Register("exec", telsh.ProducerFunc(execPruducer))
+	shellHandler.Register("discard", telsh.ProducerFunc(discardPruducer))
+	shellHandler.Register("watch", telsh.ProducerFunc(watchPruducer))
+}
+
+// telnetTxProducer queues command to transaction after MULTI instead of running it
+func telnetTxProducer(producer telsh.Producer) telsh.Producer {
+	return telsh.ProducerFunc(func(ctx telnet.Context, name string, args ...string) telsh.Handler {
+		tx := &telnetSessionOf(ctx).tx
+		if !tx.multi {
+			return producer.Produce(ctx, name, args...)
+		}
+		log.Printf("Telnet %s queued with args: %+v", name, args)
+		return telsh.PromoteHandlerFunc(func(stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error {
+			op, err := telnetTxOp(name, args)
+			if err != nil {
+				oi.LongWriteString(stdout, err.Error()+"\n\r")
+				return nil
+			}
+			tx.ops = append(tx.ops, op)
+			oi.LongWriteString(stdout, "QUEUED\n\r")
+			return nil
+		}, args...)
+	})
+}
+
+// telnetTxOp parses get, set, delete, incr and decr commands the same way as they're run outside of transaction
+func telnetTxOp(name string, args []string) (op cache.TxOp, err error) {
+	if len(args) == 0 {
+		return op, fmt.Errorf("Command '%s' requires 'Key'.", name)
+	}
+	op.Key = args[0]
+	switch name {
+	case "get", "delete":
+		if len(args) != 1 {
+			return op, fmt.Errorf("Usage: %s <key>", name)
+		}
+		op.Op = cache.TxGet
+		if name == "delete" {
+			op.Op = cache.TxDelete
+		}
+	case "set":
+		if len(args) != 2 && len(args) != 3 {
+			return op, fmt.Errorf("Usage: set <key> <value> [<ttl>]")
+		}
+		op.Op = cache.TxSet
+		if err = json.Unmarshal([]byte(args[1]), &op.Value); err != nil {
+			return op, fmt.Errorf("Value '%s' is not valid JSON.", args[1])
+		}
+		if len(args) == 3 {
+			if op.TTL, err = strconv.ParseInt(args[2], 10, 64); err != nil {
+				return op, fmt.Errorf("TTL value is invalid!")
+			}
+		}
+	case "incr", "decr":
+		usage := fmt.Errorf("Usage: %s <key> [<delta> [<ttl>]]", name)
+		if len(args) > 3 {
+			return op, usage
+		}
+		op.Op = cache.TxIncr
+		if op.TTL, err = telnetCounterTTL(args); err != nil {
+			return op, usage
+		}
+		op.Delta = counter.Int(1)
+		if len(args) > 1 {
+			if delta, err := strconv.ParseInt(args[1], 10, 64); err == nil {
+				op.Delta = counter.Int(delta)
+			} else if delta, err := strconv.ParseFloat(args[1], 64); err == nil && name == "incr" {
+				op.Delta = counter.Float(delta)
+			} else {
+				return op, usage
+			}
+		}
+		if name == "decr" {
+			if op.Delta.Integer == math.MinInt64 {
+				return op, counter.ErrOverflow
+			}
+			op.Delta.Integer = -op.Delta.Integer
+		}
+	default:
+		return op, fmt.Errorf("Command '%s' can't be used in transaction.", name)
+	}
+	return op, nil
+}
+
+func multiHandler(tx *telnetTx) telsh.HandlerFunc {
+	return func(stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error {
+		if tx.multi {
+			oi.LongWriteString(stdout, "MULTI calls can't be nested.\n\r")
+			return nil
+		}
+		tx.multi = true
+		writeTelnetResult(stdout, Result{Status: "ok"})
+		return nil
+	}
+}
+
+// execHandler runs queued commands atomically, nothing is run if a watched key was changed
+func execHandler(cm *cache.CacheManager, tx *telnetTx) telsh.HandlerFunc {
+	return func(stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error {
+		if !tx.multi {
+			oi.LongWriteString(stdout, "EXEC without MULTI.\n\r")
+			return nil
+		}
+		results, err := cm.Exec(tx.ops, tx.watched)
+		*tx = telnetTx{}
+		if err != nil {
+			writeTelnetResult(stdout, Result{Status: "error", ErrorMessage: err.Error()})
+			return nil
+		}
+		writeTelnetResult(stdout, Result{Status: "ok", Value: txResultItems(results)})
+		return nil
+	}
+}
+
+func discardHandler(tx *telnetTx) telsh.HandlerFunc {
+	return func(stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error {
+		if !tx.multi {
+			oi.LongWriteString(stdout, "DISCARD without MULTI.\n\r")
+			return nil
+		}
+		*tx = telnetTx{}
+		writeTelnetResult(stdout, Result{Status: "ok"})
+		return nil
+	}
+}
+
+// watchHandler remembers versions of keys, EXEC fails if any of them is changed meanwhile
+func watchHandler(cm *cache.CacheManager, tx *telnetTx) telsh.HandlerFunc {
+	return func(stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser, args ...string) error {
+		if len(args) == 0 {
+			oi.LongWriteString(stdout, "Command WATCH requires at least one parameter: 'Key'.\n\r")
+			return nil
+		}
+		if tx.multi {
+			oi.LongWriteString(stdout, "WATCH inside MULTI is not allowed.\n\r")
+			return nil
+		}
+		versions, err := cm.Versions(args)
+		if err != nil {
+			oi.LongWriteString(stdout, err.Error()+"\n\r")
+			return nil
+		}
+		if tx.watched == nil {
+			tx.watched = make(map[string]uint64)
+		}
+		for key, version := range versions {
+			// key watched twice keeps the first version
+			if _, found := tx.watched[key]; !found {
+				tx.watched[key] = version
+			}
+		}
+		writeTelnetResult(stdout, Result{Status: "ok"})
+		return nil
+	}
+}
+
+func writeTelnetResult(stdout io.WriteCloser, result Result) {
+	b, err := json.Marshal(result)
+	if err != nil {
+		oi.LongWriteString(stdout, fmt.Sprintf("Error occured while writing result: %s\n\r", err))
+		return
+	}
+	oi.LongWriteString(stdout, string(b)+"\n\r")
+}
+
+func multiPruducer(ctx telnet.Context, name string, args ...string) telsh.Handler {
+	log.Printf("Telnet MULTI with args: %+v", args)
+	return telsh.PromoteHandlerFunc(multiHandler(&telnetSessionOf(ctx).tx), args...)
+}
+
+func execPruducer(ctx telnet.Context, name string, args ...string) telsh.Handler {
+	log.Printf("Telnet EXEC with args: %+v", args)
+	session := telnetSessionOf(ctx)
+	return telsh.PromoteHandlerFunc(execHandler(session.manager(), &session.tx), args...)
+}
+
+func discardPruducer(ctx telnet.Context, name string, args ...string) telsh.Handler {
+	log.Printf("Telnet DISCARD with args: %+v", args)
+	return telsh.PromoteHandlerFunc(discardHandler(&telnetSessionOf(ctx).tx), args...)
+}
+
+func watchPruducer(ctx telnet.Context, name string, args ...string) telsh.Handler {
+	log.Printf("Telnet WATCH with args: %+v", args)
+	session := telnetSessionOf(ctx)
+	return telsh.PromoteHandlerFunc(watchHandler(session.manager(), &session.tx), args...)
+}